@@ -9,8 +9,10 @@ require (
 
 require (
 	github.com/99designs/gqlgen v0.17.39
+	github.com/andybalholm/brotli v1.1.1
 	github.com/barrettj12/collections v0.0.0-20230319072748-9bd971ac9abc
 	github.com/vektah/gqlparser/v2 v2.5.10
+	golang.org/x/term v0.30.0
 )
 
 require (
@@ -43,7 +45,7 @@ require (
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	go.etcd.io/bbolt v1.3.7 // indirect
 	golang.org/x/mod v0.10.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	golang.org/x/tools v0.9.3 // indirect
 )
@@ -52,5 +54,5 @@ require (
 	github.com/blevesearch/bleve v1.0.14
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
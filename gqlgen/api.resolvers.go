@@ -9,39 +9,106 @@ import (
 
 	"github.com/barrettj12/chords/gqlgen/types"
 	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/events"
 )
 
 // Artist is the resolver for the artist field.
 func (r *albumResolver) Artist(ctx context.Context, obj *types.Album) (*types.Artist, error) {
-	return r.resolveArtist(r.DB.Artists(ctx, data.ArtistsFilters{
-		Album: data.AlbumID(obj.ID),
-	}))
+	return r.loaders(ctx).artists.Load(ctx, obj.ArtistID)
 }
 
 // Songs is the resolver for the songs field.
 func (r *albumResolver) Songs(ctx context.Context, obj *types.Album) ([]*types.Song, error) {
-	return r.resolveSongs(r.DB.Songs(ctx, data.SongsFilters{
-		Album: data.AlbumID(obj.ID),
-	}))
+	return r.loaders(ctx).songs.LoadAll(ctx, obj.SongIDs)
 }
 
 // Albums is the resolver for the albums field.
 func (r *artistResolver) Albums(ctx context.Context, obj *types.Artist) ([]*types.Album, error) {
-	return r.resolveAlbums(r.DB.Albums(ctx, data.AlbumsFilters{
-		Artist: data.ArtistID(obj.ID),
-	}))
+	return r.loaders(ctx).albums.LoadAll(ctx, obj.AlbumIDs)
 }
 
 // RelatedArtists is the resolver for the relatedArtists field.
 func (r *artistResolver) RelatedArtists(ctx context.Context, obj *types.Artist) ([]*types.Artist, error) {
-	return r.resolveArtists(r.DB.Artists(ctx, data.ArtistsFilters{
-		RelatedTo: data.ArtistID(obj.ID),
+	return r.loaders(ctx).artists.LoadAll(ctx, obj.RelatedArtistIDs)
+}
+
+// AddSong is the resolver for the addSong field.
+func (r *mutationResolver) AddSong(ctx context.Context, song types.SongInput) (*types.Song, error) {
+	return r.resolveUpdatedSong(r.DB.AddSong(ctx, translateSongInput(song)))
+}
+
+// UpdateSong is the resolver for the updateSong field.
+func (r *mutationResolver) UpdateSong(ctx context.Context, id string, song types.SongInput) (*types.Song, error) {
+	return r.resolveUpdatedSong(r.DB.UpdateSong(ctx, data.SongID(id), translateSongInput(song)))
+}
+
+// UpdateChords is the resolver for the updateChords field.
+func (r *mutationResolver) UpdateChords(ctx context.Context, id string, chords string) (*types.Song, error) {
+	return r.resolveUpdatedSong(r.DB.UpdateChords(ctx, data.SongID(id), []byte(chords)))
+}
+
+// DeleteSong is the resolver for the deleteSong field.
+func (r *mutationResolver) DeleteSong(ctx context.Context, id string) (string, error) {
+	err := r.DB.DeleteSong(ctx, data.SongID(id))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// UpdateArtist is the resolver for the updateArtist field.
+func (r *mutationResolver) UpdateArtist(ctx context.Context, id string, artist types.ArtistInput) (*types.Artist, error) {
+	return r.resolveUpdatedArtist(r.DB.UpdateArtist(ctx, data.ArtistID(id), data.ArtistUpdate{
+		Name:     artist.Name,
+		SortName: artist.SortName,
+		Aliases:  artist.Aliases,
+	}))
+}
+
+// UpdateAlbum is the resolver for the updateAlbum field.
+func (r *mutationResolver) UpdateAlbum(ctx context.Context, id string, album types.AlbumInput) (*types.Album, error) {
+	return r.resolveUpdatedAlbum(r.DB.UpdateAlbum(ctx, data.AlbumID(id), data.AlbumUpdate{
+		Name:     album.Name,
+		SortName: album.SortName,
+		Year:     album.Year,
+		Aliases:  album.Aliases,
 	}))
 }
 
+// RelateArtists is the resolver for the relateArtists field.
+func (r *mutationResolver) RelateArtists(ctx context.Context, artists []string) ([]*types.Artist, error) {
+	err := forEachPair(artists, func(artist1, artist2 string) error {
+		return r.DB.RelateArtists(ctx, data.ArtistID(artist1), data.ArtistID(artist2))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.artistsByID(ctx, artists)
+}
+
+// UnrelateArtists is the resolver for the unrelateArtists field.
+func (r *mutationResolver) UnrelateArtists(ctx context.Context, artists []string) ([]*types.Artist, error) {
+	err := forEachPair(artists, func(artist1, artist2 string) error {
+		return r.DB.UnrelateArtists(ctx, data.ArtistID(artist1), data.ArtistID(artist2))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.artistsByID(ctx, artists)
+}
+
 // Artists is the resolver for the artists field.
-func (r *queryResolver) Artists(ctx context.Context) ([]*types.Artist, error) {
-	return r.resolveArtists(r.DB.Artists(ctx, data.ArtistsFilters{}))
+func (r *queryResolver) Artists(ctx context.Context, first *int, after *string, filter *types.ArtistFilter, sort *types.ArtistSort) (*types.ArtistConnection, error) {
+	filters := data.ArtistsFilters{}
+	if filter != nil && filter.RelatedTo != nil {
+		filters.RelatedTo = data.ArtistID(*filter.RelatedTo)
+	}
+	artists, err := r.resolveArtists(r.DB.Artists(ctx, filters))
+	if err != nil {
+		return nil, err
+	}
+	sortArtists(artists, sort)
+	return newArtistConnection(artists, first, after)
 }
 
 // Artist is the resolver for the artist field.
@@ -52,8 +119,21 @@ func (r *queryResolver) Artist(ctx context.Context, id string) (*types.Artist, e
 }
 
 // Albums is the resolver for the albums field.
-func (r *queryResolver) Albums(ctx context.Context) ([]*types.Album, error) {
-	return r.resolveAlbums(r.DB.Albums(ctx, data.AlbumsFilters{}))
+func (r *queryResolver) Albums(ctx context.Context, first *int, after *string, filter *types.AlbumFilter, sort *types.AlbumSort) (*types.AlbumConnection, error) {
+	filters := data.AlbumsFilters{}
+	if filter != nil {
+		if filter.Artist != nil {
+			filters.Artist = data.ArtistID(*filter.Artist)
+		}
+		filters.YearFrom = valueOrZero(filter.YearFrom)
+		filters.YearTo = valueOrZero(filter.YearTo)
+	}
+	albums, err := r.resolveAlbums(r.DB.Albums(ctx, filters))
+	if err != nil {
+		return nil, err
+	}
+	sortAlbums(albums, sort)
+	return newAlbumConnection(albums, first, after)
 }
 
 // Album is the resolver for the album field.
@@ -64,8 +144,26 @@ func (r *queryResolver) Album(ctx context.Context, id string) (*types.Album, err
 }
 
 // Songs is the resolver for the songs field.
-func (r *queryResolver) Songs(ctx context.Context) ([]*types.Song, error) {
-	return r.resolveSongs(r.DB.Songs(ctx, data.SongsFilters{}))
+func (r *queryResolver) Songs(ctx context.Context, first *int, after *string, filter *types.SongFilter, sort *types.SongSort) (*types.SongConnection, error) {
+	filters := data.SongsFilters{}
+	if filter != nil {
+		if filter.Artist != nil {
+			filters.Artist = data.ArtistID(*filter.Artist)
+		}
+		if filter.Album != nil {
+			filters.Album = data.AlbumID(*filter.Album)
+		}
+		filters.YearFrom = valueOrZero(filter.YearFrom)
+		filters.YearTo = valueOrZero(filter.YearTo)
+		filters.Key = valueOrZero(filter.Key)
+		filters.Tag = valueOrZero(filter.Tag)
+	}
+	songs, err := r.resolveSongs(r.DB.Songs(ctx, filters))
+	if err != nil {
+		return nil, err
+	}
+	sortSongs(songs, sort)
+	return newSongConnection(songs, first, after)
 }
 
 // Song is the resolver for the song field.
@@ -75,18 +173,96 @@ func (r *queryResolver) Song(ctx context.Context, id string) (*types.Song, error
 	}))
 }
 
+// Search is the resolver for the search field.
+func (r *queryResolver) Search(ctx context.Context, query string) ([]types.SearchResult, error) {
+	results, err := r.DB.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var artistIDs, songIDs []string
+	for _, res := range results {
+		if res.Artist != "" {
+			artistIDs = append(artistIDs, string(res.Artist))
+		} else {
+			songIDs = append(songIDs, string(res.Song))
+		}
+	}
+	artists, err := r.loaders(ctx).artists.LoadAll(ctx, artistIDs)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := r.loaders(ctx).songs.LoadAll(ctx, songIDs)
+	if err != nil {
+		return nil, err
+	}
+	artistsByID := byID(artists, func(a *types.Artist) string { return a.ID })
+	songsByID := byID(songs, func(s *types.Song) string { return s.ID })
+
+	// Keep the results in order of relevance
+	found := make([]types.SearchResult, 0, len(results))
+	for _, res := range results {
+		if artist, ok := artistsByID[string(res.Artist)]; ok {
+			found = append(found, artist)
+		} else if song, ok := songsByID[string(res.Song)]; ok {
+			found = append(found, song)
+		}
+	}
+	return found, nil
+}
+
 // Artist is the resolver for the artist field.
 func (r *songResolver) Artist(ctx context.Context, obj *types.Song) (*types.Artist, error) {
-	return r.resolveArtist(r.DB.Artists(ctx, data.ArtistsFilters{
-		Song: data.SongID(obj.ID),
-	}))
+	if obj.ArtistID == "" {
+		return nil, nil
+	}
+	return r.loaders(ctx).artists.Load(ctx, obj.ArtistID)
 }
 
 // Album is the resolver for the album field.
 func (r *songResolver) Album(ctx context.Context, obj *types.Song) (*types.Album, error) {
-	return r.resolveAlbum(r.DB.Albums(ctx, data.AlbumsFilters{
-		Song: data.SongID(obj.ID),
-	}))
+	if obj.AlbumID == "" {
+		return nil, nil
+	}
+	return r.loaders(ctx).albums.Load(ctx, obj.AlbumID)
+}
+
+// Chords is the resolver for the chords field.
+func (r *songResolver) Chords(ctx context.Context, obj *types.Song) (string, error) {
+	return r.loaders(ctx).chords.Load(ctx, obj.ID)
+}
+
+// SongAdded is the resolver for the songAdded field.
+func (r *subscriptionResolver) SongAdded(ctx context.Context) (<-chan *types.Song, error) {
+	return r.songEvents(ctx, func(e events.Event) bool {
+		return e.Type == events.SongAdded
+	}), nil
+}
+
+// SongUpdated is the resolver for the songUpdated field.
+func (r *subscriptionResolver) SongUpdated(ctx context.Context, id string) (<-chan *types.Song, error) {
+	return r.songEvents(ctx, func(e events.Event) bool {
+		return e.Type == events.SongUpdated && (e.ID == id || e.OldID == id)
+	}), nil
+}
+
+// SongDeleted is the resolver for the songDeleted field.
+func (r *subscriptionResolver) SongDeleted(ctx context.Context) (<-chan string, error) {
+	ids := make(chan string)
+	go func() {
+		defer close(ids)
+		for e := range r.DB.Subscribe(ctx) {
+			if e.Type != events.SongDeleted {
+				continue
+			}
+			select {
+			case ids <- e.ID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ids, nil
 }
 
 // Album returns AlbumResolver implementation.
@@ -95,13 +271,21 @@ func (r *Resolver) Album() AlbumResolver { return &albumResolver{r} }
 // Artist returns ArtistResolver implementation.
 func (r *Resolver) Artist() ArtistResolver { return &artistResolver{r} }
 
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
 // Song returns SongResolver implementation.
 func (r *Resolver) Song() SongResolver { return &songResolver{r} }
 
+// Subscription returns SubscriptionResolver implementation.
+func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
+
 type albumResolver struct{ *Resolver }
 type artistResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type songResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
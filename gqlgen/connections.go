@@ -0,0 +1,164 @@
+package gqlgen
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/barrettj12/chords/gqlgen/types"
+	"github.com/barrettj12/chords/src/util"
+)
+
+// Helpers for Relay-style connections, which paginate lists of results.
+// Cursors are the node's ID, encoded so clients treat them as opaque.
+
+// paginate returns the page of nodes after the given cursor, with at most
+// first nodes. It returns the page, the cursor for each node in it, and the
+// page info.
+func paginate[T any](nodes []T, id func(T) string, first *int, after *string) ([]T, []string, *types.PageInfo, error) {
+	start := 0
+	if after != nil {
+		afterID, err := base64.RawURLEncoding.DecodeString(*after)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid cursor %q", *after)
+		}
+		i := slices.IndexFunc(nodes, func(node T) bool { return id(node) == string(afterID) })
+		if i == -1 {
+			return nil, nil, nil, fmt.Errorf("invalid cursor %q", *after)
+		}
+		start = i + 1
+	}
+
+	end := len(nodes)
+	if first != nil {
+		if *first < 0 {
+			return nil, nil, nil, fmt.Errorf("first must not be negative")
+		}
+		end = min(start+*first, end)
+	}
+
+	page := nodes[start:end]
+	cursors := make([]string, 0, len(page))
+	for _, node := range page {
+		cursors = append(cursors, base64.RawURLEncoding.EncodeToString([]byte(id(node))))
+	}
+	pageInfo := &types.PageInfo{HasNextPage: end < len(nodes)}
+	if len(cursors) > 0 {
+		pageInfo.EndCursor = &cursors[len(cursors)-1]
+	}
+	return page, cursors, pageInfo, nil
+}
+
+func newArtistConnection(artists []*types.Artist, first *int, after *string) (*types.ArtistConnection, error) {
+	page, cursors, pageInfo, err := paginate(artists, func(a *types.Artist) string { return a.ID }, first, after)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]*types.ArtistEdge, 0, len(page))
+	for i, artist := range page {
+		edges = append(edges, &types.ArtistEdge{Cursor: cursors[i], Node: artist})
+	}
+	return &types.ArtistConnection{
+		Edges:      edges,
+		Nodes:      page,
+		PageInfo:   pageInfo,
+		TotalCount: len(artists),
+	}, nil
+}
+
+func newAlbumConnection(albums []*types.Album, first *int, after *string) (*types.AlbumConnection, error) {
+	page, cursors, pageInfo, err := paginate(albums, func(a *types.Album) string { return a.ID }, first, after)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]*types.AlbumEdge, 0, len(page))
+	for i, album := range page {
+		edges = append(edges, &types.AlbumEdge{Cursor: cursors[i], Node: album})
+	}
+	return &types.AlbumConnection{
+		Edges:      edges,
+		Nodes:      page,
+		PageInfo:   pageInfo,
+		TotalCount: len(albums),
+	}, nil
+}
+
+func newSongConnection(songs []*types.Song, first *int, after *string) (*types.SongConnection, error) {
+	page, cursors, pageInfo, err := paginate(songs, func(s *types.Song) string { return s.ID }, first, after)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]*types.SongEdge, 0, len(page))
+	for i, song := range page {
+		edges = append(edges, &types.SongEdge{Cursor: cursors[i], Node: song})
+	}
+	return &types.SongConnection{
+		Edges:      edges,
+		Nodes:      page,
+		PageInfo:   pageInfo,
+		TotalCount: len(songs),
+	}, nil
+}
+
+// Sorting. Names are compared using util.LessTitle, so leading articles are
+// ignored. Ties are broken by ID, so the order (and hence the cursors) are
+// stable.
+
+// compareTitles compares two titles for sorting, using util.LessTitle.
+func compareTitles(title1, title2 string) int {
+	switch {
+	case util.LessTitle(title1, title2):
+		return -1
+	case util.LessTitle(title2, title1):
+		return 1
+	}
+	return 0
+}
+
+func sortArtists(artists []*types.Artist, order *types.ArtistSort) {
+	slices.SortFunc(artists, func(a, b *types.Artist) int {
+		if order == nil || *order == types.ArtistSortName {
+			if c := compareTitles(a.SortName, b.SortName); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func sortAlbums(albums []*types.Album, order *types.AlbumSort) {
+	slices.SortFunc(albums, func(a, b *types.Album) int {
+		if order != nil && *order == types.AlbumSortYear {
+			// Albums without a year go last
+			if c := cmp.Compare(yearOrMax(a.Year), yearOrMax(b.Year)); c != 0 {
+				return c
+			}
+		}
+		if order == nil || *order != types.AlbumSortID {
+			if c := compareTitles(a.SortName, b.SortName); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func yearOrMax(year *int) int {
+	if year == nil {
+		return math.MaxInt
+	}
+	return *year
+}
+
+func sortSongs(songs []*types.Song, order *types.SongSort) {
+	slices.SortFunc(songs, func(a, b *types.Song) int {
+		if order == nil || *order == types.SongSortName {
+			if c := compareTitles(a.Name, b.Name); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
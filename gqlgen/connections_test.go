@@ -0,0 +1,161 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// gqlgen/connections_test.go
+// Tests for pagination, filtering, sorting and search in the GraphQL API.
+
+package gqlgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLibrary(t *testing.T) *data.Library {
+	lib, err := data.LibraryFor(dblayer.NewTempDB())
+	assert.Nil(t, err)
+	ctx := context.Background()
+	for _, song := range []data.SongInput{
+		{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles", Album: "Help!", Key: "F"},
+		{ID: "LetItBe", Name: "Let It Be", Artist: "The Beatles", Album: "Let It Be", Key: "C", Tags: []string{"piano"}},
+		{ID: "AnotherDay", Name: "Another Day", Artist: "Paul McCartney", Key: "C"},
+		{ID: "TheLongAndWindingRoad", Name: "The Long and Winding Road", Artist: "The Beatles", Album: "Let It Be", Tags: []string{"piano"}},
+		{ID: "Blackbird", Name: "Blackbird", Artist: "The Beatles", Album: "The Beatles", Key: "G"},
+	} {
+		_, err := lib.AddSong(ctx, song)
+		assert.Nil(t, err)
+	}
+
+	for id, year := range map[data.AlbumID]int{"Help": 1965, "LetItBe": 1970, "TheBeatles": 1968} {
+		_, err := lib.UpdateAlbum(ctx, id, data.AlbumUpdate{Year: &year})
+		assert.Nil(t, err)
+	}
+	return lib
+}
+
+type songsResponse struct {
+	Songs struct {
+		Edges []struct {
+			Cursor string
+			Node   struct{ ID string }
+		}
+		PageInfo struct {
+			HasNextPage bool
+			EndCursor   string
+		}
+		TotalCount int
+	}
+}
+
+func (r songsResponse) ids() []string {
+	ids := []string{}
+	for _, edge := range r.Songs.Edges {
+		ids = append(ids, edge.Node.ID)
+	}
+	return ids
+}
+
+func TestPagination(t *testing.T) {
+	c := client.New(NewHandler(newTestLibrary(t), nil))
+	query := `query($after: String) {
+		songs(first: 2, after: $after) {
+			edges { cursor node { id } }
+			pageInfo { hasNextPage endCursor }
+			totalCount
+		}
+	}`
+
+	// Songs are sorted by name, ignoring "A", "An" and "The"
+	pages := [][]string{}
+	var after *string
+	for {
+		resp := songsResponse{}
+		c.MustPost(query, &resp, client.Var("after", after))
+		assert.Equal(t, 5, resp.Songs.TotalCount)
+		pages = append(pages, resp.ids())
+		if !resp.Songs.PageInfo.HasNextPage {
+			break
+		}
+		after = &resp.Songs.PageInfo.EndCursor
+	}
+	assert.Equal(t, [][]string{
+		{"AnotherDay", "Blackbird"},
+		{"LetItBe", "TheLongAndWindingRoad"},
+		{"Yesterday"},
+	}, pages)
+
+	resp := songsResponse{}
+	err := c.Post(`{ songs(after: "nope") { totalCount } }`, &resp)
+	assert.ErrorContains(t, err, "invalid cursor")
+}
+
+func TestFilters(t *testing.T) {
+	c := client.New(NewHandler(newTestLibrary(t), nil))
+	for filter, expected := range map[string][]string{
+		`{artist: "TheBeatles"}`:              {"Blackbird", "LetItBe", "TheLongAndWindingRoad", "Yesterday"},
+		`{album: "LetItBe"}`:                  {"LetItBe", "TheLongAndWindingRoad"},
+		`{yearFrom: 1966, yearTo: 1968}`:      {"Blackbird"},
+		`{yearFrom: 1968}`:                    {"Blackbird", "LetItBe", "TheLongAndWindingRoad"},
+		`{key: "C"}`:                          {"AnotherDay", "LetItBe"},
+		`{tag: "piano"}`:                      {"LetItBe", "TheLongAndWindingRoad"},
+		`{artist: "TheBeatles", key: "C"}`:    {"LetItBe"},
+		`{artist: "PaulMcCartney", tag: "x"}`: {},
+	} {
+		resp := songsResponse{}
+		c.MustPost(`{ songs(filter: `+filter+`) { edges { node { id } } totalCount } }`, &resp)
+		assert.Equal(t, expected, resp.ids(), "filter %s", filter)
+		assert.Equal(t, len(expected), resp.Songs.TotalCount, "filter %s", filter)
+	}
+
+	// Albums can be sorted by year
+	resp := struct {
+		Albums struct{ Nodes []struct{ ID string } }
+	}{}
+	c.MustPost(`{ albums(sort: YEAR, filter: {artist: "TheBeatles"}) { nodes { id } } }`, &resp)
+	ids := []string{}
+	for _, node := range resp.Albums.Nodes {
+		ids = append(ids, node.ID)
+	}
+	assert.Equal(t, []string{"Help", "TheBeatles", "LetItBe"}, ids)
+}
+
+func TestSearch(t *testing.T) {
+	c := client.New(NewHandler(newTestLibrary(t), nil))
+	resp := struct {
+		Search []struct {
+			Typename string `json:"__typename"`
+			ID       string
+		}
+	}{}
+	c.MustPost(`{
+		search(query: "beat") {
+			__typename
+			... on Artist { id }
+			... on Song { id }
+		}
+	}`, &resp)
+	if assert.NotEmpty(t, resp.Search) {
+		assert.Equal(t, "Artist", resp.Search[0].Typename)
+		assert.Equal(t, "TheBeatles", resp.Search[0].ID)
+	}
+
+	c.MustPost(`{
+		search(query: "black") {
+			__typename
+			... on Artist { id }
+			... on Song { id }
+		}
+	}`, &resp)
+	if assert.Len(t, resp.Search, 1) {
+		assert.Equal(t, "Song", resp.Search[0].Typename)
+		assert.Equal(t, "Blackbird", resp.Search[0].ID)
+	}
+}
@@ -0,0 +1,82 @@
+package gqlgen
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/barrettj12/chords/gqlgen/types"
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/metrics"
+)
+
+var (
+	operations = metrics.NewCounter("chords_graphql_operations_total",
+		"GraphQL operations executed.", "type", "status")
+	operationDuration = metrics.NewHistogram("chords_graphql_operation_duration_seconds",
+		"Time taken by GraphQL queries and mutations.", metrics.DefaultBuckets, "type")
+)
+
+// NewHandler creates the HTTP handler for the GraphQL API, resolved using
+// the given database. Operations exceeding the limits are rejected; if
+// limits is nil, there are no limits.
+func NewHandler(db data.ChordsDBv1, limits *Limits) *handler.Server {
+	resolver := &Resolver{DB: db}
+	srv := handler.NewDefaultServer(NewExecutableSchema(Config{
+		Resolvers: resolver,
+		Directives: DirectiveRoot{
+			Authorised: authorised,
+		},
+		Complexity: complexityRoot(),
+	}))
+	srv.Use(&limitsExtension{limits: limits})
+	srv.AroundResponses(resolver.withLoaders)
+	srv.AroundOperations(observeOperation)
+	return srv
+}
+
+// observeOperation records metrics for each operation, labelled with its
+// type. Operation names aren't recorded, as clients can choose any name,
+// which would make an unbounded number of series. Subscriptions are only
+// counted, as they last until the client disconnects.
+func observeOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	oc := graphql.GetOperationContext(ctx)
+	typ := "unknown"
+	if oc.Operation != nil {
+		typ = string(oc.Operation.Operation)
+	}
+
+	start := time.Now()
+	respond := next(ctx)
+	if typ == "subscription" {
+		operations.Inc(typ, "ok")
+		return respond
+	}
+	return func(ctx context.Context) *graphql.Response {
+		resp := respond(ctx)
+		status := "ok"
+		if resp != nil && len(resp.Errors) > 0 {
+			status = "error"
+		}
+		operations.Inc(typ, status)
+		operationDuration.ObserveSince(start, typ)
+		return resp
+	}
+}
+
+// authorised implements the @authorised directive, which only allows the
+// field to be resolved if the request's user (see auth.WithUser) has the
+// given role, and scope if they used an API token.
+func authorised(ctx context.Context, _ any, next graphql.Resolver, role types.Role, scope string) (any, error) {
+	err := auth.UserFrom(ctx).Authorise(auth.Role(strings.ToLower(string(role))), auth.Scope(scope))
+	if errors.Is(err, auth.ErrForbidden) {
+		return nil, err
+	} else if err != nil {
+		return nil, errors.New("unauthorised")
+	}
+	return next(ctx)
+}
@@ -0,0 +1,31 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// gqlgen/handler_test.go
+// Tests for the GraphQL handler's metrics.
+
+package gqlgen
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/barrettj12/chords/src/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationMetrics(t *testing.T) {
+	c := client.New(NewHandler(newTestLibrary(t), nil))
+	resp := map[string]any{}
+	assert.Nil(t, c.Post(`query ChosenByTheClient { artists { nodes { name } } }`, &resp))
+
+	w := httptest.NewRecorder()
+	metrics.Default.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `chords_graphql_operations_total{type="query",status="ok"}`)
+	// Clients choose operation names, so they aren't labels
+	assert.NotContains(t, w.Body.String(), "ChosenByTheClient")
+}
@@ -0,0 +1,139 @@
+package gqlgen
+
+import (
+	"context"
+	"strings"
+
+	"github.com/99designs/gqlgen/complexity"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/barrettj12/chords/gqlgen/types"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Limits stop clients from sending queries which are too expensive to
+// resolve, e.g. artists nested in relatedArtists many times over. Zero means
+// no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting of fields in an operation.
+	// Introspection fields (e.g. __schema) aren't counted.
+	MaxDepth int
+	// MaxComplexity is the maximum estimated cost of an operation. Each
+	// field costs 1, and lists cost their items' complexity times the
+	// number of items expected - see complexityRoot.
+	MaxComplexity int
+}
+
+// The number of items assumed to be in lists, for estimating complexity.
+const (
+	// nestedListSize is for lists within other types, like an artist's
+	// albums, and search results.
+	nestedListSize = 10
+	// unpaginatedSize is for connections queried without `first`.
+	unpaginatedSize = 100
+)
+
+// complexityRoot estimates the complexity of fields which return lists.
+// Other fields use gqlgen's default: 1 plus the complexity of their
+// selections.
+func complexityRoot() ComplexityRoot {
+	c := ComplexityRoot{}
+	list := func(childComplexity int) int {
+		return 1 + nestedListSize*childComplexity
+	}
+	c.Artist.Albums = list
+	c.Artist.RelatedArtists = list
+	c.Album.Songs = list
+	c.Query.Search = func(childComplexity int, _ string) int {
+		return list(childComplexity)
+	}
+
+	c.Query.Artists = func(childComplexity int, first *int, _ *string, _ *types.ArtistFilter, _ *types.ArtistSort) int {
+		return page(childComplexity, first)
+	}
+	c.Query.Albums = func(childComplexity int, first *int, _ *string, _ *types.AlbumFilter, _ *types.AlbumSort) int {
+		return page(childComplexity, first)
+	}
+	c.Query.Songs = func(childComplexity int, first *int, _ *string, _ *types.SongFilter, _ *types.SongSort) int {
+		return page(childComplexity, first)
+	}
+	return c
+}
+
+// page estimates the complexity of a connection, whose page has first
+// nodes.
+func page(childComplexity int, first *int) int {
+	size := unpaginatedSize
+	if first != nil && *first >= 0 {
+		size = *first
+	}
+	return 1 + size*childComplexity
+}
+
+// limitsExtension rejects operations which exceed the limits, before they
+// are executed. The limits are read for each operation, so they can be set
+// after the handler is created.
+type limitsExtension struct {
+	limits *Limits
+	es     graphql.ExecutableSchema
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = &limitsExtension{}
+
+func (e *limitsExtension) ExtensionName() string {
+	return "Limits"
+}
+
+func (e *limitsExtension) Validate(es graphql.ExecutableSchema) error {
+	e.es = es
+	return nil
+}
+
+func (e *limitsExtension) MutateOperationContext(_ context.Context, oc *graphql.OperationContext) *gqlerror.Error {
+	if e.limits == nil || oc.Operation == nil {
+		return nil
+	}
+	if limit := e.limits.MaxDepth; limit > 0 {
+		if d := depth(oc.Operation.SelectionSet); d > limit {
+			return limitError("DEPTH_LIMIT_EXCEEDED", "operation has depth %d, which exceeds the limit of %d", d, limit)
+		}
+	}
+	if limit := e.limits.MaxComplexity; limit > 0 {
+		if c := complexity.Calculate(e.es, oc.Operation, oc.Variables); c > limit {
+			return limitError("COMPLEXITY_LIMIT_EXCEEDED", "operation has complexity %d, which exceeds the limit of %d", c, limit)
+		}
+	}
+	return nil
+}
+
+func limitError(code, format string, args ...any) *gqlerror.Error {
+	err := gqlerror.Errorf(format, args...)
+	err.Extensions = map[string]any{"code": code}
+	return err
+}
+
+// depth returns how deeply fields are nested in the selection set,
+// following fragments. Fragments can't be cyclic, as the query has already
+// been validated.
+func depth(set ast.SelectionSet) int {
+	deepest := 0
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name, "__") {
+				continue
+			}
+			deepest = max(deepest, 1+depth(sel.SelectionSet))
+		case *ast.InlineFragment:
+			deepest = max(deepest, depth(sel.SelectionSet))
+		case *ast.FragmentSpread:
+			if sel.Definition != nil {
+				deepest = max(deepest, depth(sel.Definition.SelectionSet))
+			}
+		}
+	}
+	return deepest
+}
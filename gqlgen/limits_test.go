@@ -0,0 +1,76 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// gqlgen/limits_test.go
+// Tests for the GraphQL depth and complexity limits.
+
+package gqlgen
+
+import (
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxDepth: 10, MaxComplexity: 50000}
+	c := client.New(NewHandler(newTestLibrary(t), &limits))
+
+	for _, test := range []struct {
+		name, query string
+		err         string
+	}{{
+		name:  "shallow",
+		query: `{ artists { nodes { name albums { name songs { name } } } } }`,
+	}, {
+		// Depth 11, but the complexity is within the limit
+		name: "deep",
+		query: `{ artist(id: "TheBeatles") { relatedArtists { relatedArtists { relatedArtists {
+			relatedArtists { relatedArtists { relatedArtists { relatedArtists { relatedArtists {
+			relatedArtists { name } } } } } } } } } } }`,
+		err: "operation has depth 11, which exceeds the limit of 10",
+	}, {
+		// Fragments are followed
+		name: "fragments",
+		query: `{ artist(id: "TheBeatles") { ...related } }
+			fragment related on Artist { relatedArtists { relatedArtists { relatedArtists {
+			relatedArtists { relatedArtists { relatedArtists { relatedArtists { relatedArtists {
+			relatedArtists { name } } } } } } } } } }`,
+		err: "operation has depth 11",
+	}, {
+		// 100 (unpaginated) * 10 * 10 * 10 related artists
+		name:  "complex",
+		query: `{ artists { nodes { relatedArtists { relatedArtists { relatedArtists { name } } } } } }`,
+		err:   "operation has complexity 111201, which exceeds the limit of 50000",
+	}, {
+		name:  "paginated",
+		query: `{ artists(first: 5) { nodes { relatedArtists { relatedArtists { relatedArtists { name } } } } } }`,
+	}, {
+		// Introspection isn't counted, so tools like the playground work
+		name: "introspection",
+		query: `{ __schema { types { name fields { name type { ...typeRef } } } } }
+			fragment typeRef on __Type { kind name ofType { kind name ofType { kind name
+			ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := map[string]any{}
+			err := c.Post(test.query, &resp)
+			if test.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.err)
+			}
+		})
+	}
+
+	// Limits can be changed after the handler is created
+	limits.MaxDepth = 0
+	limits.MaxComplexity = 0
+	resp := map[string]any{}
+	err := c.Post(`{ artists { nodes { relatedArtists { relatedArtists { relatedArtists { name } } } } } }`, &resp)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,200 @@
+package gqlgen
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/barrettj12/chords/gqlgen/types"
+	"github.com/barrettj12/chords/src/data"
+)
+
+// Dataloaders for the resolvers. The resolvers for nested fields (e.g. the
+// songs on each album) run concurrently, so rather than each making its own
+// call to the DB, they ask a loader for the objects they need. The loader
+// collects the IDs requested over a short window, and fetches them all with
+// one call to the DB. A new set of loaders is created for each response, so
+// the results are cached while building the response. (A subscription sends
+// a response for each event, and each one needs fresh data.)
+
+// loaderWait is how long a loader waits to collect IDs before fetching them.
+// If more IDs arrive while waiting, it waits again, up to maxLoaderWaits
+// times.
+const (
+	loaderWait     = time.Millisecond
+	maxLoaderWaits = 10
+)
+
+// loader batches loads of values by key.
+type loader[K comparable, V any] struct {
+	// fetch gets the values for the given keys. Keys which aren't in the
+	// returned map are treated as not found.
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu    sync.Mutex
+	cache map[K]*batch[K, V]
+	// next is the batch currently collecting keys, if any.
+	next *batch[K, V]
+}
+
+type batch[K comparable, V any] struct {
+	keys   []K
+	done   chan struct{}
+	values map[K]V
+	err    error
+}
+
+func newLoader[K comparable, V any](fetch func(context.Context, []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{
+		fetch: fetch,
+		cache: map[K]*batch[K, V]{},
+	}
+}
+
+// Load gets the value for the given key. If it isn't found, the zero value
+// is returned.
+func (l *loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	values, err := l.LoadAll(ctx, []K{key})
+	if err != nil || len(values) == 0 {
+		var zero V
+		return zero, err
+	}
+	return values[0], nil
+}
+
+// LoadAll gets the values for the given keys, in the same order. Keys which
+// aren't found are skipped.
+func (l *loader[K, V]) LoadAll(ctx context.Context, keys []K) ([]V, error) {
+	batches := make([]*batch[K, V], len(keys))
+	l.mu.Lock()
+	for i, key := range keys {
+		b, ok := l.cache[key]
+		if !ok {
+			if l.next == nil {
+				l.next = &batch[K, V]{done: make(chan struct{})}
+				go l.run(ctx, l.next)
+			}
+			b = l.next
+			b.keys = append(b.keys, key)
+			l.cache[key] = b
+		}
+		batches[i] = b
+	}
+	l.mu.Unlock()
+
+	values := make([]V, 0, len(keys))
+	for i, b := range batches {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+		if v, ok := b.values[keys[i]]; ok {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// run waits for more keys to be added to the batch, then fetches them.
+func (l *loader[K, V]) run(ctx context.Context, b *batch[K, V]) {
+	for waits, numKeys := 1, 0; ; waits++ {
+		time.Sleep(loaderWait)
+		l.mu.Lock()
+		if len(b.keys) == numKeys || waits == maxLoaderWaits {
+			l.next = nil
+			l.mu.Unlock()
+			break
+		}
+		numKeys = len(b.keys)
+		l.mu.Unlock()
+	}
+
+	b.values, b.err = l.fetch(ctx, b.keys)
+	close(b.done)
+}
+
+// loaders holds the dataloaders for one response.
+type loaders struct {
+	artists *loader[string, *types.Artist]
+	albums  *loader[string, *types.Album]
+	songs   *loader[string, *types.Song]
+	chords  *loader[string, string]
+}
+
+func (r *Resolver) newLoaders() *loaders {
+	return &loaders{
+		artists: newLoader(func(ctx context.Context, ids []string) (map[string]*types.Artist, error) {
+			artists, err := r.resolveArtists(r.DB.Artists(ctx, data.ArtistsFilters{
+				IDs: toIDs[data.ArtistID](ids),
+			}))
+			return byID(artists, func(a *types.Artist) string { return a.ID }), err
+		}),
+		albums: newLoader(func(ctx context.Context, ids []string) (map[string]*types.Album, error) {
+			albums, err := r.resolveAlbums(r.DB.Albums(ctx, data.AlbumsFilters{
+				IDs: toIDs[data.AlbumID](ids),
+			}))
+			return byID(albums, func(a *types.Album) string { return a.ID }), err
+		}),
+		songs: newLoader(func(ctx context.Context, ids []string) (map[string]*types.Song, error) {
+			songs, err := r.resolveSongs(r.DB.Songs(ctx, data.SongsFilters{
+				IDs: toIDs[data.SongID](ids),
+			}))
+			return byID(songs, func(s *types.Song) string { return s.ID }), err
+		}),
+		chords: newLoader(func(ctx context.Context, ids []string) (map[string]string, error) {
+			songs, err := r.DB.Songs(ctx, data.SongsFilters{
+				IDs:        toIDs[data.SongID](ids),
+				WithChords: true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			chords := make(map[string]string, len(songs))
+			for _, song := range songs {
+				chords[string(song.ID)] = string(song.Chords)
+			}
+			return chords, nil
+		}),
+	}
+}
+
+type loadersKey struct{}
+
+// withLoaders is a response middleware which adds a new set of loaders to
+// the context of each response.
+func (r *Resolver) withLoaders(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	return next(context.WithValue(ctx, loadersKey{}, r.newLoaders()))
+}
+
+// loaders returns the loaders for the current response. If there are none
+// (e.g. the schema is used without the handler from NewHandler), new
+// loaders are created, which won't be shared with other resolvers.
+func (r *Resolver) loaders(ctx context.Context) *loaders {
+	if l, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return l
+	}
+	return r.newLoaders()
+}
+
+// toIDs converts a list of IDs to the given ID type.
+func toIDs[ID ~string](ids []string) []ID {
+	converted := make([]ID, 0, len(ids))
+	for _, id := range ids {
+		converted = append(converted, ID(id))
+	}
+	return converted
+}
+
+// byID indexes a list of objects by ID.
+func byID[T any](objects []T, id func(T) string) map[string]T {
+	m := make(map[string]T, len(objects))
+	for _, obj := range objects {
+		m[id(obj)] = obj
+	}
+	return m
+}
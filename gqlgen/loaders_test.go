@@ -0,0 +1,170 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// gqlgen/loaders_test.go
+// Tests and benchmarks for batch loading in the GraphQL resolvers.
+
+package gqlgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/stretchr/testify/assert"
+)
+
+// Query for all the data, nested a few levels deep.
+const libraryQuery = `{
+	artists {
+		nodes {
+			name
+			albums {
+				name
+				songs { name trackNum chords artist { name } }
+			}
+			relatedArtists { name }
+		}
+	}
+}`
+
+type libraryResponse struct {
+	Artists struct {
+		Nodes []struct {
+			Name   string
+			Albums []struct {
+				Name  string
+				Songs []struct {
+					Name     string
+					TrackNum int
+					Chords   string
+					Artist   struct{ Name string }
+				}
+			}
+			RelatedArtists []struct{ Name string }
+		}
+	}
+}
+
+// countingDB counts the calls to the read methods.
+type countingDB struct {
+	data.ChordsDBv1
+	calls atomic.Int64
+}
+
+func (c *countingDB) Artists(ctx context.Context, f data.ArtistsFilters) ([]data.Artist, error) {
+	c.calls.Add(1)
+	return c.ChordsDBv1.Artists(ctx, f)
+}
+
+func (c *countingDB) Albums(ctx context.Context, f data.AlbumsFilters) ([]data.Album, error) {
+	c.calls.Add(1)
+	return c.ChordsDBv1.Albums(ctx, f)
+}
+
+func (c *countingDB) Songs(ctx context.Context, f data.SongsFilters) ([]data.Song, error) {
+	c.calls.Add(1)
+	return c.ChordsDBv1.Songs(ctx, f)
+}
+
+// generateLibrary creates a local filesystem database with the given number
+// of artists, each with 4 albums of 10 songs.
+func generateLibrary(t testing.TB, numArtists int) *data.Library {
+	dir := t.TempDir()
+	db := dblayer.NewLocalfs(dir, log.New(io.Discard, "", 0))
+	lib, err := data.NewLibrary(db, data.NewDBCatalogue(db))
+	assert.Nil(t, err)
+
+	for art := 0; art < numArtists; art++ {
+		artist := fmt.Sprintf("Artist %d", art)
+		for alb := 0; alb < 4; alb++ {
+			for sng := 0; sng < 10; sng++ {
+				_, err := lib.AddSong(context.Background(), data.SongInput{
+					ID:       data.SongID(fmt.Sprintf("A%dB%dS%d", art, alb, sng)),
+					Name:     fmt.Sprintf("Song %d", sng),
+					Artist:   artist,
+					Album:    fmt.Sprintf("Album %d", alb),
+					TrackNum: sng + 1,
+					Chords:   []byte("C - F - G - C"),
+				})
+				assert.Nil(t, err)
+			}
+		}
+		if art > 0 {
+			err = db.AddRelation(artist, fmt.Sprintf("Artist %d", art-1))
+			assert.Nil(t, err)
+		}
+	}
+	return lib
+}
+
+func TestBatchLoading(t *testing.T) {
+	db := &countingDB{ChordsDBv1: generateLibrary(t, 5)}
+	c := client.New(NewHandler(db, nil))
+
+	resp := libraryResponse{}
+	c.MustPost(libraryQuery, &resp)
+	if assert.Len(t, resp.Artists.Nodes, 5) {
+		artist := resp.Artists.Nodes[1]
+		assert.Equal(t, "Artist 1", artist.Name)
+		assert.Len(t, artist.Albums, 4)
+		assert.Len(t, artist.RelatedArtists, 2)
+		if assert.Len(t, artist.Albums[0].Songs, 10) {
+			song := artist.Albums[0].Songs[0]
+			assert.Equal(t, 1, song.TrackNum)
+			assert.Equal(t, "C - F - G - C", song.Chords)
+			assert.Equal(t, "Artist 1", song.Artist.Name)
+		}
+	}
+
+	// Loads are batched, so there should be a call for the artists, then
+	// about one per nested field (the related artists, albums, songs and
+	// chords). Without batching, there would be hundreds of calls.
+	assert.LessOrEqual(t, db.calls.Load(), int64(10))
+}
+
+// BenchmarkRelatedArtists queries the related artists of 100 artists, two
+// levels deep.
+func BenchmarkRelatedArtists(b *testing.B) {
+	lib := generateLibrary(b, 100)
+	c := client.New(NewHandler(lib, nil))
+	query := `{ artists { nodes { name relatedArtists { name relatedArtists { name } } } } }`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var resp struct {
+			Artists struct {
+				Nodes []struct {
+					Name           string
+					RelatedArtists []struct {
+						Name           string
+						RelatedArtists []struct{ Name string }
+					}
+				}
+			}
+		}
+		c.MustPost(query, &resp)
+	}
+}
+
+// BenchmarkLibraryQuery queries 4000 songs, with their albums, artists and
+// chords.
+func BenchmarkLibraryQuery(b *testing.B) {
+	lib := generateLibrary(b, 100)
+	c := client.New(NewHandler(lib, nil))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := libraryResponse{}
+		c.MustPost(libraryQuery, &resp)
+	}
+}
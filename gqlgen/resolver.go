@@ -1,8 +1,12 @@
 package gqlgen
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/barrettj12/chords/gqlgen/types"
 	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/events"
 )
 
 // Resolver is the base class embedded inside all the GraphQL resolvers.
@@ -94,32 +98,199 @@ func (r *Resolver) resolveSongs(songsData []data.Song, err error) ([]*types.Song
 	return songs, nil
 }
 
+// resolveUpdatedArtist converts an artist returned by a mutation into a
+// *types.Artist, and also handles errors from the DB.
+func (r *Resolver) resolveUpdatedArtist(artist data.Artist, err error) (*types.Artist, error) {
+	if err != nil {
+		return nil, err
+	}
+	return r.translateArtist(artist), nil
+}
+
+// resolveUpdatedAlbum converts an album returned by a mutation into a
+// *types.Album, and also handles errors from the DB.
+func (r *Resolver) resolveUpdatedAlbum(album data.Album, err error) (*types.Album, error) {
+	if err != nil {
+		return nil, err
+	}
+	return r.translateAlbum(album), nil
+}
+
+// resolveUpdatedSong converts a song returned by a mutation into a
+// *types.Song, and also handles errors from the DB.
+func (r *Resolver) resolveUpdatedSong(song data.Song, err error) (*types.Song, error) {
+	if err != nil {
+		return nil, err
+	}
+	return r.translateSong(song), nil
+}
+
+// artistsByID gets the artists with the given IDs, in the same order.
+func (r *Resolver) artistsByID(ctx context.Context, ids []string) ([]*types.Artist, error) {
+	artists := make([]*types.Artist, 0, len(ids))
+	for _, id := range ids {
+		artist, err := r.resolveArtist(r.DB.Artists(ctx, data.ArtistsFilters{
+			ID: data.ArtistID(id),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		if artist == nil {
+			return nil, fmt.Errorf("no artist found for id %s", id)
+		}
+		artists = append(artists, artist)
+	}
+	return artists, nil
+}
+
+// songEvents sends the current version of the song for each event matching
+// match, until ctx is done.
+func (r *Resolver) songEvents(ctx context.Context, match func(events.Event) bool) <-chan *types.Song {
+	songs := make(chan *types.Song)
+	go func() {
+		defer close(songs)
+		for e := range r.DB.Subscribe(ctx) {
+			if !match(e) {
+				continue
+			}
+			// The song may have changed again (or been deleted) since the
+			// event, so skip it if it can't be found.
+			song, err := r.resolveSong(r.DB.Songs(ctx, data.SongsFilters{
+				ID: data.SongID(e.ID),
+			}))
+			if err != nil || song == nil {
+				continue
+			}
+			select {
+			case songs <- song:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return songs
+}
+
+// forEachPair calls f for each pair of distinct IDs, stopping at the first
+// error.
+func forEachPair(ids []string, f func(id1, id2 string) error) error {
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if ids[i] == ids[j] {
+				continue
+			}
+			if err := f(ids[i], ids[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Stateless methods which simply translate the types from the data package
 // into corresponding GraphQL API types.
 
 // translateArtist converts a data.Artist into a *types.Artist.
 func (r *Resolver) translateArtist(artist data.Artist) *types.Artist {
 	return &types.Artist{
-		ID:   string(artist.ID),
-		Name: artist.Name,
+		ID:               string(artist.ID),
+		Name:             artist.Name,
+		SortName:         sortName(artist.Name, artist.SortName),
+		Aliases:          nonNil(artist.Aliases),
+		AlbumIDs:         fromIDs(artist.Albums),
+		RelatedArtistIDs: fromIDs(artist.RelatedArtists),
 	}
 }
 
 // translateAlbum converts a data.Album into a *types.Album.
 func (r *Resolver) translateAlbum(album data.Album) *types.Album {
-	return &types.Album{
-		ID:   string(album.ID),
-		Name: album.Name,
-		Year: &album.Year,
+	gqlAlbum := &types.Album{
+		ID:       string(album.ID),
+		Name:     album.Name,
+		SortName: sortName(album.Name, album.SortName),
+		Aliases:  nonNil(album.Aliases),
+		ArtistID: string(album.Artist),
+		SongIDs:  fromIDs(album.Songs),
+	}
+	if album.Year != 0 {
+		gqlAlbum.Year = &album.Year
 	}
+	return gqlAlbum
 }
 
-// translateSong converts a data.Song into a *types.Song.
+// translateSong converts a data.Song into a *types.Song. The chords are
+// resolved separately, so they are only loaded if requested.
 func (r *Resolver) translateSong(song data.Song) *types.Song {
-	return &types.Song{
+	gqlSong := &types.Song{
 		ID:       string(song.ID),
 		Name:     song.Name,
 		TrackNum: &song.TrackNum,
-		Chords:   string(song.Chords),
+		Tags:     nonNil(song.Tags),
+		ArtistID: string(song.Artist),
+		AlbumID:  string(song.Album),
+	}
+	if song.Key != "" {
+		gqlSong.Key = &song.Key
+	}
+	return gqlSong
+}
+
+// translateSongInput converts a types.SongInput into a data.SongInput.
+func translateSongInput(song types.SongInput) data.SongInput {
+	in := data.SongInput{
+		Name:   song.Name,
+		Artist: song.Artist,
+	}
+	if song.ID != nil {
+		in.ID = data.SongID(*song.ID)
+	}
+	if song.Album != nil {
+		in.Album = *song.Album
+	}
+	if song.TrackNum != nil {
+		in.TrackNum = *song.TrackNum
+	}
+	if song.Key != nil {
+		in.Key = *song.Key
+	}
+	in.Tags = song.Tags
+	if song.Chords != nil {
+		in.Chords = []byte(*song.Chords)
+	}
+	return in
+}
+
+// sortName returns the name to sort by, which defaults to the name.
+func sortName(name, sortName string) string {
+	if sortName == "" {
+		return name
+	}
+	return sortName
+}
+
+// fromIDs converts a list of IDs to strings.
+func fromIDs[ID ~string](ids []ID) []string {
+	converted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		converted = append(converted, string(id))
+	}
+	return converted
+}
+
+// valueOrZero returns the value of an optional argument, or the zero value
+// if it wasn't given.
+func valueOrZero[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
+
+// nonNil returns an empty slice instead of nil, for non-nullable lists.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
 	}
+	return s
 }
@@ -0,0 +1,101 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// gqlgen/subscriptions_test.go
+// Tests for GraphQL subscriptions.
+
+package gqlgen
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// keepWriting calls write repeatedly until the returned stop function is
+// called. Subscriptions are set up asynchronously, so this makes sure a
+// change happens after the subscription has started.
+func keepWriting(t *testing.T, write func(i int) error) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			assert.Nil(t, write(i))
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func TestSongUpdated(t *testing.T) {
+	lib := newTestLibrary(t)
+	c := client.New(NewHandler(lib, nil))
+	sub := c.Websocket(`subscription { songUpdated(id: "Yesterday") { id chords } }`)
+	defer sub.Close()
+
+	// Changes to other songs shouldn't be sent
+	stopOther := keepWriting(t, func(i int) error {
+		_, err := lib.UpdateChords(context.Background(), "LetItBe", []byte("other"))
+		return err
+	})
+	defer stopOther()
+	stop := keepWriting(t, func(i int) error {
+		_, err := lib.UpdateChords(context.Background(), "Yesterday", []byte(fmt.Sprint("F Em7 A7 Dm ", i)))
+		return err
+	})
+
+	resp := struct {
+		SongUpdated struct{ ID, Chords string }
+	}{}
+	err := sub.Next(&resp)
+	stop()
+	assert.Nil(t, err)
+	assert.Equal(t, "Yesterday", resp.SongUpdated.ID)
+	assert.Contains(t, resp.SongUpdated.Chords, "F Em7 A7 Dm")
+}
+
+func TestSongAddedDeleted(t *testing.T) {
+	lib := newTestLibrary(t)
+	c := client.New(NewHandler(lib, nil))
+	added := c.Websocket(`subscription { songAdded { id name artist { id } } }`)
+	defer added.Close()
+	deleted := c.Websocket(`subscription { songDeleted }`)
+	defer deleted.Close()
+
+	// Add a new song each time, and delete the previous one
+	stop := keepWriting(t, func(i int) error {
+		_, err := lib.AddSong(context.Background(), data.SongInput{
+			ID: data.SongID(fmt.Sprint("Michelle", i)), Name: "Michelle", Artist: "The Beatles",
+		})
+		if err != nil || i == 0 {
+			return err
+		}
+		return lib.DeleteSong(context.Background(), data.SongID(fmt.Sprint("Michelle", i-1)))
+	})
+	defer stop()
+
+	addedResp := struct {
+		SongAdded struct {
+			ID, Name string
+			Artist   struct{ ID string }
+		}
+	}{}
+	assert.Nil(t, added.Next(&addedResp))
+	assert.Equal(t, "Michelle", addedResp.SongAdded.Name)
+	assert.Equal(t, "TheBeatles", addedResp.SongAdded.Artist.ID)
+
+	deletedResp := struct{ SongDeleted string }{}
+	assert.Nil(t, deleted.Next(&deletedResp))
+	assert.Regexp(t, "^Michelle[0-9]+$", deletedResp.SongDeleted)
+}
@@ -2,26 +2,306 @@
 
 package types
 
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type SearchResult interface {
+	IsSearchResult()
+}
+
 type Album struct {
-	ID     string  `json:"id"`
-	Name   string  `json:"name"`
-	Year   *int    `json:"year,omitempty"`
-	Artist *Artist `json:"artist"`
-	Songs  []*Song `json:"songs"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	SortName string   `json:"sortName"`
+	Aliases  []string `json:"aliases"`
+	Year     *int     `json:"year,omitempty"`
+	Artist   *Artist  `json:"artist"`
+	Songs    []*Song  `json:"songs"`
+	ArtistID string   `json:"-"`
+	SongIDs  []string `json:"-"`
+}
+
+type AlbumConnection struct {
+	Edges      []*AlbumEdge `json:"edges"`
+	Nodes      []*Album     `json:"nodes"`
+	PageInfo   *PageInfo    `json:"pageInfo"`
+	TotalCount int          `json:"totalCount"`
+}
+
+type AlbumEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Album `json:"node"`
+}
+
+type AlbumFilter struct {
+	Artist   *string `json:"artist,omitempty"`
+	YearFrom *int    `json:"yearFrom,omitempty"`
+	YearTo   *int    `json:"yearTo,omitempty"`
+}
+
+type AlbumInput struct {
+	Name     *string  `json:"name,omitempty"`
+	SortName *string  `json:"sortName,omitempty"`
+	Year     *int     `json:"year,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
 }
 
 type Artist struct {
-	ID             string    `json:"id"`
-	Name           string    `json:"name"`
-	Albums         []*Album  `json:"albums"`
-	RelatedArtists []*Artist `json:"relatedArtists"`
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	SortName         string    `json:"sortName"`
+	Aliases          []string  `json:"aliases"`
+	Albums           []*Album  `json:"albums"`
+	RelatedArtists   []*Artist `json:"relatedArtists"`
+	AlbumIDs         []string  `json:"-"`
+	RelatedArtistIDs []string  `json:"-"`
+}
+
+func (Artist) IsSearchResult() {}
+
+type ArtistConnection struct {
+	Edges      []*ArtistEdge `json:"edges"`
+	Nodes      []*Artist     `json:"nodes"`
+	PageInfo   *PageInfo     `json:"pageInfo"`
+	TotalCount int           `json:"totalCount"`
+}
+
+type ArtistEdge struct {
+	Cursor string  `json:"cursor"`
+	Node   *Artist `json:"node"`
+}
+
+type ArtistFilter struct {
+	RelatedTo *string `json:"relatedTo,omitempty"`
+}
+
+type ArtistInput struct {
+	Name     *string  `json:"name,omitempty"`
+	SortName *string  `json:"sortName,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
 }
 
 type Song struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Artist   *Artist `json:"artist,omitempty"`
-	Album    *Album  `json:"album,omitempty"`
-	TrackNum *int    `json:"trackNum,omitempty"`
-	Chords   string  `json:"chords"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Artist   *Artist  `json:"artist,omitempty"`
+	Album    *Album   `json:"album,omitempty"`
+	TrackNum *int     `json:"trackNum,omitempty"`
+	Key      *string  `json:"key,omitempty"`
+	Tags     []string `json:"tags"`
+	Chords   string   `json:"chords"`
+	AlbumID  string   `json:"-"`
+	ArtistID string   `json:"-"`
+}
+
+func (Song) IsSearchResult() {}
+
+type SongConnection struct {
+	Edges      []*SongEdge `json:"edges"`
+	Nodes      []*Song     `json:"nodes"`
+	PageInfo   *PageInfo   `json:"pageInfo"`
+	TotalCount int         `json:"totalCount"`
+}
+
+type SongEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Song  `json:"node"`
+}
+
+type SongFilter struct {
+	Artist   *string `json:"artist,omitempty"`
+	Album    *string `json:"album,omitempty"`
+	YearFrom *int    `json:"yearFrom,omitempty"`
+	YearTo   *int    `json:"yearTo,omitempty"`
+	Key      *string `json:"key,omitempty"`
+	Tag      *string `json:"tag,omitempty"`
+}
+
+type SongInput struct {
+	ID       *string  `json:"id,omitempty"`
+	Name     string   `json:"name"`
+	Artist   string   `json:"artist"`
+	Album    *string  `json:"album,omitempty"`
+	TrackNum *int     `json:"trackNum,omitempty"`
+	Key      *string  `json:"key,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Chords   *string  `json:"chords,omitempty"`
+}
+
+type AlbumSort string
+
+const (
+	AlbumSortName AlbumSort = "NAME"
+	AlbumSortYear AlbumSort = "YEAR"
+	AlbumSortID   AlbumSort = "ID"
+)
+
+var AllAlbumSort = []AlbumSort{
+	AlbumSortName,
+	AlbumSortYear,
+	AlbumSortID,
+}
+
+func (e AlbumSort) IsValid() bool {
+	switch e {
+	case AlbumSortName, AlbumSortYear, AlbumSortID:
+		return true
+	}
+	return false
+}
+
+func (e AlbumSort) String() string {
+	return string(e)
+}
+
+func (e *AlbumSort) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AlbumSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AlbumSort", str)
+	}
+	return nil
+}
+
+func (e AlbumSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ArtistSort string
+
+const (
+	ArtistSortName ArtistSort = "NAME"
+	ArtistSortID   ArtistSort = "ID"
+)
+
+var AllArtistSort = []ArtistSort{
+	ArtistSortName,
+	ArtistSortID,
+}
+
+func (e ArtistSort) IsValid() bool {
+	switch e {
+	case ArtistSortName, ArtistSortID:
+		return true
+	}
+	return false
+}
+
+func (e ArtistSort) String() string {
+	return string(e)
+}
+
+func (e *ArtistSort) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ArtistSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ArtistSort", str)
+	}
+	return nil
+}
+
+func (e ArtistSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Role string
+
+const (
+	RoleViewer      Role = "VIEWER"
+	RoleContributor Role = "CONTRIBUTOR"
+	RoleEditor      Role = "EDITOR"
+	RoleAdmin       Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleViewer,
+	RoleContributor,
+	RoleEditor,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleViewer, RoleContributor, RoleEditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type SongSort string
+
+const (
+	SongSortName SongSort = "NAME"
+	SongSortID   SongSort = "ID"
+)
+
+var AllSongSort = []SongSort{
+	SongSortName,
+	SongSortID,
+}
+
+func (e SongSort) IsValid() bool {
+	switch e {
+	case SongSortName, SongSortID:
+		return true
+	}
+	return false
+}
+
+func (e SongSort) String() string {
+	return string(e)
+}
+
+func (e *SongSort) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SongSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SongSort", str)
+	}
+	return nil
+}
+
+func (e SongSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
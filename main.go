@@ -11,56 +11,191 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
-	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/gqlgen"
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/config"
+	"github.com/barrettj12/chords/src/data"
 	"github.com/barrettj12/chords/src/server"
 )
 
 func main() {
-	// Try to read logging flags from LOG_FLAGS environment variable
-	// Invalid/unset values will just default to 0 (no flags)
-	flags, _ := strconv.Atoi(os.Getenv("LOG_FLAGS"))
+	cfg := loadConfig(os.Args[1:])
 
 	// Initialise logger
-	logger := log.New(os.Stdout, "", flags)
+	logger := newLogger(cfg.Log.Format, cfg.Log.Level)
+	for _, warning := range cfg.Warnings {
+		logger.Warn(warning)
+	}
 
-	// Set up DB
-	var db dblayer.ChordsDB
-	dbURL := os.Getenv("DATABASE_URL")
-	db, err := dblayer.GetDB(dbURL, logger)
+	// Set up DB. The database layer uses a plain log.Logger, which writes
+	// to the same handler.
+	lib, err := data.GetDBv1(cfg.Database, slog.NewLogLogger(logger.Handler(), slog.LevelInfo))
 	if err != nil {
 		panic(err)
 	}
-	// defer db.Close()
-
-	// Read port from PORT environment variable
-	port := os.Getenv("PORT")
-	if _, err := strconv.Atoi(port); err != nil {
-		// Set default port value
-		fmt.Printf("Invalid port %q: listening on port 8080 instead\n", port)
-		port = "8080"
-	}
 
-	// Read authorisation key from env
-	authKey := os.Getenv("AUTH_KEY")
+	// The auth key is read from a file, unless it's set in the environment
+	authKey := cfg.AuthKey
 	if authKey == "" {
-		// Try read from file
-		data, err := os.ReadFile("auth_key")
+		data, err := os.ReadFile(cfg.AuthKeyFile)
 		if err == nil {
 			authKey = string(data)
+		} else if cfg.AuthKeyFile != config.DefaultAuthKeyFile {
+			logger.Warn("couldn't read auth key", "error", err)
 		}
 	}
 
-	s, err := server.New(db, ":"+port, logger, authKey)
+	// Set up user accounts. The AUTH_KEY can be used as an admin, to set up
+	// the other accounts.
+	if cfg.UsersFile == "" && cfg.Database == "" {
+		logger.Warn("user accounts will be lost when the server stops, as the database is temporary")
+	}
+	accounts, err := auth.NewAccounts(usersStore(cfg), authKey)
 	if err != nil {
 		panic(err)
 	}
-	err = s.Run()
+
+	s, err := server.New(lib.V0(), fmt.Sprintf(":%d", cfg.Port), logger, accounts)
 	if err != nil {
 		panic(err)
 	}
+	// Request and response bodies can be logged for debugging
+	s.SetBodyLogging(cfg.Log.Bodies)
+	s.SetTimeouts(server.Timeouts{
+		ReadHeader: cfg.Timeouts.ReadHeader,
+		Read:       cfg.Timeouts.Read,
+		Write:      cfg.Timeouts.Write,
+		Idle:       cfg.Timeouts.Idle,
+	})
+	s.SetLimits(serverLimits(cfg.Limits))
+	if cfg.MetricsPort != 0 {
+		s.SetMetricsAddr(fmt.Sprintf(":%d", cfg.MetricsPort))
+	}
+
+	// Shut down gracefully on SIGTERM (sent by Fly when deploying) or SIGINT,
+	// so writes in progress aren't cut off.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Run()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			panic(err)
+		}
+	case <-ctx.Done():
+		// A second signal kills the server straight away
+		stop()
+		logger.Info("received signal", "timeout", cfg.Timeouts.Shutdown.String())
+		shutdownCtx := context.Background()
+		if cfg.Timeouts.Shutdown > 0 {
+			var cancel context.CancelFunc
+			shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.Timeouts.Shutdown)
+			defer cancel()
+		}
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			logger.Error("shutdown was not clean", "error", err)
+			os.Exit(1)
+		}
+		<-errc
+	}
+}
+
+// loadConfig reads the server's config from the config file, then the
+// environment, then the command-line flags. If it's invalid, the server
+// exits.
+func loadConfig(args []string) config.Server {
+	fs := flag.NewFlagSet("chords-server", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CHORDS_CONFIG"), "path to the config `file` (env CHORDS_CONFIG)")
+	port := fs.Int("port", 0, "port to listen on (env PORT)")
+	db := fs.String("db", "", "address of the database (env DATABASE_URL)")
+	logLevel := fs.String("log-level", "", "minimum `level` to log (env LOG_LEVEL)")
+	fs.Parse(args)
+
+	cfg, _, err := config.Load(*configPath)
+	if err == nil {
+		err = cfg.Server.ApplyEnv(os.LookupEnv)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "db":
+			cfg.Server.Database = *db
+		case "log-level":
+			cfg.Server.Log.Level = *logLevel
+		}
+	})
+	if err == nil {
+		err = cfg.Server.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		os.Exit(2)
+	}
+	return cfg.Server
+}
+
+// newLogger returns a logger which writes to stdout in the given format:
+// "json" or "text". level is the minimum level to log, e.g. "debug" or
+// "warn". They have already been validated.
+func newLogger(format, level string) *slog.Logger {
+	var l slog.Level
+	l.UnmarshalText([]byte(level))
+	opts := &slog.HandlerOptions{Level: l}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+}
+
+// serverLimits converts the configured limits to server.Limits.
+func serverLimits(cfg config.Limits) server.Limits {
+	rates := map[string]server.RateLimit{}
+	for route, rate := range cfg.Rates {
+		rates[route] = server.RateLimit{Requests: rate.Requests, Per: rate.Per, Burst: rate.Burst}
+	}
+	return server.Limits{
+		Rates: rates,
+		Passwords: server.RateLimit{
+			Requests: cfg.Passwords.Requests,
+			Per:      cfg.Passwords.Per,
+			Burst:    cfg.Passwords.Burst,
+		},
+		ClientIPHeader: cfg.ClientIPHeader,
+		MaxBody:        cfg.MaxBody,
+		MaxImportBody:  cfg.MaxImportBody,
+		GraphQL: gqlgen.Limits{
+			MaxDepth:      cfg.GraphQL.MaxDepth,
+			MaxComplexity: cfg.GraphQL.MaxComplexity,
+		},
+	}
+}
+
+// usersStore returns where to store the user accounts: the configured users
+// file if it's set, otherwise a file alongside a local filesystem database.
+// For a temporary database, they're kept in memory. Postgres databases can't
+// store accounts, so the config must set a users file (see
+// config.Server.Validate).
+func usersStore(cfg config.Server) auth.Store {
+	if cfg.UsersFile != "" {
+		return auth.NewFileStore(cfg.UsersFile)
+	}
+	if cfg.Database != "" && !strings.HasPrefix(cfg.Database, "postgres") {
+		return auth.NewFileStore(filepath.Join(cfg.Database, ".users.json"))
+	}
+	return auth.NewMemoryStore()
 }
@@ -0,0 +1,456 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role says what a user is allowed to do. Each role can do everything the
+// roles below it can.
+type Role string
+
+const (
+	// RoleViewer can only read, which anonymous users can already do. It is
+	// useful for giving someone an account before they can edit.
+	RoleViewer Role = "viewer"
+	// RoleContributor can add songs, and edit songs' metadata and chords.
+	RoleContributor Role = "contributor"
+	// RoleEditor can also delete, rename and merge songs, edit artists and
+	// albums, manage relations and the trash, and export the database.
+	RoleEditor Role = "editor"
+	// RoleAdmin can also manage users and tokens, import snapshots and
+	// permanently delete songs.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists the roles from least to most powerful.
+var Roles = []Role{RoleViewer, RoleContributor, RoleEditor, RoleAdmin}
+
+// ParseRole checks that s is a valid role.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if !slices.Contains(Roles, role) {
+		return "", fmt.Errorf("%w: unknown role %q", ErrInvalid, s)
+	}
+	return role, nil
+}
+
+// Includes reports whether this role is allowed to do what the other role
+// can.
+func (r Role) Includes(other Role) bool {
+	return slices.Index(Roles, r) >= slices.Index(Roles, other)
+}
+
+// User is a user account. The password hash is never included.
+type User struct {
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Created time.Time `json:"created"`
+	// Scopes is only set when the user authenticated with an API token, and
+	// limits what the request can do.
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// Can reports whether the user has the given role (or a more powerful one).
+// A nil user (i.e. an anonymous request) can't do anything that needs a
+// role.
+func (u *User) Can(role Role) bool {
+	return u != nil && u.Role.Includes(role)
+}
+
+// HasScope reports whether the request is allowed to do things which need
+// the given scope. Requests authenticated with a password or the AUTH_KEY
+// aren't limited by scopes, and an empty scope is always allowed.
+func (u *User) HasScope(scope Scope) bool {
+	return u != nil && (u.Scopes == nil || scope == "" || slices.Contains(u.Scopes, scope))
+}
+
+// Authorise checks that the user has the given role, and scope. It returns
+// ErrUnauthenticated for a nil user, or an error wrapping ErrForbidden.
+func (u *User) Authorise(role Role, scope Scope) error {
+	switch {
+	case u == nil:
+		return ErrUnauthenticated
+	case !u.Can(role):
+		return fmt.Errorf("%w: user %q needs the %s role", ErrForbidden, u.Name, role)
+	case !u.HasScope(scope):
+		return fmt.Errorf("%w: token needs the %s scope", ErrForbidden, scope)
+	}
+	return nil
+}
+
+// Token is an API token for a user. The token itself is only shown when it
+// is created - it can be checked using its signature, so it isn't stored.
+type Token struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Name    string    `json:"name,omitempty"`
+	Scopes  []Scope   `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	// ErrUnauthenticated is returned when credentials are given, but they
+	// are wrong.
+	ErrUnauthenticated = errors.New("invalid credentials")
+	// ErrForbidden is returned when a user doesn't have the role or scope
+	// needed for a request.
+	ErrForbidden = errors.New("forbidden")
+	ErrNotFound  = errors.New("not found")
+	ErrExists    = errors.New("already exists")
+	ErrInvalid   = errors.New("invalid")
+)
+
+// AuthKeyUser is the user for requests authorised with the AUTH_KEY. It's an
+// admin, so the key can be used to set up the other accounts.
+const AuthKeyUser = "auth-key"
+
+// Accounts holds the users and their tokens, and authenticates requests.
+type Accounts struct {
+	store      Store
+	authKey    string
+	signingKey []byte
+
+	mu    sync.Mutex
+	state State
+}
+
+// NewAccounts loads the accounts from the store. If authKey is not empty,
+// requests with this key are authorised as an admin. An empty key never
+// authorises anything.
+func NewAccounts(store Store, authKey string) (*Accounts, error) {
+	state, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	a := &Accounts{
+		store:   store,
+		authKey: strings.TrimSpace(authKey),
+		state:   state,
+	}
+
+	// The token signing key is generated the first time, and kept with the
+	// accounts. Changing it invalidates all tokens.
+	if state.SigningKey == "" {
+		key, err := newSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		err = a.update(func(s *State) { s.SigningKey = key })
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.signingKey, err = base64.StdEncoding.DecodeString(a.state.SigningKey)
+	if err != nil || len(a.signingKey) < 32 {
+		return nil, fmt.Errorf("invalid token signing key")
+	}
+	return a, nil
+}
+
+// Authenticate checks the credentials in an Authorization header, which can
+// be:
+//   - "Bearer <token>", with an API token or the AUTH_KEY;
+//   - "Basic <base64 of name:password>";
+//   - just the token or AUTH_KEY, for older clients.
+//
+// If the header is empty, it returns nil (an anonymous user). If the
+// credentials are wrong, it returns ErrUnauthenticated.
+func (a *Accounts) Authenticate(header string) (*User, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, nil
+	}
+
+	scheme, creds, ok := strings.Cut(header, " ")
+	switch {
+	case ok && strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(creds))
+		if err != nil {
+			return nil, ErrUnauthenticated
+		}
+		name, password, _ := strings.Cut(string(decoded), ":")
+		return a.checkPassword(name, password)
+	case ok && strings.EqualFold(scheme, "Bearer"):
+		return a.checkToken(strings.TrimSpace(creds))
+	default:
+		return a.checkToken(header)
+	}
+}
+
+// checkToken checks an API token or the AUTH_KEY.
+func (a *Accounts) checkToken(token string) (*User, error) {
+	if a.authKey != "" && equal(token, a.authKey) {
+		return &User{Name: AuthKeyUser, Role: RoleAdmin}, nil
+	}
+
+	c, err := parseToken(a.signingKey, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// The token must not have been revoked
+	i := slices.IndexFunc(a.state.Tokens, func(t Token) bool { return t.ID == c.ID })
+	if i == -1 || a.state.Tokens[i].User != c.Subject {
+		return nil, ErrUnauthenticated
+	}
+	user, err := a.user(c.Subject)
+	if err != nil {
+		return nil, err
+	}
+	user.Scopes = slices.Clone(a.state.Tokens[i].Scopes)
+	return user, nil
+}
+
+// checkPassword checks a user's password.
+func (a *Accounts) checkPassword(name, password string) (*User, error) {
+	a.mu.Lock()
+	hash := ""
+	if i := slices.IndexFunc(a.state.Users, func(u UserRecord) bool { return u.Name == name }); i != -1 {
+		hash = a.state.Users[i].PasswordHash
+	}
+	a.mu.Unlock()
+
+	// A hash is always checked, even for unknown users or users without a
+	// password, so the response time doesn't reveal which users exist.
+	if hash == "" {
+		checkPassword(dummyPasswordHash(), password)
+		return nil, ErrUnauthenticated
+	}
+	if !checkPassword(hash, password) {
+		return nil, ErrUnauthenticated
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.user(name)
+}
+
+// user returns the user with the given name. The caller must hold the lock.
+func (a *Accounts) user(name string) (*User, error) {
+	i := slices.IndexFunc(a.state.Users, func(u UserRecord) bool { return u.Name == name })
+	if i == -1 {
+		return nil, ErrUnauthenticated
+	}
+	user := a.state.Users[i].User
+	return &user, nil
+}
+
+// equal compares two secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Users returns all the users, sorted by name.
+func (a *Accounts) Users() []User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	users := make([]User, 0, len(a.state.Users))
+	for _, u := range a.state.Users {
+		users = append(users, u.User)
+	}
+	slices.SortFunc(users, func(u1, u2 User) int { return strings.Compare(u1.Name, u2.Name) })
+	return users
+}
+
+// AddUser adds a new user. The password is optional - without one, the user
+// can only authenticate with API tokens.
+func (a *Accounts) AddUser(name string, role Role, password string) (User, error) {
+	if name == "" || name == AuthKeyUser || strings.ContainsAny(name, ": \t\n") {
+		return User{}, fmt.Errorf("%w: invalid user name %q", ErrInvalid, name)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return User{}, err
+	}
+	rec := UserRecord{User: User{Name: name, Role: role, Created: time.Now().UTC()}}
+	if password != "" {
+		var err error
+		rec.PasswordHash, err = hashPassword(password)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if slices.ContainsFunc(a.state.Users, func(u UserRecord) bool { return u.Name == name }) {
+		return User{}, fmt.Errorf("user %q %w", name, ErrExists)
+	}
+	return rec.User, a.update(func(s *State) {
+		s.Users = append(s.Users, rec)
+	})
+}
+
+// UserUpdate holds changes to a user. Nil fields are left unchanged. An
+// empty password removes the user's password.
+type UserUpdate struct {
+	Role     *Role   `json:"role,omitempty"`
+	Password *string `json:"password,omitempty"`
+}
+
+// UpdateUser changes a user's role or password.
+func (a *Accounts) UpdateUser(name string, up UserUpdate) (User, error) {
+	if up.Role != nil {
+		if _, err := ParseRole(string(*up.Role)); err != nil {
+			return User{}, err
+		}
+	}
+	hash := ""
+	if up.Password != nil && *up.Password != "" {
+		var err error
+		hash, err = hashPassword(*up.Password)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.state.Users, func(u UserRecord) bool { return u.Name == name })
+	if i == -1 {
+		return User{}, fmt.Errorf("user %q %w", name, ErrNotFound)
+	}
+	err := a.update(func(s *State) {
+		if up.Role != nil {
+			s.Users[i].Role = *up.Role
+		}
+		if up.Password != nil {
+			s.Users[i].PasswordHash = hash
+		}
+	})
+	return a.state.Users[i].User, err
+}
+
+// RemoveUser removes a user, and revokes all their tokens.
+func (a *Accounts) RemoveUser(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.ContainsFunc(a.state.Users, func(u UserRecord) bool { return u.Name == name }) {
+		return fmt.Errorf("user %q %w", name, ErrNotFound)
+	}
+	return a.update(func(s *State) {
+		s.Users = slices.DeleteFunc(s.Users, func(u UserRecord) bool { return u.Name == name })
+		s.Tokens = slices.DeleteFunc(s.Tokens, func(t Token) bool { return t.User == name })
+	})
+}
+
+// Tokens returns the tokens for the given user, oldest first. If user is
+// empty, all tokens are returned.
+func (a *Accounts) Tokens(user string) []Token {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tokens := []Token{}
+	for _, t := range a.state.Tokens {
+		if user == "" || t.User == user {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// CreateToken creates a new API token for a user, which can be used for the
+// given scopes until it expires. It returns the token's details, and the
+// token itself, which can't be retrieved again.
+func (a *Accounts) CreateToken(user, name string, scopes []Scope, expires time.Time) (Token, string, error) {
+	if len(scopes) == 0 {
+		return Token{}, "", fmt.Errorf("%w: a token needs at least one scope", ErrInvalid)
+	}
+	scopeStrs := []string{}
+	for _, scope := range scopes {
+		if _, err := ParseScope(string(scope)); err != nil {
+			return Token{}, "", err
+		}
+		scopeStrs = append(scopeStrs, string(scope))
+	}
+	now := time.Now().UTC()
+	if !expires.After(now) {
+		return Token{}, "", fmt.Errorf("%w: expiry %s is in the past", ErrInvalid, expires.Format(time.RFC3339))
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.ContainsFunc(a.state.Users, func(u UserRecord) bool { return u.Name == user }) {
+		return Token{}, "", fmt.Errorf("user %q %w", user, ErrNotFound)
+	}
+	token := Token{
+		ID:      id,
+		User:    user,
+		Name:    name,
+		Scopes:  slices.Clone(scopes),
+		Created: now.Truncate(time.Second),
+		Expires: expires.UTC().Truncate(time.Second),
+	}
+	signed, err := signToken(a.signingKey, claims{
+		ID:       token.ID,
+		Subject:  token.User,
+		Scope:    strings.Join(scopeStrs, " "),
+		IssuedAt: token.Created.Unix(),
+		Expires:  token.Expires.Unix(),
+	})
+	if err != nil {
+		return Token{}, "", err
+	}
+	err = a.update(func(s *State) {
+		// Expired tokens can't be used, so they are tidied up here
+		s.Tokens = slices.DeleteFunc(s.Tokens, func(t Token) bool { return !t.Expires.After(now) })
+		s.Tokens = append(s.Tokens, token)
+	})
+	if err != nil {
+		return Token{}, "", err
+	}
+	return token, signed, nil
+}
+
+// RevokeToken deletes a token, so it can no longer be used.
+func (a *Accounts) RevokeToken(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.ContainsFunc(a.state.Tokens, func(t Token) bool { return t.ID == id }) {
+		return fmt.Errorf("token %q %w", id, ErrNotFound)
+	}
+	return a.update(func(s *State) {
+		s.Tokens = slices.DeleteFunc(s.Tokens, func(t Token) bool { return t.ID == id })
+	})
+}
+
+// update applies a change to a copy of the state, and saves it. The state is
+// only changed if it's saved successfully. The caller must hold the lock.
+func (a *Accounts) update(change func(*State)) error {
+	state := State{
+		Users:      slices.Clone(a.state.Users),
+		Tokens:     slices.Clone(a.state.Tokens),
+		SigningKey: a.state.SigningKey,
+	}
+	change(&state)
+	if err := a.store.Save(state); err != nil {
+		return err
+	}
+	a.state = state
+	return nil
+}
+
+type userKey struct{}
+
+// WithUser records the authenticated user in the context.
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the authenticated user from the context, or nil if the
+// request is anonymous.
+func UserFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
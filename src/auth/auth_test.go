@@ -0,0 +1,224 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/auth/auth_test.go
+// Tests for user accounts and authentication.
+
+package auth
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// Make password hashing fast for tests
+	passwordIterations = 1000
+}
+
+func basic(name, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password))
+}
+
+func inAnHour() time.Time {
+	return time.Now().Add(time.Hour)
+}
+
+func TestEmptyAuthKey(t *testing.T) {
+	accounts, err := NewAccounts(NewMemoryStore(), "")
+	assert.Nil(t, err)
+	_, err = accounts.AddUser("nopass", RoleAdmin, "")
+	assert.Nil(t, err)
+
+	// An empty header is anonymous
+	user, err := accounts.Authenticate("")
+	assert.Nil(t, err)
+	assert.False(t, user.Can(RoleViewer))
+
+	// Empty credentials never match an empty key, or a user without a
+	// password
+	for _, header := range []string{"Bearer ", "Bearer  ", basic("nopass", ""), basic("", "")} {
+		user, err := accounts.Authenticate(header)
+		assert.ErrorIs(t, err, ErrUnauthenticated, "header %q", header)
+		assert.Nil(t, user)
+	}
+}
+
+func TestAuthKey(t *testing.T) {
+	accounts, err := NewAccounts(NewMemoryStore(), "sekrit\n")
+	assert.Nil(t, err)
+
+	for _, header := range []string{"sekrit", "Bearer sekrit", "bearer sekrit"} {
+		user, err := accounts.Authenticate(header)
+		assert.Nil(t, err)
+		assert.Equal(t, AuthKeyUser, user.Name)
+		assert.True(t, user.Can(RoleAdmin))
+	}
+
+	_, err = accounts.Authenticate("Bearer sekrit2")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestPasswordsAndTokens(t *testing.T) {
+	accounts, err := NewAccounts(NewMemoryStore(), "")
+	assert.Nil(t, err)
+	_, err = accounts.AddUser("alice", RoleContributor, "correct horse")
+	assert.Nil(t, err)
+	_, err = accounts.AddUser("alice", RoleViewer, "")
+	assert.ErrorIs(t, err, ErrExists)
+	_, err = accounts.AddUser("bob", "superuser", "")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	user, err := accounts.Authenticate(basic("alice", "correct horse"))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Name)
+	assert.True(t, user.Can(RoleContributor))
+	assert.False(t, user.Can(RoleEditor))
+
+	_, err = accounts.Authenticate(basic("alice", "battery staple"))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = accounts.Authenticate(basic("mallory", "correct horse"))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// Tokens
+	token, secret, err := accounts.CreateToken("alice", "laptop", []Scope{ScopeSongsWrite}, inAnHour())
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", token.User)
+	user, err = accounts.Authenticate("Bearer " + secret)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Name)
+	assert.Equal(t, []Scope{ScopeSongsWrite}, user.Scopes)
+	_, err = accounts.Authenticate("Bearer " + secret + "x")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.Equal(t, []Token{token}, accounts.Tokens("alice"))
+
+	// Tokens are limited to their scopes, as well as the user's role
+	assert.Nil(t, user.Authorise(RoleContributor, ScopeSongsWrite))
+	assert.ErrorIs(t, user.Authorise(RoleContributor, ScopeChordsWrite), ErrForbidden)
+	assert.ErrorIs(t, user.Authorise(RoleEditor, ScopeSongsWrite), ErrForbidden)
+
+	// Role changes apply to existing tokens
+	editor := RoleEditor
+	_, err = accounts.UpdateUser("alice", UserUpdate{Role: &editor})
+	assert.Nil(t, err)
+	user, err = accounts.Authenticate("Bearer " + secret)
+	assert.Nil(t, err)
+	assert.True(t, user.Can(RoleEditor))
+
+	err = accounts.RevokeToken(token.ID)
+	assert.Nil(t, err)
+	_, err = accounts.Authenticate("Bearer " + secret)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// Removing a user revokes their tokens
+	_, secret, err = accounts.CreateToken("alice", "", Scopes, inAnHour())
+	assert.Nil(t, err)
+	err = accounts.RemoveUser("alice")
+	assert.Nil(t, err)
+	_, err = accounts.Authenticate("Bearer " + secret)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.Empty(t, accounts.Tokens(""))
+}
+
+func TestTokens(t *testing.T) {
+	accounts, err := NewAccounts(NewMemoryStore(), "")
+	assert.Nil(t, err)
+	_, err = accounts.AddUser("ci", RoleEditor, "")
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		scopes  []Scope
+		expires time.Time
+	}{
+		{nil, inAnHour()},
+		{[]Scope{"songs:delete"}, inAnHour()},
+		{[]Scope{ScopeSongsWrite}, time.Now().Add(-time.Second)},
+	} {
+		_, _, err := accounts.CreateToken("ci", "", test.scopes, test.expires)
+		assert.ErrorIs(t, err, ErrInvalid)
+	}
+	_, _, err = accounts.CreateToken("nobody", "", Scopes, inAnHour())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	token, secret, err := accounts.CreateToken("ci", "", []Scope{ScopeChordsWrite, ScopeRelationsWrite}, inAnHour())
+	assert.Nil(t, err)
+	header, payload, sig := splitToken(t, secret)
+
+	// Tokens are signed, so they can't be changed
+	tampered := strings.Replace(string(decode(t, payload)), "chords:write", "accounts", 1)
+	for _, forged := range []string{
+		header + "." + base64.RawURLEncoding.EncodeToString([]byte(tampered)) + "." + sig,
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + payload + ".",
+		header + "." + payload + ".",
+	} {
+		_, err := accounts.Authenticate("Bearer " + forged)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	}
+
+	// A different signing key doesn't accept the token
+	other, err := NewAccounts(NewMemoryStore(), "")
+	assert.Nil(t, err)
+	_, err = other.AddUser("ci", RoleEditor, "")
+	assert.Nil(t, err)
+	_, err = other.Authenticate("Bearer " + secret)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// Expired tokens are rejected
+	expired, err := signToken(accounts.signingKey, claims{
+		ID: token.ID, Subject: "ci", Scope: "chords:write", Expires: time.Now().Unix() - 1,
+	})
+	assert.Nil(t, err)
+	_, err = accounts.Authenticate("Bearer " + expired)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.ErrorIs(t, err, errExpired)
+}
+
+func splitToken(t *testing.T, token string) (header, payload, sig string) {
+	parts := strings.Split(token, ".")
+	if !assert.Len(t, parts, 3) {
+		t.FailNow()
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+func decode(t *testing.T, s string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	assert.Nil(t, err)
+	return data
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	accounts, err := NewAccounts(NewFileStore(path), "")
+	assert.Nil(t, err)
+	_, err = accounts.AddUser("alice", RoleAdmin, "pw")
+	assert.Nil(t, err)
+	_, secret, err := accounts.CreateToken("alice", "", Scopes, inAnHour())
+	assert.Nil(t, err)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), secret)
+
+	// Reload - the signing key is kept, so tokens still work
+	accounts, err = NewAccounts(NewFileStore(path), "")
+	assert.Nil(t, err)
+	user, err := accounts.Authenticate(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Name)
+	user, err = accounts.Authenticate(basic("alice", "pw"))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Name)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Passwords are hashed with PBKDF2-SHA256, and stored as
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+const passwordScheme = "pbkdf2-sha256"
+
+// passwordIterations is the number of PBKDF2 iterations for new hashes, as
+// recommended by OWASP. Existing hashes record their own iteration count, so
+// this can be increased later.
+var passwordIterations = 600_000
+
+func hashPassword(password string) (string, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// checkPassword checks a password against a hash from hashPassword.
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return false
+	}
+	return equal(base64.RawStdEncoding.EncodeToString(hash), parts[3])
+}
+
+// dummyPasswordHash is checked when a user doesn't exist (or has no
+// password), so it takes as long as checking a real password. It's only
+// generated when needed, as hashing is slow.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("")
+	return hash
+})
+
+// randomBytes returns n random bytes.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
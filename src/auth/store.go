@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State is everything stored about the accounts.
+type State struct {
+	Users  []UserRecord `json:"users"`
+	Tokens []Token      `json:"tokens"`
+	// SigningKey is the base64-encoded key used to sign API tokens.
+	SigningKey string `json:"signingKey"`
+}
+
+// UserRecord is the stored record for a user.
+type UserRecord struct {
+	User
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+// Store persists the accounts.
+type Store interface {
+	Load() (State, error)
+	Save(State) error
+}
+
+// NewFileStore returns a Store which keeps the accounts in a JSON file. The
+// file is only readable by the current user, as it contains password
+// hashes.
+func NewFileStore(path string) Store {
+	return &fileStore{path}
+}
+
+type fileStore struct {
+	path string
+}
+
+func (f *fileStore) Load() (State, error) {
+	state := State{}
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return State{}, fmt.Errorf("couldn't unmarshal %s: %w", filepath.Base(f.path), err)
+	}
+	return state, nil
+}
+
+func (f *fileStore) Save(state State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so the accounts aren't lost if the
+	// write fails part way.
+	tmp := f.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// NewMemoryStore returns a Store which doesn't persist anything.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+type memoryStore struct {
+	state State
+}
+
+func (m *memoryStore) Load() (State, error) {
+	return m.state, nil
+}
+
+func (m *memoryStore) Save(state State) error {
+	m.state = state
+	return nil
+}
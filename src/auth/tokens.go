@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scope limits what an API token can be used for. A token can only do
+// things which need one of its scopes, and which its user's role allows.
+type Scope string
+
+const (
+	// ScopeSongsWrite allows adding, editing, deleting, renaming and merging
+	// songs.
+	ScopeSongsWrite Scope = "songs:write"
+	// ScopeChordsWrite allows editing songs' chords.
+	ScopeChordsWrite Scope = "chords:write"
+	// ScopeRelationsWrite allows relating and unrelating artists.
+	ScopeRelationsWrite Scope = "relations:write"
+	// ScopeArtistsWrite allows editing artists and albums.
+	ScopeArtistsWrite Scope = "artists:write"
+	// ScopeSuggestions allows reviewing, accepting and rejecting suggested
+	// corrections.
+	ScopeSuggestions Scope = "suggestions"
+	// ScopeTrash allows listing, restoring and purging the trash.
+	ScopeTrash Scope = "trash"
+	// ScopeBackup allows exporting and importing the database.
+	ScopeBackup Scope = "backup"
+	// ScopeAccounts allows managing users and tokens.
+	ScopeAccounts Scope = "accounts"
+	// ScopeAudit allows reading the audit log.
+	ScopeAudit Scope = "audit"
+)
+
+// Scopes lists all the scopes.
+var Scopes = []Scope{
+	ScopeSongsWrite, ScopeChordsWrite, ScopeRelationsWrite, ScopeArtistsWrite,
+	ScopeSuggestions, ScopeTrash, ScopeBackup, ScopeAccounts, ScopeAudit,
+}
+
+// ParseScope checks that s is a valid scope.
+func ParseScope(s string) (Scope, error) {
+	scope := Scope(s)
+	if !slices.Contains(Scopes, scope) {
+		return "", fmt.Errorf("%w: unknown scope %q", ErrInvalid, s)
+	}
+	return scope, nil
+}
+
+// API tokens are JWTs (RFC 7519), signed with HMAC-SHA256 using a key which
+// is kept with the accounts. They carry the token's ID, user, scopes and
+// expiry. The ID must also be in the accounts, so tokens can be revoked.
+//
+// Only our own header is accepted, so a token can't pick its own algorithm
+// (e.g. "none").
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// claims is the payload of a token.
+type claims struct {
+	ID      string `json:"jti"`
+	Subject string `json:"sub"`
+	// Scope is a space-separated list, as in RFC 8693.
+	Scope    string `json:"scope"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+var errExpired = errors.New("token has expired")
+
+// signToken returns a signed token for the given claims.
+func signToken(key []byte, c claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signature(key, unsigned), nil
+}
+
+// parseToken checks a token's signature and expiry, and returns its claims.
+func parseToken(key []byte, token string, now time.Time) (claims, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != jwtHeader {
+		return claims{}, ErrUnauthenticated
+	}
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok || !equal(sig, signature(key, header+"."+payload)) {
+		return claims{}, ErrUnauthenticated
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims{}, ErrUnauthenticated
+	}
+	c := claims{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return claims{}, ErrUnauthenticated
+	}
+	if now.Unix() >= c.Expires {
+		return claims{}, fmt.Errorf("%w: %w", ErrUnauthenticated, errExpired)
+	}
+	return c, nil
+}
+
+func signature(key []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTokenID returns a random ID for a token.
+func newTokenID() (string, error) {
+	b, err := randomBytes(6)
+	return hex.EncodeToString(b), err
+}
+
+// newSigningKey returns a new random key for signing tokens.
+func newSigningKey() (string, error) {
+	b, err := randomBytes(32)
+	return base64.StdEncoding.EncodeToString(b), err
+}
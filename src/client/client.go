@@ -12,18 +12,28 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/barrettj12/chords/src/auth"
 	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/types"
 )
 
 // Client makes it easy to access API methods
 type Client struct {
 	serverURL *url.URL
 	authKey   string
+
+	// Failed requests are retried up to `retries` times, waiting `backoff`
+	// before the first retry, and doubling the wait after each attempt.
+	retries int
+	backoff time.Duration
 }
 
 // TODO: move these constants into a separate package that can be used by both
@@ -34,6 +44,22 @@ const (
 	API_CHORDS   = "/api/v0/chords"
 	API_SEE_ALSO = "/api/v0/see-also"
 	API_RANDOM   = "/api/v0/random"
+	API_SEARCH   = "/api/v0/search"
+	API_EXPORT   = "/api/v0/export"
+	API_IMPORT   = "/api/v0/import"
+	API_TRASH    = "/api/v0/trash"
+	API_RESTORE  = "/api/v0/trash/restore"
+	API_RENAME   = "/api/v0/songs/rename"
+	API_MERGE    = "/api/v0/songs/merge"
+	API_ALIASES  = "/api/v0/aliases"
+
+	API_V1_ME     = "/api/v1/me"
+	API_V1_USERS  = "/api/v1/users"
+	API_V1_TOKENS = "/api/v1/tokens"
+	API_V1_AUDIT  = "/api/v1/audit"
+
+	API_V1_SONGS       = "/api/v1/songs"
+	API_V1_SUGGESTIONS = "/api/v1/suggestions"
 )
 
 func NewClient(serverURL, authKey string) (*Client, error) {
@@ -41,7 +67,19 @@ func NewClient(serverURL, authKey string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{parsed, authKey}, nil
+	return &Client{
+		serverURL: parsed,
+		authKey:   authKey,
+		retries:   3,
+		backoff:   500 * time.Millisecond,
+	}, nil
+}
+
+// SetRetry configures how failed requests are retried. Set retries to 0 to
+// disable retrying.
+func (c *Client) SetRetry(retries int, backoff time.Duration) {
+	c.retries = retries
+	c.backoff = backoff
 }
 
 func (c *Client) GetArtists() ([]string, error) {
@@ -152,6 +190,71 @@ func (c *Client) DeleteSong(id string) error {
 	return err
 }
 
+// RenameSong changes a song's ID. The old ID remains as an alias.
+func (c *Client) RenameSong(id, newID string) (dblayer.SongMeta, error) {
+	song := dblayer.SongMeta{}
+	resp, err := c.request(requestParams{
+		method: http.MethodPost,
+		path:   API_RENAME,
+		queryParams: map[string]*string{
+			"id":    &id,
+			"newId": &newID,
+		},
+		auth: true,
+	})
+	if err != nil {
+		return song, err
+	}
+
+	err = json.Unmarshal(resp, &song)
+	return song, err
+}
+
+// MergeSongs merges the song dupID into the song id.
+func (c *Client) MergeSongs(id, dupID string) (dblayer.SongMeta, error) {
+	song := dblayer.SongMeta{}
+	resp, err := c.request(requestParams{
+		method: http.MethodPost,
+		path:   API_MERGE,
+		queryParams: map[string]*string{
+			"id":        &id,
+			"duplicate": &dupID,
+		},
+		auth: true,
+	})
+	if err != nil {
+		return song, err
+	}
+
+	err = json.Unmarshal(resp, &song)
+	return song, err
+}
+
+// ResolveAlias returns the current ID of a song which used to have the given
+// ID, or "" if there is no such song.
+func (c *Client) ResolveAlias(alias string) (string, error) {
+	resp, err := c.request(requestParams{
+		method: http.MethodGet,
+		path:   API_ALIASES,
+		queryParams: map[string]*string{
+			"id": &alias,
+		},
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.Unmarshal(resp, &result)
+	return result.ID, err
+}
+
 func (c *Client) GetChords(id string) ([]byte, error) {
 	return c.request(requestParams{
 		method: http.MethodGet,
@@ -196,6 +299,41 @@ func (c *Client) SeeAlso(artist string) ([]string, error) {
 	return artists, nil
 }
 
+// AddRelation relates two artists, and returns the updated list of artists
+// related to the first one.
+func (c *Client) AddRelation(artist, related string) ([]string, error) {
+	resp, err := c.request(requestParams{
+		method: http.MethodPost,
+		path:   API_SEE_ALSO,
+		queryParams: map[string]*string{
+			"artist":  &artist,
+			"related": &related,
+		},
+		auth: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	artists := []string{}
+	err = json.Unmarshal(resp, &artists)
+	return artists, err
+}
+
+// RemoveRelation removes the relation between two artists.
+func (c *Client) RemoveRelation(artist, related string) error {
+	_, err := c.request(requestParams{
+		method: http.MethodDelete,
+		path:   API_SEE_ALSO,
+		queryParams: map[string]*string{
+			"artist":  &artist,
+			"related": &related,
+		},
+		auth: true,
+	})
+	return err
+}
+
 func (c *Client) RandomSong() (dblayer.SongMeta, error) {
 	song := dblayer.SongMeta{}
 	resp, err := c.request(requestParams{
@@ -210,10 +348,390 @@ func (c *Client) RandomSong() (dblayer.SongMeta, error) {
 	return song, err
 }
 
+func (c *Client) Search(query string) ([]types.SearchResult, error) {
+	resp, err := c.request(requestParams{
+		method: http.MethodGet,
+		path:   API_SEARCH,
+		queryParams: map[string]*string{
+			"q": &query,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []types.SearchResult{}
+	err = json.Unmarshal(resp, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ListTrash lists the deleted songs which can be restored.
+func (c *Client) ListTrash() ([]dblayer.TrashedSong, error) {
+	resp, err := c.request(requestParams{
+		method: http.MethodGet,
+		path:   API_TRASH,
+		auth:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	songs := []dblayer.TrashedSong{}
+	err = json.Unmarshal(resp, &songs)
+	if err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+// RestoreSong moves a deleted song out of the trash.
+func (c *Client) RestoreSong(id string) (dblayer.SongMeta, error) {
+	song := dblayer.SongMeta{}
+	resp, err := c.request(requestParams{
+		method: http.MethodPost,
+		path:   API_RESTORE,
+		queryParams: map[string]*string{
+			"id": &id,
+		},
+		auth: true,
+	})
+	if err != nil {
+		return song, err
+	}
+
+	err = json.Unmarshal(resp, &song)
+	return song, err
+}
+
+// PurgeSong permanently deletes a song in the trash.
+func (c *Client) PurgeSong(id string) error {
+	_, err := c.request(requestParams{
+		method: http.MethodDelete,
+		path:   API_TRASH,
+		queryParams: map[string]*string{
+			"id": &id,
+		},
+		auth: true,
+	})
+	return err
+}
+
+// Export downloads a snapshot of the whole database, as a gzipped tar archive.
+func (c *Client) Export() ([]byte, error) {
+	return c.request(requestParams{
+		method: http.MethodGet,
+		path:   API_EXPORT,
+		auth:   true,
+	})
+}
+
+// Import uploads a snapshot (as returned by Export) to the server, and restores
+// it according to the given mode.
+func (c *Client) Import(snapshot []byte, mode dblayer.ImportMode) (dblayer.ImportResult, error) {
+	result := dblayer.ImportResult{}
+	modeStr := string(mode)
+	resp, err := c.request(requestParams{
+		method: http.MethodPost,
+		path:   API_IMPORT,
+		queryParams: map[string]*string{
+			"mode": &modeStr,
+		},
+		auth:        true,
+		body:        snapshot,
+		contentType: "application/gzip",
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = json.Unmarshal(resp, &result)
+	return result, err
+}
+
+// ACCOUNTS
+
+// Me returns the user the client is authenticated as.
+func (c *Client) Me() (auth.User, error) {
+	user := auth.User{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodGet,
+		path:   API_V1_ME,
+		auth:   true,
+	}, nil, &user)
+	return user, err
+}
+
+func (c *Client) ListUsers() ([]auth.User, error) {
+	users := []auth.User{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodGet,
+		path:   API_V1_USERS,
+		auth:   true,
+	}, nil, &users)
+	return users, err
+}
+
+// AddUser adds a user. The password is optional - without one, the user can
+// only authenticate with API tokens.
+func (c *Client) AddUser(name string, role auth.Role, password string) (auth.User, error) {
+	user := auth.User{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodPost,
+		path:   API_V1_USERS,
+		auth:   true,
+	}, map[string]any{"name": name, "role": role, "password": password}, &user)
+	return user, err
+}
+
+func (c *Client) UpdateUser(name string, up auth.UserUpdate) (auth.User, error) {
+	user := auth.User{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodPatch,
+		path:   API_V1_USERS + "/" + url.PathEscape(name),
+		auth:   true,
+	}, up, &user)
+	return user, err
+}
+
+// RemoveUser removes a user, and revokes their tokens.
+func (c *Client) RemoveUser(name string) error {
+	_, err := c.request(requestParams{
+		method: http.MethodDelete,
+		path:   API_V1_USERS + "/" + url.PathEscape(name),
+		auth:   true,
+	})
+	return err
+}
+
+// ListTokens lists the API tokens for a user, or all users if user is empty.
+func (c *Client) ListTokens(user string) ([]auth.Token, error) {
+	params := requestParams{
+		method: http.MethodGet,
+		path:   API_V1_TOKENS,
+		auth:   true,
+	}
+	if user != "" {
+		params.queryParams = map[string]*string{"user": &user}
+	}
+	tokens := []auth.Token{}
+	err := c.requestJSON(params, nil, &tokens)
+	return tokens, err
+}
+
+// CreateToken creates an API token for a user, which can be used for the
+// given scopes until it expires. It returns the token's details, and the
+// token itself, which can't be retrieved again.
+func (c *Client) CreateToken(user, name string, scopes []auth.Scope, expires time.Time) (auth.Token, string, error) {
+	created := struct {
+		auth.Token
+		Secret string `json:"token"`
+	}{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodPost,
+		path:   API_V1_TOKENS,
+		auth:   true,
+	}, map[string]any{"user": user, "name": name, "scopes": scopes, "expires": expires}, &created)
+	return created.Token, created.Secret, err
+}
+
+func (c *Client) RevokeToken(id string) error {
+	_, err := c.request(requestParams{
+		method: http.MethodDelete,
+		path:   API_V1_TOKENS + "/" + url.PathEscape(id),
+		auth:   true,
+	})
+	return err
+}
+
+// AuditLog returns the entries in the server's audit log which match the
+// filter, newest first.
+func (c *Client) AuditLog(f dblayer.AuditFilter) ([]dblayer.AuditEntry, error) {
+	query := map[string]string{
+		"actor":  f.Actor,
+		"song":   f.Target,
+		"action": string(f.Action),
+	}
+	if !f.Since.IsZero() {
+		query["since"] = f.Since.Format(time.RFC3339)
+	}
+	if !f.Until.IsZero() {
+		query["until"] = f.Until.Format(time.RFC3339)
+	}
+	if f.Limit > 0 {
+		query["limit"] = strconv.Itoa(f.Limit)
+	}
+	params := requestParams{
+		method:      http.MethodGet,
+		path:        API_V1_AUDIT,
+		auth:        true,
+		queryParams: map[string]*string{},
+	}
+	for key, val := range query {
+		if val != "" {
+			params.queryParams[key] = &val
+		}
+	}
+	entries := []dblayer.AuditEntry{}
+	err := c.requestJSON(params, nil, &entries)
+	return entries, err
+}
+
+// SUGGESTIONS
+
+// SuggestChords suggests a correction to a song's chords. This doesn't need
+// authentication - the suggestion waits for a maintainer to review it.
+func (c *Client) SuggestChords(songID, chords, comment, author string) (dblayer.Suggestion, error) {
+	suggestion := dblayer.Suggestion{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodPost,
+		path:   API_V1_SONGS + "/" + url.PathEscape(songID) + "/suggestions",
+	}, map[string]any{"chords": chords, "comment": comment, "author": author}, &suggestion)
+	return suggestion, err
+}
+
+// ListSuggestions lists suggestions with the given status, for the given
+// song. Empty arguments match everything.
+func (c *Client) ListSuggestions(status dblayer.SuggestionStatus, songID string) ([]dblayer.Suggestion, error) {
+	params := requestParams{
+		method:      http.MethodGet,
+		path:        API_V1_SUGGESTIONS,
+		auth:        true,
+		queryParams: map[string]*string{},
+	}
+	if status != "" {
+		s := string(status)
+		params.queryParams["status"] = &s
+	}
+	if songID != "" {
+		params.queryParams["song"] = &songID
+	}
+	suggestions := []dblayer.Suggestion{}
+	err := c.requestJSON(params, nil, &suggestions)
+	return suggestions, err
+}
+
+// GetSuggestion returns a suggestion. If the song's chords have changed since
+// it was made, currentDiff is a diff from the current chords.
+func (c *Client) GetSuggestion(id string) (suggestion dblayer.Suggestion, currentDiff string, err error) {
+	preview := struct {
+		dblayer.Suggestion
+		CurrentDiff string `json:"currentDiff"`
+	}{}
+	err = c.requestJSON(requestParams{
+		method: http.MethodGet,
+		path:   API_V1_SUGGESTIONS + "/" + url.PathEscape(id),
+		auth:   true,
+	}, nil, &preview)
+	return preview.Suggestion, preview.CurrentDiff, err
+}
+
+// AcceptSuggestion applies a suggestion to the song's chords. If the chords
+// have changed since it was made, this fails unless force is true.
+func (c *Client) AcceptSuggestion(id string, force bool) (dblayer.Suggestion, error) {
+	params := requestParams{
+		method: http.MethodPost,
+		path:   API_V1_SUGGESTIONS + "/" + url.PathEscape(id) + "/accept",
+		auth:   true,
+	}
+	if force {
+		t := "true"
+		params.queryParams = map[string]*string{"force": &t}
+	}
+	suggestion := dblayer.Suggestion{}
+	err := c.requestJSON(params, nil, &suggestion)
+	return suggestion, err
+}
+
+func (c *Client) RejectSuggestion(id string) (dblayer.Suggestion, error) {
+	suggestion := dblayer.Suggestion{}
+	err := c.requestJSON(requestParams{
+		method: http.MethodPost,
+		path:   API_V1_SUGGESTIONS + "/" + url.PathEscape(id) + "/reject",
+		auth:   true,
+	}, nil, &suggestion)
+	return suggestion, err
+}
+
 // HELPER METHODS
 
-// Common logic for making HTTP requests
+// StatusError is returned when the server responds with an error status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the wait requested by the server via the Retry-After
+	// header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response has status %q", e.Status)
+}
+
+// requestJSON makes a request with a JSON body (if in is not nil), and
+// unmarshals the response into out.
+func (c *Client) requestJSON(rp requestParams, in, out any) error {
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		rp.body = data
+		rp.contentType = "application/json"
+	}
+	resp, err := c.request(rp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp, out)
+}
+
+// Common logic for making HTTP requests. Requests which fail with a network
+// error or a 5xx status are retried with exponential backoff, as long as it
+// is safe to repeat them.
 func (c *Client) request(rp requestParams) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doRequest(rp)
+		if err == nil || attempt >= c.retries || !retryable(rp.method, err) {
+			return resp, err
+		}
+
+		wait := c.backoff << attempt
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		time.Sleep(wait)
+	}
+}
+
+// retryable returns true if a request with the given method, which failed
+// with the given error, can be safely retried.
+func retryable(method string, err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		// Network error - only retry idempotent requests, as a non-idempotent
+		// request may have been processed before the error.
+		return method != http.MethodPost
+	}
+
+	switch {
+	case statusErr.StatusCode == http.StatusTooManyRequests:
+		// Request was rejected before being processed
+		return true
+	case statusErr.StatusCode >= 500:
+		return method != http.MethodPost
+	default:
+		return false
+	}
+}
+
+// doRequest makes a single attempt at the given request.
+func (c *Client) doRequest(rp requestParams) ([]byte, error) {
 	// Prepare request URL
 	endpoint := *c.serverURL
 	endpoint.Path = rp.path
@@ -238,8 +756,8 @@ func (c *Client) request(rp requestParams) ([]byte, error) {
 	if rp.contentType != "" {
 		req.Header.Set("Content-Type", rp.contentType)
 	}
-	if rp.auth {
-		req.Header.Set("Authorization", c.authKey)
+	if rp.auth && c.authKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authKey)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
@@ -250,7 +768,14 @@ func (c *Client) request(rp requestParams) ([]byte, error) {
 
 	// For 4xx/5xx response codes, we want to error
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("response has status %q", resp.Status)
+		statusErr := &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, statusErr
 	}
 
 	// Read body and return
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/client"
+)
+
+// whoami prints the user the CLI is authenticated as on the server.
+//
+//	chords whoami
+func whoami(st state, _ []string) {
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+	user, err := c.Me()
+	if err != nil {
+		reportErrors([]error{err})
+	}
+	if st.json {
+		printJSON(user)
+		return
+	}
+	fmt.Printf("%s (%s)\n", user.Name, user.Role)
+	if user.Scopes != nil {
+		scopes := []string{}
+		for _, scope := range user.Scopes {
+			scopes = append(scopes, string(scope))
+		}
+		fmt.Printf("token scopes: %s\n", strings.Join(scopes, ", "))
+	}
+}
+
+// users manages the user accounts on the server. This needs the admin role.
+//
+//	chords users list
+//	chords users add [--password] <name> <role>
+//	chords users role <name> <role>
+//	chords users passwd <name>
+//	chords users remove <name>
+func users(st state, args []string) {
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	subcommand, args := args[0], args[1:]
+	wantArgs := map[string]int{"list": 0, "add": 2, "role": 2, "passwd": 1, "remove": 1}
+	n, ok := wantArgs[subcommand]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown users command %q\n\n", subcommand)
+		findCommand("users").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if len(args) != n {
+		findCommand("users").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	var user auth.User
+	switch subcommand {
+	case "list":
+		users, err := c.ListUsers()
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		if st.json {
+			printJSON(users)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", u.Name, u.Role, u.Created.Local().Format("2006-01-02"))
+		}
+		tw.Flush()
+		return
+
+	case "add":
+		role, err := auth.ParseRole(args[1])
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		password := ""
+		if usersPassword {
+			password = readPassword()
+		}
+		user, err = c.AddUser(args[0], role, password)
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("adding user %q: %w", args[0], err)})
+		}
+		fmt.Printf("added user %q (%s)\n", user.Name, user.Role)
+
+	case "role":
+		role, err := auth.ParseRole(args[1])
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		user, err = c.UpdateUser(args[0], auth.UserUpdate{Role: &role})
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("updating user %q: %w", args[0], err)})
+		}
+		fmt.Printf("user %q is now %s\n", user.Name, user.Role)
+
+	case "passwd":
+		password := readPassword()
+		_, err := c.UpdateUser(args[0], auth.UserUpdate{Password: &password})
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("updating user %q: %w", args[0], err)})
+		}
+		if password == "" {
+			fmt.Printf("removed password for user %q\n", args[0])
+		} else {
+			fmt.Printf("changed password for user %q\n", args[0])
+		}
+
+	case "remove":
+		err := c.RemoveUser(args[0])
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("removing user %q: %w", args[0], err)})
+		}
+		fmt.Printf("removed user %q and revoked their tokens\n", args[0])
+	}
+}
+
+// readPassword reads a password from the first line of stdin.
+func readPassword() string {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		reportErrors([]error{fmt.Errorf("reading password: %w", err)})
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// tokens manages API tokens on the server. This needs the admin role.
+//
+//	chords token create --scope <scopes> [--ttl <duration>] [--name <name>] <user>
+//	chords token list [user]
+//	chords token revoke <id>
+func tokens(st state, args []string) {
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	subcommand, args := args[0], args[1:]
+	switch {
+	case subcommand == "create" && len(args) == 1:
+		scopes, err := parseScopes(tokenScopes)
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		ttl, err := parseTTL(tokenTTL)
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		token, secret, err := c.CreateToken(args[0], tokenName, scopes, time.Now().Add(ttl))
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("creating token: %w", err)})
+		}
+		if st.json {
+			printJSON(map[string]any{"id": token.ID, "user": token.User, "scopes": token.Scopes,
+				"expires": token.Expires, "token": secret})
+			return
+		}
+		fmt.Fprintf(os.Stderr, "created token %s for user %q, expiring %s - it won't be shown again:\n",
+			token.ID, token.User, token.Expires.Local().Format("2006-01-02 15:04"))
+		fmt.Println(secret)
+
+	case subcommand == "list" && len(args) <= 1:
+		user := ""
+		if len(args) == 1 {
+			user = args[0]
+		}
+		tokens, err := c.ListTokens(user)
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		if st.json {
+			printJSON(tokens)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, t := range tokens {
+			scopes := []string{}
+			for _, scope := range t.Scopes {
+				scopes = append(scopes, string(scope))
+			}
+			expires := "expires " + t.Expires.Local().Format("2006-01-02")
+			if t.Expires.Before(time.Now()) {
+				expires = "expired"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.User, strings.Join(scopes, ","), expires, t.Name)
+		}
+		tw.Flush()
+
+	case subcommand == "revoke" && len(args) == 1:
+		err := c.RevokeToken(args[0])
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("revoking token: %w", err)})
+		}
+		fmt.Printf("revoked token %s\n", args[0])
+
+	default:
+		findCommand("token").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// parseScopes parses a comma-separated list of token scopes.
+func parseScopes(s string) ([]auth.Scope, error) {
+	if s == "" {
+		all := []string{}
+		for _, scope := range auth.Scopes {
+			all = append(all, string(scope))
+		}
+		return nil, fmt.Errorf("no scopes given - use --scope with some of: %s", strings.Join(all, ", "))
+	}
+	scopes := []auth.Scope{}
+	for _, name := range strings.Split(s, ",") {
+		scope, err := auth.ParseScope(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// parseTTL parses a token lifetime. As well as Go durations like "12h", it
+// accepts a number of days like "30d".
+func parseTTL(s string) (time.Duration, error) {
+	var ttl time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		ttl, err = time.ParseDuration(s)
+	}
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return ttl, nil
+}
@@ -10,12 +10,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/barrettj12/chords/src/client"
 	"github.com/barrettj12/chords/src/dblayer"
@@ -23,135 +26,380 @@ import (
 )
 
 func main() {
-	st := initState()
-	cmd := os.Args[1]
-	args := os.Args[2:]
-
-	switch cmd {
-	case "albums":
-		albums(st, args)
-	case "backup":
-		backup(args)
-	case "count":
-		count(st, args)
-	case "delete", "rm", "remove":
-		delete(st, args)
-	case "diff":
-		diff(st, args)
-	case "edit":
-		edit(st, args)
-	case "new":
-		new(st, args)
-	case "pull":
-		pull(args)
-	case "sync":
-		sync(st, args)
-	case "update-chords":
-		updateChords(st, args)
-	case "validate":
-		validate(st, args)
-	default:
-		fmt.Printf("unknown command %q\n", cmd)
-		os.Exit(1)
+	st, args := initState(os.Args[1:])
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		os.Exit(2)
 	}
-}
 
-// Global state, passed to subcommands
-type state struct {
-	dbPath    string
-	serverURL string
-	authKey   string
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd.execute(st, args[1:])
 }
 
-func initState() state {
-	dbPath, ok := os.LookupEnv("DATABASE_URL")
-	if !ok {
-		dbPath = "./data"
-	}
+// Command-specific flags
+var (
+	// remoteName is the remote to use for sync, diff and pull
+	remoteName string
 
-	serverURL, ok := os.LookupEnv("SERVER_URL")
-	if !ok {
-		serverURL = "https://chords.fly.dev"
-	}
+	syncDryRun bool
 
-	authKey, err := os.ReadFile("auth_key")
-	if err != nil {
-		fmt.Printf("WARNING: couldn't read auth_key: %v\n", err)
-	} else {
-		fmt.Println("INFO: using auth key from file")
-	}
+	showTranspose  int
+	showCapo       int
+	showChordsOnly bool
+	showWidth      int
+	showColour     string
+	showNoPager    bool
+	showRemote     bool
 
-	return state{
-		dbPath,
-		serverURL,
-		string(authKey),
-	}
-}
+	trashAll bool
+	trashYes bool
+
+	mergeYes bool
+
+	usersPassword bool
+	tokenName     string
+	tokenScopes   string
+	tokenTTL      string
+
+	suggestionsStatus string
+	suggestionsSong   string
+	suggestionsForce  bool
 
-// pull gets chords from server.
-func pull(args []string) {
-	fmt.Println("pulling", args)
+	logUser   string
+	logAction string
+	logSince  string
+	logLimit  int
+	logDiff   bool
+)
+
+// The list of subcommands. This is populated in init, as some commands
+// (e.g. help) need to refer to it.
+var commands []*command
+
+func init() {
+	commands = []*command{{
+		name:     "albums",
+		args:     "[artists...]",
+		summary:  "List albums and their tracks",
+		maxArgs:  -1,
+		complete: argArtist,
+		run:      albums,
+	}, {
+		name:    "backup",
+		args:    "[output-file]",
+		summary: "Download a full backup of the remote database",
+		maxArgs: 1,
+		run:     backup,
+	}, {
+		name:     "completion",
+		args:     "<bash|zsh|fish>",
+		summary:  "Print a shell completion script",
+		minArgs:  1,
+		maxArgs:  1,
+		complete: argShell,
+		run:      completion,
+	}, {
+		name:     "config",
+		args:     "<show|remotes>",
+		summary:  "Show the configuration, or the remote servers which can be used",
+		minArgs:  1,
+		maxArgs:  1,
+		complete: argConfig,
+		run:      configCmd,
+	}, {
+		name:     "count",
+		args:     "[artists...]",
+		summary:  "Count the number of songs for each artist",
+		maxArgs:  -1,
+		complete: argArtist,
+		run:      count,
+	}, {
+		name:     "delete",
+		aliases:  []string{"rm", "remove"},
+		args:     "<ids...>",
+		summary:  "Move songs to the trash, locally and remotely",
+		minArgs:  1,
+		maxArgs:  -1,
+		complete: argSongID,
+		run:      remove,
+	}, {
+		name:     "diff",
+		args:     "[song-ids...]",
+		summary:  "Compare the local database to remote",
+		maxArgs:  -1,
+		flags:    remoteFlag,
+		complete: argSongID,
+		run:      diff,
+	}, {
+		name:     "edit",
+		args:     "<id>",
+		summary:  "Open chords for editing, then sync them",
+		minArgs:  1,
+		maxArgs:  1,
+		complete: argSongID,
+		run:      edit,
+	}, {
+		name:     "help",
+		args:     "[command]",
+		summary:  "Show help for the CLI or a command",
+		maxArgs:  1,
+		complete: argCommand,
+		run:      help,
+	}, {
+		name:    "log",
+		args:    "[song-id]",
+		summary: "Show who changed what on the server",
+		maxArgs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&logUser, "user", "", "only show changes made by this `user`")
+			fs.StringVar(&logAction, "action", "", "only show changes of this kind, e.g. chords.update")
+			fs.StringVar(&logSince, "since", "", "only show changes since this `time`, e.g. 24h, 7d or 2006-01-02")
+			fs.IntVar(&logLimit, "limit", 50, "show at most `n` changes")
+			fs.BoolVar(&logDiff, "diff", false, "show the diff of each change")
+		},
+		complete: argSongID,
+		run:      auditLog,
+	}, {
+		name:    "merge",
+		args:    "<keep-id> <duplicate-id>",
+		summary: "Merge a duplicate song into another, locally and remotely",
+		minArgs: 2,
+		maxArgs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&mergeYes, "yes", false, "don't ask for confirmation before merging")
+		},
+		complete: argSongID,
+		run:      merge,
+	}, {
+		name:     "mv",
+		aliases:  []string{"rename"},
+		args:     "<old-id> <new-id>",
+		summary:  "Change a song's ID, locally and remotely",
+		minArgs:  2,
+		maxArgs:  2,
+		complete: argSongID,
+		run:      move,
+	}, {
+		name:    "new",
+		summary: "Interactively add a new song to the local database",
+		run:     new,
+	}, {
+		name:     "pull",
+		args:     "[song-ids...]",
+		summary:  "Copy songs from remote to local, overwriting local changes",
+		maxArgs:  -1,
+		flags:    remoteFlag,
+		complete: argSongID,
+		run:      pull,
+	}, {
+		name:     "relate",
+		args:     "<artist> <related-artist>",
+		summary:  "Add two artists to each other's \"see also\" lists",
+		minArgs:  2,
+		maxArgs:  2,
+		complete: argArtist,
+		run:      relate,
+	}, {
+		name:    "show",
+		args:    "<id|search terms...>",
+		summary: "Print a chord sheet, with chords highlighted",
+		minArgs: 1,
+		maxArgs: -1,
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&showTranspose, "transpose", 0, "transpose the chords by `N` semitones")
+			fs.IntVar(&showCapo, "capo", 0, "show chord shapes for a capo on fret `N`")
+			fs.BoolVar(&showChordsOnly, "chords-only", false, "only show chords, not lyrics")
+			fs.IntVar(&showWidth, "width", 0, "wrap lines to `N` columns (default: terminal width, or no wrapping if not a terminal)")
+			fs.StringVar(&showColour, "color", "auto", "highlight chords: auto, always or never")
+			fs.BoolVar(&showNoPager, "no-pager", false, "don't use a pager")
+			fs.BoolVar(&showRemote, "remote", false, "look up the song on remote, not in the local database")
+		},
+		complete: argSongID,
+		run:      show,
+	}, {
+		name:    "suggestions",
+		aliases: []string{"suggestion"},
+		args:    "<list|show|accept|reject> [id]",
+		summary: "Review suggested corrections on the server",
+		minArgs: 1,
+		maxArgs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&suggestionsStatus, "status", "pending", "list suggestions with this `status` (pending, accepted, rejected or all)")
+			fs.StringVar(&suggestionsSong, "song", "", "only list suggestions for this song `id`")
+			fs.BoolVar(&suggestionsForce, "force", false, "accept even if the chords have changed since the suggestion was made")
+		},
+		complete: argSuggestions,
+		run:      suggestions,
+	}, {
+		name:    "sync",
+		args:    "[song-ids...]",
+		summary: "Sync the local database with remote",
+		maxArgs: -1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&syncDryRun, "dry-run", false, "print what would be done, without changing anything")
+			remoteFlag(fs)
+		},
+		complete: argSongID,
+		run:      syncSongs,
+	}, {
+		name:    "token",
+		args:    "<create|list|revoke> [user|id]",
+		summary: "Create, list or revoke API tokens on the server",
+		minArgs: 1,
+		maxArgs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&tokenName, "name", "", "describe what the token is for")
+			fs.StringVar(&tokenScopes, "scope", "", "comma-separated `scopes` the token can be used for, e.g. songs:write,chords:write")
+			fs.StringVar(&tokenTTL, "ttl", "30d", "how long the token is valid for, e.g. 12h or 90d")
+		},
+		complete: argToken,
+		run:      tokens,
+	}, {
+		name:    "trash",
+		args:    "<list|restore|purge> [ids...]",
+		summary: "List, restore or permanently delete deleted songs",
+		minArgs: 1,
+		maxArgs: -1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&trashAll, "all", false, "purge all songs in the trash")
+			fs.BoolVar(&trashYes, "yes", false, "don't ask for confirmation before purging")
+		},
+		complete: argTrash,
+		run:      trash,
+	}, {
+		name:     "unrelate",
+		args:     "<artist> <related-artist>",
+		summary:  "Remove two artists from each other's \"see also\" lists",
+		minArgs:  2,
+		maxArgs:  2,
+		complete: argArtist,
+		run:      unrelate,
+	}, {
+		name:     "update-chords",
+		args:     "<id> [path-to-chords-file]",
+		summary:  "Upload chords for a song to remote",
+		minArgs:  1,
+		maxArgs:  2,
+		complete: argSongID,
+		run:      updateChords,
+	}, {
+		name:    "users",
+		aliases: []string{"user"},
+		args:    "<list|add|role|passwd|remove> [name] [role]",
+		summary: "Manage user accounts on the server",
+		minArgs: 1,
+		maxArgs: 3,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&usersPassword, "password", false, "read a password for the new user from stdin")
+		},
+		complete: argUsers,
+		run:      users,
+	}, {
+		name:    "validate",
+		summary: "Check the local database for problems",
+		run:     validate,
+	}, {
+		name:    "whoami",
+		summary: "Show which user you are authenticated as on the server",
+		run:     whoami,
+	}, {
+		name:   "__complete",
+		run:    complete,
+		hidden: true,
+		// Arguments are the words on the command line
+		maxArgs: -1,
+	}}
 }
 
-// backup makes a full backup of the database.
-func backup(args []string) {
-	fmt.Println("backing up", args)
+// remoteFlag registers the --remote flag, for commands which compare the
+// local database to a remote.
+func remoteFlag(fs *flag.FlagSet) {
+	fs.StringVar(&remoteName, "remote", "", "use the remote server with this `name` from the config, e.g. staging")
 }
 
-// sync copies chords from local db to remote
+// pull copies songs from the remote db to the local db, overwriting any
+// local changes.
 //
-//	sync [song-ids...]
-func sync(st state, args []string) {
+//	chords pull [--remote <name>] [song-ids...]
+func pull(st state, args []string) {
+	st = st.withRemote(remoteName)
 	db := dblayer.NewLocalfs(st.dbPath, log.Default())
 	c, err := client.NewClient(st.serverURL, st.authKey)
 	check(err)
+	pool := newPool(st.concurrency)
 
-	ids := args
-	var songs []dblayer.SongMeta
-	if len(ids) == 0 {
-		// Sync all songs
-		var err error
-		songs, err = db.GetSongs("", "", "")
-		check(err)
-	} else {
-		songs = make([]dblayer.SongMeta, 0, len(ids))
-		for _, id := range ids {
-			dbSongs, err := db.GetSongs("", id, "")
-			check(err)
-			if len(dbSongs) == 0 {
+	syncState, err := loadSyncState(st.dbPath)
+	check(err)
+	s := &syncer{
+		db:     db,
+		client: c,
+		pool:   pool,
+		base:   syncState.base(strings.TrimSuffix(st.serverURL, "/")),
+	}
+
+	local, err := localSongs(db)
+	check(err)
+	metas, err := c.GetSongs(nil, nil, nil)
+	check(err)
+
+	if len(args) > 0 {
+		remoteIDs := map[string]dblayer.SongMeta{}
+		for _, meta := range metas {
+			remoteIDs[meta.ID] = meta
+		}
+		metas = metas[:0]
+		for _, id := range args {
+			meta, ok := remoteIDs[id]
+			if !ok {
 				fmt.Printf("song %q not found\n", id)
-			} else {
-				songs = append(songs, dbSongs[0])
+				continue
 			}
+			metas = append(metas, meta)
 		}
 	}
 
-	if len(songs) == 0 {
-		fmt.Println("no songs to sync")
-	}
+	errs := pool.run("pulling", len(metas), func(i int) error {
+		id := metas[i].ID
+		chords, err := c.GetChords(id)
+		if err != nil {
+			return fmt.Errorf("getting chords for %q: %w", id, err)
+		}
 
-	for _, localSong := range songs {
-		// TODO: parallelise here using goroutines
-		fmt.Printf("syncing %q\n", localSong.Name)
-		songs, err := c.GetSongs(nil, &localSong.ID, nil)
-		check(err)
-		if len(songs) == 0 {
-			// Song doesn't exist in remote DB
-			_, err := c.NewSong(localSong)
-			check(err)
-		} else if songs[0] != localSong {
-			// Update song in remote DB
-			_, err := c.UpdateSong(localSong.ID, localSong)
-			check(err)
+		err = s.pull(id, &songState{metas[i], chords}, local[id])
+		if err != nil {
+			return fmt.Errorf("pulling %q: %w", id, err)
 		}
+		return nil
+	})
 
-		// Sync chords
-		chords, err := db.GetChords(localSong.ID)
-		check(err)
-		c.UpdateChords(localSong.ID, chords)
-		check(err)
-		fmt.Printf("%s/b/chords?id=%s\n", st.serverURL, url.QueryEscape(localSong.ID))
+	check(syncState.save())
+	reportErrors(errs)
+}
+
+// backup downloads a full backup of the remote database.
+//
+//	chords backup [output-file]
+func backup(st state, args []string) {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		path = fmt.Sprintf("chords-%s.tar.gz", time.Now().Format("20060102-150405"))
 	}
+
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+	snapshot, err := c.Export()
+	check(err)
+
+	err = os.WriteFile(path, snapshot, 0644)
+	check(err)
+	fmt.Printf("backup written to %s\n", path)
 }
 
 // Update chords via the POST /api/v0/chords endpoint
@@ -165,7 +413,7 @@ func updateChords(st state, args []string) {
 	if len(args) > 1 {
 		path = args[1]
 	} else {
-		path = fmt.Sprintf("./data/%s/chords.txt", songID)
+		path = filepath.Join(st.dbPath, songID, "chords.txt")
 	}
 
 	chords, err := os.ReadFile(path)
@@ -177,16 +425,16 @@ func updateChords(st state, args []string) {
 
 // Count number of songs for each artist
 //
-//	count [artists...]
+//	chords count [artists...]
 func count(st state, args []string) {
 	artists := args
 	c, err := client.NewClient(st.serverURL, st.authKey)
 	check(err)
 
-	counts := make(map[string]int, len(artists))
-	songs, err := c.GetSongs(nil, nil, nil)
-	check(err)
+	songs, errs := songsByArtist(c, newPool(st.concurrency), artists)
+	reportErrors(errs)
 
+	counts := make(map[string]int, len(artists))
 	for _, song := range songs {
 		counts[song.Artist]++
 	}
@@ -208,6 +456,21 @@ func count(st state, args []string) {
 		return ci > cj
 	})
 
+	if st.json {
+		type artistCount struct {
+			Artist string `json:"artist"`
+			Count  int    `json:"count"`
+		}
+		result := []artistCount{}
+		for _, artist := range artists {
+			if counts[artist] > 0 {
+				result = append(result, artistCount{artist, counts[artist]})
+			}
+		}
+		printJSON(result)
+		return
+	}
+
 	for _, artist := range artists {
 		numSongs := counts[artist]
 		if numSongs > 0 {
@@ -216,6 +479,34 @@ func count(st state, args []string) {
 	}
 }
 
+// songsByArtist gets the songs for the given artists from the server, in
+// parallel. If no artists are given, it gets all songs.
+func songsByArtist(c *client.Client, pool *workerPool, artists []string) ([]dblayer.SongMeta, []error) {
+	if len(artists) == 0 {
+		songs, err := c.GetSongs(nil, nil, nil)
+		if err != nil {
+			return nil, []error{fmt.Errorf("getting songs: %w", err)}
+		}
+		return songs, nil
+	}
+
+	results := make([][]dblayer.SongMeta, len(artists))
+	errs := pool.run("fetching songs", len(artists), func(i int) error {
+		songs, err := c.GetSongs(&artists[i], nil, nil)
+		if err != nil {
+			return fmt.Errorf("getting songs for %q: %w", artists[i], err)
+		}
+		results[i] = songs
+		return nil
+	})
+
+	songs := []dblayer.SongMeta{}
+	for _, r := range results {
+		songs = append(songs, r...)
+	}
+	return songs, errs
+}
+
 // List albums and their tracks
 //
 //	usage: chords albums [artists...]
@@ -223,20 +514,8 @@ func albums(st state, args []string) {
 	c, err := client.NewClient(st.serverURL, st.authKey)
 	check(err)
 
-	artists := args
-
-	songs := []dblayer.SongMeta{}
-	if len(artists) == 0 {
-		// Get songs for all artists
-		songs, err = c.GetSongs(nil, nil, nil)
-		check(err)
-	} else {
-		for _, artist := range artists {
-			newSongs, err := c.GetSongs(&artist, nil, nil)
-			check(err)
-			songs = append(songs, newSongs...)
-		}
-	}
+	songs, errs := songsByArtist(c, newPool(st.concurrency), args)
+	reportErrors(errs)
 
 	//            artist -> album -> (trackNum, song)
 	type songWithNum struct {
@@ -253,6 +532,24 @@ func albums(st state, args []string) {
 			albums[song.Artist][song.Album], songWithNum{song.Name, song.TrackNum})
 	}
 
+	if st.json {
+		// artist -> album -> song names, in track order
+		result := map[string]map[string][]string{}
+		for artist, albumMap := range albums {
+			result[artist] = map[string][]string{}
+			for album, tracks := range albumMap {
+				sort.SliceStable(tracks, func(i, j int) bool {
+					return tracks[i].num < tracks[j].num
+				})
+				for _, song := range tracks {
+					result[artist][album] = append(result[artist][album], song.name)
+				}
+			}
+		}
+		printJSON(result)
+		return
+	}
+
 	// Print albums
 	for artist, albumMap := range albums {
 		fmt.Println(artist)
@@ -290,7 +587,7 @@ func edit(st state, args []string) {
 		log.Fatalf("no chords found with ID %q", id)
 	}
 
-	editor := chooseEditor()
+	editor := chooseEditor(st.editor)
 	editorCmd := exec.Command(editor, "--wait", fmt.Sprintf("%s/%s/chords.txt", st.dbPath, id))
 	err = editorCmd.Start()
 	if err != nil {
@@ -303,22 +600,50 @@ func edit(st state, args []string) {
 	if err != nil {
 		log.Fatalf("Error editing chords: %s", err)
 	}
-	sync(st, []string{id})
+	syncSongs(st, []string{id})
 }
 
-// Delete a set of chords locally and remotely
+// Delete songs locally and remotely. Deleted songs are moved to the trash,
+// and can be restored using `chords trash restore`.
 //
-//	chords delete <id>
-func delete(st state, args []string) {
-	id := args[0]
-
+//	chords delete <ids...>
+func remove(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
 	c, err := client.NewClient(st.serverURL, st.authKey)
 	check(err)
 
-	c.DeleteSong(id)
-	check(err)
+	errs := []error{}
+	for _, id := range args {
+		local, err := db.GetSongs("", id, "")
+		check(err)
+		remote, err := c.GetSongs(nil, &id, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("getting remote song %q: %w", id, err))
+			continue
+		}
+		if len(local) == 0 && len(remote) == 0 {
+			errs = append(errs, fmt.Errorf("song %q not found", id))
+			continue
+		}
 
-	// TODO: delete locally
+		if len(local) > 0 {
+			err = db.DeleteSong(id)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("deleting local song %q: %w", id, err))
+			} else {
+				fmt.Printf("moved local song %q to trash\n", id)
+			}
+		}
+		if len(remote) > 0 {
+			err = c.DeleteSong(id)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("deleting remote song %q: %w", id, err))
+			} else {
+				fmt.Printf("moved remote song %q to trash\n", id)
+			}
+		}
+	}
+	reportErrors(errs)
 }
 
 // HELPER FUNCTIONS
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/barrettj12/chords/src/config"
+)
+
+// command describes a CLI subcommand.
+type command struct {
+	name    string
+	aliases []string
+	// args is a synopsis of the positional arguments, e.g. "<id> [path]"
+	args    string
+	summary string
+	// Number of positional arguments accepted. maxArgs < 0 means unlimited.
+	minArgs, maxArgs int
+	// flags, if set, registers the command's flags.
+	flags func(fs *flag.FlagSet)
+	// complete says what the positional arguments should complete to.
+	complete argKind
+	run      func(st state, args []string)
+	// hidden commands are not shown in the help.
+	hidden bool
+}
+
+// argKind is the kind of value taken by a command's positional arguments,
+// used for shell completion.
+type argKind int
+
+const (
+	argNone argKind = iota
+	argSongID
+	argArtist
+	argFile
+	argShell
+	argCommand
+	// A trash subcommand, followed by IDs of songs in the trash
+	argTrash
+	// A users subcommand, followed by a user name and role
+	argUsers
+	// A token subcommand
+	argToken
+	// A suggestions subcommand
+	argSuggestions
+	// A config subcommand
+	argConfig
+)
+
+// findCommand returns the command with the given name or alias, or nil if
+// there is no such command.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// flagSet returns a new FlagSet containing the command's flags.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	return fs
+}
+
+// execute parses the command's flags and arguments, and runs it. If the
+// arguments are invalid, it prints the usage and exits.
+func (c *command) execute(st state, args []string) {
+	args, err := c.parseArgs(args)
+	if errors.Is(err, flag.ErrHelp) {
+		c.printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		c.printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	if len(args) < c.minArgs || (c.maxArgs >= 0 && len(args) > c.maxArgs) {
+		c.printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	c.run(st, args)
+}
+
+// parseArgs parses the command's flags, and returns the positional
+// arguments. Unlike flag.Parse, flags may come after positional arguments.
+// Everything after "--" is treated as a positional argument.
+func (c *command) parseArgs(args []string) ([]string, error) {
+	fs := c.flagSet()
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func (c *command) printUsage(w io.Writer) {
+	usage := "chords " + c.name
+	if c.flags != nil {
+		usage += " [flags]"
+	}
+	if c.args != "" {
+		usage += " " + c.args
+	}
+	fmt.Fprintf(w, "usage: %s\n\n%s\n", usage, c.summary)
+	if len(c.aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(c.aliases, ", "))
+	}
+
+	if c.flags != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		fs := c.flagSet()
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// GLOBAL FLAGS
+
+// Global state, passed to subcommands
+type state struct {
+	dbPath string
+	// remote is the name of the remote server (see config.CLI.Remotes)
+	remote    string
+	serverURL string
+	authKey   string
+	editor    string
+	// Maximum number of concurrent requests to the server
+	concurrency int
+	// Print output as JSON, where supported
+	json bool
+
+	// cfg is the configuration the state was made from, and cfgPath the
+	// file it was loaded from, if any
+	cfg     config.Config
+	cfgPath string
+}
+
+// globalFlags returns a FlagSet for the global flags, which writes the parsed
+// values into st, and the other flags' values into configPath and
+// authKeyFile. The defaults come from the config (see initState), so they
+// aren't set here.
+func globalFlags(st *state, configPath, authKeyFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chords", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	fs.StringVar(configPath, "config", "",
+		"path to the config `file` (env CHORDS_CONFIG; default ./chords.yaml or ~/.config/chords/config.yaml)")
+	fs.StringVar(&st.remote, "remote", "",
+		"`name` of the remote server to use, from the config (env CHORDS_REMOTE; default prod)")
+	fs.StringVar(&st.serverURL, "server", "",
+		"`URL` of the chords server, instead of the remote's (env SERVER_URL)")
+	fs.StringVar(&st.dbPath, "db", "",
+		"`path` to the local database (env DATABASE_URL; default ./data)")
+	fs.StringVar(authKeyFile, "auth-key-file", "",
+		"`file` containing the server auth key (env AUTH_KEY_FILE; default auth_key)")
+	fs.IntVar(&st.concurrency, "concurrency", 0,
+		"maximum number of concurrent requests to the server (env CHORDS_CONCURRENCY; default 8)")
+	fs.BoolVar(&st.json, "json", false, "print output as JSON, where supported")
+	return fs
+}
+
+// initState parses the global flags from the start of args, and returns the
+// resulting state, and the remaining arguments. The config file is read
+// first, then the environment and flags override it.
+func initState(args []string) (state, []string) {
+	flags := state{}
+	configPath, authKeyFile := "", ""
+	fs := globalFlags(&flags, &configPath, &authKeyFile)
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	if configPath == "" {
+		configPath = os.Getenv("CHORDS_CONFIG")
+	}
+	cfg, cfgPath, err := config.Load(configPath)
+	if err == nil {
+		err = cfg.CLI.ApplyEnv(os.LookupEnv)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "remote":
+			cfg.CLI.Remote = flags.remote
+			// A remote given on the command line overrides SERVER_URL
+			if !isFlagSet(fs, "server") {
+				cfg.CLI.Server = ""
+			}
+		case "server":
+			cfg.CLI.Server = flags.serverURL
+		case "db":
+			cfg.CLI.DB = flags.dbPath
+		case "auth-key-file":
+			cfg.CLI.AuthKeyFile = authKeyFile
+		case "concurrency":
+			cfg.CLI.Concurrency = flags.concurrency
+		}
+	})
+	if err == nil {
+		err = cfg.CLI.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		os.Exit(2)
+	}
+
+	st := state{
+		dbPath:      cfg.CLI.DB,
+		editor:      cfg.CLI.Editor,
+		concurrency: cfg.CLI.Concurrency,
+		json:        flags.json,
+		cfg:         cfg,
+		cfgPath:     cfgPath,
+	}
+	return st.useRemote(cfg.CLI), fs.Args()
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// useRemote returns a copy of st which uses the remote server chosen by cli,
+// reading its auth key.
+func (st state) useRemote(cli config.CLI) state {
+	remote, err := cli.Target()
+	check(err)
+	st.remote, st.serverURL, st.authKey = cli.Remote, remote.URL, ""
+	if cli.Server != "" {
+		st.remote = ""
+	}
+
+	authKeyFile := remote.AuthKeyFile
+	if authKeyFile == "" {
+		authKeyFile = config.DefaultAuthKeyFile
+	}
+	authKey, err := os.ReadFile(authKeyFile)
+	if err == nil {
+		st.authKey = strings.TrimSpace(string(authKey))
+	} else if !errors.Is(err, os.ErrNotExist) || remote.AuthKeyFile != "" {
+		// Only warn if the user has asked for a specific auth key file.
+		fmt.Fprintf(os.Stderr, "WARNING: couldn't read auth key: %v\n", err)
+	}
+	return st
+}
+
+// withRemote returns a copy of st which uses the named remote from the
+// config, for commands with a --remote flag. If name is empty, st is
+// returned unchanged.
+func (st state) withRemote(name string) state {
+	if name == "" {
+		return st
+	}
+	cli := st.cfg.CLI
+	cli.Remote, cli.Server = name, ""
+	return st.useRemote(cli)
+}
+
+// printUsage prints the general help for the CLI.
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "usage: chords [global flags] <command> [flags] [args...]\n\nCommands:\n")
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			fmt.Fprintf(w, "  %-15s %s\n", cmd.name, cmd.summary)
+		}
+	}
+
+	fmt.Fprintln(w, "\nGlobal flags:")
+	configPath, authKeyFile := "", ""
+	fs := globalFlags(&state{}, &configPath, &authKeyFile)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\nRun \"chords help <command>\" for more information on a command.")
+}
+
+// help prints help for the CLI or a given command.
+//
+//	chords help [command]
+func help(_ state, args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+	cmd.printUsage(os.Stdout)
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	check(err)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// completion prints a completion script for the given shell.
+//
+//	chords completion <bash|zsh|fish>
+func completion(_ state, args []string) {
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q (expected bash, zsh or fish)\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// The completion scripts pass the words on the command line (after "chords",
+// and including the word being completed) to the hidden __complete command,
+// which prints the possible completions, one per line.
+
+const bashCompletion = `# bash completion for chords
+# Add to ~/.bashrc:  source <(chords completion bash)
+_chords() {
+	local IFS=$'\n'
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	COMPREPLY=($(chords __complete -- "${COMP_WORDS[@]:1:COMP_CWORD-1}" "$cur" 2>/dev/null))
+	# Escape spaces in artist names
+	COMPREPLY=("${COMPREPLY[@]// /\\ }")
+}
+complete -o default -F _chords chords
+`
+
+const zshCompletion = `#compdef chords
+# zsh completion for chords
+# Add to ~/.zshrc:  source <(chords completion zsh)
+_chords() {
+	local -a completions
+	completions=("${(@f)$(chords __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	[[ -n "${completions[1]}" ]] && compadd -a completions
+}
+compdef _chords chords
+`
+
+const fishCompletion = `# fish completion for chords
+# Add to ~/.config/fish/config.fish:  chords completion fish | source
+complete -c chords -f -a '(chords __complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+
+// complete prints the possible completions for the last word in args. It is
+// called by the completion scripts.
+//
+//	chords __complete -- [words...] <current-word>
+func complete(st state, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	for _, c := range completions(st, args[:len(args)-1], args[len(args)-1]) {
+		fmt.Println(c)
+	}
+}
+
+// completions returns the possible values for the current word cur, given the
+// preceding words on the command line.
+func completions(st state, words []string, cur string) []string {
+	// The shell may pass on an opening quote
+	cur = strings.TrimLeft(cur, `"'`)
+
+	// Skip over global flags. These may change the DB path.
+	parsed, configPath, authKeyFile := state{}, "", ""
+	globals := globalFlags(&parsed, &configPath, &authKeyFile)
+	i := 0
+	for ; i < len(words) && strings.HasPrefix(words[i], "-"); i++ {
+		if !strings.Contains(words[i], "=") && takesValue(globals, strings.TrimLeft(words[i], "-")) {
+			i++
+		}
+	}
+	if i > len(words) {
+		// Completing the value of a global flag
+		return nil
+	}
+	globals.Parse(words[:i])
+	globals.Visit(func(f *flag.Flag) {
+		if f.Name == "db" {
+			st.dbPath = parsed.dbPath
+		}
+	})
+
+	if i == len(words) {
+		if strings.HasPrefix(cur, "-") {
+			return filterPrefix(flagNames(globals), cur)
+		}
+		names := []string{}
+		for _, cmd := range commands {
+			if !cmd.hidden {
+				names = append(names, cmd.name)
+			}
+		}
+		return filterPrefix(names, cur)
+	}
+
+	cmd := findCommand(words[i])
+	if cmd == nil {
+		return nil
+	}
+	fs := cmd.flagSet()
+	if n := len(words); n > i+1 && strings.HasPrefix(words[n-1], "-") &&
+		!strings.Contains(words[n-1], "=") && takesValue(fs, strings.TrimLeft(words[n-1], "-")) {
+		// Completing the flag value
+		return nil
+	}
+	if strings.HasPrefix(cur, "-") {
+		return filterPrefix(flagNames(fs), cur)
+	}
+
+	var candidates []string
+	switch cmd.complete {
+	case argSongID:
+		candidates = localSongIDs(st.dbPath)
+	case argArtist:
+		candidates = localArtists(st.dbPath)
+	case argShell:
+		candidates = []string{"bash", "fish", "zsh"}
+	case argTrash:
+		if len(positionalArgs(fs, words[i+1:])) == 0 {
+			candidates = []string{"list", "purge", "restore"}
+		} else {
+			candidates = trashedSongIDs(st.dbPath)
+		}
+	case argUsers:
+		switch len(positionalArgs(fs, words[i+1:])) {
+		case 0:
+			candidates = []string{"add", "list", "passwd", "remove", "role"}
+		case 2:
+			for _, role := range auth.Roles {
+				candidates = append(candidates, string(role))
+			}
+		}
+	case argToken:
+		if len(positionalArgs(fs, words[i+1:])) == 0 {
+			candidates = []string{"create", "list", "revoke"}
+		}
+	case argSuggestions:
+		if len(positionalArgs(fs, words[i+1:])) == 0 {
+			candidates = []string{"accept", "list", "reject", "show"}
+		}
+	case argConfig:
+		if len(positionalArgs(fs, words[i+1:])) == 0 {
+			candidates = []string{"remotes", "show"}
+		}
+	case argCommand:
+		for _, cmd := range commands {
+			if !cmd.hidden {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+	}
+	return filterPrefix(candidates, cur)
+}
+
+// takesValue returns true if the named flag exists and requires a value.
+func takesValue(fs *flag.FlagSet, name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return false
+	}
+	return true
+}
+
+// flagNames returns the names of all flags in fs, in the form "--name".
+func flagNames(fs *flag.FlagSet) []string {
+	names := []string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	return names
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	matches := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// positionalArgs returns the words which aren't flags or flag values.
+func positionalArgs(fs *flag.FlagSet, words []string) []string {
+	args := []string{}
+	for i := 0; i < len(words); i++ {
+		if !strings.HasPrefix(words[i], "-") {
+			args = append(args, words[i])
+		} else if !strings.Contains(words[i], "=") && takesValue(fs, strings.TrimLeft(words[i], "-")) {
+			i++
+		}
+	}
+	return args
+}
+
+// localSongIDs returns the IDs of all songs in the local DB. It reads the
+// directory directly, rather than opening the DB, so it is fast enough to
+// run on every keypress.
+func localSongIDs(dbPath string) []string {
+	entries, err := os.ReadDir(dbPath)
+	if err != nil {
+		return nil
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids
+}
+
+// localArtists returns the distinct artists of all songs in the local DB.
+func localArtists(dbPath string) []string {
+	artistSet := map[string]struct{}{}
+	for _, id := range localSongIDs(dbPath) {
+		data, err := os.ReadFile(filepath.Join(dbPath, id, "meta.json"))
+		if err != nil {
+			continue
+		}
+		meta := dblayer.SongMeta{}
+		if json.Unmarshal(data, &meta) == nil && meta.Artist != "" {
+			artistSet[meta.Artist] = struct{}{}
+		}
+	}
+
+	artists := make([]string, 0, len(artistSet))
+	for artist := range artistSet {
+		artists = append(artists, artist)
+	}
+	sort.Strings(artists)
+	return artists
+}
+
+// trashedSongIDs returns the IDs of the songs in the local trash.
+func trashedSongIDs(dbPath string) []string {
+	entries, err := os.ReadDir(filepath.Join(dbPath, ".trash"))
+	if err != nil {
+		return nil
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		// Trashed songs are stored as [time]_[id]
+		if _, id, ok := strings.Cut(entry.Name(), "_"); ok && entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletions(t *testing.T) {
+	dbPath := t.TempDir()
+	for id, artist := range map[string]string{"YourSong": "Elton John", "HeyJude": "The Beatles"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dbPath, id), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(dbPath, id, "meta.json"),
+			[]byte(`{"id":"`+id+`","artist":"`+artist+`"}`), 0666))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dbPath, ".trash", "20240101T000000.000000000Z_OldSong"), 0777))
+	st := state{dbPath: "nonexistent"}
+
+	tests := []struct {
+		words    []string
+		cur      string
+		expected []string
+	}{
+		{nil, "sy", []string{"sync"}},
+		{nil, "--js", []string{"--json"}},
+		{[]string{"--db", dbPath}, "", nil}, // all commands
+		{[]string{"--db", dbPath, "edit"}, "", []string{"HeyJude", "YourSong"}},
+		{[]string{"--db=" + dbPath, "count"}, "The", []string{"The Beatles"}},
+		{[]string{"--db", dbPath, "count"}, `"El`, []string{"Elton John"}},
+		{[]string{"sync"}, "--", []string{"--dry-run", "--remote"}},
+		{[]string{"config"}, "", []string{"remotes", "show"}},
+		{[]string{"completion"}, "", []string{"bash", "fish", "zsh"}},
+		{[]string{"trash"}, "", []string{"list", "purge", "restore"}},
+		{[]string{"--db", dbPath, "trash", "restore"}, "", []string{"OldSong"}},
+		{[]string{"users"}, "", []string{"add", "list", "passwd", "remove", "role"}},
+		{[]string{"users", "role", "alice"}, "ed", []string{"editor"}},
+		{[]string{"token"}, "", []string{"create", "list", "revoke"}},
+		{[]string{"suggestions", "--force"}, "a", []string{"accept"}},
+		{[]string{"--server"}, "", []string{}},
+		{[]string{"unknown"}, "", []string{}},
+	}
+
+	for _, test := range tests {
+		got := completions(st, test.words, test.cur)
+		if test.expected == nil {
+			assert.Contains(t, got, "help")
+			assert.NotContains(t, got, "__complete")
+			continue
+		}
+		assert.ElementsMatch(t, test.expected, got, "words %q, cur %q", test.words, test.cur)
+	}
+}
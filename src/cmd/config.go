@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// configCmd shows the CLI's configuration: the config file, overridden by
+// the environment and global flags.
+//
+//	chords config show
+//	chords config remotes
+func configCmd(st state, args []string) {
+	switch args[0] {
+	case "show":
+		cfg := st.cfg
+		// Also show how the environment would configure a server run here
+		if err := cfg.Server.ApplyEnv(os.LookupEnv); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: invalid server config: %v\n", err)
+		}
+		data, err := cfg.Marshal()
+		check(err)
+		if st.cfgPath != "" {
+			fmt.Printf("# Loaded from %s\n", st.cfgPath)
+		} else {
+			fmt.Println("# No config file found, so these are the defaults")
+		}
+		fmt.Print(string(data))
+
+	case "remotes":
+		names := make([]string, 0, len(st.cfg.CLI.Remotes))
+		for name := range st.cfg.CLI.Remotes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if st.json {
+			printJSON(st.cfg.CLI.Remotes)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, name := range names {
+			current := " "
+			if name == st.remote {
+				current = "*"
+			}
+			fmt.Fprintf(tw, "%s %s\t%s\n", current, name, st.cfg.CLI.Remotes[name].URL)
+		}
+		if st.remote == "" {
+			fmt.Fprintf(tw, "* (custom)\t%s\n", st.serverURL)
+		}
+		tw.Flush()
+
+	default:
+		findCommand("config").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
@@ -1,208 +1,201 @@
 package main
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
-	"time"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/util"
 )
 
-// diff compares the local DB to remote.
+// diff compares the local DB to remote, via the API. It exits with status 1
+// if any differences are found.
 //
-//	chords diff
+//	chords diff [--remote <name>] [song-ids...]
 func diff(st state, args []string) {
-	// Create temp dir to hold files
-	tempdir, err := os.MkdirTemp("", "chords-diff-")
+	st = st.withRemote(remoteName)
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
 	check(err)
-	defer func() {
-		err := os.RemoveAll(tempdir)
-		if err == nil {
-			fmt.Printf("cleaned up temp dir %s\n", tempdir)
-		} else {
-			fmt.Printf("error removing temp dir %q: %v\n", tempdir, err)
-		}
-	}()
-
-	fmt.Printf("writing files to %s\n", tempdir)
-	zipFile := filepath.Join(tempdir, "data.tar.gz")
-
-	// Zip remote /data and pull to local machine
-	pullZippedData(zipFile)
+	pool := newPool(st.concurrency)
 
-	// Extract tar archive
-	err = exec.Command("tar", "-zxvf", zipFile, "-C", tempdir).Run()
+	local, err := localSongs(db)
 	check(err)
+	remote, errs := remoteSongs(c, pool)
+	reportErrors(errs)
 
-	// Initialise a map to keep track of which files exist locally/remotely
-	fileMap := make(map[string]fileInfo, 0)
-	remoteDataDir := filepath.Join(tempdir, "data")
-
-	// Put local files in fileMap
-	filepath.WalkDir(remoteDataDir, func(path string, d fs.DirEntry, err error) error {
-		if d.Type().IsRegular() {
-			filename, err := filepath.Rel(remoteDataDir, path)
-			check(err)
-			fileMap[filename] = fileInfo{
-				existsRemotely: true,
-			}
+	ids := args
+	if len(ids) == 0 {
+		for id := range local {
+			ids = append(ids, id)
 		}
-		return nil
-	})
-
-	// Put local files in fileMap
-	filepath.WalkDir(st.dbPath, func(path string, d fs.DirEntry, err error) error {
-		if d.Type().IsRegular() {
-			filename, err := filepath.Rel(st.dbPath, path)
-			check(err)
-			fileMap[filename] = fileInfo{
-				existsLocally:  true,
-				existsRemotely: fileMap[filename].existsRemotely,
+		for id := range remote {
+			if local[id] == nil {
+				ids = append(ids, id)
 			}
 		}
-		return nil
-	})
-
-	// Look for differences
-	diffFound := false
-	reportDiff := func(format string, a ...any) {
-		diffFound = true
-		fmt.Printf(format, a...)
 	}
+	sort.Strings(ids)
 
-	for filename, info := range fileMap {
-		if info.existsLocally && !info.existsRemotely {
-			reportDiff("file %q exists locally but not remotely\n", filename)
-			continue
-		}
-		if !info.existsLocally && info.existsRemotely {
-			reportDiff("file %q exists remotely but not locally\n", filename)
-			continue
-		}
-
-		// Check local file and remote file match
-		localFileContents, err := os.ReadFile(filepath.Join(st.dbPath, filename))
-		check(err)
-
-		remoteFileContents, err := os.ReadFile(filepath.Join(remoteDataDir, filename))
-		check(err)
-
-		if strings.HasSuffix(filename, ".json") {
-			// For JSON files, the exact formatting is not important.
-			// Just check that the fields are the same.
-			var localData, remoteData any
-			err = json.Unmarshal(localFileContents, &localData)
-			check(err)
-			err = json.Unmarshal(remoteFileContents, &remoteData)
-			check(err)
-
-			if !reflect.DeepEqual(localData, remoteData) {
-				reportDiff(`json data %q is different between local and remote
-local data:  %#v
-remote data: %#v
----------------------------------------
-`, filename, localData, remoteData)
-			}
-			continue
+	diffFound := false
+	for _, id := range ids {
+		if printSongDiff(id, local[id], remote[id]) {
+			diffFound = true
 		}
+	}
 
-		if !bytes.Equal(localFileContents, remoteFileContents) {
-			reportDiff(`file %q is different between local and remote
---------- local -----------------------
-%s
---------- remote ----------------------
-%s
----------------------------------------
-`, filename, localFileContents, remoteFileContents)
+	// Only compare see-also data for a full diff
+	if len(args) == 0 {
+		seeAlsoDiff, errs := diffSeeAlso(db, c, pool, local, remote)
+		reportErrors(errs)
+		if seeAlsoDiff {
+			diffFound = true
 		}
 	}
 
 	if !diffFound {
 		fmt.Println("no differences found between local and remote :)")
-	} else {
-		// Should return with exit code 1, but this will skip deferred calls
-		// e.g. cleanup. So don't use os.Exit.
-		// os.Exit(1)
+		return
 	}
+	os.Exit(1)
 }
 
-type fileInfo struct {
-	existsLocally  bool
-	existsRemotely bool
-}
-
-func pullZippedData(localZipFile string) {
-	remoteZipFile := fmt.Sprintf("/tmp/data-%d.tar.gz", time.Now().Unix())
-
-	// SSH in and zip /data for quick file transfer
-	sshCmd := newSSHCommand("zip /data",
-		"fly", "ssh", "console")
-	sshCmd.exitSignal = "logout\n"
-	sshCmd.Execf("tar -zcvf %s /data\n", remoteZipFile)
-	check(sshCmd.Exit())
-
-	// Transfer zip file via sftp
-	sftpCmd := newSSHCommand("file transfer",
-		"fly", "ssh", "sftp", "get", remoteZipFile, localZipFile)
-	sftpCmd.exitSignal = "\x04"
-	check(sftpCmd.Exit())
-
-	// Delete temp file on VM
-	rmCmd := newSSHCommand("cleanup tmp",
-		"fly", "ssh", "console")
-	sshCmd.exitSignal = "logout\n"
-	rmCmd.Execf("rm %s", remoteZipFile)
-	// For some reason we have to force kill this, else it gets stuck.
-	check(rmCmd.cmd.Cancel())
-}
+// printSongDiff prints the differences between the local and remote versions
+// of a song, and returns true if there were any.
+func printSongDiff(id string, local, remote *songState) bool {
+	switch {
+	case local == nil && remote == nil:
+		fmt.Printf("song %q not found\n", id)
+		return false
+	case remote == nil:
+		fmt.Printf("song %q exists locally but not remotely\n", id)
+		return true
+	case local == nil:
+		fmt.Printf("song %q exists remotely but not locally\n", id)
+		return true
+	}
 
-type sshCommand struct {
-	descr  string
-	cmd    *exec.Cmd
-	stdin  io.WriteCloser
-	stderr *bytes.Buffer
+	diffFound := false
+	metaDiff := metaDiff(local.meta, remote.meta)
+	if len(metaDiff) > 0 {
+		diffFound = true
+		fmt.Printf("--- local/%s/meta.json\n+++ remote/%s/meta.json\n", id, id)
+		for _, line := range metaDiff {
+			fmt.Println(line)
+		}
+	}
 
-	exitSignal string
+	chordsDiff := util.UnifiedDiff(
+		fmt.Sprintf("local/%s/chords.txt", id),
+		fmt.Sprintf("remote/%s/chords.txt", id),
+		string(local.chords), string(remote.chords), 3)
+	if chordsDiff != "" {
+		diffFound = true
+		fmt.Print(chordsDiff)
+	}
+	return diffFound
 }
 
-func newSSHCommand(descr string, args ...string) *sshCommand {
-	cmd := exec.CommandContext(context.Background(), args[0], args[1:]...)
-	stdin, err := cmd.StdinPipe()
-	check(err)
-	stderr := &bytes.Buffer{}
-	cmd.Stderr = stderr
+// diffSeeAlso compares the local and remote see-also data for all artists,
+// and returns true if there were any differences.
+func diffSeeAlso(db dblayer.ChordsDB, c *client.Client, pool *workerPool,
+	local, remote map[string]*songState) (bool, []error) {
+
+	artistSet := map[string]struct{}{}
+	for _, songs := range []map[string]*songState{local, remote} {
+		for _, s := range songs {
+			artistSet[s.meta.Artist] = struct{}{}
+		}
+	}
+	artists := make([]string, 0, len(artistSet))
+	for artist := range artistSet {
+		artists = append(artists, artist)
+	}
+	sort.Strings(artists)
+
+	localRelated := make([][]string, len(artists))
+	remoteRelated := make([][]string, len(artists))
+	errs := pool.run("comparing see also", len(artists), func(i int) error {
+		var err error
+		localRelated[i], err = db.SeeAlso(artists[i])
+		if err != nil {
+			return fmt.Errorf("getting local see also data for %q: %w", artists[i], err)
+		}
+		remoteRelated[i], err = c.SeeAlso(artists[i])
+		if err != nil {
+			return fmt.Errorf("getting remote see also data for %q: %w", artists[i], err)
+		}
+		return nil
+	})
+	if len(errs) > 0 {
+		return false, errs
+	}
 
-	check(cmd.Start())
+	diffFound := false
+	for i, artist := range artists {
+		sort.Strings(localRelated[i])
+		sort.Strings(remoteRelated[i])
+		if slices.Equal(localRelated[i], remoteRelated[i]) {
+			continue
+		}
 
-	return &sshCommand{
-		descr:  descr,
-		cmd:    cmd,
-		stdin:  stdin,
-		stderr: stderr,
+		diffFound = true
+		fmt.Printf("see also data for %q is different\n", artist)
+		for _, related := range localRelated[i] {
+			if !slices.Contains(remoteRelated[i], related) {
+				fmt.Printf("-%s\n", related)
+			}
+		}
+		for _, related := range remoteRelated[i] {
+			if !slices.Contains(localRelated[i], related) {
+				fmt.Printf("+%s\n", related)
+			}
+		}
 	}
+	return diffFound, nil
 }
 
-func (c *sshCommand) Execf(format string, v ...any) {
-	io.WriteString(c.stdin, fmt.Sprintf(format, v...))
+// metaDiff compares two sets of metadata field by field. For each field
+// which differs, it returns a pair of lines in the style of a unified diff.
+// The artist and album IDs aren't compared (see syncedMeta).
+func metaDiff(a, b dblayer.SongMeta) []string {
+	a, b = syncedMeta(a), syncedMeta(b)
+	lines := []string{}
+	aVal := reflect.ValueOf(a)
+	bVal := reflect.ValueOf(b)
+	for i := 0; i < aVal.NumField(); i++ {
+		af, bf := aVal.Field(i).Interface(), bVal.Field(i).Interface()
+		if reflect.DeepEqual(af, bf) {
+			continue
+		}
+		name := metaFieldName(aVal.Type().Field(i))
+		lines = append(lines,
+			fmt.Sprintf("-%s: %s", name, formatField(af)),
+			fmt.Sprintf("+%s: %s", name, formatField(bf)),
+		)
+	}
+	return lines
 }
 
-func (c *sshCommand) Exit() error {
-	io.WriteString(c.stdin, c.exitSignal)
-	fmt.Printf("waiting for %q command to exit...\n", c.descr)
-
-	err := c.cmd.Wait()
-	if err != nil {
-		return errors.Join(err, errors.New(c.stderr.String()))
+// formatField formats a metadata value for display.
+func formatField(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
 	}
+	return fmt.Sprint(v)
+}
 
-	fmt.Println("ssh command exited successfully")
-	return nil
+// metaFieldName returns the JSON name of a SongMeta field.
+func metaFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
 }
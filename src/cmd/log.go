@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// auditLog shows the server's audit log, newest first. This needs the admin
+// role.
+//
+//	chords log [--user <name>] [--action <action>] [--since <time>] [--limit <n>] [--diff] [song-id]
+func auditLog(st state, args []string) {
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	f := dblayer.AuditFilter{
+		Actor:  logUser,
+		Action: dblayer.AuditAction(logAction),
+		Limit:  logLimit,
+	}
+	if len(args) == 1 {
+		f.Target = args[0]
+	}
+	if logSince != "" {
+		f.Since, err = parseSince(logSince)
+		if err != nil {
+			reportErrors([]error{err})
+		}
+	}
+
+	entries, err := c.AuditLog(f)
+	if err != nil {
+		reportErrors([]error{err})
+	}
+	if st.json {
+		printJSON(entries)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		actor := e.Actor
+		if actor == "" {
+			actor = "-"
+		}
+		target := e.Target
+		if e.Other != "" {
+			target += " -> " + e.Other
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"),
+			actor, e.Action, target, e.RequestID)
+		if logDiff && e.Diff != "" {
+			// Flush first, so the diff isn't aligned with the table
+			tw.Flush()
+			fmt.Print(e.Diff)
+		}
+	}
+	tw.Flush()
+}
+
+// parseSince parses the --since flag, which is either a time ago (like "12h"
+// or "7d"), a date, or an RFC 3339 time.
+func parseSince(s string) (time.Time, error) {
+	if ago, err := parseTTL(s); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q - use e.g. 24h, 7d, 2006-01-02 or an RFC 3339 time", s)
+}
@@ -59,7 +59,7 @@ func new(st state, args []string) {
 		log.Fatalf("Error creating chords directory: %s", err)
 	}
 
-	editor := chooseEditor()
+	editor := chooseEditor(st.editor)
 	editorCmd := exec.Command(editor, "--wait", filepath.Join(st.dbPath, id, "chords.txt"))
 	err = editorCmd.Start()
 	if err != nil {
@@ -103,7 +103,7 @@ func new(st state, args []string) {
 	if err != nil {
 		log.Fatalf("Error editing chords: %s", err)
 	}
-	sync(st, []string{id})
+	syncSongs(st, []string{id})
 }
 
 // promptf prints the question to stdout, then reads a line from the provided
@@ -142,13 +142,13 @@ func capitalise(word string) string {
 	return capWord
 }
 
-// Choose an editor for the chords
-func chooseEditor() string {
-	editor := os.Getenv("CHORDS_EDITOR")
+// Choose an editor for the chords: the configured editor (env
+// CHORDS_EDITOR), if it exists.
+func chooseEditor(editor string) string {
 	if editor != "" {
 		// Check the specified editor exists on path
 		path, err := exec.LookPath(editor)
-		if err != nil {
+		if err == nil {
 			return path
 		}
 		fmt.Printf("WARNING: %q not found on path\n", editor)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// workerPool runs jobs on a bounded number of goroutines, showing progress
+// on stderr.
+type workerPool struct {
+	concurrency int
+
+	mu       sync.Mutex
+	label    string
+	done     int
+	total    int
+	terminal bool
+}
+
+func newPool(concurrency int) *workerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	stat, err := os.Stderr.Stat()
+	return &workerPool{
+		concurrency: concurrency,
+		terminal:    err == nil && stat.Mode()&os.ModeCharDevice != 0,
+	}
+}
+
+// run calls fn(0), ..., fn(n-1) in parallel, and waits for them all to
+// finish. It returns the errors from any failed jobs.
+func (p *workerPool) run(label string, n int, fn func(i int) error) []error {
+	p.mu.Lock()
+	p.label, p.done, p.total = label, 0, n
+	p.draw()
+	p.mu.Unlock()
+
+	jobs := make(chan int)
+	errs := make([]error, n)
+	wg := sync.WaitGroup{}
+	for w := 0; w < min(p.concurrency, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = fn(i)
+				p.mu.Lock()
+				p.done++
+				p.draw()
+				p.mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	p.mu.Lock()
+	p.clear()
+	p.total = 0
+	p.mu.Unlock()
+
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// printf prints a message to stdout without garbling the progress display.
+// It is safe to call from inside jobs.
+func (p *workerPool) printf(format string, a ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Printf(format, a...)
+	p.draw()
+}
+
+// draw shows the current progress. p.mu must be held.
+func (p *workerPool) draw() {
+	if p.terminal && p.total > 0 {
+		fmt.Fprintf(os.Stderr, "\r%s %d/%d", p.label, p.done, p.total)
+	}
+}
+
+// clear removes the progress display. p.mu must be held.
+func (p *workerPool) clear() {
+	if p.terminal && p.total > 0 {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// reportErrors prints all the given errors, and exits with a non-zero status
+// if there were any.
+func reportErrors(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d error(s) occurred:\n", len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+	os.Exit(1)
+}
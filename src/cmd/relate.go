@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// relate marks two artists as related, locally and remotely, so each appears
+// in the other's "see also" list.
+//
+//	chords relate <artist> <related-artist>
+func relate(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	artist, related := args[0], args[1]
+	errs := []error{}
+	if err := db.AddRelation(artist, related); err != nil {
+		errs = append(errs, fmt.Errorf("relating local artists: %w", err))
+	} else {
+		fmt.Printf("related local artists %q and %q\n", artist, related)
+	}
+
+	seeAlso, err := c.AddRelation(artist, related)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("relating remote artists: %w", err))
+	} else {
+		fmt.Printf("related remote artists %q and %q\n", artist, related)
+		fmt.Printf("see also for %q: %s\n", artist, strings.Join(seeAlso, ", "))
+	}
+	reportErrors(errs)
+}
+
+// unrelate removes the relation between two artists, locally and remotely.
+//
+//	chords unrelate <artist> <related-artist>
+func unrelate(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	artist, related := args[0], args[1]
+	errs := []error{}
+	localErr := db.RemoveRelation(artist, related)
+	if localErr == nil {
+		fmt.Printf("unrelated local artists %q and %q\n", artist, related)
+	} else if !errors.Is(localErr, dblayer.ErrNotRelated) {
+		errs = append(errs, fmt.Errorf("unrelating local artists: %w", localErr))
+	}
+
+	remoteErr := c.RemoveRelation(artist, related)
+	var statusErr *client.StatusError
+	notRelatedRemote := errors.As(remoteErr, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+	if remoteErr == nil {
+		fmt.Printf("unrelated remote artists %q and %q\n", artist, related)
+	} else if !notRelatedRemote {
+		errs = append(errs, fmt.Errorf("unrelating remote artists: %w", remoteErr))
+	}
+
+	// Only an error if the artists weren't related anywhere
+	if errors.Is(localErr, dblayer.ErrNotRelated) && notRelatedRemote {
+		errs = append(errs, fmt.Errorf("artists %q and %q are not related", artist, related))
+	}
+	reportErrors(errs)
+}
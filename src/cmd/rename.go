@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// getSong gets the metadata for the song with the given ID from the local
+// and remote databases. The returned pointers are nil where the song doesn't
+// exist.
+func getSong(db dblayer.ChordsDB, c *client.Client, id string) (local, remote *dblayer.SongMeta, err error) {
+	localSongs, err := db.GetSongs("", id, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting local song %q: %w", id, err)
+	}
+	remoteSongs, err := c.GetSongs(nil, &id, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting remote song %q: %w", id, err)
+	}
+	if len(localSongs) > 0 {
+		local = &localSongs[0]
+	}
+	if len(remoteSongs) > 0 {
+		remote = &remoteSongs[0]
+	}
+	return local, remote, nil
+}
+
+// move changes a song's ID, locally and remotely. The old ID is kept as an
+// alias, so old links to the song still work.
+//
+//	chords mv <old-id> <new-id>
+func move(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	oldID, newID := args[0], args[1]
+	local, remote, err := getSong(db, c, oldID)
+	if err != nil {
+		reportErrors([]error{err})
+	}
+	if local == nil && remote == nil {
+		reportErrors([]error{fmt.Errorf("song %q not found", oldID)})
+	}
+	newLocal, newRemote, err := getSong(db, c, newID)
+	if err != nil {
+		reportErrors([]error{err})
+	}
+	if newLocal != nil || newRemote != nil {
+		reportErrors([]error{fmt.Errorf("song ID %q already in use", newID)})
+	}
+
+	errs := []error{}
+	if local != nil {
+		if _, err := db.RenameSong(oldID, newID); err != nil {
+			errs = append(errs, fmt.Errorf("renaming local song %q: %w", oldID, err))
+		} else {
+			fmt.Printf("renamed local song %q to %q\n", oldID, newID)
+		}
+	}
+	if remote != nil {
+		if _, err := c.RenameSong(oldID, newID); err != nil {
+			errs = append(errs, fmt.Errorf("renaming remote song %q: %w", oldID, err))
+		} else {
+			fmt.Printf("renamed remote song %q to %q\n", oldID, newID)
+		}
+	}
+	reportErrors(errs)
+}
+
+// merge merges a duplicate song into another, locally and remotely. The
+// duplicate is moved to the trash, and its ID becomes an alias for the song
+// being kept.
+//
+//	chords merge [--yes] <keep-id> <duplicate-id>
+func merge(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	keepID, dupID := args[0], args[1]
+	if keepID == dupID {
+		reportErrors([]error{fmt.Errorf("cannot merge song %q into itself", keepID)})
+	}
+	keepLocal, keepRemote, err := getSong(db, c, keepID)
+	if err != nil {
+		reportErrors([]error{err})
+	}
+	dupLocal, dupRemote, err := getSong(db, c, dupID)
+	if err != nil {
+		reportErrors([]error{err})
+	}
+
+	errs := []error{}
+	if (keepLocal == nil) != (dupLocal == nil) {
+		errs = append(errs, fmt.Errorf("cannot merge locally: songs %q and %q must both exist", keepID, dupID))
+	}
+	if (keepRemote == nil) != (dupRemote == nil) {
+		errs = append(errs, fmt.Errorf("cannot merge remotely: songs %q and %q must both exist", keepID, dupID))
+	}
+	if keepLocal == nil && keepRemote == nil && dupLocal == nil && dupRemote == nil {
+		errs = append(errs, fmt.Errorf("songs %q and %q not found", keepID, dupID))
+	}
+	reportErrors(errs)
+
+	if !mergeYes {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range []struct {
+			where string
+			meta  *dblayer.SongMeta
+		}{
+			{"keep (local)", keepLocal}, {"keep (remote)", keepRemote},
+			{"duplicate (local)", dupLocal}, {"duplicate (remote)", dupRemote},
+		} {
+			if e.meta != nil {
+				fmt.Fprintf(tw, "%s\t%s\t%s - %s\t%s\n", e.where, e.meta.ID, e.meta.Name, e.meta.Artist, e.meta.Album)
+			}
+		}
+		tw.Flush()
+		s := bufio.NewScanner(os.Stdin)
+		resp := promptf(s, "Merge %q into %q? [y/n]: ", dupID, keepID)
+		if resp != "y" {
+			return
+		}
+	}
+
+	if keepLocal != nil {
+		if _, err := db.MergeSongs(keepID, dupID); err != nil {
+			errs = append(errs, fmt.Errorf("merging local songs: %w", err))
+		} else {
+			fmt.Printf("merged local song %q into %q\n", dupID, keepID)
+		}
+	}
+	if keepRemote != nil {
+		if _, err := c.MergeSongs(keepID, dupID); err != nil {
+			errs = append(errs, fmt.Errorf("merging remote songs: %w", err))
+		} else {
+			fmt.Printf("merged remote song %q into %q\n", dupID, keepID)
+		}
+	}
+	reportErrors(errs)
+}
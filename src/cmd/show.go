@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/sheet"
+	"github.com/barrettj12/chords/src/types"
+	"golang.org/x/term"
+)
+
+// show prints a chord sheet, looking it up by ID or by searching. Songs are
+// looked up in the local DB first, then on remote.
+//
+//	chords show [flags] <id|search terms...>
+func show(st state, args []string) {
+	meta, chords, err := findSong(st, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	stdoutTerminal := term.IsTerminal(int(os.Stdout.Fd()))
+	opts := sheet.Options{
+		// With a capo, play the chord shapes for a lower key
+		Transpose:  showTranspose - showCapo,
+		ChordsOnly: showChordsOnly,
+		Width:      showWidth,
+	}
+	switch showColour {
+	case "always":
+		opts.Colour = true
+	case "auto":
+		_, noColour := os.LookupEnv("NO_COLOR")
+		opts.Colour = stdoutTerminal && !noColour
+	case "never":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value %q for --color (expected auto, always or never)\n", showColour)
+		os.Exit(2)
+	}
+	if opts.Width == 0 && stdoutTerminal {
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			opts.Width = width
+		}
+	}
+
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "%s - %s\n", meta.Name, meta.Artist)
+	if meta.Album != "" {
+		fmt.Fprintf(out, "%s\n", meta.Album)
+	}
+	if showTranspose != 0 {
+		fmt.Fprintf(out, "Transposed %+d\n", showTranspose)
+	}
+	if showCapo != 0 {
+		fmt.Fprintf(out, "Capo %d\n", showCapo)
+	}
+	fmt.Fprintln(out)
+	out.WriteString(sheet.Render(string(chords), opts))
+
+	if !stdoutTerminal || showNoPager {
+		fmt.Print(out.String())
+		return
+	}
+	check(page(out.String()))
+}
+
+// findSong looks up the song specified by args, and returns its metadata and
+// chords. args may be a song ID, or search terms which match a single song.
+func findSong(st state, args []string) (dblayer.SongMeta, []byte, error) {
+	if !showRemote {
+		if _, err := os.Stat(st.dbPath); err == nil {
+			db := dblayer.NewLocalfs(st.dbPath, log.New(io.Discard, "", 0))
+			songs, err := lookupSongs(args, db.GetSongs, db.Search)
+			if err != nil {
+				return dblayer.SongMeta{}, nil, err
+			}
+			if len(songs) > 0 {
+				return chooseSong(args, songs, func(id string) ([]byte, error) {
+					return db.GetChords(id)
+				})
+			}
+		}
+	}
+
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+	songs, err := lookupSongs(args,
+		func(artist, id, query string) ([]dblayer.SongMeta, error) {
+			return c.GetSongs(nil, &id, nil)
+		}, c.Search)
+	if err != nil {
+		return dblayer.SongMeta{}, nil, err
+	}
+	return chooseSong(args, songs, c.GetChords)
+}
+
+// lookupSongs returns the song with ID args[0], if there is exactly one
+// argument and such a song exists. Otherwise it returns the songs found by
+// searching for args.
+func lookupSongs(args []string,
+	getSongs func(artist, id, query string) ([]dblayer.SongMeta, error),
+	search func(query string) ([]types.SearchResult, error),
+) ([]dblayer.SongMeta, error) {
+	if len(args) == 1 {
+		songs, err := getSongs("", args[0], "")
+		if err != nil {
+			return nil, fmt.Errorf("getting song %q: %w", args[0], err)
+		}
+		if len(songs) > 0 {
+			return songs, nil
+		}
+	}
+
+	query := strings.Join(args, " ")
+	results, err := search(query)
+	if err != nil {
+		return nil, fmt.Errorf("searching for %q: %w", query, err)
+	}
+	songs := []dblayer.SongMeta{}
+	for _, res := range results {
+		if res.Type == "song" && res.Meta != nil {
+			songs = append(songs, *res.Meta)
+		}
+	}
+	return songs, nil
+}
+
+// chooseSong picks a single song from the search results, and gets its
+// chords. If the results are ambiguous, it returns an error listing them.
+func chooseSong(args []string, songs []dblayer.SongMeta,
+	getChords func(id string) ([]byte, error),
+) (dblayer.SongMeta, []byte, error) {
+	query := strings.Join(args, " ")
+	if len(songs) == 0 {
+		return dblayer.SongMeta{}, nil, fmt.Errorf("no songs found matching %q", query)
+	}
+	song := songs[0]
+	if len(songs) > 1 {
+		// Prefer an exact match on the song name
+		found := false
+		for _, s := range songs {
+			if strings.EqualFold(s.Name, query) {
+				song, found = s, true
+				break
+			}
+		}
+		if !found {
+			msg := fmt.Sprintf("multiple songs match %q:", query)
+			for _, s := range songs {
+				msg += fmt.Sprintf("\n  %-20s %s - %s", s.ID, s.Name, s.Artist)
+			}
+			return dblayer.SongMeta{}, nil, fmt.Errorf("%s", msg)
+		}
+	}
+
+	chords, err := getChords(song.ID)
+	if err != nil {
+		return dblayer.SongMeta{}, nil, fmt.Errorf("getting chords for %q: %w", song.ID, err)
+	}
+	return song, chords, nil
+}
+
+// page displays text using the user's pager ($PAGER, or less by default).
+func page(text string) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+		// Keep colours, and don't page if the text fits on one screen
+		if _, ok := os.LookupEnv("LESS"); !ok {
+			os.Setenv("LESS", "FRX")
+		}
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("running pager %q: %w", pager, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// suggestions reviews the suggested corrections on the server. This needs
+// the editor role.
+//
+//	chords suggestions list [--status <status>] [--song <id>]
+//	chords suggestions show <id>
+//	chords suggestions accept [--force] <id>
+//	chords suggestions reject <id>
+func suggestions(st state, args []string) {
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	subcommand, args := args[0], args[1:]
+	switch {
+	case subcommand == "list" && len(args) == 0:
+		status := dblayer.SuggestionStatus(suggestionsStatus)
+		if status == "all" {
+			status = ""
+		}
+		suggestions, err := c.ListSuggestions(status, suggestionsSong)
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		if st.json {
+			printJSON(suggestions)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, s := range suggestions {
+			author := s.Author
+			if author == "" {
+				author = "anonymous"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.SongID, s.Status,
+				s.Created.Local().Format("2006-01-02"), author, s.Comment)
+		}
+		tw.Flush()
+
+	case subcommand == "show" && len(args) == 1:
+		s, currentDiff, err := c.GetSuggestion(args[0])
+		if err != nil {
+			reportErrors([]error{err})
+		}
+		if st.json {
+			printJSON(s)
+			return
+		}
+		fmt.Printf("suggestion %s for %q (%s)\n", s.ID, s.SongID, s.Status)
+		if s.Author != "" {
+			fmt.Printf("by: %s\n", s.Author)
+		}
+		if s.Comment != "" {
+			fmt.Printf("comment: %s\n", s.Comment)
+		}
+		if s.ReviewedAt != nil {
+			fmt.Printf("%s by %s on %s\n", s.Status, s.ReviewedBy, s.ReviewedAt.Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Println()
+		if currentDiff != "" {
+			fmt.Println("the chords have changed since this was suggested - diff from the current chords:")
+			fmt.Print(currentDiff)
+		} else {
+			fmt.Print(s.Diff)
+		}
+
+	case subcommand == "accept" && len(args) == 1:
+		s, err := c.AcceptSuggestion(args[0], suggestionsForce)
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("accepting suggestion %s: %w", args[0], err)})
+		}
+		fmt.Printf("accepted suggestion %s - run `chords pull %s` to update the local copy\n", s.ID, s.SongID)
+
+	case subcommand == "reject" && len(args) == 1:
+		s, err := c.RejectSuggestion(args[0])
+		if err != nil {
+			reportErrors([]error{fmt.Errorf("rejecting suggestion %s: %w", args[0], err)})
+		}
+		fmt.Printf("rejected suggestion %s\n", s.ID)
+
+	default:
+		findCommand("suggestions").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
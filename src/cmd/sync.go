@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/util"
+)
+
+// syncSongs performs a three-way sync between the local db and remote. Changes
+// made on only one side since the last sync are copied to the other side
+// (including deletions). Songs changed on both sides are conflicts, which
+// are resolved interactively.
+//
+//	sync [--dry-run] [--remote <name>] [song-ids...]
+func syncSongs(st state, args []string) {
+	st = st.withRemote(remoteName)
+	ids := args
+
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+	pool := newPool(st.concurrency)
+
+	syncState, err := loadSyncState(st.dbPath)
+	check(err)
+	remoteKey := strings.TrimSuffix(st.serverURL, "/")
+	base := syncState.base(remoteKey)
+
+	local, err := localSongs(db)
+	check(err)
+	remote, errs := remoteSongs(c, pool)
+	reportErrors(errs)
+
+	for _, id := range ids {
+		if local[id] == nil && remote[id] == nil {
+			fmt.Printf("song %q not found\n", id)
+		}
+	}
+
+	items := planSync(local, remote, base, ids)
+	if len(items) == 0 {
+		fmt.Println("everything up to date")
+		return
+	}
+
+	if syncDryRun {
+		for _, item := range items {
+			if item.action != actionRecord && item.action != actionForget {
+				fmt.Printf("%-14s %s\n", item.action, item.id)
+			}
+		}
+		return
+	}
+
+	// Conflicts need user input, so resolve them one at a time after
+	// everything else is done.
+	var conflicts, others []syncItem
+	for _, item := range items {
+		if item.action == actionConflict {
+			conflicts = append(conflicts, item)
+		} else {
+			others = append(others, item)
+		}
+	}
+
+	s := &syncer{
+		db:      db,
+		client:  c,
+		base:    base,
+		pool:    pool,
+		scanner: bufio.NewScanner(os.Stdin),
+		editor:  st.editor,
+	}
+	errs = pool.run("syncing", len(others), func(i int) error {
+		item := others[i]
+		err := s.apply(item)
+		if err != nil {
+			return fmt.Errorf("syncing %q: %w", item.id, err)
+		}
+		if item.action == actionPush {
+			pool.printf("%s/b/chords?id=%s\n", remoteKey, url.QueryEscape(item.id))
+		}
+		return nil
+	})
+	for _, item := range conflicts {
+		err := s.apply(item)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("syncing %q: %w", item.id, err))
+		}
+	}
+
+	// Save state even if there were errors, to record the changes which
+	// did succeed.
+	check(syncState.save())
+	reportErrors(errs)
+}
+
+// songState is the full state of a song on one side of the sync.
+type songState struct {
+	meta   dblayer.SongMeta
+	chords []byte
+}
+
+func (s *songState) record() *syncRecord {
+	if s == nil {
+		return nil
+	}
+	return &syncRecord{Meta: s.meta, Chords: hashChords(s.chords)}
+}
+
+func hashChords(chords []byte) string {
+	sum := sha256.Sum256(chords)
+	return hex.EncodeToString(sum[:])
+}
+
+// localSongs reads the state of all songs in the local db.
+func localSongs(db dblayer.ChordsDB) (map[string]*songState, error) {
+	metas, err := db.GetSongs("", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make(map[string]*songState, len(metas))
+	for _, meta := range metas {
+		chords, err := db.GetChords(meta.ID)
+		if err != nil {
+			return nil, fmt.Errorf("getting chords for %q: %w", meta.ID, err)
+		}
+		songs[meta.ID] = &songState{meta, chords}
+	}
+	return songs, nil
+}
+
+// remoteSongs reads the state of all songs in the remote db.
+func remoteSongs(c *client.Client, pool *workerPool) (map[string]*songState, []error) {
+	metas, err := c.GetSongs(nil, nil, nil)
+	if err != nil {
+		return nil, []error{fmt.Errorf("getting songs: %w", err)}
+	}
+
+	states := make([]*songState, len(metas))
+	errs := pool.run("fetching chords", len(metas), func(i int) error {
+		chords, err := c.GetChords(metas[i].ID)
+		if err != nil {
+			return fmt.Errorf("getting chords for %q: %w", metas[i].ID, err)
+		}
+		states[i] = &songState{metas[i], chords}
+		return nil
+	})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	songs := make(map[string]*songState, len(states))
+	for _, state := range states {
+		songs[state.meta.ID] = state
+	}
+	return songs, nil
+}
+
+// SYNC STATE
+
+// syncRecord is the state of a song as of the last successful sync.
+type syncRecord struct {
+	Meta dblayer.SongMeta `json:"meta"`
+	// Chords is a hash of the chords, as we only need to detect changes.
+	Chords string `json:"chords"`
+}
+
+// sameRecord returns true if both records are nil, or if they are equal.
+func sameRecord(a, b *syncRecord) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Chords == b.Chords && reflect.DeepEqual(syncedMeta(a.Meta), syncedMeta(b.Meta))
+}
+
+// syncedMeta returns the metadata which is synced. The artist and album IDs
+// are left out, as each database links its own records (the local database
+// doesn't link them at all).
+func syncedMeta(meta dblayer.SongMeta) dblayer.SongMeta {
+	meta.ArtistID, meta.AlbumID = "", ""
+	return meta
+}
+
+// syncState records the last-synced state of each song, per remote. It is
+// stored in the local db directory. localfs ignores plain files, so this
+// won't be mistaken for a song.
+type syncState struct {
+	path string
+	// remote URL -> song ID -> record
+	Remotes map[string]map[string]syncRecord `json:"remotes"`
+}
+
+const syncStateFile = ".sync-state.json"
+
+func loadSyncState(dbPath string) (*syncState, error) {
+	s := &syncState{
+		path:    filepath.Join(dbPath, syncStateFile),
+		Remotes: map[string]map[string]syncRecord{},
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Never synced before
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+	if s.Remotes == nil {
+		s.Remotes = map[string]map[string]syncRecord{}
+	}
+	return s, nil
+}
+
+// base returns the sync records for the given remote.
+func (s *syncState) base(remote string) map[string]syncRecord {
+	if s.Remotes[remote] == nil {
+		s.Remotes[remote] = map[string]syncRecord{}
+	}
+	return s.Remotes[remote]
+}
+
+func (s *syncState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// PLANNING
+
+type syncAction string
+
+const (
+	actionPush         syncAction = "push"
+	actionPull         syncAction = "pull"
+	actionDeleteRemote syncAction = "delete-remote"
+	actionDeleteLocal  syncAction = "delete-local"
+	actionConflict     syncAction = "conflict"
+	// Both sides already match, but we need to record this
+	actionRecord syncAction = "record"
+	// Song was deleted on both sides, forget it
+	actionForget syncAction = "forget"
+)
+
+type syncItem struct {
+	id     string
+	action syncAction
+	local  *songState
+	remote *songState
+	base   *syncRecord
+}
+
+// planSync decides what needs to be done to sync each song, comparing the
+// local and remote states to the state at the last sync (base). If ids is
+// non-empty, only these songs will be considered. Songs which are already in
+// sync are omitted.
+func planSync(local, remote map[string]*songState, base map[string]syncRecord, ids []string) []syncItem {
+	if len(ids) == 0 {
+		all := map[string]struct{}{}
+		for id := range local {
+			all[id] = struct{}{}
+		}
+		for id := range remote {
+			all[id] = struct{}{}
+		}
+		for id := range base {
+			all[id] = struct{}{}
+		}
+		for id := range all {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	items := []syncItem{}
+	for _, id := range ids {
+		item := syncItem{
+			id:     id,
+			local:  local[id],
+			remote: remote[id],
+		}
+		if b, ok := base[id]; ok {
+			item.base = &b
+		}
+		item.action = chooseAction(item)
+		if item.action != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// chooseAction returns the action needed to sync the given item, or "" if
+// nothing needs to be done.
+func chooseAction(item syncItem) syncAction {
+	l := item.local.record()
+	r := item.remote.record()
+	b := item.base
+
+	if sameRecord(l, r) {
+		switch {
+		case l == nil && b != nil:
+			return actionForget
+		case !sameRecord(l, b):
+			return actionRecord
+		default:
+			return ""
+		}
+	}
+
+	if b == nil {
+		// Never synced before
+		switch {
+		case r == nil:
+			return actionPush
+		case l == nil:
+			return actionPull
+		default:
+			return actionConflict
+		}
+	}
+
+	localChanged := !sameRecord(l, b)
+	remoteChanged := !sameRecord(r, b)
+	switch {
+	case localChanged && remoteChanged:
+		return actionConflict
+	case localChanged && l == nil:
+		return actionDeleteRemote
+	case localChanged:
+		return actionPush
+	case r == nil:
+		return actionDeleteLocal
+	default:
+		return actionPull
+	}
+}
+
+// APPLYING CHANGES
+
+// syncer applies sync actions. Its methods are safe to call concurrently,
+// except for resolveConflict, which needs user input.
+type syncer struct {
+	db      dblayer.ChordsDB
+	client  *client.Client
+	pool    *workerPool
+	scanner *bufio.Scanner
+	// editor is used to resolve conflicts
+	editor string
+
+	mu   sync.Mutex // protects base
+	base map[string]syncRecord
+}
+
+func (s *syncer) setBase(id string, state *songState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base[id] = *state.record()
+}
+
+func (s *syncer) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.base, id)
+}
+
+func (s *syncer) apply(item syncItem) error {
+	switch item.action {
+	case actionPush:
+		s.pool.printf("pushing %q\n", item.id)
+		return s.push(item.id, item.local, item.remote)
+	case actionPull:
+		s.pool.printf("pulling %q\n", item.id)
+		return s.pull(item.id, item.remote, item.local)
+	case actionDeleteRemote:
+		s.pool.printf("deleting %q remotely\n", item.id)
+		return s.deleteRemote(item.id)
+	case actionDeleteLocal:
+		s.pool.printf("deleting %q locally\n", item.id)
+		return s.deleteLocal(item.id)
+	case actionRecord:
+		s.setBase(item.id, item.local)
+	case actionForget:
+		s.forget(item.id)
+	case actionConflict:
+		return s.resolveConflict(item)
+	}
+	return nil
+}
+
+// push copies the song from local to remote. The artist and album IDs are
+// left out, so the server links the song itself.
+func (s *syncer) push(id string, local, remote *songState) error {
+	meta := syncedMeta(local.meta)
+	if remote == nil {
+		_, err := s.client.NewSong(meta)
+		if err != nil {
+			return fmt.Errorf("creating song: %w", err)
+		}
+		remote = &songState{meta: meta}
+	} else if !reflect.DeepEqual(meta, syncedMeta(remote.meta)) {
+		_, err := s.client.UpdateSong(id, meta)
+		if err != nil {
+			return fmt.Errorf("updating song: %w", err)
+		}
+	}
+
+	if !bytes.Equal(local.chords, remote.chords) {
+		_, err := s.client.UpdateChords(id, local.chords)
+		if err != nil {
+			return fmt.Errorf("updating chords: %w", err)
+		}
+	}
+
+	s.setBase(id, local)
+	return nil
+}
+
+// pull copies the song from remote to local. The server's artist and album
+// IDs are left out, as they mean nothing to the local database.
+func (s *syncer) pull(id string, remote, local *songState) error {
+	meta := syncedMeta(remote.meta)
+	if local == nil {
+		_, err := s.db.NewSong(meta)
+		if err != nil {
+			return fmt.Errorf("creating song: %w", err)
+		}
+		local = &songState{meta: meta}
+	} else if !reflect.DeepEqual(syncedMeta(local.meta), meta) {
+		_, err := s.db.UpdateSong(id, meta)
+		if err != nil {
+			return fmt.Errorf("updating song: %w", err)
+		}
+	}
+
+	if !bytes.Equal(local.chords, remote.chords) {
+		_, err := s.db.UpdateChords(id, remote.chords)
+		if err != nil {
+			return fmt.Errorf("updating chords: %w", err)
+		}
+	}
+
+	s.setBase(id, remote)
+	return nil
+}
+
+func (s *syncer) deleteRemote(id string) error {
+	err := s.client.DeleteSong(id)
+	if err != nil {
+		return err
+	}
+	s.forget(id)
+	return nil
+}
+
+func (s *syncer) deleteLocal(id string) error {
+	err := s.db.DeleteSong(id)
+	if err != nil {
+		return err
+	}
+	s.forget(id)
+	return nil
+}
+
+// CONFLICTS
+
+// resolveConflict shows the differences between the local and remote
+// versions, and asks the user how to resolve them.
+func (s *syncer) resolveConflict(item syncItem) error {
+	fmt.Printf("CONFLICT: %q has been changed both locally and remotely\n", item.id)
+	switch {
+	case item.local == nil:
+		fmt.Println("  deleted locally, changed remotely")
+	case item.remote == nil:
+		fmt.Println("  changed locally, deleted remotely")
+	default:
+		printSongDiff(item.id, item.local, item.remote)
+	}
+
+	canMerge := item.local != nil && item.remote != nil
+	for {
+		var resp string
+		if canMerge {
+			resp = promptf(s.scanner, "keep [l]ocal, keep [r]emote, [m]erge, or [s]kip? ")
+		} else {
+			resp = promptf(s.scanner, "keep [l]ocal, keep [r]emote, or [s]kip? ")
+		}
+
+		switch {
+		case resp == "l":
+			if item.local == nil {
+				return s.deleteRemote(item.id)
+			}
+			return s.push(item.id, item.local, item.remote)
+		case resp == "r":
+			if item.remote == nil {
+				return s.deleteLocal(item.id)
+			}
+			return s.pull(item.id, item.remote, item.local)
+		case resp == "m" && canMerge:
+			return s.merge(item)
+		case resp == "s" || resp == "":
+			// Includes the case where stdin has been closed
+			fmt.Printf("skipping %q\n", item.id)
+			return nil
+		}
+	}
+}
+
+// merge combines the local and remote versions of a song, then writes the
+// merged version to both sides.
+func (s *syncer) merge(item syncItem) error {
+	var baseMeta *dblayer.SongMeta
+	baseChords := ""
+	if item.base != nil {
+		meta := syncedMeta(item.base.Meta)
+		baseMeta = &meta
+		baseChords = item.base.Chords
+	}
+
+	meta := s.mergeMeta(baseMeta, syncedMeta(item.local.meta), syncedMeta(item.remote.meta))
+
+	var chords []byte
+	switch {
+	case bytes.Equal(item.local.chords, item.remote.chords):
+		chords = item.local.chords
+	case hashChords(item.local.chords) == baseChords:
+		chords = item.remote.chords
+	case hashChords(item.remote.chords) == baseChords:
+		chords = item.local.chords
+	default:
+		var err error
+		chords, err = editConflict(s.editor, item.id, item.local.chords, item.remote.chords)
+		if err != nil {
+			return err
+		}
+	}
+
+	merged := &songState{meta, chords}
+	err := s.push(item.id, merged, item.remote)
+	if err != nil {
+		return err
+	}
+	return s.pull(item.id, merged, item.local)
+}
+
+// mergeMeta merges the local and remote metadata field by field. Fields
+// which have only changed on one side (relative to base) are taken from that
+// side. If a field has changed on both sides, the user is asked to choose.
+func (s *syncer) mergeMeta(base *dblayer.SongMeta, local, remote dblayer.SongMeta) dblayer.SongMeta {
+	merged := local
+	mergedVal := reflect.ValueOf(&merged).Elem()
+	localVal := reflect.ValueOf(local)
+	remoteVal := reflect.ValueOf(remote)
+
+	for i := 0; i < mergedVal.NumField(); i++ {
+		l, r := localVal.Field(i), remoteVal.Field(i)
+		if reflect.DeepEqual(l.Interface(), r.Interface()) {
+			continue
+		}
+
+		if base != nil {
+			b := reflect.ValueOf(*base).Field(i)
+			if reflect.DeepEqual(l.Interface(), b.Interface()) {
+				mergedVal.Field(i).Set(r)
+				continue
+			}
+			if reflect.DeepEqual(r.Interface(), b.Interface()) {
+				continue
+			}
+		}
+
+		name := metaFieldName(mergedVal.Type().Field(i))
+		for {
+			resp := promptf(s.scanner, "%s: use [l]ocal %v or [r]emote %v? ", name, l.Interface(), r.Interface())
+			if resp == "l" || resp == "" {
+				break
+			}
+			if resp == "r" {
+				mergedVal.Field(i).Set(r)
+				break
+			}
+		}
+	}
+	return merged
+}
+
+// editConflict writes the two versions of the chords with conflict markers
+// around the differing sections, and opens them in an editor so the user can
+// resolve the conflict.
+func editConflict(editor, id string, local, remote []byte) ([]byte, error) {
+	file, err := os.CreateTemp("", fmt.Sprintf("chords-merge-%s-*.txt", id))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(conflictMarkers(local, remote))
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Opening editor to resolve conflicts. Waiting for editor to close")
+	err = exec.Command(chooseEditor(editor), "--wait", file.Name()).Run()
+	if err != nil {
+		return nil, fmt.Errorf("editing chords: %w", err)
+	}
+
+	merged, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(merged, []byte("<<<<<<< ")) || bytes.Contains(merged, []byte(">>>>>>> ")) {
+		return nil, errors.New("conflict markers still present, not syncing")
+	}
+	return merged, nil
+}
+
+// conflictMarkers combines the local and remote chords into a single text,
+// marking each section which differs in the style of git.
+func conflictMarkers(local, remote []byte) []byte {
+	lines := util.DiffLines(util.SplitLines(string(local)), util.SplitLines(string(remote)))
+
+	buf := &bytes.Buffer{}
+	var ours, theirs []string
+	flush := func() {
+		if len(ours) == 0 && len(theirs) == 0 {
+			return
+		}
+		buf.WriteString("<<<<<<< local\n")
+		for _, l := range ours {
+			buf.WriteString(l + "\n")
+		}
+		buf.WriteString("=======\n")
+		for _, l := range theirs {
+			buf.WriteString(l + "\n")
+		}
+		buf.WriteString(">>>>>>> remote\n")
+		ours, theirs = nil, nil
+	}
+
+	for _, l := range lines {
+		switch l.Kind {
+		case util.DiffDelete:
+			ours = append(ours, l.Text)
+		case util.DiffInsert:
+			theirs = append(theirs, l.Text)
+		default:
+			flush()
+			buf.WriteString(l.Text + "\n")
+		}
+	}
+	flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChooseAction(t *testing.T) {
+	meta := dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}
+	orig := &songState{meta, []byte("C - F - G")}
+	edited := &songState{meta, []byte("C - Fmaj7 - G")}
+	renamed := &songState{dblayer.SongMeta{ID: "YourSong", Name: "Your Song (Live)", Artist: "Elton John"}, orig.chords}
+	// The server links songs to artist and album records, but the local
+	// database doesn't
+	linked := &songState{dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John", ArtistID: "EltonJohn"}, orig.chords}
+
+	tests := []struct {
+		about          string
+		local, remote  *songState
+		base           *songState
+		expectedAction syncAction
+	}{{
+		about:          "in sync",
+		local:          orig,
+		remote:         orig,
+		base:           orig,
+		expectedAction: "",
+	}, {
+		about:          "in sync, linked remotely",
+		local:          orig,
+		remote:         linked,
+		base:           linked,
+		expectedAction: "",
+	}, {
+		about:          "in sync, linked remotely, never synced",
+		local:          orig,
+		remote:         linked,
+		expectedAction: actionRecord,
+	}, {
+		about:          "new locally",
+		local:          orig,
+		expectedAction: actionPush,
+	}, {
+		about:          "new remotely",
+		remote:         orig,
+		expectedAction: actionPull,
+	}, {
+		about:          "added on both sides, never synced",
+		local:          orig,
+		remote:         edited,
+		expectedAction: actionConflict,
+	}, {
+		about:          "same on both sides, never synced",
+		local:          orig,
+		remote:         orig,
+		expectedAction: actionRecord,
+	}, {
+		about:          "changed locally",
+		local:          edited,
+		remote:         orig,
+		base:           orig,
+		expectedAction: actionPush,
+	}, {
+		about:          "changed remotely",
+		local:          orig,
+		remote:         renamed,
+		base:           orig,
+		expectedAction: actionPull,
+	}, {
+		about:          "changed on both sides",
+		local:          edited,
+		remote:         renamed,
+		base:           orig,
+		expectedAction: actionConflict,
+	}, {
+		about:          "deleted locally",
+		remote:         orig,
+		base:           orig,
+		expectedAction: actionDeleteRemote,
+	}, {
+		about:          "deleted remotely",
+		local:          orig,
+		base:           orig,
+		expectedAction: actionDeleteLocal,
+	}, {
+		about:          "deleted locally, changed remotely",
+		remote:         edited,
+		base:           orig,
+		expectedAction: actionConflict,
+	}, {
+		about:          "deleted on both sides",
+		base:           orig,
+		expectedAction: actionForget,
+	}}
+
+	for _, test := range tests {
+		item := syncItem{local: test.local, remote: test.remote, base: test.base.record()}
+		assert.Equal(t, test.expectedAction, chooseAction(item), test.about)
+	}
+}
+
+// recordingDB records the songs written to the wrapped database.
+type recordingDB struct {
+	dblayer.ChordsDB
+	writes []string
+}
+
+func (r *recordingDB) NewSong(meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	r.writes = append(r.writes, "NewSong "+meta.ID)
+	return r.ChordsDB.NewSong(meta)
+}
+
+func (r *recordingDB) UpdateSong(id string, meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	r.writes = append(r.writes, "UpdateSong "+id)
+	return r.ChordsDB.UpdateSong(id, meta)
+}
+
+func (r *recordingDB) UpdateChords(id string, chords dblayer.Chords) (dblayer.Chords, error) {
+	r.writes = append(r.writes, "UpdateChords "+id)
+	return r.ChordsDB.UpdateChords(id, chords)
+}
+
+func TestPushPullUnchanged(t *testing.T) {
+	var mu sync.Mutex
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	c, err := client.NewClient(server.URL, "")
+	assert.NoError(t, err)
+	c.SetRetry(0, 0)
+
+	db := &recordingDB{ChordsDB: dblayer.NewTempDB()}
+	s := &syncer{db: db, client: c, base: map[string]syncRecord{}}
+
+	// The songs only differ in the server's artist and album IDs
+	local := &songState{dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}, []byte("C - F - G")}
+	remote := &songState{dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John", ArtistID: "EltonJohn", AlbumID: "EltonJohn"}, local.chords}
+	assert.NoError(t, s.push("YourSong", local, remote))
+	assert.NoError(t, s.pull("YourSong", remote, local))
+	assert.Empty(t, requests)
+	assert.Empty(t, db.writes)
+
+	// New songs are pulled without the server's IDs
+	assert.NoError(t, s.pull("YourSong", remote, nil))
+	songs, err := db.GetSongs("", "YourSong", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []dblayer.SongMeta{local.meta}, songs)
+}
+
+func TestMetaDiff(t *testing.T) {
+	local := dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}
+
+	tests := []struct {
+		about  string
+		remote dblayer.SongMeta
+		want   []string
+	}{{
+		about:  "identical",
+		remote: local,
+		want:   []string{},
+	}, {
+		about:  "artist and album IDs are ignored",
+		remote: dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John", ArtistID: "EltonJohn", AlbumID: "EltonJohn"},
+		want:   []string{},
+	}, {
+		about:  "one field",
+		remote: dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John", Key: "Eb"},
+		want:   []string{`-key: ""`, `+key: "Eb"`},
+	}, {
+		about:  "several fields, in order",
+		remote: dblayer.SongMeta{ID: "YourSong", Name: "Your Song (Live)", Artist: "Elton John", TrackNum: 3, Tags: []string{"live"}},
+		want: []string{
+			`-name: "Your Song"`, `+name: "Your Song (Live)"`,
+			`-trackNum: 0`, `+trackNum: 3`,
+			`-tags: []`, `+tags: [live]`,
+		},
+	}}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, metaDiff(local, test.remote), test.about)
+	}
+}
+
+func TestConflictMarkers(t *testing.T) {
+	local := []byte("verse:\nC - F\nchorus:\nG - Am\n")
+	remote := []byte("verse:\nC - Fmaj7\nchorus:\nG - Am\n")
+
+	assert.Equal(t, `verse:
+<<<<<<< local
+C - F
+=======
+C - Fmaj7
+>>>>>>> remote
+chorus:
+G - Am
+`, string(conflictMarkers(local, remote)))
+}
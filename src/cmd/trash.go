@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/barrettj12/chords/src/client"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// trash manages deleted songs, locally and remotely.
+//
+//	chords trash list
+//	chords trash restore <ids...>
+//	chords trash purge [--all] [--yes] [ids...]
+func trash(st state, args []string) {
+	db := dblayer.NewLocalfs(st.dbPath, log.Default())
+	c, err := client.NewClient(st.serverURL, st.authKey)
+	check(err)
+
+	subcommand, ids := args[0], args[1:]
+	switch subcommand {
+	case "list":
+		trashList(st, db, c)
+	case "restore":
+		if len(ids) == 0 {
+			fmt.Fprintln(os.Stderr, "no songs specified to restore")
+			os.Exit(2)
+		}
+		trashRestore(db, c, ids)
+	case "purge":
+		trashPurge(db, c, ids)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown trash command %q\n\n", subcommand)
+		findCommand("trash").printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// trashContents gets the contents of the local and remote trash.
+func trashContents(db dblayer.ChordsDB, c *client.Client) (local, remote []dblayer.TrashedSong, errs []error) {
+	local, err := db.ListTrash()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing local trash: %w", err))
+	}
+	remote, err = c.ListTrash()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing remote trash: %w", err))
+	}
+	return local, remote, errs
+}
+
+func trashList(st state, db dblayer.ChordsDB, c *client.Client) {
+	local, remote, errs := trashContents(db, c)
+
+	if st.json {
+		printJSON(map[string][]dblayer.TrashedSong{
+			"local":  local,
+			"remote": remote,
+		})
+	} else {
+		type entry struct {
+			where string
+			dblayer.TrashedSong
+		}
+		entries := []entry{}
+		for _, s := range local {
+			entries = append(entries, entry{"local", s})
+		}
+		for _, s := range remote {
+			entries = append(entries, entry{"remote", s})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].DeletedAt.After(entries[j].DeletedAt)
+		})
+
+		if len(entries) == 0 && len(errs) == 0 {
+			fmt.Println("trash is empty")
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s - %s\n", e.where,
+				e.DeletedAt.Local().Format("2006-01-02 15:04"), e.ID, e.Name, e.Artist)
+		}
+		tw.Flush()
+	}
+	reportErrors(errs)
+}
+
+// inTrash returns true if the song with the given ID is in the trash.
+func inTrash(songs []dblayer.TrashedSong, id string) bool {
+	for _, s := range songs {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// trashRestore restores the given songs, wherever they are in the trash.
+func trashRestore(db dblayer.ChordsDB, c *client.Client, ids []string) {
+	local, remote, errs := trashContents(db, c)
+	reportErrors(errs)
+
+	for _, id := range ids {
+		if !inTrash(local, id) && !inTrash(remote, id) {
+			errs = append(errs, fmt.Errorf("song %q not found in trash", id))
+			continue
+		}
+		if inTrash(local, id) {
+			if _, err := db.RestoreSong(id); err != nil {
+				errs = append(errs, fmt.Errorf("restoring local song %q: %w", id, err))
+			} else {
+				fmt.Printf("restored local song %q\n", id)
+			}
+		}
+		if inTrash(remote, id) {
+			if _, err := c.RestoreSong(id); err != nil {
+				errs = append(errs, fmt.Errorf("restoring remote song %q: %w", id, err))
+			} else {
+				fmt.Printf("restored remote song %q\n", id)
+			}
+		}
+	}
+	reportErrors(errs)
+}
+
+// trashPurge permanently deletes the given songs (or all songs, if --all is
+// specified) from the local and remote trash.
+func trashPurge(db dblayer.ChordsDB, c *client.Client, ids []string) {
+	if trashAll == (len(ids) > 0) {
+		fmt.Fprintln(os.Stderr, "specify either song IDs or --all")
+		os.Exit(2)
+	}
+
+	local, remote, errs := trashContents(db, c)
+	reportErrors(errs)
+
+	if trashAll {
+		idSet := map[string]struct{}{}
+		for _, s := range append(local, remote...) {
+			if _, ok := idSet[s.ID]; !ok {
+				idSet[s.ID] = struct{}{}
+				ids = append(ids, s.ID)
+			}
+		}
+		if len(ids) == 0 {
+			fmt.Println("trash is empty")
+			return
+		}
+	}
+
+	if !trashYes {
+		s := bufio.NewScanner(os.Stdin)
+		resp := promptf(s, "Permanently delete %d song(s)? This cannot be undone. [y/n]: ", len(ids))
+		if resp != "y" {
+			return
+		}
+	}
+
+	for _, id := range ids {
+		if !inTrash(local, id) && !inTrash(remote, id) {
+			errs = append(errs, fmt.Errorf("song %q not found in trash", id))
+			continue
+		}
+		if inTrash(local, id) {
+			if err := db.PurgeSong(id); err != nil {
+				errs = append(errs, fmt.Errorf("purging local song %q: %w", id, err))
+			} else {
+				fmt.Printf("purged local song %q\n", id)
+			}
+		}
+		if inTrash(remote, id) {
+			if err := c.PurgeSong(id); err != nil {
+				errs = append(errs, fmt.Errorf("purging remote song %q: %w", id, err))
+			} else {
+				fmt.Printf("purged remote song %q\n", id)
+			}
+		}
+	}
+	reportErrors(errs)
+}
@@ -6,10 +6,19 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/barrettj12/chords/src/dblayer"
 )
 
+// Files in the database directory which aren't songs.
+var dataFiles = map[string]bool{
+	"see-also.json": true,
+	"aliases.json":  true,
+	"artists.json":  true,
+	"albums.json":   true,
+}
+
 // Validate local database
 //
 //	usage: chords validate
@@ -29,6 +38,12 @@ func validate(st state, args []string) {
 	for _, entry := range entries {
 		path := filepath.Join(st.dbPath, entry.Name())
 
+		// Skip hidden files (e.g. sync state), see-also data, aliases and
+		// artist/album records
+		if strings.HasPrefix(entry.Name(), ".") || !entry.IsDir() && dataFiles[entry.Name()] {
+			continue
+		}
+
 		// Check it's a directory
 		if !entry.IsDir() {
 			log.Printf("WARNING: %q is not a directory\n", path)
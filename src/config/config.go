@@ -0,0 +1,443 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barrettj12/chords/src/server"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration for the server and the CLI. It's read from a
+// YAML file (see Load), then environment variables and flags override it.
+type Config struct {
+	Server Server `yaml:"server"`
+	CLI    CLI    `yaml:"cli"`
+}
+
+// Server configures the server (main.go).
+type Server struct {
+	Port int `yaml:"port"`
+	// MetricsPort is the port where Prometheus metrics are served, which
+	// shouldn't be exposed publicly. 0 turns metrics off.
+	MetricsPort int `yaml:"metrics_port"`
+	// Database is the address of the database: a Postgres URI, a path to a
+	// local filesystem database, or "" for a temporary database.
+	Database string `yaml:"database"`
+	// AuthKey authorises requests as an admin (see docs/API.md). It can only
+	// be set by the AUTH_KEY environment variable, so it's never written to
+	// a file; otherwise, it's read from AuthKeyFile.
+	AuthKey     string `yaml:"-"`
+	AuthKeyFile string `yaml:"auth_key_file"`
+	// UsersFile is where user accounts are stored. If it's empty, they are
+	// stored alongside a local filesystem database, or in memory for a
+	// temporary database. It must be set for a Postgres database, which
+	// can't store accounts.
+	UsersFile string   `yaml:"users_file,omitempty"`
+	Log       Log      `yaml:"log"`
+	Timeouts  Timeouts `yaml:"timeouts"`
+	Limits    Limits   `yaml:"limits"`
+	// Warnings are problems with the config which aren't errors, e.g.
+	// deprecated settings. They should be logged once the logger is set up.
+	Warnings []string `yaml:"-"`
+}
+
+// Log configures the server's logs.
+type Log struct {
+	// Format is "json" or "text".
+	Format string `yaml:"format"`
+	// Level is the minimum level to log, e.g. "info".
+	Level string `yaml:"level"`
+	// Bodies is the number of bytes of request and response bodies to log,
+	// or 0 to not log them.
+	Bodies int `yaml:"bodies"`
+}
+
+// Timeouts limit how long the server spends on each connection (see
+// server.Timeouts), and how long it waits for requests to finish when
+// shutting down. Zero means no limit.
+type Timeouts struct {
+	ReadHeader time.Duration `yaml:"read_header"`
+	Read       time.Duration `yaml:"read"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+	Shutdown   time.Duration `yaml:"shutdown"`
+}
+
+// Limits protect the server from abuse (see server.Limits).
+type Limits struct {
+	// Rates are the rate limits for each route, keyed by its path, e.g.
+	// /api/v0/search. Rates in the config file are added to the default
+	// ones; set requests to 0 to remove a default limit.
+	Rates map[string]Rate `yaml:"rates"`
+	// Passwords is the rate at which each client can send requests with a
+	// password (Basic credentials). Set requests to 0 for no limit.
+	Passwords Rate `yaml:"passwords"`
+	// ClientIPHeader is a header set by a trusted proxy to the client's IP
+	// address, which requests are rate limited by. If it's empty, the
+	// address of the connection is used.
+	ClientIPHeader string `yaml:"client_ip_header,omitempty"`
+	// MaxBody and MaxImportBody are the maximum size of request bodies,
+	// and snapshots sent to /api/v0/import, in bytes. Zero means no limit.
+	MaxBody       int64   `yaml:"max_body"`
+	MaxImportBody int64   `yaml:"max_import_body"`
+	GraphQL       GraphQL `yaml:"graphql"`
+}
+
+// Rate allows each client Requests requests Per period, and bursts of up to
+// Burst requests. If Burst is 0, it's the same as Requests.
+type Rate struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst,omitempty"`
+}
+
+// GraphQL limits GraphQL operations (see gqlgen.Limits). Zero means no
+// limit.
+type GraphQL struct {
+	MaxDepth      int `yaml:"max_depth"`
+	MaxComplexity int `yaml:"max_complexity"`
+}
+
+// CLI configures the command-line interface (src/cmd).
+type CLI struct {
+	// DB is the path to the local database.
+	DB string `yaml:"db"`
+	// Remote is the name of the remote to use, from Remotes.
+	Remote  string            `yaml:"remote"`
+	Remotes map[string]Remote `yaml:"remotes"`
+	// Server and AuthKeyFile override the remote's URL and auth key file, if
+	// set. They are usually set by environment variables or flags.
+	Server      string `yaml:"server,omitempty"`
+	AuthKeyFile string `yaml:"auth_key_file,omitempty"`
+	// Editor is the command used to edit chords.
+	Editor string `yaml:"editor,omitempty"`
+	// Concurrency is the maximum number of concurrent requests to the
+	// server.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// Remote is a chords server which the CLI can use.
+type Remote struct {
+	URL string `yaml:"url"`
+	// AuthKeyFile is a file containing an auth key or API token for the
+	// server. If it's empty, "auth_key" in the working directory is used.
+	AuthKeyFile string `yaml:"auth_key_file,omitempty"`
+}
+
+// DefaultAuthKeyFile is the file the auth key is read from, if no other file
+// is configured.
+const DefaultAuthKeyFile = "auth_key"
+
+// Defaults returns the configuration used when nothing else is set.
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Port:        8080,
+			MetricsPort: 9091,
+			AuthKeyFile: DefaultAuthKeyFile,
+			Log:         Log{Format: "json", Level: "info"},
+			Timeouts: Timeouts{
+				ReadHeader: 10 * time.Second,
+				Read:       time.Minute,
+				Write:      2 * time.Minute,
+				Idle:       2 * time.Minute,
+				Shutdown:   25 * time.Second,
+			},
+			Limits: defaultLimits(),
+		},
+		CLI: CLI{
+			DB:     "./data",
+			Remote: "prod",
+			Remotes: map[string]Remote{
+				"prod":  {URL: "https://chords.fly.dev"},
+				"local": {URL: "http://localhost:8080"},
+			},
+			Concurrency: 8,
+		},
+	}
+}
+
+// defaultLimits converts server.DefaultLimits, so the default limits are
+// only defined in one place.
+func defaultLimits() Limits {
+	d := server.DefaultLimits
+	rates := make(map[string]Rate, len(d.Rates))
+	for route, rl := range d.Rates {
+		rates[route] = Rate{Requests: rl.Requests, Per: rl.Per, Burst: rl.Burst}
+	}
+	return Limits{
+		Rates:          rates,
+		Passwords:      Rate{Requests: d.Passwords.Requests, Per: d.Passwords.Per, Burst: d.Passwords.Burst},
+		ClientIPHeader: d.ClientIPHeader,
+		MaxBody:        d.MaxBody,
+		MaxImportBody:  d.MaxImportBody,
+		GraphQL: GraphQL{
+			MaxDepth:      d.GraphQL.MaxDepth,
+			MaxComplexity: d.GraphQL.MaxComplexity,
+		},
+	}
+}
+
+// DefaultPaths returns the paths where the config file is looked for, if no
+// path is given: chords.yaml in the working directory, then
+// chords/config.yaml in the user's config directory (e.g. ~/.config).
+func DefaultPaths() []string {
+	paths := []string{"chords.yaml"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "chords", "config.yaml"))
+	}
+	return paths
+}
+
+// Load reads the config file at path over the defaults, and returns the
+// config and the path it was read from. If path is empty, the first of
+// DefaultPaths which exists is read, or if none exist, the defaults are
+// returned with an empty path. Unknown keys are an error, to catch typos.
+func Load(path string) (Config, string, error) {
+	cfg := Defaults()
+	if path == "" {
+		for _, p := range DefaultPaths() {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			return cfg, "", nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, path, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, path, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return cfg, path, nil
+}
+
+// Marshal returns the config as YAML.
+func (c Config) Marshal() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+// LookupFunc looks up an environment variable, like os.LookupEnv.
+type LookupFunc func(key string) (string, bool)
+
+// ApplyEnv overrides the server config with environment variables. See
+// docs/DEV.md for the list.
+func (s *Server) ApplyEnv(lookup LookupFunc) error {
+	e := envReader{lookup: lookup}
+	e.int("PORT", &s.Port)
+	e.int("METRICS_PORT", &s.MetricsPort)
+	e.string("DATABASE_URL", &s.Database)
+	e.string("AUTH_KEY", &s.AuthKey)
+	e.string("AUTH_KEY_FILE", &s.AuthKeyFile)
+	e.string("USERS_FILE", &s.UsersFile)
+	e.string("LOG_FORMAT", &s.Log.Format)
+	e.string("LOG_LEVEL", &s.Log.Level)
+	e.int("LOG_BODIES", &s.Log.Bodies)
+	e.duration("READ_HEADER_TIMEOUT", &s.Timeouts.ReadHeader)
+	e.duration("READ_TIMEOUT", &s.Timeouts.Read)
+	e.duration("WRITE_TIMEOUT", &s.Timeouts.Write)
+	e.duration("IDLE_TIMEOUT", &s.Timeouts.Idle)
+	e.duration("SHUTDOWN_TIMEOUT", &s.Timeouts.Shutdown)
+	e.string("CLIENT_IP_HEADER", &s.Limits.ClientIPHeader)
+	// LOG_FLAGS were the flags of the old plain-text logger, which have no
+	// equivalent in structured logs.
+	if _, ok := lookup("LOG_FLAGS"); ok {
+		s.Warnings = append(s.Warnings, "LOG_FLAGS is no longer supported and is ignored; use LOG_FORMAT and LOG_LEVEL instead")
+	}
+	return errors.Join(e.errs...)
+}
+
+// ApplyEnv overrides the CLI config with environment variables. See
+// docs/DEV.md for the list.
+func (c *CLI) ApplyEnv(lookup LookupFunc) error {
+	e := envReader{lookup: lookup}
+	e.string("DATABASE_URL", &c.DB)
+	e.string("CHORDS_REMOTE", &c.Remote)
+	e.string("SERVER_URL", &c.Server)
+	e.string("AUTH_KEY_FILE", &c.AuthKeyFile)
+	e.string("CHORDS_EDITOR", &c.Editor)
+	e.int("CHORDS_CONCURRENCY", &c.Concurrency)
+	return errors.Join(e.errs...)
+}
+
+// envReader reads environment variables into config fields, collecting any
+// parsing errors.
+type envReader struct {
+	lookup LookupFunc
+	errs   []error
+}
+
+func (e *envReader) string(key string, field *string) {
+	if value, ok := e.lookup(key); ok {
+		*field = value
+	}
+}
+
+// Numbers and durations which are set but empty are ignored.
+func (e *envReader) int(key string, field *int) {
+	if value, ok := e.lookup(key); ok && value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+			return
+		}
+		*field = n
+	}
+}
+
+func (e *envReader) duration(key string, field *time.Duration) {
+	if value, ok := e.lookup(key); ok && value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a duration, e.g. 30s", key, value))
+			return
+		}
+		*field = d
+	}
+}
+
+// Validate checks the server config.
+func (s Server) Validate() error {
+	errs := []error{}
+	if s.Port < 1 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", s.Port))
+	}
+	switch {
+	case s.MetricsPort < 0 || s.MetricsPort > 65535:
+		errs = append(errs, fmt.Errorf("metrics port %d is out of range", s.MetricsPort))
+	case s.MetricsPort == s.Port:
+		errs = append(errs, fmt.Errorf("metrics port %d is the same as the server's port", s.MetricsPort))
+	}
+	if s.AuthKeyFile == "" {
+		errs = append(errs, errors.New("auth_key_file is empty"))
+	}
+	if s.UsersFile == "" && strings.HasPrefix(s.Database, "postgres") {
+		errs = append(errs, errors.New("users_file must be set for a Postgres database"))
+	}
+	if s.Log.Format != "json" && s.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log format %q should be json or text", s.Log.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log level %q should be debug, info, warn or error", s.Log.Level))
+	}
+	if s.Log.Bodies < 0 {
+		errs = append(errs, fmt.Errorf("log bodies %d is negative", s.Log.Bodies))
+	}
+	for name, d := range map[string]time.Duration{
+		"read_header": s.Timeouts.ReadHeader,
+		"read":        s.Timeouts.Read,
+		"write":       s.Timeouts.Write,
+		"idle":        s.Timeouts.Idle,
+		"shutdown":    s.Timeouts.Shutdown,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s timeout %s is negative", name, d))
+		}
+	}
+	errs = append(errs, s.Limits.validate()...)
+	return errors.Join(errs...)
+}
+
+func (l Limits) validate() []error {
+	errs := []error{}
+	rates := maps.Clone(l.Rates)
+	if rates == nil {
+		rates = map[string]Rate{}
+	}
+	rates["passwords"] = l.Passwords
+	for name, rate := range rates {
+		switch {
+		case rate.Requests < 0 || rate.Burst < 0:
+			errs = append(errs, fmt.Errorf("rate for %s is negative", name))
+		case rate.Requests > 0 && rate.Per <= 0:
+			errs = append(errs, fmt.Errorf("rate for %s needs a period, e.g. per: 1m", name))
+		}
+	}
+	for name, n := range map[string]int64{
+		"max_body":               l.MaxBody,
+		"max_import_body":        l.MaxImportBody,
+		"graphql max_depth":      int64(l.GraphQL.MaxDepth),
+		"graphql max_complexity": int64(l.GraphQL.MaxComplexity),
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("%s %d is negative", name, n))
+		}
+	}
+	return errs
+}
+
+// Validate checks the CLI config.
+func (c CLI) Validate() error {
+	errs := []error{}
+	if c.DB == "" {
+		errs = append(errs, errors.New("db is empty"))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("concurrency %d should be at least 1", c.Concurrency))
+	}
+	for name, remote := range c.Remotes {
+		if err := checkURL(remote.URL); err != nil {
+			errs = append(errs, fmt.Errorf("remote %q: %w", name, err))
+		}
+	}
+	if c.Server != "" {
+		if err := checkURL(c.Server); err != nil {
+			errs = append(errs, fmt.Errorf("server: %w", err))
+		}
+	} else if _, ok := c.Remotes[c.Remote]; !ok {
+		errs = append(errs, fmt.Errorf("unknown remote %q", c.Remote))
+	}
+	return errors.Join(errs...)
+}
+
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL %q should be http(s)://host", rawURL)
+	}
+	return nil
+}
+
+// Target returns the remote the CLI should use: the named Remote, with the
+// Server and AuthKeyFile overrides applied.
+func (c CLI) Target() (Remote, error) {
+	remote, ok := c.Remotes[c.Remote]
+	if !ok && c.Server == "" {
+		return Remote{}, fmt.Errorf("unknown remote %q", c.Remote)
+	}
+	if c.Server != "" {
+		remote.URL = c.Server
+	}
+	if c.AuthKeyFile != "" {
+		remote.AuthKeyFile = c.AuthKeyFile
+	}
+	return remote, nil
+}
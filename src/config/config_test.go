@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/barrettj12/chords/src/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env returns a LookupFunc for the given environment.
+func env(vars map[string]string) LookupFunc {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chords.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  port: 9000
+  database: /data
+  log:
+    format: text
+  timeouts:
+    write: 5m
+  limits:
+    rates:
+      /api/v0/search:
+        requests: 10
+        per: 1s
+      /api/v0/random:
+        requests: 0
+cli:
+  remote: staging
+  remotes:
+    staging:
+      url: https://chords-staging.fly.dev
+      auth_key_file: staging_key
+`), 0666))
+
+	cfg, loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, path, loaded)
+	assert.Equal(t, 9000, cfg.Server.Port)
+	assert.Equal(t, "/data", cfg.Server.Database)
+	assert.Equal(t, "text", cfg.Server.Log.Format)
+	// Unset values keep their defaults
+	assert.Equal(t, "info", cfg.Server.Log.Level)
+	assert.Equal(t, 5*time.Minute, cfg.Server.Timeouts.Write)
+	assert.Equal(t, time.Minute, cfg.Server.Timeouts.Read)
+	// Rates are added to the default ones
+	assert.Equal(t, Rate{Requests: 10, Per: time.Second}, cfg.Server.Limits.Rates["/api/v0/search"])
+	assert.Equal(t, Rate{}, cfg.Server.Limits.Rates["/api/v0/random"])
+	assert.Equal(t, 120, cfg.Server.Limits.Rates["/graphql"].Requests)
+	assert.Equal(t, "./data", cfg.CLI.DB)
+	// Remotes are added to the default ones
+	assert.Len(t, cfg.CLI.Remotes, 3)
+	remote, err := cfg.CLI.Target()
+	require.NoError(t, err)
+	assert.Equal(t, Remote{URL: "https://chords-staging.fly.dev", AuthKeyFile: "staging_key"}, remote)
+	assert.NoError(t, cfg.Server.Validate())
+	assert.NoError(t, cfg.CLI.Validate())
+
+	// Typos are caught
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  prot: 9000\n"), 0666))
+	_, _, err = Load(path)
+	assert.ErrorContains(t, err, "field prot not found")
+
+	// A missing file is only an error if it was asked for
+	_, _, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	t.Chdir(t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	cfg, loaded, err = Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "", loaded)
+	assert.Equal(t, Defaults(), cfg)
+}
+
+func TestDefaultLimits(t *testing.T) {
+	// The defaults come from the server, and aren't changed by other configs
+	limits := Defaults().Server.Limits
+	assert.Len(t, limits.Rates, len(server.DefaultLimits.Rates))
+	for route, rl := range server.DefaultLimits.Rates {
+		assert.Equal(t, Rate{Requests: rl.Requests, Per: rl.Per, Burst: rl.Burst}, limits.Rates[route], route)
+	}
+	assert.Equal(t, server.DefaultLimits.MaxImportBody, limits.MaxImportBody)
+	assert.Equal(t, server.DefaultLimits.GraphQL.MaxDepth, limits.GraphQL.MaxDepth)
+	limits.Rates["/graphql"] = Rate{}
+	assert.Equal(t, 120, Defaults().Server.Limits.Rates["/graphql"].Requests)
+}
+
+func TestEnv(t *testing.T) {
+	cfg := Defaults()
+	err := cfg.Server.ApplyEnv(env(map[string]string{
+		"PORT":             "3000",
+		"METRICS_PORT":     "0",
+		"DATABASE_URL":     "",
+		"AUTH_KEY":         "secret",
+		"LOG_BODIES":       "1024",
+		"WRITE_TIMEOUT":    "",
+		"IDLE_TIMEOUT":     "0",
+		"CLIENT_IP_HEADER": "Fly-Client-IP",
+		"LOG_FLAGS":        "3",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 3000, cfg.Server.Port)
+	assert.Equal(t, 0, cfg.Server.MetricsPort)
+	assert.Equal(t, "", cfg.Server.Database)
+	assert.Equal(t, "secret", cfg.Server.AuthKey)
+	assert.Equal(t, 1024, cfg.Server.Log.Bodies)
+	assert.Equal(t, 2*time.Minute, cfg.Server.Timeouts.Write)
+	assert.Equal(t, time.Duration(0), cfg.Server.Timeouts.Idle)
+	assert.Equal(t, "Fly-Client-IP", cfg.Server.Limits.ClientIPHeader)
+	// LOG_FLAGS is ignored, with a warning
+	if assert.Len(t, cfg.Server.Warnings, 1) {
+		assert.Contains(t, cfg.Server.Warnings[0], "LOG_FLAGS")
+	}
+
+	err = cfg.CLI.ApplyEnv(env(map[string]string{
+		"DATABASE_URL":  "./mine",
+		"CHORDS_REMOTE": "local",
+		"AUTH_KEY_FILE": "local_key",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "./mine", cfg.CLI.DB)
+	remote, err := cfg.CLI.Target()
+	require.NoError(t, err)
+	assert.Equal(t, Remote{URL: "http://localhost:8080", AuthKeyFile: "local_key"}, remote)
+
+	// SERVER_URL overrides the remote
+	require.NoError(t, cfg.CLI.ApplyEnv(env(map[string]string{"SERVER_URL": "http://example.com"})))
+	remote, err = cfg.CLI.Target()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", remote.URL)
+
+	err = cfg.Server.ApplyEnv(env(map[string]string{"PORT": "eighty", "READ_TIMEOUT": "10"}))
+	assert.ErrorContains(t, err, `PORT: "eighty" is not an integer`)
+	assert.ErrorContains(t, err, `READ_TIMEOUT: "10" is not a duration`)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Defaults()
+	cfg.Server.Port = 70000
+	cfg.Server.MetricsPort = -1
+	cfg.Server.Database = "postgres://localhost/chords"
+	cfg.Server.Log.Format = "xml"
+	cfg.Server.Log.Level = "loud"
+	cfg.Server.Timeouts.Read = -time.Second
+	cfg.Server.Limits.Rates["/graphql"] = Rate{Requests: 10}
+	cfg.Server.Limits.Passwords.Burst = -1
+	cfg.Server.Limits.MaxBody = -1
+	err := cfg.Server.Validate()
+	for _, msg := range []string{"port 70000", "metrics port -1", `format "xml"`, `level "loud"`, "read timeout -1s",
+		"rate for /graphql needs a period", "rate for passwords is negative", "max_body -1", "users_file must be set"} {
+		assert.ErrorContains(t, err, msg)
+	}
+
+	cfg.CLI.Remote = "staging"
+	cfg.CLI.Concurrency = 0
+	cfg.CLI.Remotes["broken"] = Remote{URL: "chords.fly.dev"}
+	err = cfg.CLI.Validate()
+	for _, msg := range []string{`unknown remote "staging"`, "concurrency 0", `remote "broken"`} {
+		assert.ErrorContains(t, err, msg)
+	}
+	_, err = cfg.CLI.Target()
+	assert.ErrorContains(t, err, `unknown remote "staging"`)
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := Defaults()
+	cfg.Server.AuthKey = "secret"
+	data, err := cfg.Marshal()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "shutdown: 25s")
+	assert.Contains(t, string(data), "url: https://chords.fly.dev")
+	assert.NotContains(t, string(data), "secret")
+
+	// The output can be read back
+	path := filepath.Join(t.TempDir(), "chords.yaml")
+	require.NoError(t, os.WriteFile(path, data, 0666))
+	loaded, _, err := Load(path)
+	require.NoError(t, err)
+	cfg.Server.AuthKey = ""
+	assert.Equal(t, cfg, loaded)
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/util"
+)
+
+// Catalogue holds the artist and album records for a library.
+type Catalogue struct {
+	Artists []ArtistRecord
+	Albums  []AlbumRecord
+}
+
+// CatalogueStore persists a Catalogue.
+type CatalogueStore interface {
+	Load() (Catalogue, error)
+	Save(Catalogue) error
+}
+
+// NewDBCatalogue returns a CatalogueStore which keeps the records in the
+// given database, alongside the songs.
+func NewDBCatalogue(db dblayer.ChordsDB) CatalogueStore {
+	return &dbCatalogue{db}
+}
+
+type dbCatalogue struct {
+	db dblayer.ChordsDB
+}
+
+func (d *dbCatalogue) Load() (Catalogue, error) {
+	data, err := d.db.GetCatalogue()
+	if err != nil {
+		return Catalogue{}, err
+	}
+	return parseCatalogue(data)
+}
+
+func (d *dbCatalogue) Save(cat Catalogue) error {
+	data, err := cat.data()
+	if err != nil {
+		return err
+	}
+	return d.db.SetCatalogue(data)
+}
+
+// parseCatalogue unmarshals the records stored in a database. Missing
+// records are treated as empty.
+func parseCatalogue(data dblayer.CatalogueData) (Catalogue, error) {
+	cat := Catalogue{}
+	if len(data.Artists) > 0 {
+		if err := json.Unmarshal(data.Artists, &cat.Artists); err != nil {
+			return Catalogue{}, fmt.Errorf("couldn't unmarshal artists: %w", err)
+		}
+	}
+	if len(data.Albums) > 0 {
+		if err := json.Unmarshal(data.Albums, &cat.Albums); err != nil {
+			return Catalogue{}, fmt.Errorf("couldn't unmarshal albums: %w", err)
+		}
+	}
+	return cat, nil
+}
+
+// data marshals the records to be stored in a database.
+func (c Catalogue) data() (dblayer.CatalogueData, error) {
+	artists, err := json.Marshal(c.Artists)
+	if err != nil {
+		return dblayer.CatalogueData{}, err
+	}
+	albums, err := json.Marshal(c.Albums)
+	if err != nil {
+		return dblayer.CatalogueData{}, err
+	}
+	return dblayer.CatalogueData{Artists: artists, Albums: albums}, nil
+}
+
+func (c Catalogue) clone() Catalogue {
+	return Catalogue{
+		Artists: slices.Clone(c.Artists),
+		Albums:  slices.Clone(c.Albums),
+	}
+}
+
+// artist returns the artist with the given ID, or nil if there is none.
+func (c *Catalogue) artist(id ArtistID) *ArtistRecord {
+	for i := range c.Artists {
+		if c.Artists[i].ID == id {
+			return &c.Artists[i]
+		}
+	}
+	return nil
+}
+
+// findArtist returns the artist with the given name or alias, or nil if
+// there is none.
+func (c *Catalogue) findArtist(name string) *ArtistRecord {
+	for i := range c.Artists {
+		if c.Artists[i].Name == name || slices.Contains(c.Artists[i].Aliases, name) {
+			return &c.Artists[i]
+		}
+	}
+	return nil
+}
+
+// album returns the album with the given ID, or nil if there is none.
+func (c *Catalogue) album(id AlbumID) *AlbumRecord {
+	for i := range c.Albums {
+		if c.Albums[i].ID == id {
+			return &c.Albums[i]
+		}
+	}
+	return nil
+}
+
+// findAlbum returns the given artist's album with the given name or alias,
+// or nil if there is none.
+func (c *Catalogue) findAlbum(artist ArtistID, name string) *AlbumRecord {
+	for i := range c.Albums {
+		a := &c.Albums[i]
+		if a.Artist == artist && (a.Name == name || slices.Contains(a.Aliases, name)) {
+			return a
+		}
+	}
+	return nil
+}
+
+// addArtist adds a record for a new artist. If id is empty, a new ID is
+// generated from the name.
+func (c *Catalogue) addArtist(id ArtistID, name string) *ArtistRecord {
+	if id == "" {
+		id = ArtistID(c.newID(util.MakeID(name), "Artist", func(id string) bool {
+			return c.artist(ArtistID(id)) != nil
+		}))
+	}
+	c.Artists = append(c.Artists, ArtistRecord{ID: id, Name: name, SortName: name})
+	return &c.Artists[len(c.Artists)-1]
+}
+
+// addAlbum adds a record for a new album by the given artist. If id is
+// empty, a new ID is generated from the name. If another artist has an album
+// with the same name, the artist's ID is used as a prefix.
+func (c *Catalogue) addAlbum(id AlbumID, artist ArtistID, name string) *AlbumRecord {
+	if id == "" {
+		base := util.MakeID(name)
+		if c.album(AlbumID(base)) != nil {
+			base = string(artist) + base
+		}
+		id = AlbumID(c.newID(base, "Album", func(id string) bool {
+			return c.album(AlbumID(id)) != nil
+		}))
+	}
+	c.Albums = append(c.Albums, AlbumRecord{ID: id, Name: name, SortName: name, Artist: artist})
+	return &c.Albums[len(c.Albums)-1]
+}
+
+// newID returns base if it isn't taken, otherwise base with the smallest
+// numeric suffix which isn't taken. If base is empty, fallback is used.
+func (c *Catalogue) newID(base, fallback string, taken func(string) bool) string {
+	if base == "" {
+		base = fallback
+	}
+	id := base
+	for n := 2; taken(id); n++ {
+		id = fmt.Sprintf("%s%d", base, n)
+	}
+	return id
+}
+
+// link sets the artist and album IDs for a song, adding new records to the
+// catalogue if needed. The IDs are the source of truth: a song with the ID
+// of a known record is linked to it, and its name is set to the record's
+// name. Songs without IDs (e.g. stored before artists and albums were
+// records) are linked by name. link returns true if the catalogue was
+// changed.
+func (c *Catalogue) link(meta *dblayer.SongMeta) bool {
+	changed := false
+
+	artist := c.artist(ArtistID(meta.ArtistID))
+	switch {
+	case artist != nil:
+	case meta.Artist == "":
+		meta.ArtistID = ""
+	default:
+		artist = c.findArtist(meta.Artist)
+		if artist == nil {
+			// If the song was linked elsewhere (e.g. synced from another
+			// database) to an artist we don't know about, keep the same ID.
+			artist = c.addArtist(ArtistID(meta.ArtistID), meta.Artist)
+			changed = true
+		}
+	}
+	if artist != nil {
+		meta.ArtistID = string(artist.ID)
+		meta.Artist = artist.Name
+	}
+
+	artistID := ArtistID(meta.ArtistID)
+	album := c.album(AlbumID(meta.AlbumID))
+	if album != nil && album.Artist != artistID {
+		// The album belongs to another artist, so its ID can't be used
+		album = nil
+		meta.AlbumID = ""
+	}
+	switch {
+	case album != nil:
+	case meta.Album == "":
+		meta.AlbumID = ""
+	default:
+		album = c.findAlbum(artistID, meta.Album)
+		if album == nil {
+			album = c.addAlbum(AlbumID(meta.AlbumID), artistID, meta.Album)
+			changed = true
+		}
+	}
+	if album != nil {
+		meta.AlbumID = string(album.ID)
+		meta.Album = album.Name
+	}
+
+	return changed
+}
+
+// sort sorts the records by ID, so the stored catalogue is stable.
+func (c *Catalogue) sort() {
+	sort.Slice(c.Artists, func(i, j int) bool { return c.Artists[i].ID < c.Artists[j].ID })
+	sort.Slice(c.Albums, func(i, j int) bool { return c.Albums[i].ID < c.Albums[j].ID })
+}
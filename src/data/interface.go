@@ -2,19 +2,72 @@ package data
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 
 	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/events"
 )
 
 type ChordsDBv1 interface {
 	Artists(context.Context, ArtistsFilters) ([]Artist, error)
 	Albums(context.Context, AlbumsFilters) ([]Album, error)
 	Songs(context.Context, SongsFilters) ([]Song, error)
+	// Search searches for artists and songs, returning the best matches
+	// first.
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+
+	// AddSong adds a new song. The song is linked to its artist and album
+	// by name, creating new artist/album records if needed.
+	AddSong(context.Context, SongInput) (Song, error)
+	// UpdateSong replaces the metadata of a song. If the input has a
+	// different ID, the song is renamed.
+	UpdateSong(context.Context, SongID, SongInput) (Song, error)
+	UpdateChords(context.Context, SongID, []byte) (Song, error)
+	// DeleteSong moves a song to the trash.
+	DeleteSong(context.Context, SongID) error
+
+	// UpdateArtist and UpdateAlbum update artist/album records. When they
+	// are renamed, the old name is kept as an alias.
+	UpdateArtist(context.Context, ArtistID, ArtistUpdate) (Artist, error)
+	UpdateAlbum(context.Context, AlbumID, AlbumUpdate) (Album, error)
+
+	RelateArtists(ctx context.Context, artist1, artist2 ArtistID) error
+	UnrelateArtists(ctx context.Context, artist1, artist2 ArtistID) error
+
+	// Subscribe returns a channel of changes to songs, which is closed once
+	// the context is done.
+	Subscribe(context.Context) <-chan events.Event
+}
+
+// ErrNotFound is returned (wrapped) by the write methods when the given
+// artist, album or song doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+type notFoundError struct {
+	kind string
+	id   any
+}
+
+func notFound(kind string, id any) error {
+	return notFoundError{kind, id}
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("no %s found for id %s", e.kind, e.id)
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
 }
 
 type ArtistsFilters struct {
-	ID        ArtistID
+	ID ArtistID
+	// IDs restricts the results to any of the given artists, so they can be
+	// fetched in one batch.
+	IDs       []ArtistID
 	RelatedTo ArtistID
 	Album     AlbumID
 	Song      SongID
@@ -22,19 +75,91 @@ type ArtistsFilters struct {
 
 type AlbumsFilters struct {
 	ID     AlbumID
+	IDs    []AlbumID
 	Artist ArtistID
 	Song   SongID
+	// YearFrom and YearTo restrict the results to albums released in the
+	// given range of years (inclusive). Zero means no limit.
+	YearFrom, YearTo int
 }
 
 type SongsFilters struct {
-	ID    SongID
-	Album AlbumID
+	ID     SongID
+	IDs    []SongID
+	Artist ArtistID
+	Album  AlbumID
+	// YearFrom and YearTo filter songs by the year of their album.
+	YearFrom, YearTo int
+	Key              string
+	Tag              string
+	// WithChords loads the songs' chords. Otherwise, Song.Chords is nil.
+	WithChords bool
+}
+
+// matchYear checks a year against the YearFrom and YearTo filters.
+func matchYear(year, from, to int) bool {
+	if from != 0 && year < from {
+		return false
+	}
+	return to == 0 || year <= to
+}
+
+// matchID checks an ID against the ID and IDs filters.
+func matchID[ID comparable](id, filter ID, filters []ID) bool {
+	var zero ID
+	if filter != zero && id != filter {
+		return false
+	}
+	return len(filters) == 0 || slices.Contains(filters, id)
+}
+
+// SearchResult is an artist or song matching a search. Exactly one of the
+// fields is set.
+type SearchResult struct {
+	Artist ArtistID `json:"artist,omitempty"`
+	Song   SongID   `json:"song,omitempty"`
+}
+
+// SongInput is the data used to add or update a song.
+type SongInput struct {
+	// ID is optional when adding a song. If empty, an ID is generated from
+	// the name.
+	ID       SongID   `json:"id,omitempty"`
+	Name     string   `json:"name"`
+	Artist   string   `json:"artist,omitempty"`
+	Album    string   `json:"album,omitempty"`
+	TrackNum int      `json:"trackNum,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Chords are left unchanged if nil. They aren't part of the JSON
+	// encoding, as the REST API sends chords as plain text.
+	Chords []byte `json:"-"`
+}
+
+// ArtistUpdate holds changes to an artist record. Nil fields are left
+// unchanged.
+type ArtistUpdate struct {
+	Name     *string  `json:"name,omitempty"`
+	SortName *string  `json:"sortName,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
+// AlbumUpdate holds changes to an album record. Nil fields are left
+// unchanged. A zero Year clears the year.
+type AlbumUpdate struct {
+	Name     *string  `json:"name,omitempty"`
+	SortName *string  `json:"sortName,omitempty"`
+	Year     *int     `json:"year,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
 }
 
-func GetDBv1(url string, logger *log.Logger) (ChordsDBv1, error) {
+// GetDBv1 opens the database at the given URL (see dblayer.GetDB) as a
+// Library. Use Library.V0 to get a dblayer.ChordsDB for the same data. The
+// catalogue is stored in the same database.
+func GetDBv1(url string, logger *log.Logger) (*Library, error) {
 	db, err := dblayer.GetDB(url, logger)
 	if err != nil {
 		return nil, err
 	}
-	return &ChordsDBv1Shim{db}, nil
+	return NewLibrary(db, NewDBCatalogue(db))
 }
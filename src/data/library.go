@@ -0,0 +1,405 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/events"
+)
+
+// Library is the native implementation of ChordsDBv1. Artists and albums are
+// stored as records with stable IDs (see Catalogue), and songs refer to them
+// by ID. Songs and chords are stored in the underlying ChordsDB.
+type Library struct {
+	db    dblayer.ChordsDB
+	store CatalogueStore
+	// Changes to songs are published here, whether they are made through the
+	// Library or the v0 API.
+	bus *events.Bus
+
+	// mu protects cat, and makes sure songs are linked and written atomically.
+	mu  sync.Mutex
+	cat Catalogue
+}
+
+// NewLibrary creates a Library using the given database for songs, and store
+// for the catalogue. It migrates any songs which aren't yet linked to
+// artist/album records.
+func NewLibrary(db dblayer.ChordsDB, store CatalogueStore) (*Library, error) {
+	cat, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	bus := events.NewBus()
+	l := &Library{db: dblayer.WithEvents(db, bus), store: store, bus: bus, cat: cat}
+	_, err = l.Migrate()
+	return l, err
+}
+
+// LibraryFor returns the Library backing db, if db was returned by
+// Library.V0. Otherwise, it creates a new Library for db, with the catalogue
+// stored in db.
+func LibraryFor(db dblayer.ChordsDB) (*Library, error) {
+	if v0, ok := db.(*libraryV0); ok {
+		return v0.lib, nil
+	}
+	return NewLibrary(db, NewDBCatalogue(db))
+}
+
+// Migrate links all songs to artist and album records, creating the records
+// as needed. This converts songs stored before artists and albums were
+// records. It returns the number of songs which were updated.
+func (l *Library) Migrate() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	songs, err := l.db.GetSongs("", "", "")
+	if err != nil {
+		return 0, err
+	}
+	// Link songs in a consistent order, so new IDs are deterministic
+	sort.Slice(songs, func(i, j int) bool { return songs[i].ID < songs[j].ID })
+
+	updated := 0
+	for _, meta := range songs {
+		linked, err := l.relink(meta)
+		if err != nil {
+			return updated, err
+		}
+		if !sameLinks(linked, meta) {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// save writes the catalogue to the store. The caller must hold l.mu.
+func (l *Library) save() error {
+	l.cat.sort()
+	return l.store.Save(l.cat)
+}
+
+// link links the song to its artist and album records, saving the catalogue
+// if it changed. The caller must hold l.mu.
+func (l *Library) link(meta *dblayer.SongMeta) error {
+	if l.cat.link(meta) {
+		return l.save()
+	}
+	return nil
+}
+
+// relink links an existing song, and writes it back to the database if it
+// changed. The caller must hold l.mu.
+func (l *Library) relink(meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	linked := meta
+	if err := l.link(&linked); err != nil {
+		return dblayer.SongMeta{}, err
+	}
+	if sameLinks(linked, meta) {
+		return meta, nil
+	}
+	return l.db.UpdateSong(meta.ID, linked)
+}
+
+// sameLinks returns true if two songs' artist and album fields are the same.
+// These are the only fields changed by linking.
+func sameLinks(a, b dblayer.SongMeta) bool {
+	return a.Artist == b.Artist && a.ArtistID == b.ArtistID &&
+		a.Album == b.Album && a.AlbumID == b.AlbumID
+}
+
+// V0 returns the library as a dblayer.ChordsDB, for the v0 API. Songs written
+// through it are linked to the right artist and album records.
+func (l *Library) V0() dblayer.ChordsDB {
+	return &libraryV0{l.db, l}
+}
+
+// audited returns the library's database, with changes recorded in the
+// audit log as made by the actor in ctx (see dblayer.ContextWithActor).
+func (l *Library) audited(ctx context.Context) dblayer.ChordsDB {
+	return dblayer.WithAudit(l.db, dblayer.ActorFrom(ctx))
+}
+
+type libraryV0 struct {
+	dblayer.ChordsDB
+	lib *Library
+}
+
+func (v *libraryV0) NewSong(meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	v.lib.mu.Lock()
+	defer v.lib.mu.Unlock()
+	if err := v.lib.link(&meta); err != nil {
+		return dblayer.SongMeta{}, err
+	}
+	return v.ChordsDB.NewSong(meta)
+}
+
+func (v *libraryV0) UpdateSong(id string, meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	v.lib.mu.Lock()
+	defer v.lib.mu.Unlock()
+	if err := v.lib.link(&meta); err != nil {
+		return dblayer.SongMeta{}, err
+	}
+	return v.ChordsDB.UpdateSong(id, meta)
+}
+
+// The metadata of restored and merged songs may come from elsewhere, so
+// they need to be relinked.
+
+// GetCatalogue and SetCatalogue go through the library, so its copy of the
+// catalogue stays up to date.
+func (v *libraryV0) GetCatalogue() (dblayer.CatalogueData, error) {
+	return v.lib.catalogue().data()
+}
+
+func (v *libraryV0) SetCatalogue(data dblayer.CatalogueData) error {
+	cat, err := parseCatalogue(data)
+	if err != nil {
+		return err
+	}
+	v.lib.mu.Lock()
+	defer v.lib.mu.Unlock()
+	v.lib.cat = cat
+	return v.lib.save()
+}
+
+func (v *libraryV0) RestoreSong(id string) (dblayer.SongMeta, error) {
+	return v.relink(v.ChordsDB.RestoreSong(id))
+}
+
+func (v *libraryV0) MergeSongs(id, dupID string) (dblayer.SongMeta, error) {
+	return v.relink(v.ChordsDB.MergeSongs(id, dupID))
+}
+
+func (v *libraryV0) relink(meta dblayer.SongMeta, err error) (dblayer.SongMeta, error) {
+	if err != nil {
+		return meta, err
+	}
+	v.lib.mu.Lock()
+	defer v.lib.mu.Unlock()
+	return v.lib.relink(meta)
+}
+
+// Subscribe returns a channel of changes to songs, which is closed once ctx
+// is done.
+func (l *Library) Subscribe(ctx context.Context) <-chan events.Event {
+	return l.bus.Subscribe(ctx)
+}
+
+// catalogue returns a copy of the current catalogue.
+func (l *Library) catalogue() Catalogue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cat.clone()
+}
+
+func (l *Library) Artists(_ context.Context, filters ArtistsFilters) ([]Artist, error) {
+	cat := l.catalogue()
+	songs, err := l.db.GetSongs("", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only artists with songs are listed
+	albums := map[ArtistID][]AlbumID{}
+	songArtist := map[SongID]ArtistID{}
+	for _, song := range songs {
+		artistID := ArtistID(song.ArtistID)
+		if artistID == "" {
+			continue
+		}
+		songArtist[SongID(song.ID)] = artistID
+		if _, ok := albums[artistID]; !ok {
+			albums[artistID] = []AlbumID{}
+		}
+		albumID := AlbumID(song.AlbumID)
+		if albumID != "" && !slices.Contains(albums[artistID], albumID) {
+			albums[artistID] = append(albums[artistID], albumID)
+		}
+	}
+
+	for _, ids := range albums {
+		slices.Sort(ids)
+	}
+
+	// See-also data is stored by artist name. It's loaded all at once, as
+	// there may be many artists.
+	relations, err := l.db.Relations()
+	if err != nil {
+		return nil, err
+	}
+	seeAlso := map[string][]string{}
+	for _, pair := range relations {
+		seeAlso[pair[0]] = append(seeAlso[pair[0]], pair[1])
+		seeAlso[pair[1]] = append(seeAlso[pair[1]], pair[0])
+	}
+
+	artists := []Artist{}
+	for _, record := range cat.Artists {
+		if _, ok := albums[record.ID]; !ok {
+			continue
+		}
+		if !matchID(record.ID, filters.ID, filters.IDs) {
+			continue
+		}
+		if filters.Album != "" {
+			album := cat.album(filters.Album)
+			if album == nil || album.Artist != record.ID {
+				continue
+			}
+		}
+		if filters.Song != "" && songArtist[filters.Song] != record.ID {
+			continue
+		}
+
+		related := []ArtistID{}
+		for _, name := range seeAlso[record.Name] {
+			if other := cat.findArtist(name); other != nil {
+				related = append(related, other.ID)
+			}
+		}
+		if filters.RelatedTo != "" && !slices.Contains(related, filters.RelatedTo) {
+			continue
+		}
+
+		artists = append(artists, Artist{
+			ArtistRecord:   record,
+			Albums:         albums[record.ID],
+			RelatedArtists: related,
+		})
+	}
+	return artists, nil
+}
+
+func (l *Library) Albums(_ context.Context, filters AlbumsFilters) ([]Album, error) {
+	cat := l.catalogue()
+	songs, err := l.db.GetSongs("", string(filters.Song), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Songs on each album, in track order. Only albums with songs are listed.
+	sort.SliceStable(songs, func(i, j int) bool { return songs[i].TrackNum < songs[j].TrackNum })
+	albumSongs := map[AlbumID][]SongID{}
+	for _, song := range songs {
+		if song.AlbumID != "" {
+			albumID := AlbumID(song.AlbumID)
+			albumSongs[albumID] = append(albumSongs[albumID], SongID(song.ID))
+		}
+	}
+
+	albums := []Album{}
+	for _, record := range cat.Albums {
+		if _, ok := albumSongs[record.ID]; !ok {
+			continue
+		}
+		if !matchID(record.ID, filters.ID, filters.IDs) {
+			continue
+		}
+		if filters.Artist != "" && record.Artist != filters.Artist {
+			continue
+		}
+		if !matchYear(record.Year, filters.YearFrom, filters.YearTo) {
+			continue
+		}
+		albums = append(albums, Album{
+			AlbumRecord: record,
+			Songs:       albumSongs[record.ID],
+		})
+	}
+	return albums, nil
+}
+
+func (l *Library) Search(_ context.Context, query string) ([]SearchResult, error) {
+	rawResults, err := l.db.Search(query)
+	if err != nil {
+		return nil, err
+	}
+
+	cat := l.catalogue()
+	results := []SearchResult{}
+	for _, res := range rawResults {
+		switch res.Type {
+		case "artist":
+			// Artists are indexed by name
+			artist := cat.findArtist(res.Name)
+			if artist == nil || slices.Contains(results, SearchResult{Artist: artist.ID}) {
+				continue
+			}
+			results = append(results, SearchResult{Artist: artist.ID})
+		case "song":
+			results = append(results, SearchResult{Song: SongID(res.ID)})
+		}
+	}
+	return results, nil
+}
+
+func (l *Library) Songs(_ context.Context, filters SongsFilters) ([]Song, error) {
+	var rawSongs []dblayer.SongMeta
+	if filters.ID == "" && len(filters.IDs) > 0 {
+		// Get each song by ID, rather than scanning all the songs
+		for _, id := range filters.IDs {
+			songs, err := l.db.GetSongs("", string(id), "")
+			if err != nil {
+				return nil, err
+			}
+			rawSongs = append(rawSongs, songs...)
+		}
+	} else {
+		var err error
+		rawSongs, err = l.db.GetSongs("", string(filters.ID), "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var cat Catalogue
+	if filters.YearFrom != 0 || filters.YearTo != 0 {
+		cat = l.catalogue()
+	}
+
+	songs := []Song{}
+	for _, song := range rawSongs {
+		if !matchID(SongID(song.ID), "", filters.IDs) {
+			continue
+		}
+		if filters.Artist != "" && ArtistID(song.ArtistID) != filters.Artist {
+			continue
+		}
+		if filters.Album != "" && AlbumID(song.AlbumID) != filters.Album {
+			continue
+		}
+		if filters.YearFrom != 0 || filters.YearTo != 0 {
+			album := cat.album(AlbumID(song.AlbumID))
+			if album == nil || !matchYear(album.Year, filters.YearFrom, filters.YearTo) {
+				continue
+			}
+		}
+		if filters.Key != "" && song.Key != filters.Key {
+			continue
+		}
+		if filters.Tag != "" && !slices.Contains(song.Tags, filters.Tag) {
+			continue
+		}
+
+		var chords []byte
+		if filters.WithChords {
+			chords, _ = l.db.GetChords(song.ID)
+		}
+
+		songs = append(songs, Song{
+			ID:       SongID(song.ID),
+			Name:     song.Name,
+			Artist:   ArtistID(song.ArtistID),
+			Album:    AlbumID(song.AlbumID),
+			TrackNum: song.TrackNum,
+			Key:      song.Key,
+			Tags:     song.Tags,
+			Chords:   chords,
+		})
+	}
+	return songs, nil
+}
@@ -0,0 +1,284 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/data/library_test.go
+// Tests for the native v1 data model.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLibrary(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "data")
+	assert.Nil(t, err)
+	defer func() {
+		err := os.RemoveAll(dataDir)
+		assert.Nil(t, err)
+	}()
+
+	// Songs stored before artists and albums had records
+	db := dblayer.NewLocalfs(dataDir, log.Default())
+	for _, song := range []dblayer.SongMeta{
+		{ID: "YourSong", Name: "Your Song", Artist: "Elton John", Album: "Greatest Hits", TrackNum: 1},
+		{ID: "Daniel", Name: "Daniel", Artist: "Elton John", Album: "Greatest Hits", TrackNum: 2},
+		{ID: "Maggie", Name: "Maggie May", Artist: "Rod Stewart", Album: "Greatest Hits"},
+		{ID: "Single", Name: "Single", Artist: "Rod Stewart"},
+	} {
+		_, err := db.NewSong(song)
+		assert.Nil(t, err)
+	}
+
+	lib, err := NewLibrary(db, NewDBCatalogue(db))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	// Albums with the same name by different artists are distinct
+	albums, err := lib.Albums(ctx, AlbumsFilters{})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Album{{
+		AlbumRecord: AlbumRecord{ID: "GreatestHits", Name: "Greatest Hits", SortName: "Greatest Hits", Artist: "EltonJohn"},
+		Songs:       []SongID{"YourSong", "Daniel"},
+	}, {
+		AlbumRecord: AlbumRecord{ID: "RodStewartGreatestHits", Name: "Greatest Hits", SortName: "Greatest Hits", Artist: "RodStewart"},
+		Songs:       []SongID{"Maggie"},
+	}}, albums)
+
+	// Songs refer to the records by ID
+	metas, err := db.GetSongs("", "Maggie", "")
+	assert.Nil(t, err)
+	if assert.Len(t, metas, 1) {
+		assert.Equal(t, "RodStewart", metas[0].ArtistID)
+		assert.Equal(t, "RodStewartGreatestHits", metas[0].AlbumID)
+	}
+
+	// Renaming an artist keeps its ID, and songs with the old name still
+	// link to it
+	cat := lib.catalogue()
+	cat.artist("EltonJohn").Name = "Sir Elton John"
+	cat.artist("EltonJohn").Aliases = []string{"Elton John"}
+	assert.Nil(t, lib.store.Save(cat))
+	lib, err = NewLibrary(db, NewDBCatalogue(db))
+	assert.Nil(t, err)
+
+	song, err := lib.V0().NewSong(dblayer.SongMeta{ID: "RocketMan", Name: "Rocket Man", Artist: "Elton John"})
+	assert.Nil(t, err)
+	assert.Equal(t, "EltonJohn", song.ArtistID)
+	assert.Equal(t, "Sir Elton John", song.Artist)
+
+	artists, err := lib.Artists(ctx, ArtistsFilters{Song: "RocketMan"})
+	assert.Nil(t, err)
+	if assert.Len(t, artists, 1) {
+		assert.Equal(t, ArtistID("EltonJohn"), artists[0].ID)
+		assert.Equal(t, []AlbumID{"GreatestHits"}, artists[0].Albums)
+	}
+
+	// The artist ID decides which artist the song belongs to, and the name
+	// follows it
+	song.Artist = "Rod Stewart"
+	song, err = lib.V0().UpdateSong(song.ID, song)
+	assert.Nil(t, err)
+	assert.Equal(t, "EltonJohn", song.ArtistID)
+	assert.Equal(t, "Sir Elton John", song.Artist)
+
+	// Without an ID, the song is linked by name
+	song.Artist, song.ArtistID, song.AlbumID = "Rod Stewart", "", ""
+	song, err = lib.V0().UpdateSong(song.ID, song)
+	assert.Nil(t, err)
+	assert.Equal(t, "RodStewart", song.ArtistID)
+
+	// Albums of another artist aren't linked by ID
+	song.ArtistID, song.Album, song.AlbumID = "EltonJohn", "Greatest Hits", "RodStewartGreatestHits"
+	song, err = lib.V0().UpdateSong(song.ID, song)
+	assert.Nil(t, err)
+	assert.Equal(t, "GreatestHits", song.AlbumID)
+}
+
+// countingSeeAlso counts the calls to read see-also data.
+type countingSeeAlso struct {
+	dblayer.ChordsDB
+	seeAlso, relations int
+}
+
+func (c *countingSeeAlso) SeeAlso(artist string) ([]string, error) {
+	c.seeAlso++
+	return c.ChordsDB.SeeAlso(artist)
+}
+
+func (c *countingSeeAlso) Relations() ([][2]string, error) {
+	c.relations++
+	return c.ChordsDB.Relations()
+}
+
+func TestArtistsLoadRelationsOnce(t *testing.T) {
+	ctx := context.Background()
+	db := &countingSeeAlso{ChordsDB: dblayer.NewTempDB()}
+	lib, err := NewLibrary(db, NewDBCatalogue(db))
+	assert.Nil(t, err)
+	for _, artist := range []string{"Elton John", "Billy Joel", "Rod Stewart"} {
+		_, err := lib.AddSong(ctx, SongInput{Name: "Song", Artist: artist})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.AddRelation("Elton John", "Billy Joel"))
+	assert.Nil(t, db.AddRelation("Elton John", "Rod Stewart"))
+
+	artists, err := lib.Artists(ctx, ArtistsFilters{})
+	assert.Nil(t, err)
+	related := map[ArtistID][]ArtistID{}
+	for _, artist := range artists {
+		related[artist.ID] = artist.RelatedArtists
+	}
+	assert.Equal(t, map[ArtistID][]ArtistID{
+		"EltonJohn":  {"BillyJoel", "RodStewart"},
+		"BillyJoel":  {"EltonJohn"},
+		"RodStewart": {"EltonJohn"},
+	}, related)
+	assert.Equal(t, 0, db.seeAlso)
+	assert.Equal(t, 1, db.relations)
+}
+
+func TestSnapshotCatalogue(t *testing.T) {
+	ctx := context.Background()
+	srcDB := dblayer.NewTempDB()
+	src, err := NewLibrary(srcDB, NewDBCatalogue(srcDB))
+	assert.Nil(t, err)
+	_, err = src.AddSong(ctx, SongInput{ID: "Changes", Name: "Changes", Artist: "The Beatles"})
+	assert.Nil(t, err)
+	sortName := "Beatles, The"
+	_, err = src.UpdateArtist(ctx, "TheBeatles", ArtistUpdate{SortName: &sortName})
+	assert.Nil(t, err)
+
+	snapshot := &bytes.Buffer{}
+	assert.Nil(t, dblayer.Export(src.V0(), snapshot))
+
+	// The records survive a restart of the destination database
+	dstDB := dblayer.NewTempDB()
+	dst, err := NewLibrary(dstDB, NewDBCatalogue(dstDB))
+	assert.Nil(t, err)
+	_, err = dblayer.Import(dst.V0(), snapshot, dblayer.ImportReplace)
+	assert.Nil(t, err)
+	dst, err = NewLibrary(dstDB, NewDBCatalogue(dstDB))
+	assert.Nil(t, err)
+
+	artists, err := dst.Artists(ctx, ArtistsFilters{})
+	assert.Nil(t, err)
+	if assert.Len(t, artists, 1) {
+		assert.Equal(t, ArtistID("TheBeatles"), artists[0].ID)
+		assert.Equal(t, "Beatles, The", artists[0].SortName)
+	}
+}
+
+// failingUpdate fails to update the metadata of one song, or the chords of
+// another.
+type failingUpdate struct {
+	dblayer.ChordsDB
+	id       string
+	chordsID string
+}
+
+func (f *failingUpdate) UpdateSong(id string, meta dblayer.SongMeta) (dblayer.SongMeta, error) {
+	if id == f.id {
+		return dblayer.SongMeta{}, errors.New("disk full")
+	}
+	return f.ChordsDB.UpdateSong(id, meta)
+}
+
+func (f *failingUpdate) UpdateChords(id string, chords dblayer.Chords) (dblayer.Chords, error) {
+	if id == f.chordsID {
+		return nil, errors.New("disk full")
+	}
+	return f.ChordsDB.UpdateChords(id, chords)
+}
+
+func TestUpdateSongRollback(t *testing.T) {
+	ctx := context.Background()
+	db := &failingUpdate{ChordsDB: dblayer.NewTempDB()}
+	lib, err := NewLibrary(db, NewDBCatalogue(db))
+	assert.Nil(t, err)
+	_, err = lib.AddSong(ctx, SongInput{ID: "A", Name: "A", Artist: "Prince", Chords: []byte("C")})
+	assert.Nil(t, err)
+
+	// IDs are checked before anything is written
+	_, err = lib.AddSong(ctx, SongInput{ID: "B/C", Name: "B", Artist: "Prince"})
+	assert.ErrorContains(t, err, "cannot contain a slash")
+	songs, err := db.GetSongs("", "", "")
+	assert.Nil(t, err)
+	assert.Len(t, songs, 1)
+
+	// Renaming and updating the metadata succeed, but the chords fail
+	db.chordsID = "B"
+	_, err = lib.UpdateSong(ctx, "A", SongInput{ID: "B", Name: "B", Artist: "Madonna", Chords: []byte("G")})
+	assert.ErrorContains(t, err, "disk full")
+
+	// Everything is as it was before
+	song, err := lib.song(ctx, "A")
+	assert.Nil(t, err)
+	assert.Equal(t, "A", song.Name)
+	assert.Equal(t, ArtistID("Prince"), song.Artist)
+	assert.Equal(t, "C", string(song.Chords))
+	songs, err = db.GetSongs("", "B", "")
+	assert.Nil(t, err)
+	assert.Empty(t, songs)
+
+	// The same when the metadata fails
+	db.id, db.chordsID = "B", ""
+	_, err = lib.UpdateSong(ctx, "A", SongInput{ID: "B", Name: "B", Artist: "Prince"})
+	assert.ErrorContains(t, err, "disk full")
+	songs, err = db.GetSongs("", "", "")
+	assert.Nil(t, err)
+	if assert.Len(t, songs, 1) {
+		assert.Equal(t, "A", songs[0].ID)
+	}
+}
+
+func TestUpdateArtistRollback(t *testing.T) {
+	ctx := context.Background()
+	db := &failingUpdate{ChordsDB: dblayer.NewTempDB()}
+	lib, err := NewLibrary(db, NewDBCatalogue(db))
+	assert.Nil(t, err)
+	for _, song := range []SongInput{
+		{ID: "A", Name: "A", Artist: "Prince"},
+		{ID: "B", Name: "B", Artist: "Prince"},
+		{ID: "C", Name: "C", Artist: "Madonna"},
+	} {
+		_, err := lib.AddSong(ctx, song)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, lib.RelateArtists(ctx, "Prince", "Madonna"))
+
+	// Renaming fails part way through updating the songs
+	db.id = "B"
+	newName := "The Artist"
+	_, err = lib.UpdateArtist(ctx, "Prince", ArtistUpdate{Name: &newName})
+	assert.ErrorContains(t, err, "disk full")
+
+	// Everything is as it was before
+	artists, err := lib.Artists(ctx, ArtistsFilters{ID: "Prince"})
+	assert.Nil(t, err)
+	if assert.Len(t, artists, 1) {
+		assert.Equal(t, "Prince", artists[0].Name)
+		assert.Empty(t, artists[0].Aliases)
+		assert.Equal(t, []ArtistID{"Madonna"}, artists[0].RelatedArtists)
+	}
+	stored, err := NewDBCatalogue(db).Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "Prince", stored.artist("Prince").Name)
+	songs, err := db.GetSongs("Prince", "", "")
+	assert.Nil(t, err)
+	assert.Len(t, songs, 2)
+	seeAlso, err := db.SeeAlso("Prince")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Madonna"}, seeAlso)
+}
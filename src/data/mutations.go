@@ -0,0 +1,420 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/util"
+)
+
+// Write methods for Library.
+
+func (l *Library) AddSong(ctx context.Context, in SongInput) (Song, error) {
+	id := in.ID
+	if id == "" {
+		var err error
+		id, err = l.newSongID(in.Name)
+		if err != nil {
+			return Song{}, err
+		}
+	} else if err := dblayer.ValidateID(string(id)); err != nil {
+		return Song{}, err
+	}
+
+	db := l.audited(ctx)
+	_, err := (&libraryV0{db, l}).NewSong(in.meta(id))
+	if err != nil {
+		return Song{}, err
+	}
+	if in.Chords != nil {
+		_, err = db.UpdateChords(string(id), in.Chords)
+		if err != nil {
+			return Song{}, err
+		}
+	}
+	return l.song(ctx, id)
+}
+
+// newSongID generates an unused song ID from the song name.
+func (l *Library) newSongID(name string) (SongID, error) {
+	base := util.MakeID(name)
+	if base == "" {
+		base = "Song"
+	}
+	id := base
+	for n := 2; ; n++ {
+		songs, err := l.db.GetSongs("", id, "")
+		if err != nil {
+			return "", err
+		}
+		if len(songs) == 0 {
+			return SongID(id), nil
+		}
+		id = fmt.Sprintf("%s%d", base, n)
+	}
+}
+
+// UpdateSong renames the song (if in.ID is set), then updates its metadata
+// and chords. If any step fails, the previous steps are undone.
+func (l *Library) UpdateSong(ctx context.Context, id SongID, in SongInput) (Song, error) {
+	if _, err := l.song(ctx, id); err != nil {
+		return Song{}, err
+	}
+	db := l.audited(ctx)
+	var undo undoList
+	if in.ID != "" && in.ID != id {
+		_, err := db.RenameSong(string(id), string(in.ID))
+		if err != nil {
+			return Song{}, err
+		}
+		oldID, newID := id, in.ID
+		undo.add(func() error {
+			_, err := db.RenameSong(string(newID), string(oldID))
+			return err
+		})
+		id = in.ID
+	}
+
+	oldMeta, err := l.songMeta(id)
+	if err != nil {
+		return Song{}, undo.run(err)
+	}
+	_, err = (&libraryV0{db, l}).UpdateSong(string(id), in.meta(id))
+	if err != nil {
+		return Song{}, undo.run(err)
+	}
+	undo.add(func() error {
+		_, err := db.UpdateSong(string(id), oldMeta)
+		return err
+	})
+	if in.Chords != nil {
+		_, err = db.UpdateChords(string(id), in.Chords)
+		if err != nil {
+			return Song{}, undo.run(err)
+		}
+	}
+	return l.song(ctx, id)
+}
+
+// songMeta gets the stored metadata for the song with the given ID.
+func (l *Library) songMeta(id SongID) (dblayer.SongMeta, error) {
+	songs, err := l.db.GetSongs("", string(id), "")
+	if err != nil {
+		return dblayer.SongMeta{}, err
+	}
+	if len(songs) == 0 {
+		return dblayer.SongMeta{}, notFound("song", id)
+	}
+	return songs[0], nil
+}
+
+// meta converts the input to song metadata with the given ID.
+func (in SongInput) meta(id SongID) dblayer.SongMeta {
+	return dblayer.SongMeta{
+		ID:       string(id),
+		Name:     in.Name,
+		Artist:   in.Artist,
+		Album:    in.Album,
+		TrackNum: in.TrackNum,
+		Key:      in.Key,
+		Tags:     in.Tags,
+	}
+}
+
+func (l *Library) UpdateChords(ctx context.Context, id SongID, chords []byte) (Song, error) {
+	if _, err := l.song(ctx, id); err != nil {
+		return Song{}, err
+	}
+	_, err := l.audited(ctx).UpdateChords(string(id), chords)
+	if err != nil {
+		return Song{}, err
+	}
+	return l.song(ctx, id)
+}
+
+func (l *Library) DeleteSong(ctx context.Context, id SongID) error {
+	if _, err := l.song(ctx, id); err != nil {
+		return err
+	}
+	return l.audited(ctx).DeleteSong(string(id))
+}
+
+// song gets the song with the given ID.
+func (l *Library) song(ctx context.Context, id SongID) (Song, error) {
+	songs, err := l.Songs(ctx, SongsFilters{ID: id, WithChords: true})
+	if err != nil {
+		return Song{}, err
+	}
+	if len(songs) == 0 {
+		return Song{}, notFound("song", id)
+	}
+	return songs[0], nil
+}
+
+func (l *Library) UpdateArtist(ctx context.Context, id ArtistID, up ArtistUpdate) (Artist, error) {
+	err := l.updateArtist(l.audited(ctx), id, up)
+	if err != nil {
+		return Artist{}, err
+	}
+	artists, err := l.Artists(ctx, ArtistsFilters{ID: id})
+	if err != nil {
+		return Artist{}, err
+	}
+	if len(artists) == 0 {
+		return Artist{}, notFound("artist", id)
+	}
+	return artists[0], nil
+}
+
+func (l *Library) updateArtist(db dblayer.ChordsDB, id ArtistID, up ArtistUpdate) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Make the changes to a copy, so the catalogue is left as it was if
+	// anything fails
+	cat := l.cat.clone()
+	artist := cat.artist(id)
+	if artist == nil {
+		return notFound("artist", id)
+	}
+	oldName, newName := artist.Name, artist.Name
+	if up.Name != nil {
+		newName = *up.Name
+		if newName == "" {
+			return fmt.Errorf("artist name cannot be empty")
+		}
+		if other := cat.findArtist(newName); other != nil && other.ID != id {
+			return fmt.Errorf("artist %s already has the name %q", other.ID, newName)
+		}
+	}
+
+	if up.SortName != nil {
+		artist.SortName = *up.SortName
+	}
+	if up.Aliases != nil {
+		artist.Aliases = up.Aliases
+	}
+	var related []string
+	if newName != oldName {
+		artist.Name = newName
+		artist.Aliases = rename(artist.Aliases, oldName, newName)
+		if up.SortName == nil && artist.SortName == oldName {
+			// Sort name was the default
+			artist.SortName = newName
+		}
+
+		// See-also data is stored by artist name, so the relations need to
+		// be moved to the new name.
+		var err error
+		related, err = l.db.SeeAlso(oldName)
+		if err != nil {
+			return err
+		}
+	}
+
+	var undo undoList
+	if err := l.setCatalogue(cat, &undo); err != nil || newName == oldName {
+		return err
+	}
+	for _, other := range related {
+		err := db.RemoveRelation(oldName, other)
+		if err != nil {
+			return undo.run(err)
+		}
+		undo.add(func() error { return db.AddRelation(oldName, other) })
+	}
+	err := l.renameInSongs(db, &undo, func(meta *dblayer.SongMeta) bool {
+		if meta.ArtistID != string(id) {
+			return false
+		}
+		meta.Artist = newName
+		return true
+	})
+	if err != nil {
+		return undo.run(err)
+	}
+	for _, other := range related {
+		err = db.AddRelation(newName, other)
+		if err != nil {
+			return undo.run(err)
+		}
+		undo.add(func() error { return db.RemoveRelation(newName, other) })
+	}
+	return nil
+}
+
+func (l *Library) UpdateAlbum(ctx context.Context, id AlbumID, up AlbumUpdate) (Album, error) {
+	err := l.updateAlbum(l.audited(ctx), id, up)
+	if err != nil {
+		return Album{}, err
+	}
+	albums, err := l.Albums(ctx, AlbumsFilters{ID: id})
+	if err != nil {
+		return Album{}, err
+	}
+	if len(albums) == 0 {
+		return Album{}, notFound("album", id)
+	}
+	return albums[0], nil
+}
+
+func (l *Library) updateAlbum(db dblayer.ChordsDB, id AlbumID, up AlbumUpdate) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cat := l.cat.clone()
+	album := cat.album(id)
+	if album == nil {
+		return notFound("album", id)
+	}
+	oldName, newName := album.Name, album.Name
+	if up.Name != nil {
+		newName = *up.Name
+		if newName == "" {
+			return fmt.Errorf("album name cannot be empty")
+		}
+		if other := cat.findAlbum(album.Artist, newName); other != nil && other.ID != id {
+			return fmt.Errorf("album %s already has the name %q", other.ID, newName)
+		}
+	}
+
+	if up.SortName != nil {
+		album.SortName = *up.SortName
+	}
+	if up.Year != nil {
+		album.Year = *up.Year
+	}
+	if up.Aliases != nil {
+		album.Aliases = up.Aliases
+	}
+	if newName != oldName {
+		album.Name = newName
+		album.Aliases = rename(album.Aliases, oldName, newName)
+		if up.SortName == nil && album.SortName == oldName {
+			// Sort name was the default
+			album.SortName = newName
+		}
+	}
+
+	var undo undoList
+	if err := l.setCatalogue(cat, &undo); err != nil || newName == oldName {
+		return err
+	}
+	err := l.renameInSongs(db, &undo, func(meta *dblayer.SongMeta) bool {
+		if meta.AlbumID != string(id) {
+			return false
+		}
+		meta.Album = newName
+		return true
+	})
+	if err != nil {
+		return undo.run(err)
+	}
+	return nil
+}
+
+// rename updates the aliases of a record being renamed, so that the old name
+// becomes an alias.
+func rename(aliases []string, oldName, newName string) []string {
+	aliases = slices.DeleteFunc(slices.Clone(aliases), func(a string) bool {
+		return a == newName
+	})
+	if !slices.Contains(aliases, oldName) {
+		aliases = append(aliases, oldName)
+	}
+	return aliases
+}
+
+// undoList records how to undo the completed steps of a change which writes
+// to the database several times, so they can be undone if a later step
+// fails.
+type undoList []func() error
+
+func (u *undoList) add(f func() error) {
+	*u = append(*u, f)
+}
+
+// run undoes the completed steps, most recent first, and returns err along
+// with any errors from undoing them.
+func (u undoList) run(err error) error {
+	for i := len(u) - 1; i >= 0; i-- {
+		if uerr := u[i](); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("undoing change: %w", uerr))
+		}
+	}
+	return err
+}
+
+// setCatalogue replaces the catalogue and saves it. If the save fails, the
+// old catalogue is kept. The caller must hold l.mu.
+func (l *Library) setCatalogue(cat Catalogue, undo *undoList) error {
+	old := l.cat
+	l.cat = cat
+	if err := l.save(); err != nil {
+		l.cat = old
+		return err
+	}
+	undo.add(func() error {
+		l.cat = old
+		return l.save()
+	})
+	return nil
+}
+
+// renameInSongs applies update to the metadata of every song, and writes
+// back the songs which changed to db. Each song written is added to undo.
+// The caller must hold l.mu.
+func (l *Library) renameInSongs(db dblayer.ChordsDB, undo *undoList, update func(*dblayer.SongMeta) bool) error {
+	songs, err := l.db.GetSongs("", "", "")
+	if err != nil {
+		return err
+	}
+	for _, meta := range songs {
+		old := meta
+		if !update(&meta) {
+			continue
+		}
+		_, err = db.UpdateSong(meta.ID, meta)
+		if err != nil {
+			return fmt.Errorf("updating song %q: %w", meta.ID, err)
+		}
+		undo.add(func() error {
+			_, err := db.UpdateSong(old.ID, old)
+			return err
+		})
+	}
+	return nil
+}
+
+func (l *Library) RelateArtists(ctx context.Context, artist1, artist2 ArtistID) error {
+	name1, name2, err := l.artistNames(artist1, artist2)
+	if err != nil {
+		return err
+	}
+	return l.audited(ctx).AddRelation(name1, name2)
+}
+
+func (l *Library) UnrelateArtists(ctx context.Context, artist1, artist2 ArtistID) error {
+	name1, name2, err := l.artistNames(artist1, artist2)
+	if err != nil {
+		return err
+	}
+	return l.audited(ctx).RemoveRelation(name1, name2)
+}
+
+// artistNames gets the names of two artists, as see-also data is stored by
+// artist name.
+func (l *Library) artistNames(artist1, artist2 ArtistID) (string, string, error) {
+	cat := l.catalogue()
+	a1, a2 := cat.artist(artist1), cat.artist(artist2)
+	switch {
+	case a1 == nil:
+		return "", "", notFound("artist", artist1)
+	case a2 == nil:
+		return "", "", notFound("artist", artist2)
+	}
+	return a1.Name, a2.Name, nil
+}
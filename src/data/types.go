@@ -1,20 +1,39 @@
 package data
 
+// ArtistRecord is the stored record for an artist. The ID never changes, even
+// if the artist is renamed.
+type ArtistRecord struct {
+	ID   ArtistID `json:"id"`
+	Name string   `json:"name"`
+	// SortName is used to sort artists, e.g. "Beatles, The".
+	SortName string `json:"sortName,omitempty"`
+	// Aliases are other names for the artist (e.g. old names). Songs with
+	// these artist names are linked to this artist.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
 type Artist struct {
-	ID             ArtistID   `json:"id"`
-	Name           string     `json:"name"`
+	ArtistRecord
 	Albums         []AlbumID  `json:"albums"`
 	RelatedArtists []ArtistID `json:"relatedArtists"`
 }
 
 type ArtistID string
 
+// AlbumRecord is the stored record for an album. Albums belong to an artist,
+// so two albums with the same name by different artists are distinct.
+type AlbumRecord struct {
+	ID       AlbumID  `json:"id"`
+	Name     string   `json:"name"`
+	SortName string   `json:"sortName,omitempty"`
+	Year     int      `json:"year,omitempty"`
+	Artist   ArtistID `json:"artist"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
 type Album struct {
-	ID     AlbumID  `json:"id"`
-	Name   string   `json:"name"`
-	Year   int      `json:"year,omitempty"`
-	Artist ArtistID `json:"artist"`
-	Songs  []SongID `json:"songs"`
+	AlbumRecord
+	Songs []SongID `json:"songs"`
 }
 
 type AlbumID string
@@ -25,7 +44,11 @@ type Song struct {
 	Artist   ArtistID `json:"artist,omitempty"`
 	Album    AlbumID  `json:"album,omitempty"`
 	TrackNum int      `json:"trackNum,omitempty"`
-	Chords   []byte   `json:"chords"`
+	Key      string   `json:"key,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Chords are only loaded if requested (see SongsFilters.WithChords).
+	// The REST API serves them separately, as plain text.
+	Chords []byte `json:"-"`
 }
 
 type SongID string
@@ -0,0 +1,73 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/aliases.go
+// Helpers for renaming and merging songs. When a song's ID changes, the old
+// ID is kept as an alias, so that links to the old ID still work.
+
+package dblayer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// aliasMap maps old song IDs to current ones.
+type aliasMap map[string]string
+
+// add records that alias now refers to the song with the given ID. Existing
+// aliases which pointed to alias are updated, so that aliases never need to
+// be followed more than once.
+func (a aliasMap) add(alias, id string) {
+	for old, target := range a {
+		if target == alias {
+			a[old] = id
+		}
+	}
+	a[alias] = id
+
+	// The ID belongs to a real song now, so it can't be an alias.
+	delete(a, id)
+}
+
+// ValidateID checks that id can be used as a song ID.
+func ValidateID(id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("song ID cannot be empty")
+	case strings.HasPrefix(id, "."):
+		return fmt.Errorf("invalid song ID %q: cannot start with a '.'", id)
+	case strings.ContainsAny(id, `/\`):
+		return fmt.Errorf("invalid song ID %q: cannot contain a slash", id)
+	}
+	return nil
+}
+
+// mergeMeta combines the metadata for two copies of the same song. The
+// metadata for the song being kept takes precedence, but any fields it is
+// missing are filled in from the duplicate.
+func mergeMeta(keep, dup SongMeta) SongMeta {
+	if keep.Name == "" {
+		keep.Name = dup.Name
+	}
+	if keep.Artist == "" {
+		keep.Artist = dup.Artist
+	}
+	if keep.Album == "" {
+		keep.Album = dup.Album
+		keep.TrackNum = dup.TrackNum
+	}
+	if keep.Key == "" {
+		keep.Key = dup.Key
+	}
+	for _, tag := range dup.Tags {
+		if !slices.Contains(keep.Tags, tag) {
+			keep.Tags = append(keep.Tags, tag)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,293 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/audit.go
+// The audit log is an append-only record of every change to the database:
+// who made it, when, and what changed. Changes made through a database
+// wrapped with WithAudit are recorded in the underlying database.
+
+package dblayer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/barrettj12/chords/src/util"
+)
+
+// AuditAction is the kind of change recorded in an audit entry.
+type AuditAction string
+
+const (
+	AuditSongAdd        AuditAction = "song.add"
+	AuditSongUpdate     AuditAction = "song.update"
+	AuditSongDelete     AuditAction = "song.delete"
+	AuditSongRestore    AuditAction = "song.restore"
+	AuditSongPurge      AuditAction = "song.purge"
+	AuditSongRename     AuditAction = "song.rename"
+	AuditSongMerge      AuditAction = "song.merge"
+	AuditChordsUpdate   AuditAction = "chords.update"
+	AuditRelationAdd    AuditAction = "relation.add"
+	AuditRelationRemove AuditAction = "relation.remove"
+)
+
+// AuditActions lists all the audit actions.
+var AuditActions = []AuditAction{
+	AuditSongAdd, AuditSongUpdate, AuditSongDelete, AuditSongRestore, AuditSongPurge,
+	AuditSongRename, AuditSongMerge, AuditChordsUpdate, AuditRelationAdd, AuditRelationRemove,
+}
+
+// AuditEntry records a single change to the database.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is the user who made the change, or empty if unknown.
+	Actor string `json:"actor,omitempty"`
+	// RequestID identifies the HTTP request which made the change.
+	RequestID string      `json:"requestId,omitempty"`
+	Action    AuditAction `json:"action"`
+	// Target is the ID of the song changed, or for relations, the first
+	// artist. Other is the new ID of a renamed song, the duplicate of a
+	// merged song, or the other artist in a relation.
+	Target string `json:"target"`
+	Other  string `json:"other,omitempty"`
+	// Before and After are hashes of the target's state before and after the
+	// change. They are empty if the target didn't exist.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// Diff is a unified diff of the change, for changes to metadata or
+	// chords.
+	Diff string `json:"diff,omitempty"`
+}
+
+// AuditFilter selects entries from the audit log. Zero fields match
+// everything.
+type AuditFilter struct {
+	Actor  string
+	Target string
+	Action AuditAction
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of entries to return.
+	Limit int
+}
+
+// Matches returns true if the entry is selected by the filter. It doesn't
+// take Limit into account.
+func (f AuditFilter) Matches(e AuditEntry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Target == "" || e.Target == f.Target || e.Other == f.Target) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// filterAudit returns the entries matching the filter, newest first, from a
+// log in the order it was written.
+func filterAudit(log []AuditEntry, f AuditFilter) []AuditEntry {
+	entries := []AuditEntry{}
+	for i := len(log) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(entries) == f.Limit {
+			break
+		}
+		if f.Matches(log[i]) {
+			entries = append(entries, log[i])
+		}
+	}
+	return entries
+}
+
+// hash returns a short hash identifying some data.
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Actor identifies who is making changes to the database.
+type Actor struct {
+	User      string
+	RequestID string
+}
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying the given actor.
+func ContextWithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor carried by ctx, or the zero Actor.
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+// WithAudit wraps db so that every change made through it is recorded in
+// the audit log as made by the given actor. If the change succeeds but
+// can't be recorded, an error is returned.
+func WithAudit(db ChordsDB, actor Actor) ChordsDB {
+	return &auditDB{db, actor}
+}
+
+type auditDB struct {
+	ChordsDB
+	actor Actor
+}
+
+func (a *auditDB) record(e AuditEntry) error {
+	e.Time = time.Now().UTC()
+	e.Actor = a.actor.User
+	e.RequestID = a.actor.RequestID
+	if err := a.AppendAudit(e); err != nil {
+		return fmt.Errorf("recording %s of %q in audit log: %w", e.Action, e.Target, err)
+	}
+	return nil
+}
+
+// meta returns a song's metadata as indented JSON, or "" if it doesn't
+// exist.
+func (a *auditDB) meta(id string) string {
+	songs, err := a.GetSongs("", id, "")
+	if err != nil || len(songs) == 0 {
+		return ""
+	}
+	data, err := json.MarshalIndent(songs[0], "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+// metaEntry returns an audit entry for a change to a song's metadata.
+func metaEntry(action AuditAction, id, other, before, after string) AuditEntry {
+	e := AuditEntry{Action: action, Target: id, Other: other}
+	if before != "" {
+		e.Before = hash([]byte(before))
+	}
+	if after != "" {
+		e.After = hash([]byte(after))
+	}
+	if before != after {
+		e.Diff = util.UnifiedDiff(id+"/meta.json", id+"/meta.json", before, after, 3)
+	}
+	return e
+}
+
+func (a *auditDB) NewSong(meta SongMeta) (SongMeta, error) {
+	meta, err := a.ChordsDB.NewSong(meta)
+	if err != nil {
+		return meta, err
+	}
+	return meta, a.record(metaEntry(AuditSongAdd, meta.ID, "", "", a.meta(meta.ID)))
+}
+
+func (a *auditDB) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
+	before := a.meta(id)
+	meta, err := a.ChordsDB.UpdateSong(id, meta)
+	if err != nil {
+		return meta, err
+	}
+	return meta, a.record(metaEntry(AuditSongUpdate, id, "", before, a.meta(meta.ID)))
+}
+
+func (a *auditDB) DeleteSong(id string) error {
+	before := a.meta(id)
+	err := a.ChordsDB.DeleteSong(id)
+	if err != nil {
+		return err
+	}
+	return a.record(metaEntry(AuditSongDelete, id, "", before, ""))
+}
+
+func (a *auditDB) RestoreSong(id string) (SongMeta, error) {
+	meta, err := a.ChordsDB.RestoreSong(id)
+	if err != nil {
+		return meta, err
+	}
+	return meta, a.record(metaEntry(AuditSongRestore, meta.ID, "", "", a.meta(meta.ID)))
+}
+
+func (a *auditDB) PurgeSong(id string) error {
+	err := a.ChordsDB.PurgeSong(id)
+	if err != nil {
+		return err
+	}
+	return a.record(AuditEntry{Action: AuditSongPurge, Target: id})
+}
+
+func (a *auditDB) RenameSong(id, newID string) (SongMeta, error) {
+	before := a.meta(id)
+	meta, err := a.ChordsDB.RenameSong(id, newID)
+	if err != nil {
+		return meta, err
+	}
+	return meta, a.record(metaEntry(AuditSongRename, id, meta.ID, before, a.meta(meta.ID)))
+}
+
+func (a *auditDB) MergeSongs(id, dupID string) (SongMeta, error) {
+	before := a.meta(id)
+	meta, err := a.ChordsDB.MergeSongs(id, dupID)
+	if err != nil {
+		return meta, err
+	}
+	return meta, a.record(metaEntry(AuditSongMerge, id, dupID, before, a.meta(meta.ID)))
+}
+
+func (a *auditDB) UpdateChords(id string, chords Chords) (Chords, error) {
+	before, _ := a.GetChords(id)
+	chords, err := a.ChordsDB.UpdateChords(id, chords)
+	if err != nil {
+		return chords, err
+	}
+	e := AuditEntry{Action: AuditChordsUpdate, Target: id, Before: Revision(before), After: Revision(chords)}
+	if e.Before != e.After {
+		e.Diff = util.UnifiedDiff(id+"/chords.txt", id+"/chords.txt", string(before), string(chords), 3)
+	}
+	return chords, a.record(e)
+}
+
+// relationEntry returns an audit entry for a change to an artist's see-also
+// list. The hashes are of the list.
+func (a *auditDB) relationEntry(action AuditAction, artist1, artist2, before string) AuditEntry {
+	e := AuditEntry{Action: action, Target: artist1, Other: artist2}
+	if before != "" {
+		e.Before = hash([]byte(before))
+	}
+	if after := a.seeAlso(artist1); after != "" {
+		e.After = hash([]byte(after))
+	}
+	return e
+}
+
+func (a *auditDB) seeAlso(artist string) string {
+	related, err := a.SeeAlso(artist)
+	if err != nil {
+		return ""
+	}
+	return strings.Join(related, "\n")
+}
+
+func (a *auditDB) AddRelation(artist1, artist2 string) error {
+	before := a.seeAlso(artist1)
+	err := a.ChordsDB.AddRelation(artist1, artist2)
+	if err != nil {
+		return err
+	}
+	return a.record(a.relationEntry(AuditRelationAdd, artist1, artist2, before))
+}
+
+func (a *auditDB) RemoveRelation(artist1, artist2 string) error {
+	before := a.seeAlso(artist1)
+	err := a.ChordsDB.RemoveRelation(artist1, artist2)
+	if err != nil {
+		return err
+	}
+	return a.record(a.relationEntry(AuditRelationRemove, artist1, artist2, before))
+}
@@ -0,0 +1,111 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/catalogue.go
+// Storage for the artist and album records (see data.Catalogue). Databases
+// store them as JSON documents, without looking inside them, except to merge
+// them when importing a snapshot.
+
+package dblayer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CatalogueData is the artist and album records of a library, as JSON
+// arrays of objects with an "id" field. A nil field means there are no
+// records.
+type CatalogueData struct {
+	Artists json.RawMessage
+	Albums  json.RawMessage
+}
+
+func (c CatalogueData) empty() bool {
+	return len(c.Artists) == 0 && len(c.Albums) == 0
+}
+
+// check checks that both fields are arrays of records with IDs.
+func (c CatalogueData) check() error {
+	if _, err := records(c.Artists); err != nil {
+		return fmt.Errorf("artists: %w", err)
+	}
+	if _, err := records(c.Albums); err != nil {
+		return fmt.Errorf("albums: %w", err)
+	}
+	return nil
+}
+
+// mergeCatalogue adds the records in c to existing. Records in c replace
+// existing records with the same ID.
+func mergeCatalogue(existing, c CatalogueData) (CatalogueData, error) {
+	artists, err := mergeRecords(existing.Artists, c.Artists)
+	if err != nil {
+		return CatalogueData{}, fmt.Errorf("artists: %w", err)
+	}
+	albums, err := mergeRecords(existing.Albums, c.Albums)
+	if err != nil {
+		return CatalogueData{}, fmt.Errorf("albums: %w", err)
+	}
+	return CatalogueData{artists, albums}, nil
+}
+
+// record is a catalogue record, with its ID parsed.
+type record struct {
+	id   string
+	data json.RawMessage
+}
+
+// records parses a JSON array of records.
+func records(data json.RawMessage) ([]record, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	recs := make([]record, 0, len(raw))
+	for _, r := range raw {
+		var id struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(r, &id); err != nil {
+			return nil, err
+		}
+		if id.ID == "" {
+			return nil, fmt.Errorf("record has no id: %s", r)
+		}
+		recs = append(recs, record{id.ID, r})
+	}
+	return recs, nil
+}
+
+func mergeRecords(existing, updates json.RawMessage) (json.RawMessage, error) {
+	old, err := records(existing)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := records(updates)
+	if err != nil {
+		return nil, err
+	}
+	replaced := set[string]{}
+	for _, r := range recs {
+		replaced.add(r.id)
+	}
+
+	merged := []json.RawMessage{}
+	for _, r := range old {
+		if _, ok := replaced[r.id]; !ok {
+			merged = append(merged, r.data)
+		}
+	}
+	for _, r := range recs {
+		merged = append(merged, r.data)
+	}
+	return json.Marshal(merged)
+}
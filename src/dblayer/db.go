@@ -18,6 +18,7 @@ import (
 	"log"
 	"math/rand"
 	"strings"
+	"time"
 
 	"github.com/barrettj12/chords/src/types"
 )
@@ -30,23 +31,83 @@ type ChordsDB interface {
 	UpdateSong(id string, meta SongMeta) (SongMeta, error)
 	DeleteSong(id string) error
 	GetChords(id string) (Chords, error)
+	// ChordsModTime returns when the song's chords were last changed, or
+	// the zero time if the database doesn't record it.
+	ChordsModTime(id string) (time.Time, error)
 	UpdateChords(id string, chords Chords) (Chords, error)
 	SeeAlso(artist string) ([]string, error)
 	Search(query string) ([]types.SearchResult, error)
-	// Close() error
+
+	// Deleted songs are moved to the trash, from where they can be restored
+	// or purged (deleted permanently).
+	ListTrash() ([]TrashedSong, error)
+	RestoreSong(id string) (SongMeta, error)
+	PurgeSong(id string) error
+
+	// RenameSong changes the ID of a song. The old ID becomes an alias for
+	// the new one.
+	RenameSong(id, newID string) (SongMeta, error)
+	// MergeSongs merges the song dupID into the song id, keeping id's chords.
+	// The duplicate is moved to the trash, and dupID becomes an alias for id.
+	MergeSongs(id, dupID string) (SongMeta, error)
+	// ResolveAlias returns the current ID of a song which used to have the
+	// given ID, or "" if there is no such song.
+	ResolveAlias(alias string) (string, error)
+
+	// AddRelation records that two artists are related, so each appears in
+	// the other's see-also list. Both artists must exist in the database.
+	AddRelation(artist1, artist2 string) error
+	// RemoveRelation removes the relation between two artists.
+	RemoveRelation(artist1, artist2 string) error
+	// Relations returns every relation as a pair of artists, each pair once,
+	// in sorted order (see seealso.go).
+	Relations() ([][2]string, error)
+
+	// GetCatalogue and SetCatalogue read and replace the artist and album
+	// records (see catalogue.go).
+	GetCatalogue() (CatalogueData, error)
+	SetCatalogue(CatalogueData) error
+
+	// Suggestions are corrections to songs' chords from visitors, which are
+	// kept until they are accepted or rejected (see suggestions.go).
+	AddSuggestion(Suggestion) error
+	// ListSuggestions returns the suggestions with the given status, or all
+	// suggestions if status is empty, oldest first.
+	ListSuggestions(status SuggestionStatus) ([]Suggestion, error)
+	GetSuggestion(id string) (Suggestion, error)
+	UpdateSuggestion(Suggestion) error
+
+	// The audit log records every change to the database (see audit.go).
+	// Entries can only be appended.
+	AppendAudit(AuditEntry) error
+	// ListAudit returns the entries matching the filter, newest first.
+	ListAudit(AuditFilter) ([]AuditEntry, error)
+
+	// Ping checks that the database is reachable and ready to serve
+	// requests.
+	Ping() error
+	// Close releases the database's resources, such as connections and the
+	// search index. The database can't be used afterwards.
+	Close() error
 }
 
+// GetDB returns the database at the given URL (see docs/DEV.md). Its
+// operations are timed for the /metrics endpoint.
 func GetDB(url string, logger *log.Logger) (ChordsDB, error) {
 	if strings.HasPrefix(url, "postgres") {
 		logger.Printf("Using Postgres database at %s\n", url)
-		return NewPostgres(url)
+		db, err := NewPostgres(url, logger)
+		if err != nil {
+			return nil, err
+		}
+		return WithMetrics(db, "postgres"), nil
 	} else if url == "" {
 		logger.Println("Using temporary local database")
 		db := NewTempDB()
-		return db, Fill(db)
+		return WithMetrics(db, "temp"), Fill(db)
 	} else {
 		logger.Printf("Using local filesystem database at %s\n", url)
-		return NewLocalfs(url, logger), nil
+		return WithMetrics(NewLocalfs(url, logger), "localfs"), nil
 	}
 }
 
@@ -54,6 +115,12 @@ type SongMeta = types.SongMeta
 
 type Chords []byte
 
+// TrashedSong is a song which has been deleted, but not yet purged.
+type TrashedSong struct {
+	SongMeta
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
 // Fill fills a ChordsDB with some sample data - good for demonstration
 // and/or testing.
 func Fill(db ChordsDB) error {
@@ -0,0 +1,75 @@
+package dblayer
+
+import "github.com/barrettj12/chords/src/events"
+
+// WithEvents wraps db so that every change to a song is published to the
+// given bus.
+func WithEvents(db ChordsDB, bus *events.Bus) ChordsDB {
+	return &eventsDB{db, bus}
+}
+
+type eventsDB struct {
+	ChordsDB
+	bus *events.Bus
+}
+
+func (e *eventsDB) publish(typ events.Type, id string) {
+	e.bus.Publish(events.Event{Type: typ, ID: id})
+}
+
+func (e *eventsDB) NewSong(meta SongMeta) (SongMeta, error) {
+	meta, err := e.ChordsDB.NewSong(meta)
+	if err == nil {
+		e.publish(events.SongAdded, meta.ID)
+	}
+	return meta, err
+}
+
+func (e *eventsDB) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
+	meta, err := e.ChordsDB.UpdateSong(id, meta)
+	if err == nil {
+		e.publish(events.SongUpdated, meta.ID)
+	}
+	return meta, err
+}
+
+func (e *eventsDB) DeleteSong(id string) error {
+	err := e.ChordsDB.DeleteSong(id)
+	if err == nil {
+		e.publish(events.SongDeleted, id)
+	}
+	return err
+}
+
+func (e *eventsDB) UpdateChords(id string, chords Chords) (Chords, error) {
+	chords, err := e.ChordsDB.UpdateChords(id, chords)
+	if err == nil {
+		e.publish(events.SongUpdated, id)
+	}
+	return chords, err
+}
+
+func (e *eventsDB) RestoreSong(id string) (SongMeta, error) {
+	meta, err := e.ChordsDB.RestoreSong(id)
+	if err == nil {
+		e.publish(events.SongAdded, meta.ID)
+	}
+	return meta, err
+}
+
+func (e *eventsDB) RenameSong(id, newID string) (SongMeta, error) {
+	meta, err := e.ChordsDB.RenameSong(id, newID)
+	if err == nil {
+		e.bus.Publish(events.Event{Type: events.SongUpdated, ID: meta.ID, OldID: id})
+	}
+	return meta, err
+}
+
+func (e *eventsDB) MergeSongs(id, dupID string) (SongMeta, error) {
+	meta, err := e.ChordsDB.MergeSongs(id, dupID)
+	if err == nil {
+		e.publish(events.SongDeleted, dupID)
+		e.publish(events.SongUpdated, meta.ID)
+	}
+	return meta, err
+}
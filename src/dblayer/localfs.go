@@ -10,6 +10,8 @@
 package dblayer
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +20,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/barrettj12/chords/src/search"
 	"github.com/barrettj12/chords/src/types"
@@ -33,12 +39,34 @@ import (
 //   │  ├─ meta.json
 //   │  └─ chords.txt
 //   ...
+//   ├─ .trash
+//   │  ├─ [deletion time]_[id]
+//   │  │  ├─ meta.json
+//   │  │  └─ chords.txt
+//   │  ...
+//   ├─ .suggestions
+//   │  ├─ [suggestion id].json
+//   │  ...
+//   ├─ artists.json
+//   ├─ albums.json
+//   └─ .audit.jsonl
+// Directories starting with a "." are not songs.
 
 type localfs struct {
 	basedir string
 	log     *log.Logger
 	// Index for text search
 	index *search.Index
+	// idMu is held while a song ID is checked and then taken (by creating,
+	// restoring or renaming a song), and while aliases.json is read,
+	// modified and written back.
+	idMu sync.Mutex
+	// seeAlsoMu is held while see-also.json is read, modified and written
+	// back, so concurrent changes aren't lost.
+	seeAlsoMu sync.Mutex
+	// auditMu serialises appends to the audit log, so entries aren't
+	// interleaved, and stops them being read half-written.
+	auditMu sync.Mutex
 }
 
 func NewLocalfs(basedir string, logger *log.Logger) *localfs {
@@ -68,7 +96,7 @@ func (l *localfs) makeIndex() {
 	}
 
 	for _, d := range dirs {
-		if !d.Type().IsDir() {
+		if !isSongDir(d) {
 			continue
 		}
 
@@ -90,7 +118,7 @@ func (l *localfs) GetArtists() ([]string, error) {
 	}
 
 	for _, d := range dirs {
-		if !d.Type().IsDir() {
+		if !isSongDir(d) {
 			continue
 		}
 
@@ -108,11 +136,27 @@ func (l *localfs) GetArtists() ([]string, error) {
 
 func (l *localfs) GetSongs(artist, id, query string) ([]SongMeta, error) {
 	songs := []SongMeta{}
-	dirs, err := os.ReadDir(l.basedir)
-	if err != nil {
-		return nil, err
+	var dirs []fs.DirEntry
+	if id != "" {
+		// Only one song can match, so don't scan the whole directory
+		info, err := os.Stat(filepath.Join(l.basedir, id))
+		if errors.Is(err, fs.ErrNotExist) {
+			return songs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		dirs = []fs.DirEntry{fs.FileInfoToDirEntry(info)}
+	} else {
+		var err error
+		dirs, err = os.ReadDir(l.basedir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	var err error
+
 	var queryMatcher *regexp.Regexp
 	if query != "" {
 		queryMatcher, err = regexp.Compile("(?i)" + query) // case insensitive
@@ -122,7 +166,7 @@ func (l *localfs) GetSongs(artist, id, query string) ([]SongMeta, error) {
 	}
 
 	for _, d := range dirs {
-		if !d.Type().IsDir() {
+		if !isSongDir(d) {
 			continue
 		}
 		if id != "" && d.Name() != id {
@@ -149,6 +193,8 @@ func (l *localfs) GetSongs(artist, id, query string) ([]SongMeta, error) {
 }
 
 func (l *localfs) NewSong(meta SongMeta) (SongMeta, error) {
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
 	if !l.checkID(meta.ID) {
 		return SongMeta{}, fmt.Errorf("id %q already in use", meta.ID)
 	}
@@ -221,12 +267,29 @@ func (l *localfs) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
 	return meta, nil
 }
 
+// DeleteSong moves a song to the trash.
 func (l *localfs) DeleteSong(id string) error {
 	dir := filepath.Join(l.basedir, id)
-	err := os.RemoveAll(dir)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	_, err := l.trashSong(id)
+	return err
+}
 
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+// trashSong moves the song's directory to the trash, and returns its new
+// path.
+func (l *localfs) trashSong(id string) (string, error) {
+	trashDir := filepath.Join(l.basedir, trashDirName)
+	err := os.MkdirAll(trashDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format(trashTimeFormat) + "_" + id
+	trashed := filepath.Join(trashDir, name)
+	err = os.Rename(filepath.Join(l.basedir, id), trashed)
+	if err != nil {
+		return "", err
 	}
 
 	// Update search index
@@ -235,14 +298,303 @@ func (l *localfs) DeleteSong(id string) error {
 		l.log.Printf("WARNING error updating index: %v", err)
 	}
 
+	return trashed, nil
+}
+
+const (
+	trashDirName = ".trash"
+	// Trashed songs are stored in directories named "[time]_[id]"
+	trashTimeFormat = "20060102T150405.000000000Z"
+)
+
+// isSongDir returns true if the directory entry contains a song.
+func isSongDir(d fs.DirEntry) bool {
+	return d.IsDir() && !strings.HasPrefix(d.Name(), ".")
+}
+
+// trashEntry is a song directory inside the trash.
+type trashEntry struct {
+	dir       string
+	id        string
+	deletedAt time.Time
+}
+
+// trashEntries returns the songs in the trash, most recently deleted first.
+func (l *localfs) trashEntries() ([]trashEntry, error) {
+	trashDir := filepath.Join(l.basedir, trashDirName)
+	dirs, err := os.ReadDir(trashDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []trashEntry{}
+	for _, d := range dirs {
+		timestamp, id, ok := strings.Cut(d.Name(), "_")
+		if !d.IsDir() || !ok {
+			continue
+		}
+		deletedAt, err := time.Parse(trashTimeFormat, timestamp)
+		if err != nil {
+			l.log.Printf("WARNING ignoring %q in trash: %v", d.Name(), err)
+			continue
+		}
+		entries = append(entries, trashEntry{filepath.Join(trashDir, d.Name()), id, deletedAt})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].deletedAt.After(entries[j].deletedAt)
+	})
+	return entries, nil
+}
+
+func (l *localfs) ListTrash() ([]TrashedSong, error) {
+	entries, err := l.trashEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	songs := []TrashedSong{}
+	for _, e := range entries {
+		meta, err := readMeta(filepath.Join(e.dir, "meta.json"))
+		if err != nil {
+			l.log.Printf("WARNING getting metadata for trashed song %q: %v", e.id, err)
+			meta.ID = e.id
+		}
+		songs = append(songs, TrashedSong{meta, e.deletedAt})
+	}
+	return songs, nil
+}
+
+// RestoreSong moves the most recently deleted song with the given ID out of
+// the trash.
+func (l *localfs) RestoreSong(id string) (SongMeta, error) {
+	entries, err := l.trashEntries()
+	if err != nil {
+		return SongMeta{}, err
+	}
+
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+	for _, e := range entries {
+		if e.id != id {
+			continue
+		}
+		if !l.checkID(id) {
+			return SongMeta{}, fmt.Errorf("id %q already in use", id)
+		}
+		err = os.Rename(e.dir, filepath.Join(l.basedir, id))
+		if err != nil {
+			return SongMeta{}, err
+		}
+
+		meta, err := l.getMeta(id)
+		if err != nil {
+			return SongMeta{}, err
+		}
+		err = l.index.Add(meta)
+		if err != nil {
+			l.log.Printf("WARNING error updating index: %v", err)
+		}
+		return meta, nil
+	}
+	return SongMeta{}, notInTrash(id)
+}
+
+// PurgeSong permanently deletes all songs in the trash with the given ID.
+func (l *localfs) PurgeSong(id string) error {
+	entries, err := l.trashEntries()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, e := range entries {
+		if e.id != id {
+			continue
+		}
+		found = true
+		err = os.RemoveAll(e.dir)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return notInTrash(id)
+	}
 	return nil
 }
 
+// RenameSong renames the song's directory, and updates its metadata. If any
+// step fails, the previous steps are rolled back.
+func (l *localfs) RenameSong(id, newID string) (SongMeta, error) {
+	for _, id := range []string{id, newID} {
+		if err := ValidateID(id); err != nil {
+			return SongMeta{}, err
+		}
+	}
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+	meta, err := l.getMeta(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return SongMeta{}, songNotFound(id)
+	} else if err != nil {
+		return SongMeta{}, err
+	}
+	if !l.checkID(newID) {
+		return SongMeta{}, fmt.Errorf("id %q already in use", newID)
+	}
+	aliases, err := l.readAliases()
+	if err != nil {
+		return SongMeta{}, err
+	}
+
+	oldDir := filepath.Join(l.basedir, id)
+	newDir := filepath.Join(l.basedir, newID)
+	err = os.Rename(oldDir, newDir)
+	if err != nil {
+		return SongMeta{}, err
+	}
+
+	newMeta := meta
+	newMeta.ID = newID
+	err = writeMeta(newDir, newMeta)
+	if err != nil {
+		os.Rename(newDir, oldDir)
+		return SongMeta{}, err
+	}
+
+	aliases.add(id, newID)
+	err = l.writeAliases(aliases)
+	if err != nil {
+		writeMeta(newDir, meta)
+		os.Rename(newDir, oldDir)
+		return SongMeta{}, err
+	}
+
+	// Update search index
+	err = l.index.Remove(id)
+	if err != nil {
+		l.log.Printf("WARNING error updating index: %v", err)
+	}
+	err = l.index.Add(newMeta)
+	if err != nil {
+		l.log.Printf("WARNING error updating index: %v", err)
+	}
+
+	return newMeta, nil
+}
+
+// MergeSongs updates the song's metadata, moves the duplicate to the trash
+// and records its ID as an alias. If any step fails, the previous steps are
+// rolled back.
+func (l *localfs) MergeSongs(id, dupID string) (SongMeta, error) {
+	if id == dupID {
+		return SongMeta{}, fmt.Errorf("cannot merge song %q into itself", id)
+	}
+	for _, id := range []string{id, dupID} {
+		if err := ValidateID(id); err != nil {
+			return SongMeta{}, err
+		}
+	}
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+	meta, err := l.getMeta(id)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("getting song %q: %w", id, err)
+	}
+	dupMeta, err := l.getMeta(dupID)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("getting song %q: %w", dupID, err)
+	}
+	aliases, err := l.readAliases()
+	if err != nil {
+		return SongMeta{}, err
+	}
+
+	merged, err := l.UpdateSong(id, mergeMeta(meta, dupMeta))
+	if err != nil {
+		return SongMeta{}, err
+	}
+	trashed, err := l.trashSong(dupID)
+	if err != nil {
+		l.UpdateSong(id, meta)
+		return SongMeta{}, err
+	}
+	aliases.add(dupID, id)
+	err = l.writeAliases(aliases)
+	if err != nil {
+		if os.Rename(trashed, filepath.Join(l.basedir, dupID)) == nil {
+			l.index.Add(dupMeta)
+		}
+		l.UpdateSong(id, meta)
+		return SongMeta{}, err
+	}
+	return merged, nil
+}
+
+func (l *localfs) ResolveAlias(alias string) (string, error) {
+	l.idMu.Lock()
+	defer l.idMu.Unlock()
+	aliases, err := l.readAliases()
+	if err != nil {
+		return "", err
+	}
+	return aliases[alias], nil
+}
+
+// Aliases are stored as a JSON object, mapping old IDs to current ones.
+const aliasesFileName = "aliases.json"
+
+func (l *localfs) readAliases() (aliasMap, error) {
+	aliases := aliasMap{}
+	data, err := os.ReadFile(filepath.Join(l.basedir, aliasesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &aliases)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+func (l *localfs) writeAliases(aliases aliasMap) error {
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(l.basedir, aliasesFileName), data, os.ModePerm)
+}
+
+// writeMeta writes a song's metadata to meta.json in the given directory.
+func writeMeta(dir string, meta SongMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "meta.json"), data, os.ModePerm)
+}
+
 func (l *localfs) GetChords(id string) (Chords, error) {
 	path := filepath.Join(l.basedir, id, "chords.txt")
 	return os.ReadFile(path)
 }
 
+func (l *localfs) ChordsModTime(id string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(l.basedir, id, "chords.txt"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 func (l *localfs) UpdateChords(id string, chords Chords) (Chords, error) {
 	path := filepath.Join(l.basedir, id, "chords.txt")
 	os.WriteFile(path, chords, os.ModePerm)
@@ -250,34 +602,307 @@ func (l *localfs) UpdateChords(id string, chords Chords) (Chords, error) {
 }
 
 func (l *localfs) SeeAlso(artist string) ([]string, error) {
-	path := filepath.Join(l.basedir, "see-also.json")
-	data, err := os.ReadFile(path)
+	pairs, err := l.readSeeAlso()
+	if err != nil {
+		return nil, err
+	}
+
+	var artists []string
+	for _, pair := range pairs {
+		if pair[0] == artist {
+			artists = append(artists, pair[1])
+		}
+		if pair[1] == artist {
+			artists = append(artists, pair[0])
+		}
+	}
+
+	return artists, nil
+}
+
+func (l *localfs) Relations() ([][2]string, error) {
+	pairs, err := l.readSeeAlso()
+	if err != nil {
+		return nil, err
+	}
+	// The file may have been edited by hand
+	return normaliseRelations(pairs), nil
+}
+
+func (l *localfs) AddRelation(artist1, artist2 string) error {
+	err := checkRelation(l, artist1, artist2)
+	if err != nil {
+		return err
+	}
+	l.seeAlsoMu.Lock()
+	defer l.seeAlsoMu.Unlock()
+	pairs, err := l.readSeeAlso()
+	if err != nil {
+		return err
+	}
+
+	rel := relation(artist1, artist2)
+	for _, pair := range pairs {
+		if relation(pair[0], pair[1]) == rel {
+			// Already related
+			return nil
+		}
+	}
+	return l.writeSeeAlso(append(pairs, rel))
+}
+
+func (l *localfs) RemoveRelation(artist1, artist2 string) error {
+	l.seeAlsoMu.Lock()
+	defer l.seeAlsoMu.Unlock()
+	pairs, err := l.readSeeAlso()
+	if err != nil {
+		return err
+	}
+
+	rel := relation(artist1, artist2)
+	remaining := [][2]string{}
+	for _, pair := range pairs {
+		if relation(pair[0], pair[1]) != rel {
+			remaining = append(remaining, pair)
+		}
+	}
+	if len(remaining) == len(pairs) {
+		return notRelated(artist1, artist2)
+	}
+	return l.writeSeeAlso(remaining)
+}
+
+// See-also data is stored as a JSON list of related pairs of artists.
+const seeAlsoFileName = "see-also.json"
+
+func (l *localfs) readSeeAlso() ([][2]string, error) {
+	data, err := os.ReadFile(filepath.Join(l.basedir, seeAlsoFileName))
 	if errors.Is(err, os.ErrNotExist) {
 		// File doesn't exist - no see also data to report
-		l.log.Printf("WARNING see-also.json not found")
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	seeAlsos := &[][]string{}
-	err = json.Unmarshal(data, seeAlsos)
+	pairs := [][2]string{}
+	err = json.Unmarshal(data, &pairs)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't unmarshal see also data: %w", err)
 	}
+	return pairs, nil
+}
 
-	var artists []string
-	for _, grp := range *seeAlsos {
-		if grp[0] == artist {
-			artists = append(artists, grp[1])
+func (l *localfs) writeSeeAlso(pairs [][2]string) error {
+	for i, pair := range pairs {
+		pairs[i] = relation(pair[0], pair[1])
+	}
+	sortRelations(pairs)
+
+	data, err := json.MarshalIndent(pairs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(l.basedir, seeAlsoFileName), data, os.ModePerm)
+}
+
+// The catalogue is stored in artists.json and albums.json.
+const (
+	artistsFileName = "artists.json"
+	albumsFileName  = "albums.json"
+)
+
+func (l *localfs) GetCatalogue() (CatalogueData, error) {
+	c := CatalogueData{}
+	for name, field := range map[string]*json.RawMessage{
+		artistsFileName: &c.Artists,
+		albumsFileName:  &c.Albums,
+	} {
+		data, err := os.ReadFile(filepath.Join(l.basedir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
 		}
-		if grp[1] == artist {
-			artists = append(artists, grp[0])
+		if err != nil {
+			return CatalogueData{}, err
 		}
+		*field = data
 	}
+	return c, nil
+}
 
-	return artists, nil
+func (l *localfs) SetCatalogue(c CatalogueData) error {
+	for name, data := range map[string]json.RawMessage{
+		artistsFileName: c.Artists,
+		albumsFileName:  c.Albums,
+	} {
+		if len(data) == 0 {
+			data = json.RawMessage("[]")
+		}
+		// Keep the files readable, as they may be edited by hand
+		buf := &bytes.Buffer{}
+		if err := json.Indent(buf, data, "", "  "); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(l.basedir, name), buf.Bytes(), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Each suggestion is stored as a JSON file in the suggestions directory.
+const suggestionsDirName = ".suggestions"
+
+func (l *localfs) suggestionPath(id string) string {
+	return filepath.Join(l.basedir, suggestionsDirName, id+".json")
+}
+
+func (l *localfs) AddSuggestion(s Suggestion) error {
+	err := os.MkdirAll(filepath.Join(l.basedir, suggestionsDirName), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return l.writeSuggestion(s)
+}
+
+func (l *localfs) writeSuggestion(s Suggestion) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.suggestionPath(s.ID), data, os.ModePerm)
+}
+
+func (l *localfs) ListSuggestions(status SuggestionStatus) ([]Suggestion, error) {
+	files, err := os.ReadDir(filepath.Join(l.basedir, suggestionsDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Suggestion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []Suggestion{}
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		s, err := l.GetSuggestion(id)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" || s.Status == status {
+			suggestions = append(suggestions, s)
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Created.Before(suggestions[j].Created)
+	})
+	return suggestions, nil
+}
+
+func (l *localfs) GetSuggestion(id string) (Suggestion, error) {
+	if ValidateID(id) != nil {
+		return Suggestion{}, suggestionNotFound(id)
+	}
+	data, err := os.ReadFile(l.suggestionPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Suggestion{}, suggestionNotFound(id)
+	}
+	if err != nil {
+		return Suggestion{}, err
+	}
+	s := Suggestion{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return Suggestion{}, fmt.Errorf("couldn't unmarshal suggestion %s: %w", id, err)
+	}
+	return s, nil
+}
+
+func (l *localfs) UpdateSuggestion(s Suggestion) error {
+	if _, err := l.GetSuggestion(s.ID); err != nil {
+		return err
+	}
+	return l.writeSuggestion(s)
+}
+
+// The audit log is stored as JSON Lines, one entry per line, oldest first.
+const auditFileName = ".audit.jsonl"
+
+func (l *localfs) AppendAudit(e AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.auditMu.Lock()
+	defer l.auditMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(l.basedir, auditFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Entries can be any size, so a single write isn't guaranteed to be
+	// atomic. auditMu stops other appends from this process interleaving.
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *localfs) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	l.auditMu.Lock()
+	defer l.auditMu.Unlock()
+	file, err := os.Open(filepath.Join(l.basedir, auditFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	all := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	// Entries include diffs, so lines can be long
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		e := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", auditFileName, line, err)
+		}
+		all = append(all, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filterAudit(all, f), nil
+}
+
+// Ping checks that the base directory can be read, and that the search
+// index was built.
+func (l *localfs) Ping() error {
+	info, err := os.Stat(l.basedir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", l.basedir)
+	}
+	if l.index == nil {
+		return errors.New("search index was not built")
+	}
+	return nil
+}
+
+// Close closes the search index. Every write has already been made to the
+// filesystem.
+func (l *localfs) Close() error {
+	if l.index == nil {
+		return nil
+	}
+	return l.index.Close()
 }
 
 func (l *localfs) Search(query string) ([]types.SearchResult, error) {
@@ -309,11 +934,15 @@ func (l *localfs) Search(query string) ([]types.SearchResult, error) {
 }
 
 func (l *localfs) getMeta(id string) (meta types.SongMeta, err error) {
-	metaPath := filepath.Join(l.basedir, id, "meta.json")
-	file, err := os.Open(metaPath)
+	return readMeta(filepath.Join(l.basedir, id, "meta.json"))
+}
+
+func readMeta(path string) (meta types.SongMeta, err error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return meta, err
 	}
+	defer file.Close()
 
 	err = json.NewDecoder(file).Decode(&meta)
 	return meta, err
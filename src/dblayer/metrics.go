@@ -0,0 +1,186 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/metrics.go
+// Records how long each database operation takes, and how many fail, for
+// the /metrics endpoint.
+
+package dblayer
+
+import (
+	"time"
+
+	"github.com/barrettj12/chords/src/metrics"
+	"github.com/barrettj12/chords/src/types"
+)
+
+var (
+	dbDuration = metrics.NewHistogram("chords_db_operation_duration_seconds",
+		"Time taken by database operations.", metrics.DefaultBuckets, "backend", "method")
+	dbErrors = metrics.NewCounter("chords_db_operation_errors_total",
+		"Database operations which returned an error.", "backend", "method")
+)
+
+// WithMetrics wraps db so that the duration and errors of each operation are
+// recorded, labelled with the given backend name.
+func WithMetrics(db ChordsDB, backend string) ChordsDB {
+	return &metricsDB{db, backend}
+}
+
+type metricsDB struct {
+	ChordsDB
+	backend string
+}
+
+// observe records an operation which started at start. It's used as
+//
+//	defer m.observe("Method", time.Now(), &err)
+func (m *metricsDB) observe(method string, start time.Time, err *error) {
+	dbDuration.ObserveSince(start, m.backend, method)
+	if *err != nil {
+		dbErrors.Inc(m.backend, method)
+	}
+}
+
+func (m *metricsDB) GetArtists() (_ []string, err error) {
+	defer m.observe("GetArtists", time.Now(), &err)
+	return m.ChordsDB.GetArtists()
+}
+
+func (m *metricsDB) GetSongs(artist, id, query string) (_ []SongMeta, err error) {
+	defer m.observe("GetSongs", time.Now(), &err)
+	return m.ChordsDB.GetSongs(artist, id, query)
+}
+
+func (m *metricsDB) NewSong(meta SongMeta) (_ SongMeta, err error) {
+	defer m.observe("NewSong", time.Now(), &err)
+	return m.ChordsDB.NewSong(meta)
+}
+
+func (m *metricsDB) UpdateSong(id string, meta SongMeta) (_ SongMeta, err error) {
+	defer m.observe("UpdateSong", time.Now(), &err)
+	return m.ChordsDB.UpdateSong(id, meta)
+}
+
+func (m *metricsDB) DeleteSong(id string) (err error) {
+	defer m.observe("DeleteSong", time.Now(), &err)
+	return m.ChordsDB.DeleteSong(id)
+}
+
+func (m *metricsDB) GetChords(id string) (_ Chords, err error) {
+	defer m.observe("GetChords", time.Now(), &err)
+	return m.ChordsDB.GetChords(id)
+}
+
+func (m *metricsDB) ChordsModTime(id string) (_ time.Time, err error) {
+	defer m.observe("ChordsModTime", time.Now(), &err)
+	return m.ChordsDB.ChordsModTime(id)
+}
+
+func (m *metricsDB) UpdateChords(id string, chords Chords) (_ Chords, err error) {
+	defer m.observe("UpdateChords", time.Now(), &err)
+	return m.ChordsDB.UpdateChords(id, chords)
+}
+
+func (m *metricsDB) SeeAlso(artist string) (_ []string, err error) {
+	defer m.observe("SeeAlso", time.Now(), &err)
+	return m.ChordsDB.SeeAlso(artist)
+}
+
+func (m *metricsDB) GetCatalogue() (_ CatalogueData, err error) {
+	defer m.observe("GetCatalogue", time.Now(), &err)
+	return m.ChordsDB.GetCatalogue()
+}
+
+func (m *metricsDB) SetCatalogue(c CatalogueData) (err error) {
+	defer m.observe("SetCatalogue", time.Now(), &err)
+	return m.ChordsDB.SetCatalogue(c)
+}
+
+func (m *metricsDB) Search(query string) (_ []types.SearchResult, err error) {
+	defer m.observe("Search", time.Now(), &err)
+	return m.ChordsDB.Search(query)
+}
+
+func (m *metricsDB) ListTrash() (_ []TrashedSong, err error) {
+	defer m.observe("ListTrash", time.Now(), &err)
+	return m.ChordsDB.ListTrash()
+}
+
+func (m *metricsDB) RestoreSong(id string) (_ SongMeta, err error) {
+	defer m.observe("RestoreSong", time.Now(), &err)
+	return m.ChordsDB.RestoreSong(id)
+}
+
+func (m *metricsDB) PurgeSong(id string) (err error) {
+	defer m.observe("PurgeSong", time.Now(), &err)
+	return m.ChordsDB.PurgeSong(id)
+}
+
+func (m *metricsDB) RenameSong(id, newID string) (_ SongMeta, err error) {
+	defer m.observe("RenameSong", time.Now(), &err)
+	return m.ChordsDB.RenameSong(id, newID)
+}
+
+func (m *metricsDB) MergeSongs(id, dupID string) (_ SongMeta, err error) {
+	defer m.observe("MergeSongs", time.Now(), &err)
+	return m.ChordsDB.MergeSongs(id, dupID)
+}
+
+func (m *metricsDB) ResolveAlias(alias string) (_ string, err error) {
+	defer m.observe("ResolveAlias", time.Now(), &err)
+	return m.ChordsDB.ResolveAlias(alias)
+}
+
+func (m *metricsDB) Relations() (_ [][2]string, err error) {
+	defer m.observe("Relations", time.Now(), &err)
+	return m.ChordsDB.Relations()
+}
+
+func (m *metricsDB) AddRelation(artist1, artist2 string) (err error) {
+	defer m.observe("AddRelation", time.Now(), &err)
+	return m.ChordsDB.AddRelation(artist1, artist2)
+}
+
+func (m *metricsDB) RemoveRelation(artist1, artist2 string) (err error) {
+	defer m.observe("RemoveRelation", time.Now(), &err)
+	return m.ChordsDB.RemoveRelation(artist1, artist2)
+}
+
+func (m *metricsDB) AddSuggestion(s Suggestion) (err error) {
+	defer m.observe("AddSuggestion", time.Now(), &err)
+	return m.ChordsDB.AddSuggestion(s)
+}
+
+func (m *metricsDB) ListSuggestions(status SuggestionStatus) (_ []Suggestion, err error) {
+	defer m.observe("ListSuggestions", time.Now(), &err)
+	return m.ChordsDB.ListSuggestions(status)
+}
+
+func (m *metricsDB) GetSuggestion(id string) (_ Suggestion, err error) {
+	defer m.observe("GetSuggestion", time.Now(), &err)
+	return m.ChordsDB.GetSuggestion(id)
+}
+
+func (m *metricsDB) UpdateSuggestion(s Suggestion) (err error) {
+	defer m.observe("UpdateSuggestion", time.Now(), &err)
+	return m.ChordsDB.UpdateSuggestion(s)
+}
+
+func (m *metricsDB) AppendAudit(e AuditEntry) (err error) {
+	defer m.observe("AppendAudit", time.Now(), &err)
+	return m.ChordsDB.AppendAudit(e)
+}
+
+func (m *metricsDB) ListAudit(f AuditFilter) (_ []AuditEntry, err error) {
+	defer m.observe("ListAudit", time.Now(), &err)
+	return m.ChordsDB.ListAudit(f)
+}
+
+func (m *metricsDB) Ping() (err error) {
+	defer m.observe("Ping", time.Now(), &err)
+	return m.ChordsDB.Ping()
+}
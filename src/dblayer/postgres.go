@@ -4,26 +4,35 @@
 //     https://github.com/barrettj12
 // Licensed under the GNU AGPLv3.
 
-// backend/dblayer/postgres.go
+// src/dblayer/postgres.go
 // A wrapper for a Postgres database.
 
 package dblayer
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/barrettj12/chords/src/types"
+	"log"
+	"regexp"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/barrettj12/chords/src/search"
+	"github.com/barrettj12/chords/src/types"
+	"github.com/lib/pq"
 )
 
 // postgres represents a postgres database.
 type postgres struct {
-	db *sql.DB
+	db  *sql.DB
+	log *log.Logger
+	// Index for text search
+	index *search.Index
 }
 
 // NewPostgres creates and initialises a Postgres DB at the given URL.
-func NewPostgres(url string) (*postgres, error) {
+func NewPostgres(url string, logger *log.Logger) (*postgres, error) {
 	db, err := sql.Open("postgres", url)
 	if err != nil {
 		return nil, err
@@ -34,33 +43,139 @@ func NewPostgres(url string) (*postgres, error) {
 		return nil, err
 	}
 
-	// Ensure table `chords` exists
-	// TODO
 	err = initDB(db)
 	if err != nil {
 		return nil, err
 	}
 
-	return &postgres{db}, nil
+	p := &postgres{db: db, log: logger}
+	err = p.makeIndex()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// makeIndex creates a search.Index and initialises it with all the songs
+// already in the database.
+func (p *postgres) makeIndex() error {
+	index, err := search.NewIndex()
+	if err != nil {
+		return fmt.Errorf("creating search index: %w", err)
+	}
+	p.index = index
+
+	songs, err := p.GetSongs("", "", "")
+	if err != nil {
+		return fmt.Errorf("creating search index: %w", err)
+	}
+	for _, meta := range songs {
+		p.indexSong(meta)
+	}
+	return nil
 }
 
-// initDB creates the required tables in the given database.
+// indexSong adds or updates a song in the search index.
+func (p *postgres) indexSong(meta SongMeta) {
+	err := p.index.Remove(meta.ID)
+	if err == nil {
+		err = p.index.Add(meta)
+	}
+	if err != nil {
+		p.log.Printf("WARNING error updating index: %v", err)
+	}
+}
+
+// unindexSong removes a song from the search index.
+func (p *postgres) unindexSong(id string) {
+	err := p.index.Remove(id)
+	if err != nil {
+		p.log.Printf("WARNING error updating index: %v", err)
+	}
+}
+
+// initDB creates the required tables in the given database, and migrates
+// tables created by older versions.
+//
+// Songs are identified by song_id, the same text ID used by the other
+// databases. The numeric id column is only a row ID: a song in the trash keeps
+// its song_id, so there can be several rows with the same song_id, but only
+// one of them can be outside the trash.
 func initDB(db *sql.DB) error {
 	_, err := db.Exec(`
-CREATE TABLE chords (
-	id       SERIAL PRIMARY KEY,
-	artist   TEXT,
-	album    TEXT,
-	song     TEXT,
-	data     TEXT
+CREATE TABLE IF NOT EXISTS chords (
+	id         SERIAL PRIMARY KEY,
+	song_id    TEXT NOT NULL,
+	artist     TEXT,
+	album      TEXT,
+	song       TEXT,
+	track_num  INTEGER NOT NULL DEFAULT 0,
+	song_key   TEXT NOT NULL DEFAULT '',
+	tags       TEXT[] NOT NULL DEFAULT '{}',
+	artist_id  TEXT NOT NULL DEFAULT '',
+	album_id   TEXT NOT NULL DEFAULT '',
+	data       TEXT,
+	modified   TIMESTAMPTZ,
+	deleted_at TIMESTAMPTZ
+);
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS track_num INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS song_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS artist_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS album_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS modified TIMESTAMPTZ;
+ALTER TABLE chords ADD COLUMN IF NOT EXISTS song_id TEXT;
+UPDATE chords SET song_id = id::text WHERE song_id IS NULL;
+ALTER TABLE chords ALTER COLUMN song_id SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS chords_song_id ON chords (song_id) WHERE deleted_at IS NULL;
+CREATE TABLE IF NOT EXISTS aliases (
+	alias TEXT PRIMARY KEY,
+	id    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS see_also (
+	artist1 TEXT NOT NULL,
+	artist2 TEXT NOT NULL,
+	PRIMARY KEY (artist1, artist2),
+	CHECK (artist1 < artist2)
+);
+CREATE TABLE IF NOT EXISTS suggestions (
+	id          TEXT PRIMARY KEY,
+	song_id     TEXT NOT NULL,
+	chords      TEXT NOT NULL,
+	base        TEXT NOT NULL,
+	diff        TEXT NOT NULL,
+	comment     TEXT NOT NULL DEFAULT '',
+	author      TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL,
+	created     TIMESTAMPTZ NOT NULL,
+	reviewed_by TEXT NOT NULL DEFAULT '',
+	reviewed_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS catalogue (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         BIGSERIAL PRIMARY KEY,
+	time       TIMESTAMPTZ NOT NULL,
+	actor      TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	action     TEXT NOT NULL,
+	target     TEXT NOT NULL,
+	other      TEXT NOT NULL DEFAULT '',
+	before     TEXT NOT NULL DEFAULT '',
+	after      TEXT NOT NULL DEFAULT '',
+	diff       TEXT NOT NULL DEFAULT ''
 );`)
 	return err
 }
 
 func (p *postgres) GetArtists() ([]string, error) {
 	rows, err := p.db.Query(`
-SELECT DISTINCT artist
+SELECT DISTINCT COALESCE(artist, '')
 FROM chords
+WHERE deleted_at IS NULL
 `)
 	if err != nil {
 		return nil, fmt.Errorf("Postgres.GetArtists: %w", err)
@@ -71,67 +186,709 @@ FROM chords
 	artists := []string{}
 	for rows.Next() {
 		var artist string
-		rows.Scan(&artist)
+		err = rows.Scan(&artist)
 		if err != nil {
 			return nil, fmt.Errorf("Postgres.GetArtists: %w", err)
 		}
 		artists = append(artists, artist)
 	}
 
-	return artists, nil
+	return artists, rows.Err()
+}
+
+// songColumns are the columns holding a song's metadata, in the order
+// scanned by scanSong. Columns from old versions of the table may be NULL.
+const songColumns = `song_id, COALESCE(song, ''), COALESCE(artist, ''), COALESCE(album, ''),
+	track_num, song_key, tags, artist_id, album_id`
+
+// scanSong scans a row with the columns in songColumns, followed by extra.
+func scanSong(row interface{ Scan(...any) error }, extra ...any) (SongMeta, error) {
+	var meta SongMeta
+	var tags pq.StringArray
+	dest := []any{&meta.ID, &meta.Name, &meta.Artist, &meta.Album,
+		&meta.TrackNum, &meta.Key, &tags, &meta.ArtistID, &meta.AlbumID}
+	err := row.Scan(append(dest, extra...)...)
+	if len(tags) > 0 {
+		meta.Tags = tags
+	}
+	return meta, err
 }
 
+// tagsArray converts tags for the tags column, which can't be NULL.
+func tagsArray(tags []string) pq.StringArray {
+	return append(pq.StringArray{}, tags...)
+}
+
+// GetSongs returns the songs by the given artist, with the given ID, and
+// whose name matches the query (a case-insensitive regular expression). Empty
+// parameters match every song.
 func (p *postgres) GetSongs(artist, id, query string) ([]SongMeta, error) {
-	// TODO: fill this in
-	return nil, nil
+	if _, err := regexp.Compile(query); err != nil {
+		p.log.Printf("WARNING ignoring query %q: %v", query, err)
+		query = ""
+	}
+	rows, err := p.db.Query(`
+SELECT `+songColumns+`
+FROM chords
+WHERE deleted_at IS NULL
+	AND ($1 = '' OR artist = $1)
+	AND ($2 = '' OR song_id = $2)
+	AND ($3 = '' OR song ~* $3)
+ORDER BY song_id;`,
+		artist, id, query)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.GetSongs: %w", err)
+	}
+	defer rows.Close()
+
+	songs := []SongMeta{}
+	for rows.Next() {
+		meta, err := scanSong(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.GetSongs: %w", err)
+		}
+		songs = append(songs, meta)
+	}
+	return songs, rows.Err()
 }
 
-func (p *postgres) NewSong(SongMeta) (SongMeta, error) {
-	// TODO: fill this in
-	return SongMeta{}, nil
-	// 	res, err := p.db.Exec(`
-	// INSERT INTO	chords
-	// VALUES (DEFAULT, $1, $2, $3, $4);`,
-	// 		nc.Artist, nc.Album, nc.Song, nc.Chords)
-	// 	if err != nil {
-	// 		return 0, fmt.Errorf("Postgres.MakeChords: %w", err)
-	// 	}
-	// 	id, err := res.RowsAffected()
-	// 	return int(id), nil
+func (p *postgres) NewSong(meta SongMeta) (SongMeta, error) {
+	err := ValidateID(meta.ID)
+	if err != nil {
+		return SongMeta{}, err
+	}
+	res, err := p.db.Exec(`
+INSERT INTO chords (song_id, song, artist, album, track_num, song_key, tags, artist_id, album_id, data, modified)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, '', now())
+ON CONFLICT (song_id) WHERE deleted_at IS NULL DO NOTHING;`,
+		meta.ID, meta.Name, meta.Artist, meta.Album, meta.TrackNum, meta.Key,
+		tagsArray(meta.Tags), meta.ArtistID, meta.AlbumID)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.NewSong: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return SongMeta{}, fmt.Errorf("id %q already in use", meta.ID)
+	}
+
+	p.indexSong(meta)
+	return meta, nil
 }
 
 func (p *postgres) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
-	// TODO: fill this in
-	return SongMeta{}, nil
+	meta, err := updateSong(p.db, id, meta)
+	if err != nil {
+		return SongMeta{}, err
+	}
+	p.indexSong(meta)
+	return meta, nil
+}
+
+// updateSong writes the metadata for the song with the given ID, using db or
+// a transaction.
+func updateSong(db interface {
+	Exec(string, ...any) (sql.Result, error)
+}, id string, meta SongMeta) (SongMeta, error) {
+	meta.ID = id
+	res, err := db.Exec(`
+UPDATE chords
+SET song = $2, artist = $3, album = $4, track_num = $5, song_key = $6, tags = $7,
+	artist_id = $8, album_id = $9
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		id, meta.Name, meta.Artist, meta.Album, meta.TrackNum, meta.Key,
+		tagsArray(meta.Tags), meta.ArtistID, meta.AlbumID)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.UpdateSong: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return SongMeta{}, songNotFound(id)
+	}
+	return meta, nil
 }
 
+// DeleteSong soft-deletes a song, by setting its deleted_at time.
 func (p *postgres) DeleteSong(id string) error {
-	// TODO: fill this in
+	_, err := p.db.Exec(`
+UPDATE chords
+SET deleted_at = now()
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		id)
+	if err != nil {
+		return fmt.Errorf("Postgres.DeleteSong: %w", err)
+	}
+	p.unindexSong(id)
+	return nil
+}
+
+func (p *postgres) ListTrash() ([]TrashedSong, error) {
+	rows, err := p.db.Query(`
+SELECT ` + songColumns + `, deleted_at
+FROM chords
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;`)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.ListTrash: %w", err)
+	}
+	defer rows.Close()
+
+	songs := []TrashedSong{}
+	for rows.Next() {
+		var s TrashedSong
+		s.SongMeta, err = scanSong(rows, &s.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.ListTrash: %w", err)
+		}
+		songs = append(songs, s)
+	}
+	return songs, rows.Err()
+}
+
+// RestoreSong restores the most recently deleted song with the given ID.
+func (p *postgres) RestoreSong(id string) (SongMeta, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RestoreSong: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = checkIDFree(tx, id)
+	if err != nil {
+		return SongMeta{}, err
+	}
+	meta, err := scanSong(tx.QueryRow(`
+UPDATE chords
+SET deleted_at = NULL
+WHERE id = (
+	SELECT id FROM chords
+	WHERE song_id = $1 AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	LIMIT 1
+)
+RETURNING `+songColumns+`;`,
+		id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return SongMeta{}, notInTrash(id)
+	}
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RestoreSong: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RestoreSong: %w", err)
+	}
+	p.indexSong(meta)
+	return meta, nil
+}
+
+// checkIDFree returns an error if a song outside the trash has the given ID.
+func checkIDFree(tx *sql.Tx, id string) error {
+	var taken bool
+	err := tx.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM chords WHERE song_id = $1 AND deleted_at IS NULL);`,
+		id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("id %q already in use", id)
+	}
 	return nil
 }
 
+// PurgeSong permanently deletes all songs in the trash with the given ID.
+func (p *postgres) PurgeSong(id string) error {
+	res, err := p.db.Exec(`
+DELETE FROM chords
+WHERE song_id = $1 AND deleted_at IS NOT NULL;`,
+		id)
+	if err != nil {
+		return fmt.Errorf("Postgres.PurgeSong: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return notInTrash(id)
+	}
+	return nil
+}
+
+// RenameSong changes a song's ID and records the alias in a single
+// transaction.
+func (p *postgres) RenameSong(id, newID string) (SongMeta, error) {
+	err := ValidateID(newID)
+	if err != nil {
+		return SongMeta{}, err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RenameSong: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = checkIDFree(tx, newID)
+	if err != nil {
+		return SongMeta{}, err
+	}
+	meta, err := scanSong(tx.QueryRow(`
+UPDATE chords
+SET song_id = $2
+WHERE song_id = $1 AND deleted_at IS NULL
+RETURNING `+songColumns+`;`,
+		id, newID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return SongMeta{}, songNotFound(id)
+	}
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RenameSong: %w", err)
+	}
+
+	err = addAlias(tx, id, newID)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RenameSong: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.RenameSong: %w", err)
+	}
+	p.unindexSong(id)
+	p.indexSong(meta)
+	return meta, nil
+}
+
+// MergeSongs merges the metadata of two songs (see mergeMeta), moves the
+// duplicate to the trash and records its ID as an alias, in a single
+// transaction.
+func (p *postgres) MergeSongs(id, dupID string) (SongMeta, error) {
+	if id == dupID {
+		return SongMeta{}, fmt.Errorf("cannot merge song %q into itself", id)
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.MergeSongs: %w", err)
+	}
+	defer tx.Rollback()
+
+	metas := map[string]SongMeta{}
+	for _, id := range []string{id, dupID} {
+		meta, err := scanSong(tx.QueryRow(`
+SELECT `+songColumns+`
+FROM chords
+WHERE song_id = $1 AND deleted_at IS NULL
+FOR UPDATE;`,
+			id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return SongMeta{}, songNotFound(id)
+		}
+		if err != nil {
+			return SongMeta{}, fmt.Errorf("Postgres.MergeSongs: %w", err)
+		}
+		metas[id] = meta
+	}
+
+	merged, err := updateSong(tx, id, mergeMeta(metas[id], metas[dupID]))
+	if err != nil {
+		return SongMeta{}, err
+	}
+	_, err = tx.Exec(`
+UPDATE chords
+SET deleted_at = now()
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		dupID)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.MergeSongs: %w", err)
+	}
+
+	err = addAlias(tx, dupID, id)
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.MergeSongs: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return SongMeta{}, fmt.Errorf("Postgres.MergeSongs: %w", err)
+	}
+	p.unindexSong(dupID)
+	p.indexSong(merged)
+	return merged, nil
+}
+
+// addAlias records that alias now refers to the song with the given ID.
+// See aliasMap.add.
+func addAlias(tx *sql.Tx, alias, id string) error {
+	_, err := tx.Exec(`UPDATE aliases SET id = $2 WHERE id = $1;`, alias, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM aliases WHERE alias = $1;`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+INSERT INTO aliases (alias, id) VALUES ($1, $2)
+ON CONFLICT (alias) DO UPDATE SET id = EXCLUDED.id;`,
+		alias, id)
+	return err
+}
+
+func (p *postgres) ResolveAlias(alias string) (string, error) {
+	var id string
+	err := p.db.QueryRow(`
+SELECT id
+FROM aliases
+WHERE alias = $1;`,
+		alias).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Postgres.ResolveAlias: %w", err)
+	}
+	return id, nil
+}
+
 func (p *postgres) GetChords(id string) (Chords, error) {
-	// TODO: fill this in
-	return Chords{}, nil
+	var chords string
+	err := p.db.QueryRow(`
+SELECT COALESCE(data, '')
+FROM chords
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		id).Scan(&chords)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chords{}, songNotFound(id)
+	}
+	if err != nil {
+		return Chords{}, fmt.Errorf("Postgres.GetChords: %w", err)
+	}
+	return Chords(chords), nil
+}
+
+// ChordsModTime returns when the chords were last changed. It's zero for
+// songs stored before this was recorded.
+func (p *postgres) ChordsModTime(id string) (time.Time, error) {
+	var modified sql.NullTime
+	err := p.db.QueryRow(`
+SELECT modified
+FROM chords
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		id).Scan(&modified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, songNotFound(id)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Postgres.ChordsModTime: %w", err)
+	}
+	return modified.Time, nil
 }
 
 func (p *postgres) UpdateChords(id string, chords Chords) (Chords, error) {
-	// TODO: fill this in
-	return Chords{}, nil
-	// 	_, err := p.db.Exec(`
-	// UPDATE chords
-	// SET data = $1
-	// WHERE id = $2;`,
-	// 		string(data), id)
-	// 	return err
+	res, err := p.db.Exec(`
+UPDATE chords
+SET data = $2, modified = now()
+WHERE song_id = $1 AND deleted_at IS NULL;`,
+		id, string(chords))
+	if err != nil {
+		return Chords{}, fmt.Errorf("Postgres.UpdateChords: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return Chords{}, songNotFound(id)
+	}
+	return chords, nil
 }
 
 func (p *postgres) SeeAlso(artist string) ([]string, error) {
-	// TODO: fill this in
-	return nil, nil
+	rows, err := p.db.Query(`
+SELECT artist2 FROM see_also WHERE artist1 = $1
+UNION
+SELECT artist1 FROM see_also WHERE artist2 = $1
+ORDER BY 1;`,
+		artist)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.SeeAlso: %w", err)
+	}
+	defer rows.Close()
+
+	artists := []string{}
+	for rows.Next() {
+		var related string
+		err = rows.Scan(&related)
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.SeeAlso: %w", err)
+		}
+		artists = append(artists, related)
+	}
+	return artists, rows.Err()
+}
+
+func (p *postgres) Relations() ([][2]string, error) {
+	rows, err := p.db.Query(`
+SELECT artist1, artist2
+FROM see_also
+ORDER BY artist1 COLLATE "C", artist2 COLLATE "C";`)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.Relations: %w", err)
+	}
+	defer rows.Close()
+
+	pairs := [][2]string{}
+	for rows.Next() {
+		var pair [2]string
+		err = rows.Scan(&pair[0], &pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.Relations: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+// The artist and album records are stored as JSON documents in the
+// catalogue table, named "artists" and "albums".
+func (p *postgres) GetCatalogue() (CatalogueData, error) {
+	rows, err := p.db.Query(`SELECT name, data FROM catalogue;`)
+	if err != nil {
+		return CatalogueData{}, fmt.Errorf("Postgres.GetCatalogue: %w", err)
+	}
+	defer rows.Close()
+
+	c := CatalogueData{}
+	for rows.Next() {
+		var name, data string
+		if err := rows.Scan(&name, &data); err != nil {
+			return CatalogueData{}, fmt.Errorf("Postgres.GetCatalogue: %w", err)
+		}
+		switch name {
+		case "artists":
+			c.Artists = json.RawMessage(data)
+		case "albums":
+			c.Albums = json.RawMessage(data)
+		}
+	}
+	return c, rows.Err()
+}
+
+func (p *postgres) SetCatalogue(c CatalogueData) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Postgres.SetCatalogue: %w", err)
+	}
+	defer tx.Rollback()
+	for name, data := range map[string]json.RawMessage{"artists": c.Artists, "albums": c.Albums} {
+		if len(data) == 0 {
+			data = json.RawMessage("[]")
+		}
+		_, err := tx.Exec(`
+INSERT INTO catalogue (name, data) VALUES ($1, $2)
+ON CONFLICT (name) DO UPDATE SET data = EXCLUDED.data;`,
+			name, string(data))
+		if err != nil {
+			return fmt.Errorf("Postgres.SetCatalogue: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Postgres.SetCatalogue: %w", err)
+	}
+	return nil
+}
+
+// AddRelation records that two artists are related. Relations are stored
+// once, with the artists in sorted order.
+func (p *postgres) AddRelation(artist1, artist2 string) error {
+	err := checkRelation(p, artist1, artist2)
+	if err != nil {
+		return err
+	}
+
+	rel := relation(artist1, artist2)
+	_, err = p.db.Exec(`
+INSERT INTO see_also (artist1, artist2) VALUES ($1, $2)
+ON CONFLICT DO NOTHING;`,
+		rel[0], rel[1])
+	if err != nil {
+		return fmt.Errorf("Postgres.AddRelation: %w", err)
+	}
+	return nil
+}
+
+func (p *postgres) RemoveRelation(artist1, artist2 string) error {
+	rel := relation(artist1, artist2)
+	res, err := p.db.Exec(`
+DELETE FROM see_also
+WHERE artist1 = $1 AND artist2 = $2;`,
+		rel[0], rel[1])
+	if err != nil {
+		return fmt.Errorf("Postgres.RemoveRelation: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return notRelated(artist1, artist2)
+	}
+	return nil
 }
 
 func (p *postgres) Search(query string) ([]types.SearchResult, error) {
-	// TODO: fill this in
-	return nil, nil
+	rawResults, err := p.index.Search(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []types.SearchResult{}
+	for _, res := range rawResults {
+		if res.Type == "song" {
+			// Fill in song metadata
+			songs, err := p.GetSongs("", res.ID, "")
+			if err != nil {
+				return nil, err
+			}
+			if len(songs) == 0 {
+				continue
+			}
+			res.Meta = &songs[0]
+		}
+		results = append(results, res)
+
+		// Limit to first 10 results
+		if len(results) >= 10 {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (p *postgres) AppendAudit(e AuditEntry) error {
+	_, err := p.db.Exec(`
+INSERT INTO audit_log (time, actor, request_id, action, target, other, before, after, diff)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		e.Time, e.Actor, e.RequestID, e.Action, e.Target, e.Other, e.Before, e.After, e.Diff)
+	if err != nil {
+		return fmt.Errorf("Postgres.AppendAudit: %w", err)
+	}
+	return nil
+}
+
+func (p *postgres) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	var since, until sql.NullTime
+	if !f.Since.IsZero() {
+		since = sql.NullTime{Time: f.Since, Valid: true}
+	}
+	if !f.Until.IsZero() {
+		until = sql.NullTime{Time: f.Until, Valid: true}
+	}
+	var limit sql.NullInt64
+	if f.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(f.Limit), Valid: true}
+	}
+	rows, err := p.db.Query(`
+SELECT time, actor, request_id, action, target, other, before, after, diff
+FROM audit_log
+WHERE ($1 = '' OR actor = $1)
+	AND ($2 = '' OR target = $2 OR other = $2)
+	AND ($3 = '' OR action = $3)
+	AND ($4::timestamptz IS NULL OR time >= $4)
+	AND ($5::timestamptz IS NULL OR time < $5)
+ORDER BY id DESC
+LIMIT $6;`,
+		f.Actor, f.Target, f.Action, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.ListAudit: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.Time, &e.Actor, &e.RequestID, &e.Action, &e.Target, &e.Other,
+			&e.Before, &e.After, &e.Diff)
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.ListAudit: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (p *postgres) Ping() error {
+	if err := p.db.Ping(); err != nil {
+		return fmt.Errorf("Postgres.Ping: %w", err)
+	}
+	return nil
+}
+
+func (p *postgres) Close() error {
+	return errors.Join(p.index.Close(), p.db.Close())
+}
+
+func (p *postgres) AddSuggestion(s Suggestion) error {
+	_, err := p.db.Exec(`
+INSERT INTO suggestions (id, song_id, chords, base, diff, comment, author, status, created)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+		s.ID, s.SongID, s.Chords, s.Base, s.Diff, s.Comment, s.Author, s.Status, s.Created)
+	if err != nil {
+		return fmt.Errorf("Postgres.AddSuggestion: %w", err)
+	}
+	return nil
+}
+
+const suggestionColumns = `id, song_id, chords, base, diff, comment, author, status, created, reviewed_by, reviewed_at`
+
+// scanSuggestion scans a row with the columns in suggestionColumns.
+func scanSuggestion(row interface{ Scan(...any) error }) (Suggestion, error) {
+	var s Suggestion
+	var reviewedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.SongID, &s.Chords, &s.Base, &s.Diff, &s.Comment, &s.Author,
+		&s.Status, &s.Created, &s.ReviewedBy, &reviewedAt)
+	if reviewedAt.Valid {
+		s.ReviewedAt = &reviewedAt.Time
+	}
+	return s, err
+}
+
+func (p *postgres) ListSuggestions(status SuggestionStatus) ([]Suggestion, error) {
+	rows, err := p.db.Query(`
+SELECT `+suggestionColumns+`
+FROM suggestions
+WHERE $1 = '' OR status = $1
+ORDER BY created;`,
+		status)
+	if err != nil {
+		return nil, fmt.Errorf("Postgres.ListSuggestions: %w", err)
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		s, err := scanSuggestion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Postgres.ListSuggestions: %w", err)
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+func (p *postgres) GetSuggestion(id string) (Suggestion, error) {
+	s, err := scanSuggestion(p.db.QueryRow(`
+SELECT `+suggestionColumns+`
+FROM suggestions
+WHERE id = $1;`,
+		id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Suggestion{}, suggestionNotFound(id)
+	}
+	if err != nil {
+		return Suggestion{}, fmt.Errorf("Postgres.GetSuggestion: %w", err)
+	}
+	return s, nil
+}
+
+func (p *postgres) UpdateSuggestion(s Suggestion) error {
+	res, err := p.db.Exec(`
+UPDATE suggestions
+SET chords = $2, base = $3, diff = $4, comment = $5, author = $6, status = $7,
+	reviewed_by = $8, reviewed_at = $9
+WHERE id = $1;`,
+		s.ID, s.Chords, s.Base, s.Diff, s.Comment, s.Author, s.Status, s.ReviewedBy, s.ReviewedAt)
+	if err != nil {
+		return fmt.Errorf("Postgres.UpdateSuggestion: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return suggestionNotFound(s.ID)
+	}
+	return nil
 }
@@ -0,0 +1,214 @@
+package dblayer
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPostgres opens the Postgres database at TEST_POSTGRES_URL, in a new
+// schema which is dropped when the test finishes. It calls setup (if not
+// nil) on the empty schema before the tables are created. If
+// TEST_POSTGRES_URL isn't set, the test is skipped.
+func newTestPostgres(t *testing.T, setup func(*sql.DB)) *postgres {
+	rawURL := os.Getenv("TEST_POSTGRES_URL")
+	if rawURL == "" {
+		t.Skip("TEST_POSTGRES_URL not set")
+	}
+
+	admin, err := sql.Open("postgres", rawURL)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("chords_test_%d", rand.Uint32())
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		assert.NoError(t, err)
+		admin.Close()
+	})
+
+	// Unknown parameters are sent to the server as settings
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	if setup != nil {
+		db, err := sql.Open("postgres", u.String())
+		require.NoError(t, err)
+		setup(db)
+		require.NoError(t, db.Close())
+	}
+	p, err := NewPostgres(u.String(), log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPostgresMigration(t *testing.T) {
+	// Tables created before songs had text IDs
+	p := newTestPostgres(t, func(db *sql.DB) {
+		_, err := db.Exec(`
+CREATE TABLE chords (
+	id     SERIAL PRIMARY KEY,
+	artist TEXT,
+	album  TEXT,
+	song   TEXT,
+	data   TEXT
+);
+INSERT INTO chords (artist, album, song, data)
+VALUES ('Elton John', 'Madman Across the Water', 'Tiny Dancer', 'C - F/C');`)
+		require.NoError(t, err)
+	})
+
+	// Old songs keep their numeric ID, as text
+	meta, err := p.RenameSong("1", "TinyDancer")
+	require.NoError(t, err)
+	assert.Equal(t, SongMeta{ID: "TinyDancer", Name: "Tiny Dancer", Artist: "Elton John", Album: "Madman Across the Water"}, meta)
+	id, err := p.ResolveAlias("1")
+	require.NoError(t, err)
+	assert.Equal(t, "TinyDancer", id)
+}
+
+func TestPostgresRenameMerge(t *testing.T) {
+	p := newTestPostgres(t, nil)
+	_, err := p.db.Exec(`
+INSERT INTO chords (song_id, song, artist, album) VALUES
+	('YourSong', 'Your Song', 'Elton John', NULL),
+	('YourSong2', 'Your Song', 'Elton John', 'Elton John'),
+	('Daniel', 'Daniel', 'Elton John', NULL);`)
+	require.NoError(t, err)
+
+	// Rename keeps the old ID as an alias
+	_, err = p.RenameSong("YourSong", "Daniel")
+	assert.ErrorContains(t, err, `id "Daniel" already in use`)
+	meta, err := p.RenameSong("YourSong", "YourSongElton")
+	require.NoError(t, err)
+	assert.Equal(t, "YourSongElton", meta.ID)
+	_, err = p.RenameSong("YourSong", "Other")
+	assert.ErrorContains(t, err, "no song found for id YourSong")
+
+	// Merge fills in missing metadata, and trashes the duplicate
+	meta, err = p.MergeSongs("YourSongElton", "YourSong2")
+	require.NoError(t, err)
+	assert.Equal(t, SongMeta{ID: "YourSongElton", Name: "Your Song", Artist: "Elton John", Album: "Elton John"}, meta)
+	for _, alias := range []string{"YourSong", "YourSong2"} {
+		id, err := p.ResolveAlias(alias)
+		require.NoError(t, err)
+		assert.Equal(t, "YourSongElton", id)
+	}
+	trash, err := p.ListTrash()
+	require.NoError(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "YourSong2", trash[0].ID)
+	}
+
+	// Several deleted songs can have the same ID, but only one can be
+	// restored at a time
+	_, err = p.db.Exec(`INSERT INTO chords (song_id, song) VALUES ('YourSong2', 'Your Song (Live)');`)
+	require.NoError(t, err)
+	require.NoError(t, p.DeleteSong("YourSong2"))
+	meta, err = p.RestoreSong("YourSong2")
+	require.NoError(t, err)
+	assert.Equal(t, "Your Song (Live)", meta.Name)
+	_, err = p.RestoreSong("YourSong2")
+	assert.ErrorContains(t, err, `id "YourSong2" already in use`)
+	require.NoError(t, p.PurgeSong("YourSong2"))
+	trash, err = p.ListTrash()
+	require.NoError(t, err)
+	assert.Empty(t, trash)
+}
+
+func TestPostgresSongs(t *testing.T) {
+	p := newTestPostgres(t, nil)
+
+	meta := SongMeta{ID: "TinyDancer", Name: "Tiny Dancer", Artist: "Elton John", Tags: []string{"ballad"}}
+	got, err := p.NewSong(meta)
+	require.NoError(t, err)
+	assert.Equal(t, meta, got)
+	_, err = p.NewSong(meta)
+	assert.ErrorContains(t, err, `id "TinyDancer" already in use`)
+	_, err = p.NewSong(SongMeta{ID: "a/b"})
+	assert.Error(t, err)
+	_, err = p.NewSong(SongMeta{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles"})
+	require.NoError(t, err)
+
+	// Filter by artist, ID and name
+	songs, err := p.GetSongs("Elton John", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, []SongMeta{meta}, songs)
+	songs, err = p.GetSongs("", "Yesterday", "")
+	require.NoError(t, err)
+	assert.Len(t, songs, 1)
+	songs, err = p.GetSongs("", "", "^tiny")
+	require.NoError(t, err)
+	assert.Len(t, songs, 1)
+	songs, err = p.GetSongs("", "", "(")
+	require.NoError(t, err)
+	assert.Len(t, songs, 2)
+	artists, err := p.GetArtists()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Elton John", "The Beatles"}, artists)
+
+	// The ID in the path wins over the one in the metadata
+	meta.ID = "Other"
+	meta.Album = "Madman Across the Water"
+	meta.TrackNum = 1
+	got, err = p.UpdateSong("TinyDancer", meta)
+	require.NoError(t, err)
+	assert.Equal(t, "TinyDancer", got.ID)
+	songs, err = p.GetSongs("", "TinyDancer", "")
+	require.NoError(t, err)
+	assert.Equal(t, []SongMeta{got}, songs)
+	_, err = p.UpdateSong("Missing", meta)
+	assert.ErrorContains(t, err, "no song found for id Missing")
+
+	// Chords
+	chords, err := p.GetChords("TinyDancer")
+	require.NoError(t, err)
+	assert.Equal(t, Chords(""), chords)
+	_, err = p.UpdateChords("TinyDancer", Chords("C - F/C"))
+	require.NoError(t, err)
+	chords, err = p.GetChords("TinyDancer")
+	require.NoError(t, err)
+	assert.Equal(t, Chords("C - F/C"), chords)
+	modTime, err := p.ChordsModTime("TinyDancer")
+	require.NoError(t, err)
+	assert.False(t, modTime.IsZero())
+	_, err = p.UpdateChords("Missing", Chords("C"))
+	assert.ErrorContains(t, err, "no song found for id Missing")
+	_, err = p.GetChords("Missing")
+	assert.ErrorContains(t, err, "no song found for id Missing")
+
+	// Search follows writes, and deleted songs drop out
+	results, err := p.Search("dancer")
+	require.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, &got, results[0].Meta)
+	}
+	require.NoError(t, p.DeleteSong("TinyDancer"))
+	results, err = p.Search("dancer")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Relations are listed in sorted order
+	_, err = p.NewSong(SongMeta{ID: "Help", Name: "Help!", Artist: "The Beatles"})
+	require.NoError(t, err)
+	_, err = p.NewSong(SongMeta{ID: "Imagine", Name: "Imagine", Artist: "John Lennon"})
+	require.NoError(t, err)
+	require.NoError(t, p.AddRelation("The Beatles", "John Lennon"))
+	relations, err := p.Relations()
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{"John Lennon", "The Beatles"}}, relations)
+	_, err = p.GetChords("TinyDancer")
+	assert.Error(t, err)
+}
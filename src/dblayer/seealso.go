@@ -0,0 +1,88 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/seealso.go
+// Helpers for see-also data, which records artists who are related to each
+// other. Relations are symmetric, so each one is stored once, as a pair of
+// artists in sorted order.
+
+package dblayer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrUnknownArtist is returned when adding a relation for an artist who
+	// has no songs in the database.
+	ErrUnknownArtist = errors.New("unknown artist")
+	// ErrNotRelated is returned when removing a relation which doesn't exist.
+	ErrNotRelated = errors.New("artists are not related")
+)
+
+// relation returns the pair of artists in sorted order, so that the relation
+// between a and b is the same as the one between b and a.
+func relation(a, b string) [2]string {
+	if b < a {
+		return [2]string{b, a}
+	}
+	return [2]string{a, b}
+}
+
+// sortRelations sorts a list of relations in place.
+func sortRelations(pairs [][2]string) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] == pairs[j][0] {
+			return pairs[i][1] < pairs[j][1]
+		}
+		return pairs[i][0] < pairs[j][0]
+	})
+}
+
+// normaliseRelations puts each pair in sorted order, removes duplicates and
+// sorts the list.
+func normaliseRelations(pairs [][2]string) [][2]string {
+	seen := set[[2]string]{}
+	normalised := [][2]string{}
+	for _, pair := range pairs {
+		pair = relation(pair[0], pair[1])
+		if _, ok := seen[pair]; ok {
+			continue
+		}
+		seen.add(pair)
+		normalised = append(normalised, pair)
+	}
+	sortRelations(normalised)
+	return normalised
+}
+
+// checkRelation checks that a relation can be added between the two given
+// artists, i.e. that they are different, and both exist in the database.
+func checkRelation(db ChordsDB, a, b string) error {
+	if a == b {
+		return fmt.Errorf("cannot relate artist %q to itself", a)
+	}
+	artists, err := db.GetArtists()
+	if err != nil {
+		return err
+	}
+	known := set[string]{}
+	for _, artist := range artists {
+		known.add(artist)
+	}
+	for _, artist := range []string{a, b} {
+		if _, ok := known[artist]; !ok {
+			return fmt.Errorf("%w %q", ErrUnknownArtist, artist)
+		}
+	}
+	return nil
+}
+
+func notRelated(a, b string) error {
+	return fmt.Errorf("%w: %q and %q", ErrNotRelated, a, b)
+}
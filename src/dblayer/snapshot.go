@@ -0,0 +1,455 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/snapshot.go
+// Export and import whole-database snapshots, for any ChordsDB.
+
+package dblayer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A snapshot is a gzipped tar archive, laid out in the same way as the
+// localfs file tree (see docs/DATA_MODEL.md):
+//   [id1]/meta.json
+//   [id1]/chords.txt
+//   ...
+//   see-also.json
+//   artists.json
+//   albums.json
+// The artist and album files are only included if the database has a
+// catalogue (see GetCatalogue). Hence an exported snapshot can be extracted and used directly as a
+// localfs database.
+
+const (
+	snapshotMetaFile    = "meta.json"
+	snapshotChordsFile  = "chords.txt"
+	snapshotSeeAlsoFile = "see-also.json"
+	snapshotArtistsFile = artistsFileName
+	snapshotAlbumsFile  = albumsFileName
+)
+
+// ImportMode determines how an imported snapshot is combined with the
+// existing contents of the database.
+type ImportMode string
+
+const (
+	// ImportReplace deletes all existing songs which aren't in the snapshot,
+	// and replaces the catalogue with the snapshot's. Deleted songs aren't
+	// kept in the trash.
+	ImportReplace ImportMode = "replace"
+	// ImportMerge adds/updates songs and catalogue records from the snapshot,
+	// leaving all others untouched.
+	ImportMerge ImportMode = "merge"
+)
+
+// ImportResult summarises the changes made by an import.
+type ImportResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// Export writes a snapshot of all songs, chords, see-also data and catalogue
+// records in db to w.
+func Export(db ChordsDB, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	modTime := time.Now()
+
+	songs, err := db.GetSongs("", "", "")
+	if err != nil {
+		return fmt.Errorf("getting songs: %w", err)
+	}
+	// Sort songs so that the archive is deterministic
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].ID < songs[j].ID
+	})
+
+	for _, meta := range songs {
+		metaData, err := json.Marshal(meta)
+		if err != nil {
+			return fmt.Errorf("marshalling metadata for %q: %w", meta.ID, err)
+		}
+		err = writeTarFile(tw, path.Join(meta.ID, snapshotMetaFile), metaData, modTime)
+		if err != nil {
+			return err
+		}
+
+		chords, err := db.GetChords(meta.ID)
+		if err != nil {
+			return fmt.Errorf("getting chords for %q: %w", meta.ID, err)
+		}
+		err = writeTarFile(tw, path.Join(meta.ID, snapshotChordsFile), chords, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	seeAlso, err := allRelations(db)
+	if err != nil {
+		return fmt.Errorf("getting see also data: %w", err)
+	}
+	seeAlsoData, err := json.Marshal(seeAlso)
+	if err != nil {
+		return fmt.Errorf("marshalling see also data: %w", err)
+	}
+	err = writeTarFile(tw, snapshotSeeAlsoFile, seeAlsoData, modTime)
+	if err != nil {
+		return err
+	}
+
+	catalogue, err := db.GetCatalogue()
+	if err != nil {
+		return fmt.Errorf("getting catalogue: %w", err)
+	}
+	if !catalogue.empty() {
+		err = writeTarFile(tw, snapshotArtistsFile, catalogue.Artists, modTime)
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, snapshotAlbumsFile, catalogue.Albums, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// allRelations collects the see-also data for every artist in the database,
+// as a list of artist pairs. Each pair appears only once. Relations of
+// artists who no longer have any songs are left out.
+func allRelations(db ChordsDB) ([][2]string, error) {
+	artists, err := db.GetArtists()
+	if err != nil {
+		return nil, err
+	}
+	known := set[string]{}
+	for _, artist := range artists {
+		known.add(artist)
+	}
+
+	relations, err := db.Relations()
+	if err != nil {
+		return nil, err
+	}
+	pairs := [][2]string{}
+	for _, pair := range relations {
+		_, ok0 := known[pair[0]]
+		_, ok1 := known[pair[1]]
+		if ok0 && ok1 {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return fmt.Errorf("writing header for %q: %w", name, err)
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", name, err)
+	}
+	return nil
+}
+
+// Import reads a snapshot (as written by Export) from r, and restores it into
+// db according to the given mode. The whole snapshot is read and checked
+// before any changes are made to the database. If a change fails part way,
+// the database is restored to how it was before the import.
+func Import(db ChordsDB, r io.Reader, mode ImportMode) (ImportResult, error) {
+	if mode != ImportReplace && mode != ImportMerge {
+		return ImportResult{}, fmt.Errorf("invalid import mode %q", mode)
+	}
+
+	snap, err := readSnapshot(r)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("reading snapshot: %w", err)
+	}
+	if err := snap.checkSeeAlso(db, mode); err != nil {
+		return ImportResult{}, err
+	}
+
+	// Keep a copy of the current contents, to restore if the import fails
+	backup := &bytes.Buffer{}
+	if err := Export(db, backup); err != nil {
+		return ImportResult{}, fmt.Errorf("backing up database: %w", err)
+	}
+
+	result, err := snap.apply(db, mode)
+	if err != nil {
+		if rerr := restore(db, backup); rerr != nil {
+			return result, errors.Join(err, fmt.Errorf("restoring database: %w", rerr))
+		}
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// restore replaces the contents of db with a backup made by Export.
+func restore(db ChordsDB, backup io.Reader) error {
+	snap, err := readSnapshot(backup)
+	if err != nil {
+		return err
+	}
+	_, err = snap.apply(db, ImportReplace)
+	return err
+}
+
+// checkSeeAlso checks the see-also data refers to artists who will be in the
+// database after the snapshot is imported.
+func (s *snapshot) checkSeeAlso(db ChordsDB, mode ImportMode) error {
+	artists := set[string]{}
+	for _, song := range s.songs {
+		artists.add(song.Artist)
+	}
+	if mode == ImportMerge {
+		existing, err := db.GetSongs("", "", "")
+		if err != nil {
+			return fmt.Errorf("getting songs: %w", err)
+		}
+		for _, meta := range existing {
+			artists.add(meta.Artist)
+		}
+	}
+	for _, pair := range s.seeAlso {
+		for _, artist := range pair {
+			if _, ok := artists[artist]; !ok {
+				return fmt.Errorf("see-also data: %w %q", ErrUnknownArtist, artist)
+			}
+		}
+	}
+	return nil
+}
+
+// apply writes the snapshot's contents to db.
+func (s *snapshot) apply(db ChordsDB, mode ImportMode) (ImportResult, error) {
+	result := ImportResult{}
+	existing, err := db.GetSongs("", "", "")
+	if err != nil {
+		return result, fmt.Errorf("getting songs: %w", err)
+	}
+	exists := set[string]{}
+	for _, meta := range existing {
+		exists.add(meta.ID)
+	}
+
+	// Set up the catalogue first, so new songs are linked to the snapshot's
+	// records rather than new ones
+	catalogue := s.catalogue
+	if mode == ImportMerge {
+		existing, err := db.GetCatalogue()
+		if err != nil {
+			return result, fmt.Errorf("getting catalogue: %w", err)
+		}
+		catalogue, err = mergeCatalogue(existing, s.catalogue)
+		if err != nil {
+			return result, fmt.Errorf("merging catalogue: %w", err)
+		}
+	}
+
+	if mode == ImportReplace {
+		relations, err := allRelations(db)
+		if err != nil {
+			return result, fmt.Errorf("getting see-also data: %w", err)
+		}
+		for _, pair := range relations {
+			err := db.RemoveRelation(pair[0], pair[1])
+			if err != nil && !errors.Is(err, ErrNotRelated) {
+				return result, fmt.Errorf("removing see-also data: %w", err)
+			}
+		}
+
+		// Songs in the snapshot are overwritten below, and the rest are
+		// deleted permanently, so the trash isn't filled with the old
+		// library. Trashed songs with the same IDs are left alone.
+		trash, err := db.ListTrash()
+		if err != nil {
+			return result, fmt.Errorf("listing trash: %w", err)
+		}
+		trashed := set[string]{}
+		for _, s := range trash {
+			trashed.add(s.ID)
+		}
+		for _, meta := range existing {
+			if _, ok := s.songs[meta.ID]; ok {
+				continue
+			}
+			if err := db.DeleteSong(meta.ID); err != nil {
+				return result, fmt.Errorf("deleting song %q: %w", meta.ID, err)
+			}
+			if _, ok := trashed[meta.ID]; !ok {
+				if err := db.PurgeSong(meta.ID); err != nil {
+					return result, fmt.Errorf("purging song %q: %w", meta.ID, err)
+				}
+			}
+			delete(exists, meta.ID)
+			result.Deleted++
+		}
+	}
+
+	if mode == ImportReplace || !s.catalogue.empty() {
+		if err := db.SetCatalogue(catalogue); err != nil {
+			return result, fmt.Errorf("setting catalogue: %w", err)
+		}
+	}
+
+	for _, id := range s.ids() {
+		song := s.songs[id]
+		if _, ok := exists[id]; ok {
+			_, err = db.UpdateSong(id, song.SongMeta)
+			if err != nil {
+				return result, fmt.Errorf("updating song %q: %w", id, err)
+			}
+			result.Updated++
+		} else {
+			_, err = db.NewSong(song.SongMeta)
+			if err != nil {
+				return result, fmt.Errorf("creating song %q: %w", id, err)
+			}
+			result.Added++
+		}
+
+		_, err = db.UpdateChords(id, song.Chords)
+		if err != nil {
+			return result, fmt.Errorf("updating chords for %q: %w", id, err)
+		}
+	}
+
+	for _, pair := range s.seeAlso {
+		err = db.AddRelation(pair[0], pair[1])
+		if err != nil {
+			return result, fmt.Errorf("relating %q and %q: %w", pair[0], pair[1], err)
+		}
+	}
+	return result, nil
+}
+
+// snapshot is the in-memory contents of a snapshot archive.
+type snapshot struct {
+	songs     map[string]*song
+	seeAlso   [][2]string
+	catalogue CatalogueData
+}
+
+// ids returns the IDs of all songs in the snapshot, in sorted order.
+func (s *snapshot) ids() []string {
+	ids := make([]string, 0, len(s.songs))
+	for id := range s.songs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	snap := &snapshot{songs: map[string]*song{}}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %q: %w", hdr.Name, err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == snapshotSeeAlsoFile {
+			err = json.Unmarshal(data, &snap.seeAlso)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q: %w", name, err)
+			}
+			continue
+		}
+		if name == snapshotArtistsFile {
+			snap.catalogue.Artists = data
+			continue
+		}
+		if name == snapshotAlbumsFile {
+			snap.catalogue.Albums = data
+			continue
+		}
+
+		id, file := path.Split(name)
+		id = strings.TrimSuffix(id, "/")
+		if id == "" || strings.Contains(id, "/") {
+			return nil, fmt.Errorf("unexpected file %q", hdr.Name)
+		}
+		if err := ValidateID(id); err != nil {
+			return nil, fmt.Errorf("%q: %w", hdr.Name, err)
+		}
+		if snap.songs[id] == nil {
+			snap.songs[id] = &song{Chords: Chords{}}
+		}
+
+		switch file {
+		case snapshotMetaFile:
+			meta := SongMeta{}
+			err = json.Unmarshal(data, &meta)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q: %w", name, err)
+			}
+			if meta.ID != id {
+				return nil, fmt.Errorf("%q: id %q doesn't match dir name %q", name, meta.ID, id)
+			}
+			snap.songs[id].SongMeta = meta
+		case snapshotChordsFile:
+			snap.songs[id].Chords = data
+		default:
+			return nil, fmt.Errorf("unexpected file %q", hdr.Name)
+		}
+	}
+
+	if err := snap.catalogue.check(); err != nil {
+		return nil, err
+	}
+
+	// Every song should have metadata
+	for id, s := range snap.songs {
+		if s.ID == "" {
+			return nil, fmt.Errorf("no %s found for song %q", snapshotMetaFile, id)
+		}
+	}
+	return snap, nil
+}
@@ -0,0 +1,179 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/dblayer/suggestions.go
+// Suggestions are corrections to a song's chords, submitted by visitors. They
+// wait in a moderation queue until a maintainer accepts or rejects them.
+
+package dblayer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/barrettj12/chords/src/util"
+)
+
+// SuggestionStatus is the state of a suggestion in the moderation queue.
+type SuggestionStatus string
+
+const (
+	SuggestionPending  SuggestionStatus = "pending"
+	SuggestionAccepted SuggestionStatus = "accepted"
+	SuggestionRejected SuggestionStatus = "rejected"
+)
+
+// Suggestion is a proposed change to a song's chords.
+type Suggestion struct {
+	ID     string `json:"id"`
+	SongID string `json:"songId"`
+	// Chords are the proposed chords.
+	Chords string `json:"chords"`
+	// Base is the revision (see Revision) of the song's chords which the
+	// suggestion was made against.
+	Base string `json:"base"`
+	// Diff is a unified diff from the chords at the base revision to the
+	// proposed chords.
+	Diff    string `json:"diff"`
+	Comment string `json:"comment,omitempty"`
+	// Author is an optional name given by the visitor.
+	Author  string           `json:"author,omitempty"`
+	Status  SuggestionStatus `json:"status"`
+	Created time.Time        `json:"created"`
+	// ReviewedBy and ReviewedAt are set when the suggestion is accepted or
+	// rejected.
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+}
+
+var (
+	// ErrSuggestionNotFound is returned for an unknown suggestion ID.
+	ErrSuggestionNotFound = errors.New("suggestion not found")
+	// ErrSuggestionReviewed is returned when accepting or rejecting a
+	// suggestion which isn't pending.
+	ErrSuggestionReviewed = errors.New("suggestion has already been reviewed")
+	// ErrSuggestionStale is returned when accepting a suggestion for chords
+	// which have changed since it was made.
+	ErrSuggestionStale = errors.New("chords have changed since the suggestion was made")
+	// ErrNoChange is returned when suggesting chords which are the same as
+	// the current ones.
+	ErrNoChange = errors.New("suggested chords are the same as the current chords")
+)
+
+// Revision identifies a version of a song's chords.
+func Revision(chords Chords) string {
+	return hash(chords)
+}
+
+// NewSuggestion creates a pending suggestion to change the given song's
+// chords, with a diff against the current chords. It isn't stored - use
+// ChordsDB.AddSuggestion.
+func NewSuggestion(db ChordsDB, songID string, chords, comment, author string) (Suggestion, error) {
+	current, err := db.GetChords(songID)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	if string(current) == chords {
+		return Suggestion{}, ErrNoChange
+	}
+
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return Suggestion{}, err
+	}
+	return Suggestion{
+		ID:      hex.EncodeToString(id),
+		SongID:  songID,
+		Chords:  chords,
+		Base:    Revision(current),
+		Diff:    suggestionDiff(songID, string(current), chords),
+		Comment: comment,
+		Author:  author,
+		Status:  SuggestionPending,
+		Created: time.Now().UTC(),
+	}, nil
+}
+
+func suggestionDiff(songID, current, suggested string) string {
+	return util.UnifiedDiff(
+		fmt.Sprintf("%s/chords.txt", songID),
+		fmt.Sprintf("%s/chords.txt (suggested)", songID),
+		current, suggested, 3)
+}
+
+// CurrentDiff returns a diff from the song's current chords to the
+// suggestion, and whether the chords have changed since the suggestion was
+// made.
+func CurrentDiff(db ChordsDB, s Suggestion) (diff string, stale bool, err error) {
+	current, err := db.GetChords(s.SongID)
+	if err != nil {
+		return "", false, err
+	}
+	if Revision(current) == s.Base {
+		return s.Diff, false, nil
+	}
+	return suggestionDiff(s.SongID, string(current), s.Chords), true, nil
+}
+
+// AcceptSuggestion applies a pending suggestion to the song's chords, and
+// marks it as accepted by the given reviewer. If the chords have changed
+// since the suggestion was made, it returns ErrSuggestionStale, unless force
+// is true.
+func AcceptSuggestion(db ChordsDB, id, reviewer string, force bool) (Suggestion, error) {
+	s, err := pendingSuggestion(db, id)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	_, stale, err := CurrentDiff(db, s)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	if stale && !force {
+		return Suggestion{}, fmt.Errorf("suggestion %s: %w", id, ErrSuggestionStale)
+	}
+
+	_, err = db.UpdateChords(s.SongID, Chords(s.Chords))
+	if err != nil {
+		return Suggestion{}, err
+	}
+	return review(db, s, SuggestionAccepted, reviewer)
+}
+
+// RejectSuggestion marks a pending suggestion as rejected by the given
+// reviewer.
+func RejectSuggestion(db ChordsDB, id, reviewer string) (Suggestion, error) {
+	s, err := pendingSuggestion(db, id)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	return review(db, s, SuggestionRejected, reviewer)
+}
+
+func pendingSuggestion(db ChordsDB, id string) (Suggestion, error) {
+	s, err := db.GetSuggestion(id)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	if s.Status != SuggestionPending {
+		return Suggestion{}, fmt.Errorf("%w (%s)", ErrSuggestionReviewed, s.Status)
+	}
+	return s, nil
+}
+
+func review(db ChordsDB, s Suggestion, status SuggestionStatus, reviewer string) (Suggestion, error) {
+	now := time.Now().UTC()
+	s.Status = status
+	s.ReviewedBy = reviewer
+	s.ReviewedAt = &now
+	return s, db.UpdateSuggestion(s)
+}
+
+func suggestionNotFound(id string) error {
+	return fmt.Errorf("%w: %s", ErrSuggestionNotFound, id)
+}
@@ -11,29 +11,61 @@ package dblayer
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/barrettj12/chords/src/search"
 	"github.com/barrettj12/chords/src/types"
 )
 
 type song struct {
 	SongMeta
 	Chords
+	// modTime is when the chords were last changed.
+	modTime time.Time
 }
 
 type tempDB struct {
+	// mu protects all the fields below, so the DB can be used concurrently
+	mu sync.RWMutex
+
 	// map from id -> song
 	data   map[string]*song
 	nextID int
+	// Deleted songs, in order of deletion
+	trash []trashedSong
+	// Old IDs of renamed/merged songs
+	aliases aliasMap
+	// Related artists
+	seeAlso set[[2]string]
+	// Artist and album records
+	catalogue CatalogueData
+	// Suggested corrections, oldest first
+	suggestions []Suggestion
+	// Audit log, oldest first
+	audit []AuditEntry
+}
+
+type trashedSong struct {
+	*song
+	deletedAt time.Time
 }
 
 // Return a correctly initialised tempDB.
 func NewTempDB() *tempDB {
 	return &tempDB{
-		make(map[string]*song),
-		1,
+		data:    make(map[string]*song),
+		nextID:  1,
+		aliases: aliasMap{},
+		seeAlso: set[[2]string]{},
 	}
 }
 
 func (t *tempDB) GetArtists() ([]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	artists := set[string]{}
 	for _, row := range t.data {
 		artists.add(row.Artist)
@@ -42,6 +74,8 @@ func (t *tempDB) GetArtists() ([]string, error) {
 }
 
 func (t *tempDB) GetSongs(artist, id, query string) ([]SongMeta, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	songs := []SongMeta{}
 
 	for _, s := range t.data {
@@ -59,15 +93,32 @@ func (t *tempDB) GetSongs(artist, id, query string) ([]SongMeta, error) {
 }
 
 func (t *tempDB) NewSong(meta SongMeta) (SongMeta, error) {
-	idStr := fmt.Sprint(t.nextID)
-	meta.ID = idStr
-	t.nextID++
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if meta.ID == "" {
+		meta.ID = t.newID()
+	} else if _, ok := t.data[meta.ID]; ok {
+		return SongMeta{}, fmt.Errorf("id %q already in use", meta.ID)
+	}
 
-	t.data[idStr] = &song{meta, []byte{}}
+	t.data[meta.ID] = &song{meta, []byte{}, time.Now()}
 	return meta, nil
 }
 
+// newID returns the next unused numeric ID.
+func (t *tempDB) newID() string {
+	for {
+		idStr := fmt.Sprint(t.nextID)
+		t.nextID++
+		if _, ok := t.data[idStr]; !ok {
+			return idStr
+		}
+	}
+}
+
 func (t *tempDB) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	song, ok := t.data[id]
 	if !ok {
 		return SongMeta{}, songNotFound(id)
@@ -79,11 +130,118 @@ func (t *tempDB) UpdateSong(id string, meta SongMeta) (SongMeta, error) {
 }
 
 func (t *tempDB) DeleteSong(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deleteSong(id)
+	return nil
+}
+
+// deleteSong moves a song to the trash. The caller must hold the lock.
+func (t *tempDB) deleteSong(id string) {
+	song, ok := t.data[id]
+	if !ok {
+		return
+	}
+	t.trash = append(t.trash, trashedSong{song, time.Now()})
 	delete(t.data, id)
+}
+
+func (t *tempDB) ListTrash() ([]TrashedSong, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	songs := make([]TrashedSong, 0, len(t.trash))
+	// Most recently deleted first
+	for i := len(t.trash) - 1; i >= 0; i-- {
+		songs = append(songs, TrashedSong{t.trash[i].SongMeta, t.trash[i].deletedAt})
+	}
+	return songs, nil
+}
+
+func (t *tempDB) RestoreSong(id string) (SongMeta, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.trash) - 1; i >= 0; i-- {
+		if t.trash[i].ID != id {
+			continue
+		}
+		if _, ok := t.data[id]; ok {
+			return SongMeta{}, fmt.Errorf("id %q already in use", id)
+		}
+		t.data[id] = t.trash[i].song
+		t.trash = append(t.trash[:i], t.trash[i+1:]...)
+		return t.data[id].SongMeta, nil
+	}
+	return SongMeta{}, notInTrash(id)
+}
+
+func (t *tempDB) PurgeSong(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	remaining := t.trash[:0]
+	for _, s := range t.trash {
+		if s.ID != id {
+			remaining = append(remaining, s)
+		}
+	}
+	if len(remaining) == len(t.trash) {
+		return notInTrash(id)
+	}
+	t.trash = remaining
 	return nil
 }
 
+func (t *tempDB) RenameSong(id, newID string) (SongMeta, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := ValidateID(newID)
+	if err != nil {
+		return SongMeta{}, err
+	}
+	song, ok := t.data[id]
+	if !ok {
+		return SongMeta{}, songNotFound(id)
+	}
+	if _, ok := t.data[newID]; ok {
+		return SongMeta{}, fmt.Errorf("id %q already in use", newID)
+	}
+
+	song.ID = newID
+	t.data[newID] = song
+	delete(t.data, id)
+	t.aliases.add(id, newID)
+	return song.SongMeta, nil
+}
+
+func (t *tempDB) MergeSongs(id, dupID string) (SongMeta, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id == dupID {
+		return SongMeta{}, fmt.Errorf("cannot merge song %q into itself", id)
+	}
+	song, ok := t.data[id]
+	if !ok {
+		return SongMeta{}, songNotFound(id)
+	}
+	dup, ok := t.data[dupID]
+	if !ok {
+		return SongMeta{}, songNotFound(dupID)
+	}
+
+	song.SongMeta = mergeMeta(song.SongMeta, dup.SongMeta)
+	t.deleteSong(dupID)
+	t.aliases.add(dupID, id)
+	return song.SongMeta, nil
+}
+
+func (t *tempDB) ResolveAlias(alias string) (string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.aliases[alias], nil
+}
+
 func (t *tempDB) GetChords(id string) (Chords, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	song, ok := t.data[id]
 	if !ok {
 		return Chords{}, songNotFound(id)
@@ -92,22 +250,103 @@ func (t *tempDB) GetChords(id string) (Chords, error) {
 }
 
 func (t *tempDB) UpdateChords(id string, chords Chords) (Chords, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	song, ok := t.data[id]
 	if !ok {
 		return Chords{}, songNotFound(id)
 	}
 	song.Chords = chords
+	song.modTime = time.Now()
 	return chords, nil
 }
 
+func (t *tempDB) ChordsModTime(id string) (time.Time, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	song, ok := t.data[id]
+	if !ok {
+		return time.Time{}, songNotFound(id)
+	}
+	return song.modTime, nil
+}
+
 func (t *tempDB) SeeAlso(artist string) ([]string, error) {
-	// TODO: fill this in
-	return nil, nil
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	artists := []string{}
+	for pair := range t.seeAlso {
+		if pair[0] == artist {
+			artists = append(artists, pair[1])
+		}
+		if pair[1] == artist {
+			artists = append(artists, pair[0])
+		}
+	}
+	sort.Strings(artists)
+	return artists, nil
+}
+
+func (t *tempDB) Relations() ([][2]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	pairs := make([][2]string, 0, len(t.seeAlso))
+	for pair := range t.seeAlso {
+		pairs = append(pairs, pair)
+	}
+	sortRelations(pairs)
+	return pairs, nil
+}
+
+func (t *tempDB) AddRelation(artist1, artist2 string) error {
+	err := checkRelation(t, artist1, artist2)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seeAlso.add(relation(artist1, artist2))
+	return nil
+}
+
+func (t *tempDB) RemoveRelation(artist1, artist2 string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rel := relation(artist1, artist2)
+	if _, ok := t.seeAlso[rel]; !ok {
+		return notRelated(artist1, artist2)
+	}
+	delete(t.seeAlso, rel)
+	return nil
 }
 
+// Search builds a search index of all the songs each time, which is fine for
+// a small temporary database.
 func (t *tempDB) Search(query string) ([]types.SearchResult, error) {
-	// TODO: fill this in
-	return nil, nil
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	index, err := search.NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range t.data {
+		err = index.Add(song.SongMeta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := index.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		if res.Type == "song" {
+			meta := t.data[res.ID].SongMeta
+			results[i].Meta = &meta
+		}
+	}
+	return results, nil
 }
 
 // Set type
@@ -127,7 +366,87 @@ func (s *set[T]) toSlice() []T {
 	return slice
 }
 
+func (t *tempDB) GetCatalogue() (CatalogueData, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.catalogue, nil
+}
+
+func (t *tempDB) SetCatalogue(c CatalogueData) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.catalogue = CatalogueData{slices.Clone(c.Artists), slices.Clone(c.Albums)}
+	return nil
+}
+
+func (t *tempDB) AddSuggestion(s Suggestion) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.suggestions = append(t.suggestions, s)
+	return nil
+}
+
+func (t *tempDB) ListSuggestions(status SuggestionStatus) ([]Suggestion, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	suggestions := []Suggestion{}
+	for _, s := range t.suggestions {
+		if status == "" || s.Status == status {
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions, nil
+}
+
+func (t *tempDB) GetSuggestion(id string) (Suggestion, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, s := range t.suggestions {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return Suggestion{}, suggestionNotFound(id)
+}
+
+func (t *tempDB) UpdateSuggestion(s Suggestion) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.suggestions {
+		if t.suggestions[i].ID == s.ID {
+			t.suggestions[i] = s
+			return nil
+		}
+	}
+	return suggestionNotFound(s.ID)
+}
+
+func (t *tempDB) AppendAudit(e AuditEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.audit = append(t.audit, e)
+	return nil
+}
+
+func (t *tempDB) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return filterAudit(t.audit, f), nil
+}
+
+func (t *tempDB) Ping() error {
+	return nil
+}
+
+func (t *tempDB) Close() error {
+	return nil
+}
+
 // Helper functions
 func songNotFound(id string) error {
 	return fmt.Errorf("no song found for id %s", id)
 }
+
+func notInTrash(id string) error {
+	return fmt.Errorf("no song found in trash for id %s", id)
+}
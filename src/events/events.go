@@ -0,0 +1,70 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Type is the kind of change to a song.
+type Type string
+
+const (
+	SongAdded   Type = "songAdded"
+	SongUpdated Type = "songUpdated"
+	SongDeleted Type = "songDeleted"
+)
+
+// Event describes a change to a song in the database.
+type Event struct {
+	Type Type
+	// ID is the ID of the song.
+	ID string
+	// OldID is the song's previous ID, if it was renamed.
+	OldID string
+}
+
+// subscriberBuffer is the number of events which can be queued for a slow
+// subscriber before events are dropped.
+const subscriberBuffer = 64
+
+// Bus delivers events to subscribers.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewBus returns a Bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: map[chan Event]struct{}{}}
+}
+
+// Publish sends an event to all subscribers. It never blocks: if a
+// subscriber isn't keeping up, the event is dropped for that subscriber.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel which receives all events published from now
+// on. The channel is closed once ctx is done.
+func (b *Bus) Subscribe(ctx context.Context) <-chan Event {
+	sub := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, sub)
+		close(sub)
+		b.mu.Unlock()
+	}()
+	return sub
+}
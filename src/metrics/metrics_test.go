@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("test_requests_total", "Requests handled.", "route", "status")
+	latency := r.Histogram("test_latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	size := r.Gauge("test_size", "Size of things.")
+	r.GaugeFunc("test_up", "Whether it's up.", func() float64 { return 1 })
+
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "404")
+	requests.Add(2, "/a", "404")
+	requests.Inc(`/"odd"\path`, "200")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.1, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(3, "/a")
+	size.Set(42)
+
+	out := &strings.Builder{}
+	_, err := r.WriteTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, `# HELP test_latency_seconds Request latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/a",le="0.1"} 2
+test_latency_seconds_bucket{route="/a",le="1"} 3
+test_latency_seconds_bucket{route="/a",le="+Inf"} 4
+test_latency_seconds_sum{route="/a"} 3.65
+test_latency_seconds_count{route="/a"} 4
+# HELP test_requests_total Requests handled.
+# TYPE test_requests_total counter
+test_requests_total{route="/\"odd\"\\path",status="200"} 1
+test_requests_total{route="/a",status="404"} 3
+test_requests_total{route="/b",status="200"} 1
+# HELP test_size Size of things.
+# TYPE test_size gauge
+test_size 42
+# HELP test_up Whether it's up.
+# TYPE test_up gauge
+test_up 1
+`, out.String())
+}
+
+func TestWrongLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "Test.", "a", "b")
+	assert.Panics(t, func() { c.Inc("x") })
+	assert.Panics(t, func() { r.Gauge("test_total", "Again.") })
+}
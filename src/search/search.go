@@ -2,12 +2,21 @@ package search
 
 import (
 	"strings"
+	"time"
 
+	"github.com/barrettj12/chords/src/metrics"
 	"github.com/barrettj12/chords/src/types"
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search/query"
 )
 
+var (
+	indexDocuments = metrics.NewGauge("chords_search_index_documents",
+		"Number of documents (songs and artists) in the search index.")
+	searchDuration = metrics.NewHistogram("chords_search_duration_seconds",
+		"Time taken by search queries.", metrics.DefaultBuckets)
+)
+
 type Index struct {
 	bleveIndex bleve.Index
 }
@@ -27,6 +36,7 @@ func NewIndex() (*Index, error) {
 }
 
 func (i *Index) Add(meta types.SongMeta) error {
+	defer i.updateSize()
 	err := i.bleveIndex.Index("artist/"+meta.Artist, meta.Artist)
 	if err != nil {
 		return err
@@ -34,11 +44,26 @@ func (i *Index) Add(meta types.SongMeta) error {
 	return i.bleveIndex.Index("song/"+meta.ID, meta)
 }
 
+// Close releases the index's resources.
+func (i *Index) Close() error {
+	return i.bleveIndex.Close()
+}
+
 func (i *Index) Remove(id string) error {
-	return i.bleveIndex.Delete(id)
+	defer i.updateSize()
+	return i.bleveIndex.Delete("song/" + id)
+}
+
+// updateSize records the number of documents in the index.
+func (i *Index) updateSize() {
+	if n, err := i.bleveIndex.DocCount(); err == nil {
+		indexDocuments.Set(float64(n))
+	}
 }
 
 func (i *Index) Search(rawQuery string) ([]types.SearchResult, error) {
+	defer searchDuration.ObserveSince(time.Now())
+
 	// For some reason, terms are not matched with mixed case
 	// So map everything to lowercase
 	rawQuery = strings.ToLower(rawQuery)
@@ -0,0 +1,121 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/admin.go
+// Handlers for the v1 admin API, which manages users and API tokens, and
+// shows the audit log. These all need the admin role, so s.accounts is
+// always set - without it, no one can authenticate.
+
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// newUser is the request body for creating a user.
+type newUser struct {
+	Name string    `json:"name"`
+	Role auth.Role `json:"role"`
+	// Password is optional. Users without a password can only use tokens.
+	Password string `json:"password,omitempty"`
+}
+
+// newToken is the request body for creating a token.
+type newToken struct {
+	User string `json:"user"`
+	// Name describes what the token is for.
+	Name    string       `json:"name,omitempty"`
+	Scopes  []auth.Scope `json:"scopes"`
+	Expires time.Time    `json:"expires"`
+}
+
+// createdToken is the response when a token is created. It's the only time
+// the token itself is sent.
+type createdToken struct {
+	auth.Token
+	Secret string `json:"token"`
+}
+
+func (s *ChordsAPI) currentUserV1(r *http.Request) (any, error) {
+	return auth.UserFrom(r.Context()), nil
+}
+
+func (s *ChordsAPI) listUsersV1(r *http.Request) (any, error) {
+	return s.accounts.Users(), nil
+}
+
+func (s *ChordsAPI) addUserV1(r *http.Request) (any, error) {
+	in := newUser{}
+	if err := decodeJSON(r, &in); err != nil {
+		return nil, err
+	}
+	return s.accounts.AddUser(in.Name, in.Role, in.Password)
+}
+
+func (s *ChordsAPI) updateUserV1(r *http.Request) (any, error) {
+	up := auth.UserUpdate{}
+	if err := decodeJSON(r, &up); err != nil {
+		return nil, err
+	}
+	return s.accounts.UpdateUser(r.PathValue("name"), up)
+}
+
+func (s *ChordsAPI) removeUserV1(r *http.Request) (any, error) {
+	return nil, s.accounts.RemoveUser(r.PathValue("name"))
+}
+
+func (s *ChordsAPI) listTokensV1(r *http.Request) (any, error) {
+	return s.accounts.Tokens(r.URL.Query().Get("user")), nil
+}
+
+func (s *ChordsAPI) createTokenV1(r *http.Request) (any, error) {
+	in := newToken{}
+	if err := decodeJSON(r, &in); err != nil {
+		return nil, err
+	}
+	token, secret, err := s.accounts.CreateToken(in.User, in.Name, in.Scopes, in.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return createdToken{token, secret}, nil
+}
+
+func (s *ChordsAPI) revokeTokenV1(r *http.Request) (any, error) {
+	return nil, s.accounts.RevokeToken(r.PathValue("id"))
+}
+
+func (s *ChordsAPI) auditV1(r *http.Request) (any, error) {
+	query := r.URL.Query()
+	f := dblayer.AuditFilter{
+		Actor:  query.Get("actor"),
+		Target: query.Get("song"),
+		Action: dblayer.AuditAction(query.Get("action")),
+	}
+	if f.Action != "" && !slices.Contains(dblayer.AuditActions, f.Action) {
+		return nil, badRequest("unknown action %q", f.Action)
+	}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if !query.Has(name) {
+			continue
+		}
+		var err error
+		*t, err = time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			return nil, badRequest("query parameter %q must be an RFC 3339 time", name)
+		}
+	}
+	if query.Has("limit") {
+		// The query has already been validated.
+		f.Limit, _ = strconv.Atoi(query.Get("limit"))
+	}
+	return s.db.ListAudit(f)
+}
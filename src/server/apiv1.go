@@ -0,0 +1,730 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/apiv1.go
+// The v1 REST API, built on data.ChordsDBv1. Unlike v0, this API is stable.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// v1Route is an endpoint of the v1 API. The routes are registered with the
+// mux and described in the OpenAPI document (see openapi.go) from the same
+// table, so the handlers and the spec can't get out of sync.
+type v1Route struct {
+	method, path string
+	summary      string
+	// query lists the allowed query parameters.
+	query []v1Param
+	// request and response are the types of the request and response
+	// bodies, or nil if there is none. String and []byte bodies are sent as
+	// plain text, and other types as JSON.
+	request, response reflect.Type
+	// status is the status code for a successful response.
+	status int
+	// role is the role needed to use the endpoint. Empty means it's public.
+	role auth.Role
+	// scope is the scope needed by API tokens to use the endpoint.
+	scope  auth.Scope
+	handle func(*ChordsAPI, *http.Request) (any, error)
+}
+
+// v1Param is a query parameter.
+type v1Param struct {
+	name, description string
+	integer, boolean  bool
+}
+
+var v1Routes = []v1Route{{
+	method:  http.MethodGet,
+	path:    "/api/v1/artists",
+	summary: "List artists",
+	query: []v1Param{
+		{name: "relatedTo", description: "Only artists related to this artist"},
+		{name: "album", description: "Only the artist of this album"},
+		{name: "song", description: "Only the artist of this song"},
+	},
+	response: reflect.TypeFor[[]data.Artist](),
+	handle:   (*ChordsAPI).listArtistsV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/artists/{id}",
+	summary:  "Get an artist",
+	response: reflect.TypeFor[data.Artist](),
+	handle:   (*ChordsAPI).getArtistV1,
+}, {
+	method:   http.MethodPatch,
+	path:     "/api/v1/artists/{id}",
+	summary:  "Update an artist. If renamed, the old name is kept as an alias.",
+	request:  reflect.TypeFor[data.ArtistUpdate](),
+	response: reflect.TypeFor[data.Artist](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeArtistsWrite,
+	handle:   (*ChordsAPI).updateArtistV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/artists/{id}/albums",
+	summary:  "List an artist's albums",
+	response: reflect.TypeFor[[]data.Album](),
+	handle:   (*ChordsAPI).artistAlbumsV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/artists/{id}/songs",
+	summary:  "List an artist's songs",
+	response: reflect.TypeFor[[]data.Song](),
+	handle:   (*ChordsAPI).artistSongsV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/artists/{id}/related",
+	summary:  "List related artists",
+	response: reflect.TypeFor[[]data.Artist](),
+	handle:   (*ChordsAPI).relatedArtistsV1,
+}, {
+	method:  http.MethodPut,
+	path:    "/api/v1/artists/{id}/related/{other}",
+	summary: "Relate two artists",
+	status:  http.StatusNoContent,
+	role:    auth.RoleEditor,
+	scope:   auth.ScopeRelationsWrite,
+	handle:  (*ChordsAPI).relateArtistsV1,
+}, {
+	method:  http.MethodDelete,
+	path:    "/api/v1/artists/{id}/related/{other}",
+	summary: "Remove the relation between two artists",
+	status:  http.StatusNoContent,
+	role:    auth.RoleEditor,
+	scope:   auth.ScopeRelationsWrite,
+	handle:  (*ChordsAPI).unrelateArtistsV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/albums",
+	summary: "List albums",
+	query: []v1Param{
+		{name: "artist", description: "Only albums by this artist"},
+		{name: "song", description: "Only the album of this song"},
+		{name: "yearFrom", description: "Only albums released in or after this year", integer: true},
+		{name: "yearTo", description: "Only albums released in or before this year", integer: true},
+	},
+	response: reflect.TypeFor[[]data.Album](),
+	handle:   (*ChordsAPI).listAlbumsV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/albums/{id}",
+	summary:  "Get an album",
+	response: reflect.TypeFor[data.Album](),
+	handle:   (*ChordsAPI).getAlbumV1,
+}, {
+	method:   http.MethodPatch,
+	path:     "/api/v1/albums/{id}",
+	summary:  "Update an album. If renamed, the old name is kept as an alias.",
+	request:  reflect.TypeFor[data.AlbumUpdate](),
+	response: reflect.TypeFor[data.Album](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeArtistsWrite,
+	handle:   (*ChordsAPI).updateAlbumV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/albums/{id}/songs",
+	summary:  "List the songs on an album",
+	response: reflect.TypeFor[[]data.Song](),
+	handle:   (*ChordsAPI).albumSongsV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/songs",
+	summary: "List songs",
+	query: []v1Param{
+		{name: "artist", description: "Only songs by this artist"},
+		{name: "album", description: "Only songs on this album"},
+		{name: "yearFrom", description: "Only songs on albums released in or after this year", integer: true},
+		{name: "yearTo", description: "Only songs on albums released in or before this year", integer: true},
+		{name: "key", description: "Only songs in this key"},
+		{name: "tag", description: "Only songs with this tag"},
+	},
+	response: reflect.TypeFor[[]data.Song](),
+	handle:   (*ChordsAPI).listSongsV1,
+}, {
+	method:   http.MethodPost,
+	path:     "/api/v1/songs",
+	summary:  "Add a song. If the ID isn't given, it is generated from the name.",
+	request:  reflect.TypeFor[data.SongInput](),
+	response: reflect.TypeFor[data.Song](),
+	status:   http.StatusCreated,
+	role:     auth.RoleContributor,
+	scope:    auth.ScopeSongsWrite,
+	handle:   (*ChordsAPI).addSongV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/songs/{id}",
+	summary:  "Get a song",
+	response: reflect.TypeFor[data.Song](),
+	handle:   (*ChordsAPI).getSongV1,
+}, {
+	method:   http.MethodPut,
+	path:     "/api/v1/songs/{id}",
+	summary:  "Replace a song's metadata. A different ID renames the song.",
+	request:  reflect.TypeFor[data.SongInput](),
+	response: reflect.TypeFor[data.Song](),
+	role:     auth.RoleContributor,
+	scope:    auth.ScopeSongsWrite,
+	handle:   (*ChordsAPI).updateSongV1,
+}, {
+	method:  http.MethodDelete,
+	path:    "/api/v1/songs/{id}",
+	summary: "Move a song to the trash",
+	status:  http.StatusNoContent,
+	role:    auth.RoleEditor,
+	scope:   auth.ScopeSongsWrite,
+	handle:  (*ChordsAPI).deleteSongV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/songs/{id}/chords",
+	summary:  "Get a song's chords",
+	response: reflect.TypeFor[string](),
+	handle:   (*ChordsAPI).getChordsV1,
+}, {
+	method:   http.MethodPut,
+	path:     "/api/v1/songs/{id}/chords",
+	summary:  "Update a song's chords",
+	request:  reflect.TypeFor[string](),
+	response: reflect.TypeFor[string](),
+	role:     auth.RoleContributor,
+	scope:    auth.ScopeChordsWrite,
+	handle:   (*ChordsAPI).updateChordsV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/search",
+	summary: "Search for artists and songs, best matches first",
+	query: []v1Param{
+		{name: "q", description: "The search query"},
+	},
+	response: reflect.TypeFor[[]data.SearchResult](),
+	handle:   (*ChordsAPI).searchV1,
+}, {
+	// Suggestions API (see suggestions.go)
+	method:   http.MethodPost,
+	path:     "/api/v1/songs/{id}/suggestions",
+	summary:  "Suggest a correction to a song's chords. Anyone can do this - the suggestion is kept until a maintainer reviews it.",
+	request:  reflect.TypeFor[suggestionInput](),
+	response: reflect.TypeFor[dblayer.Suggestion](),
+	status:   http.StatusCreated,
+	handle:   (*ChordsAPI).addSuggestionV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/suggestions",
+	summary: "List suggested corrections, oldest first",
+	query: []v1Param{
+		{name: "status", description: "Only suggestions with this status: pending, accepted or rejected"},
+		{name: "song", description: "Only suggestions for this song"},
+	},
+	response: reflect.TypeFor[[]dblayer.Suggestion](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeSuggestions,
+	handle:   (*ChordsAPI).listSuggestionsV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/suggestions/{id}",
+	summary:  "Preview a suggestion. If the chords have changed since it was made, it includes a diff against the current chords.",
+	response: reflect.TypeFor[suggestionPreview](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeSuggestions,
+	handle:   (*ChordsAPI).getSuggestionV1,
+}, {
+	method:  http.MethodPost,
+	path:    "/api/v1/suggestions/{id}/accept",
+	summary: "Accept a suggestion, and apply it to the song's chords",
+	query: []v1Param{
+		{name: "force", description: "Apply the suggestion even if the chords have changed since it was made", boolean: true},
+	},
+	response: reflect.TypeFor[dblayer.Suggestion](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeSuggestions,
+	handle:   (*ChordsAPI).acceptSuggestionV1,
+}, {
+	method:   http.MethodPost,
+	path:     "/api/v1/suggestions/{id}/reject",
+	summary:  "Reject a suggestion",
+	response: reflect.TypeFor[dblayer.Suggestion](),
+	role:     auth.RoleEditor,
+	scope:    auth.ScopeSuggestions,
+	handle:   (*ChordsAPI).rejectSuggestionV1,
+}, {
+	// Admin API (see admin.go)
+	method:   http.MethodGet,
+	path:     "/api/v1/me",
+	summary:  "Get the authenticated user",
+	response: reflect.TypeFor[auth.User](),
+	role:     auth.RoleViewer,
+	handle:   (*ChordsAPI).currentUserV1,
+}, {
+	method:   http.MethodGet,
+	path:     "/api/v1/users",
+	summary:  "List users",
+	response: reflect.TypeFor[[]auth.User](),
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAccounts,
+	handle:   (*ChordsAPI).listUsersV1,
+}, {
+	method:   http.MethodPost,
+	path:     "/api/v1/users",
+	summary:  "Add a user",
+	request:  reflect.TypeFor[newUser](),
+	response: reflect.TypeFor[auth.User](),
+	status:   http.StatusCreated,
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAccounts,
+	handle:   (*ChordsAPI).addUserV1,
+}, {
+	method:   http.MethodPatch,
+	path:     "/api/v1/users/{name}",
+	summary:  "Change a user's role or password. An empty password removes it.",
+	request:  reflect.TypeFor[auth.UserUpdate](),
+	response: reflect.TypeFor[auth.User](),
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAccounts,
+	handle:   (*ChordsAPI).updateUserV1,
+}, {
+	method:  http.MethodDelete,
+	path:    "/api/v1/users/{name}",
+	summary: "Remove a user, and revoke their tokens",
+	status:  http.StatusNoContent,
+	role:    auth.RoleAdmin,
+	scope:   auth.ScopeAccounts,
+	handle:  (*ChordsAPI).removeUserV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/tokens",
+	summary: "List API tokens",
+	query: []v1Param{
+		{name: "user", description: "Only tokens for this user"},
+	},
+	response: reflect.TypeFor[[]auth.Token](),
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAccounts,
+	handle:   (*ChordsAPI).listTokensV1,
+}, {
+	method:   http.MethodPost,
+	path:     "/api/v1/tokens",
+	summary:  "Create an API token for a user. The token is only shown in this response.",
+	request:  reflect.TypeFor[newToken](),
+	response: reflect.TypeFor[createdToken](),
+	status:   http.StatusCreated,
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAccounts,
+	handle:   (*ChordsAPI).createTokenV1,
+}, {
+	method:  http.MethodDelete,
+	path:    "/api/v1/tokens/{id}",
+	summary: "Revoke an API token",
+	status:  http.StatusNoContent,
+	role:    auth.RoleAdmin,
+	scope:   auth.ScopeAccounts,
+	handle:  (*ChordsAPI).revokeTokenV1,
+}, {
+	method:  http.MethodGet,
+	path:    "/api/v1/audit",
+	summary: "List changes to the database, newest first",
+	query: []v1Param{
+		{name: "actor", description: "Only changes made by this user"},
+		{name: "song", description: "Only changes to this song (or artist, for relations)"},
+		{name: "action", description: "Only changes of this kind, e.g. chords.update"},
+		{name: "since", description: "Only changes at or after this time (RFC 3339)"},
+		{name: "until", description: "Only changes before this time (RFC 3339)"},
+		{name: "limit", description: "Maximum number of changes to return", integer: true},
+	},
+	response: reflect.TypeFor[[]dblayer.AuditEntry](),
+	role:     auth.RoleAdmin,
+	scope:    auth.ScopeAudit,
+	handle:   (*ChordsAPI).auditV1,
+}}
+
+// registerV1 registers the v1 API endpoints with the mux.
+func (s *ChordsAPI) registerV1(mux *http.ServeMux) {
+	for _, route := range v1Routes {
+		mux.Handle(route.method+" "+route.path, s.v1Handler(route))
+	}
+
+	spec, err := json.Marshal(openAPISpec(v1Routes))
+	if err != nil {
+		// The spec is generated from static data, so this can't happen
+		panic(err)
+	}
+	mux.HandleFunc("GET /api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	})
+}
+
+// v1Handler does the common handling for a v1 endpoint: checking
+// authorisation and query parameters, and writing the response or error.
+func (s *ChordsAPI) v1Handler(route v1Route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.authenticate(r)
+		if route.role != "" {
+			if err == nil {
+				err = user.Authorise(route.role, route.scope)
+			}
+			if err != nil {
+				s.writeErrorV1(w, r, err)
+				return
+			}
+			s.log(r).Info("authorised", "user", user.Name, "role", user.Role)
+		}
+		r = withActor(r, user)
+		if err := checkQuery(r, route.query); err != nil {
+			s.writeErrorV1(w, r, err)
+			return
+		}
+
+		resp, err := route.handle(s, r)
+		if err != nil {
+			s.writeErrorV1(w, r, err)
+			return
+		}
+
+		status := route.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if m, ok := resp.(modified); ok {
+			setLastModified(w, m.modTime)
+			resp = m.resp
+		}
+		switch resp := resp.(type) {
+		case nil:
+			w.WriteHeader(status)
+		case []byte:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write(resp)
+		default:
+			jData, err := json.Marshal(resp)
+			if err != nil {
+				s.writeErrorV1(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write(jData)
+		}
+	})
+}
+
+// checkQuery checks the request only has the allowed query parameters, and
+// that integer parameters are valid.
+func checkQuery(r *http.Request, params []v1Param) error {
+	allowed := map[string]v1Param{}
+	for _, p := range params {
+		allowed[p.name] = p
+	}
+	for name, values := range r.URL.Query() {
+		p, ok := allowed[name]
+		if !ok {
+			return badRequest("unknown query parameter %q", name)
+		}
+		if _, err := strconv.Atoi(values[0]); p.integer && err != nil {
+			return badRequest("query parameter %q must be an integer", name)
+		}
+		if _, err := strconv.ParseBool(values[0]); p.boolean && err != nil {
+			return badRequest("query parameter %q must be true or false", name)
+		}
+	}
+	return nil
+}
+
+// apiError is an error with an HTTP status code.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e apiError) Error() string {
+	return e.msg
+}
+
+var errUnauthorised = apiError{http.StatusUnauthorized, "unauthorised"}
+
+func badRequest(format string, a ...any) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+// modified is a response, with the time it was last modified, for the
+// Last-Modified header.
+type modified struct {
+	resp    any
+	modTime time.Time
+}
+
+// v1Error is the response body for errors.
+type v1Error struct {
+	Error string `json:"error"`
+}
+
+// writeErrorV1 writes an error response, with a status code depending on the
+// error.
+func (s *ChordsAPI) writeErrorV1(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	var apiErr apiError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.status
+	case errors.As(err, &tooLarge):
+		err = apiError{http.StatusRequestEntityTooLarge, bodyTooLarge(tooLarge.Limit)}
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, auth.ErrUnauthenticated):
+		err = errUnauthorised
+		status = http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, data.ErrNotFound), errors.Is(err, dblayer.ErrNotRelated),
+		errors.Is(err, auth.ErrNotFound), errors.Is(err, dblayer.ErrSuggestionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, auth.ErrExists), errors.Is(err, dblayer.ErrSuggestionReviewed),
+		errors.Is(err, dblayer.ErrSuggestionStale):
+		status = http.StatusConflict
+	case errors.Is(err, auth.ErrInvalid), errors.Is(err, dblayer.ErrNoChange):
+		status = http.StatusBadRequest
+	default:
+		s.log(r).Error("handling request", "error", err)
+	}
+
+	jData, _ := json.Marshal(v1Error{err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jData)
+}
+
+// decodeJSON decodes the JSON request body into v.
+func decodeJSON(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		// Reported as 413 by writeErrorV1
+		return err
+	}
+	if err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// queryInt gets an integer query parameter. It has already been checked by
+// checkQuery, so it's zero if missing.
+func queryInt(r *http.Request, name string) int {
+	n, _ := strconv.Atoi(r.URL.Query().Get(name))
+	return n
+}
+
+// one returns the only element of list, or a not found error if it's empty.
+func one[T any](list []T, err error, kind, id string) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, apiError{http.StatusNotFound, fmt.Sprintf("no %s found for id %s", kind, id)}
+	}
+	return list[0], nil
+}
+
+// nonNil makes sure empty lists are encoded as [] rather than null.
+func nonNil[T any](list []T, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []T{}
+	}
+	return list, nil
+}
+
+// ARTISTS
+
+func (s *ChordsAPI) listArtistsV1(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	return nonNil(s.v1.Artists(r.Context(), data.ArtistsFilters{
+		RelatedTo: data.ArtistID(q.Get("relatedTo")),
+		Album:     data.AlbumID(q.Get("album")),
+		Song:      data.SongID(q.Get("song")),
+	}))
+}
+
+func (s *ChordsAPI) getArtistV1(r *http.Request) (any, error) {
+	id := r.PathValue("id")
+	artists, err := s.v1.Artists(r.Context(), data.ArtistsFilters{ID: data.ArtistID(id)})
+	return one(artists, err, "artist", id)
+}
+
+func (s *ChordsAPI) updateArtistV1(r *http.Request) (any, error) {
+	up := data.ArtistUpdate{}
+	if err := decodeJSON(r, &up); err != nil {
+		return nil, err
+	}
+	return s.v1.UpdateArtist(r.Context(), data.ArtistID(r.PathValue("id")), up)
+}
+
+func (s *ChordsAPI) artistAlbumsV1(r *http.Request) (any, error) {
+	if _, err := s.getArtistV1(r); err != nil {
+		return nil, err
+	}
+	return nonNil(s.v1.Albums(r.Context(), data.AlbumsFilters{
+		Artist: data.ArtistID(r.PathValue("id")),
+	}))
+}
+
+func (s *ChordsAPI) artistSongsV1(r *http.Request) (any, error) {
+	if _, err := s.getArtistV1(r); err != nil {
+		return nil, err
+	}
+	return nonNil(s.v1.Songs(r.Context(), data.SongsFilters{
+		Artist: data.ArtistID(r.PathValue("id")),
+	}))
+}
+
+func (s *ChordsAPI) relatedArtistsV1(r *http.Request) (any, error) {
+	if _, err := s.getArtistV1(r); err != nil {
+		return nil, err
+	}
+	return nonNil(s.v1.Artists(r.Context(), data.ArtistsFilters{
+		RelatedTo: data.ArtistID(r.PathValue("id")),
+	}))
+}
+
+func (s *ChordsAPI) relateArtistsV1(r *http.Request) (any, error) {
+	return nil, s.v1.RelateArtists(r.Context(),
+		data.ArtistID(r.PathValue("id")), data.ArtistID(r.PathValue("other")))
+}
+
+func (s *ChordsAPI) unrelateArtistsV1(r *http.Request) (any, error) {
+	return nil, s.v1.UnrelateArtists(r.Context(),
+		data.ArtistID(r.PathValue("id")), data.ArtistID(r.PathValue("other")))
+}
+
+// ALBUMS
+
+func (s *ChordsAPI) listAlbumsV1(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	return nonNil(s.v1.Albums(r.Context(), data.AlbumsFilters{
+		Artist:   data.ArtistID(q.Get("artist")),
+		Song:     data.SongID(q.Get("song")),
+		YearFrom: queryInt(r, "yearFrom"),
+		YearTo:   queryInt(r, "yearTo"),
+	}))
+}
+
+func (s *ChordsAPI) getAlbumV1(r *http.Request) (any, error) {
+	id := r.PathValue("id")
+	albums, err := s.v1.Albums(r.Context(), data.AlbumsFilters{ID: data.AlbumID(id)})
+	return one(albums, err, "album", id)
+}
+
+func (s *ChordsAPI) updateAlbumV1(r *http.Request) (any, error) {
+	up := data.AlbumUpdate{}
+	if err := decodeJSON(r, &up); err != nil {
+		return nil, err
+	}
+	return s.v1.UpdateAlbum(r.Context(), data.AlbumID(r.PathValue("id")), up)
+}
+
+func (s *ChordsAPI) albumSongsV1(r *http.Request) (any, error) {
+	if _, err := s.getAlbumV1(r); err != nil {
+		return nil, err
+	}
+	return nonNil(s.v1.Songs(r.Context(), data.SongsFilters{
+		Album: data.AlbumID(r.PathValue("id")),
+	}))
+}
+
+// SONGS
+
+func (s *ChordsAPI) listSongsV1(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	return nonNil(s.v1.Songs(r.Context(), data.SongsFilters{
+		Artist:   data.ArtistID(q.Get("artist")),
+		Album:    data.AlbumID(q.Get("album")),
+		YearFrom: queryInt(r, "yearFrom"),
+		YearTo:   queryInt(r, "yearTo"),
+		Key:      q.Get("key"),
+		Tag:      q.Get("tag"),
+	}))
+}
+
+func (s *ChordsAPI) addSongV1(r *http.Request) (any, error) {
+	in := data.SongInput{}
+	if err := decodeJSON(r, &in); err != nil {
+		return nil, err
+	}
+	return s.v1.AddSong(r.Context(), in)
+}
+
+func (s *ChordsAPI) getSongV1(r *http.Request) (any, error) {
+	id := r.PathValue("id")
+	songs, err := s.v1.Songs(r.Context(), data.SongsFilters{ID: data.SongID(id)})
+	return one(songs, err, "song", id)
+}
+
+func (s *ChordsAPI) updateSongV1(r *http.Request) (any, error) {
+	in := data.SongInput{}
+	if err := decodeJSON(r, &in); err != nil {
+		return nil, err
+	}
+	return s.v1.UpdateSong(r.Context(), data.SongID(r.PathValue("id")), in)
+}
+
+func (s *ChordsAPI) deleteSongV1(r *http.Request) (any, error) {
+	return nil, s.v1.DeleteSong(r.Context(), data.SongID(r.PathValue("id")))
+}
+
+func (s *ChordsAPI) getChordsV1(r *http.Request) (any, error) {
+	id := r.PathValue("id")
+	songs, err := s.v1.Songs(r.Context(), data.SongsFilters{ID: data.SongID(id), WithChords: true})
+	song, err := one(songs, err, "song", id)
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := s.db.ChordsModTime(id)
+	if err != nil {
+		return nil, err
+	}
+	return modified{nonNilBytes(song.(data.Song).Chords), modTime}, nil
+}
+
+func (s *ChordsAPI) updateChordsV1(r *http.Request) (any, error) {
+	chords, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	song, err := s.v1.UpdateChords(r.Context(), data.SongID(r.PathValue("id")), chords)
+	if err != nil {
+		return nil, err
+	}
+	return nonNilBytes(song.Chords), nil
+}
+
+// nonNilBytes makes sure an empty chord sheet is sent as text, rather than
+// as no body.
+func nonNilBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func (s *ChordsAPI) searchV1(r *http.Request) (any, error) {
+	return nonNil(s.v1.Search(r.Context(), r.URL.Query().Get("q")))
+}
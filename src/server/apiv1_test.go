@@ -0,0 +1,532 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/apiv1_test.go
+// Tests for the v1 REST API, including a contract test which checks the
+// handlers against the OpenAPI document.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newV1TestServer(t *testing.T) (http.Handler, *auth.Accounts) {
+	lib, err := data.LibraryFor(dblayer.NewTempDB())
+	assert.Nil(t, err)
+	ctx := context.Background()
+	for _, song := range []data.SongInput{
+		{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles", Album: "Help!", Key: "F", Tags: []string{"acoustic"}, Chords: []byte("F Em7 A7 Dm")},
+		{ID: "Help", Name: "Help!", Artist: "The Beatles", Album: "Help!", TrackNum: 1},
+		{ID: "AnotherDay", Name: "Another Day", Artist: "Paul McCartney"},
+	} {
+		_, err := lib.AddSong(ctx, song)
+		assert.Nil(t, err)
+	}
+	year := 1965
+	_, err = lib.UpdateAlbum(ctx, "Help", data.AlbumUpdate{Year: &year})
+	assert.Nil(t, err)
+
+	accounts := newTestAccounts(t)
+	api := &ChordsAPI{db: lib.V0(), v1: lib, logger: slog.New(slog.DiscardHandler), accounts: accounts}
+	mux := http.NewServeMux()
+	api.registerV1(mux)
+	return mux, accounts
+}
+
+// v1Request makes a request to the v1 API, and returns the response.
+func v1Request(h http.Handler, method, path, authKey, body string) *http.Response {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authKey != "" {
+		r.Header.Set("Authorization", authKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// Test values for the path parameters and request bodies, so that every
+// operation in the spec can be called.
+var (
+	contractIDs = map[string]string{
+		"/api/v1/songs/":   "Yesterday",
+		"/api/v1/artists/": "TheBeatles",
+		"/api/v1/albums/":  "Help",
+		"/api/v1/users/":   "contract",
+	}
+	contractOtherID = "PaulMccartney"
+	contractBodies  = map[string]string{
+		"#/components/schemas/SongInput":       `{"name": "Michelle", "artist": "The Beatles", "tags": ["ballad"]}`,
+		"#/components/schemas/ArtistUpdate":    `{"sortName": "Beatles, The"}`,
+		"#/components/schemas/AlbumUpdate":     `{"year": 1965}`,
+		"#/components/schemas/NewUser":         `{"name": "ringo", "role": "viewer"}`,
+		"#/components/schemas/UserUpdate":      `{"role": "editor"}`,
+		"#/components/schemas/SuggestionInput": `{"chords": "F Em7 A7 Dm G7", "comment": "Missing the G7", "author": "Ringo"}`,
+		"#/components/schemas/NewToken":        `{"user": "contract", "scopes": ["songs:write"], "expires": "2099-01-01T00:00:00Z"}`,
+		"text/plain":                           "F Em7 A7 Dm Dm/C",
+	}
+	// Operations are called in this order, so the write operations don't
+	// remove the data needed by the others.
+	contractMethods = []string{"get", "post", "put", "patch", "delete"}
+)
+
+// TestOpenAPIContract calls every operation in the OpenAPI document, and
+// checks the responses match the spec.
+func TestOpenAPIContract(t *testing.T) {
+	h, accounts := newV1TestServer(t)
+	_, err := accounts.AddUser("contract", auth.RoleContributor, "")
+	assert.Nil(t, err)
+	token, _, err := accounts.CreateToken("contract", "", auth.Scopes, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	ids := maps.Clone(contractIDs)
+	ids["/api/v1/tokens/"] = token.ID
+	// Suggestions can only be reviewed once, so accept and reject need
+	// different ones. Full path templates take priority over prefixes.
+	ids["/api/v1/suggestions/"] = addTestSuggestion(t, h, "Yesterday", "F Em7 A7 Dm7")
+	ids["/api/v1/suggestions/{id}/reject"] = addTestSuggestion(t, h, "Help", "A C#m F#m D G A")
+
+	resp := v1Request(h, http.MethodGet, "/api/v1/openapi.json", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	spec := map[string]any{}
+	err = json.NewDecoder(resp.Body).Decode(&spec)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0.3", spec["openapi"])
+
+	type operation struct {
+		method, path string
+		op           map[string]any
+	}
+	ops := []operation{}
+	for path, item := range spec["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			assert.Contains(t, contractMethods, method)
+			ops = append(ops, operation{method, path, op.(map[string]any)})
+		}
+	}
+	slices.SortFunc(ops, func(a, b operation) int {
+		if c := slices.Index(contractMethods, a.method) - slices.Index(contractMethods, b.method); c != 0 {
+			return c
+		}
+		return strings.Compare(a.path, b.path)
+	})
+	assert.Len(t, ops, len(v1Routes))
+
+	for _, o := range ops {
+		name := strings.ToUpper(o.method) + " " + o.path
+		path := strings.ReplaceAll(o.path, "{other}", contractOtherID)
+		if id, ok := ids[o.path]; ok {
+			path = strings.Replace(path, "{id}", id, 1)
+		}
+		for prefix, id := range ids {
+			if rest, ok := strings.CutPrefix(path, prefix); ok && strings.HasPrefix(rest, "{") {
+				_, after, _ := strings.Cut(rest, "}")
+				path = prefix + id + after
+			}
+		}
+		assert.NotContains(t, path, "{", name)
+
+		// Every documented query parameter should be accepted
+		query := []string{}
+		for _, p := range list(o.op["parameters"]) {
+			p := p.(map[string]any)
+			if p["in"] == "query" {
+				value := "x"
+				switch schemaType(p["schema"]) {
+				case "integer":
+					value = "1965"
+				case "boolean":
+					value = "true"
+				}
+				query = append(query, p["name"].(string)+"="+value)
+			}
+		}
+		if len(query) > 0 && o.method == "get" {
+			resp := v1Request(h, http.MethodGet, path+"?"+strings.Join(query, "&"), "", "")
+			checkResponse(t, spec, o.op, resp, name+" with query")
+		}
+
+		body := ""
+		if reqBody, ok := o.op["requestBody"].(map[string]any); ok {
+			for contentType, content := range reqBody["content"].(map[string]any) {
+				if contentType == "text/plain" {
+					body = contractBodies[contentType]
+				} else {
+					body = contractBodies[content.(map[string]any)["schema"].(map[string]any)["$ref"].(string)]
+				}
+			}
+			assert.NotEmpty(t, body, "no test body for %s", name)
+		}
+
+		if o.op["security"] != nil {
+			resp := v1Request(h, strings.ToUpper(o.method), path, "wrong", body)
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, name)
+			checkResponse(t, spec, o.op, resp, name+" unauthorised")
+		}
+
+		resp := v1Request(h, strings.ToUpper(o.method), path, testAuthKey, body)
+		assert.Less(t, resp.StatusCode, 300, name)
+		checkResponse(t, spec, o.op, resp, name)
+	}
+}
+
+// addTestSuggestion suggests new chords for a song, and returns the
+// suggestion's ID.
+func addTestSuggestion(t *testing.T, h http.Handler, songID, chords string) string {
+	body, err := json.Marshal(suggestionInput{Chords: chords})
+	assert.Nil(t, err)
+	resp := v1Request(h, http.MethodPost, "/api/v1/songs/"+songID+"/suggestions", "", string(body))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	suggestion := dblayer.Suggestion{}
+	err = json.NewDecoder(resp.Body).Decode(&suggestion)
+	assert.Nil(t, err)
+	return suggestion.ID
+}
+
+// checkResponse checks the response against the responses documented for
+// the operation.
+func checkResponse(t *testing.T, spec map[string]any, op map[string]any, resp *http.Response, name string) {
+	responses := op["responses"].(map[string]any)
+	documented, ok := responses[fmt.Sprint(resp.StatusCode)].(map[string]any)
+	if !ok {
+		documented = responses["default"].(map[string]any)
+		assert.GreaterOrEqual(t, resp.StatusCode, 400, "%s: undocumented status %d", name, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	content, ok := documented["content"].(map[string]any)
+	if !ok {
+		assert.Empty(t, body, "%s: body should be empty", name)
+		return
+	}
+
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	media, ok := content[contentType].(map[string]any)
+	if !assert.True(t, ok, "%s: undocumented content type %q", name, contentType) {
+		return
+	}
+	if contentType == "application/json" {
+		var value any
+		err := json.Unmarshal(body, &value)
+		assert.Nil(t, err, "%s: invalid JSON %s", name, body)
+		validateSchema(t, spec, media["schema"], value, name)
+	}
+}
+
+// validateSchema checks a JSON value against a schema. It handles the subset
+// of JSON Schema used in our OpenAPI document. Objects must not have any
+// undocumented properties.
+func validateSchema(t *testing.T, spec map[string]any, schema any, value any, path string) {
+	s := schema.(map[string]any)
+	if ref, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schemas := spec["components"].(map[string]any)["schemas"].(map[string]any)
+		if assert.Contains(t, schemas, name, path) {
+			validateSchema(t, spec, schemas[name], value, path)
+		}
+		return
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !assert.True(t, ok, "%s: expected object, got %#v", path, value) {
+			return
+		}
+		properties := s["properties"].(map[string]any)
+		for _, req := range list(s["required"]) {
+			assert.Contains(t, obj, req, "%s: missing required property", path)
+		}
+		for key, v := range obj {
+			if assert.Contains(t, properties, key, "%s: undocumented property", path) {
+				validateSchema(t, spec, properties[key], v, path+"."+key)
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !assert.True(t, ok, "%s: expected array, got %#v", path, value) {
+			return
+		}
+		for i, v := range arr {
+			validateSchema(t, spec, s["items"], v, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "string":
+		_, ok := value.(string)
+		assert.True(t, ok, "%s: expected string, got %#v", path, value)
+	case "integer":
+		n, ok := value.(float64)
+		assert.True(t, ok && n == math.Trunc(n), "%s: expected integer, got %#v", path, value)
+	case "boolean":
+		_, ok := value.(bool)
+		assert.True(t, ok, "%s: expected boolean, got %#v", path, value)
+	default:
+		t.Errorf("%s: unknown schema type %v", path, s["type"])
+	}
+}
+
+func list(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func schemaType(schema any) any {
+	return schema.(map[string]any)["type"]
+}
+
+func TestV1Errors(t *testing.T) {
+	h, _ := newV1TestServer(t)
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+		error              string
+	}{
+		{"GET", "/api/v1/songs/Nope", "", http.StatusNotFound, "no song found for id Nope"},
+		{"GET", "/api/v1/artists/Nope/songs", "", http.StatusNotFound, "no artist found for id Nope"},
+		{"PUT", "/api/v1/songs/Nope/chords", "C", http.StatusNotFound, "no song found for id Nope"},
+		{"DELETE", "/api/v1/artists/TheBeatles/related/PaulMccartney", "", http.StatusNotFound, "artists are not related"},
+		{"PUT", "/api/v1/artists/TheBeatles/related/Nope", "", http.StatusNotFound, "no artist found for id Nope"},
+		{"GET", "/api/v1/songs?colour=blue", "", http.StatusBadRequest, `unknown query parameter "colour"`},
+		{"GET", "/api/v1/albums?yearFrom=sixties", "", http.StatusBadRequest, `query parameter "yearFrom" must be an integer`},
+		{"POST", "/api/v1/songs", "{", http.StatusBadRequest, "invalid request body"},
+	} {
+		resp := v1Request(h, test.method, test.path, testAuthKey, test.body)
+		assert.Equal(t, test.status, resp.StatusCode, "%s %s", test.method, test.path)
+		body := v1Error{}
+		err := json.NewDecoder(resp.Body).Decode(&body)
+		assert.Nil(t, err)
+		assert.Contains(t, body.Error, test.error)
+	}
+
+	// Unsupported methods are rejected by the mux
+	resp := v1Request(h, http.MethodPost, "/api/v1/songs/Yesterday", testAuthKey, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestV1Songs(t *testing.T) {
+	h, _ := newV1TestServer(t)
+
+	// Chords are sent separately as plain text
+	resp := v1Request(h, http.MethodGet, "/api/v1/songs/Yesterday", "", "")
+	song := map[string]any{}
+	err := json.NewDecoder(resp.Body).Decode(&song)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"id": "Yesterday", "name": "Yesterday", "artist": "TheBeatles", "album": "Help",
+		"key": "F", "tags": []any{"acoustic"},
+	}, song)
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/songs/Yesterday/chords", "", "")
+	chords, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "F Em7 A7 Dm", string(chords))
+
+	// Adding a song generates an ID
+	resp = v1Request(h, http.MethodPost, "/api/v1/songs", testAuthKey, `{"name": "Let It Be", "artist": "The Beatles", "album": "Let It Be"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	added := data.Song{}
+	err = json.NewDecoder(resp.Body).Decode(&added)
+	assert.Nil(t, err)
+	assert.Equal(t, data.SongID("LetItBe"), added.ID)
+
+	// Filters
+	resp = v1Request(h, http.MethodGet, "/api/v1/artists/TheBeatles/albums", "", "")
+	albums := []data.Album{}
+	err = json.NewDecoder(resp.Body).Decode(&albums)
+	assert.Nil(t, err)
+	assert.Len(t, albums, 2)
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/songs?yearFrom=1960&yearTo=1969", "", "")
+	songs := []data.Song{}
+	err = json.NewDecoder(resp.Body).Decode(&songs)
+	assert.Nil(t, err)
+	ids := []data.SongID{}
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+	assert.ElementsMatch(t, []data.SongID{"Help", "Yesterday"}, ids)
+}
+
+func TestV1Roles(t *testing.T) {
+	h, accounts := newV1TestServer(t)
+	basic := func(name, password string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(name, password)
+		return r.Header.Get("Authorization")
+	}
+
+	// Anonymous users can't write
+	resp := v1Request(h, http.MethodPost, "/api/v1/songs", "", `{"name": "Michelle"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/me", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Users are managed with the AUTH_KEY
+	resp = v1Request(h, http.MethodPost, "/api/v1/users", testAuthKey, `{"name": "alice", "role": "contributor", "password": "pw"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = v1Request(h, http.MethodPost, "/api/v1/users", testAuthKey, `{"name": "alice", "role": "viewer"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = v1Request(h, http.MethodPost, "/api/v1/users", testAuthKey, `{"name": "bob", "role": "boss"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	alice := basic("alice", "pw")
+	resp = v1Request(h, http.MethodGet, "/api/v1/me", alice, "")
+	user := auth.User{}
+	err := json.NewDecoder(resp.Body).Decode(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Name)
+	assert.Equal(t, auth.RoleContributor, user.Role)
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/me", basic("alice", "wrong"), "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Contributors can add and edit songs, but not delete them
+	resp = v1Request(h, http.MethodPost, "/api/v1/songs", alice, `{"name": "Michelle", "artist": "The Beatles"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = v1Request(h, http.MethodPut, "/api/v1/songs/Michelle/chords", alice, "F Bbm")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, path := range []string{"/api/v1/songs/Michelle", "/api/v1/users/alice"} {
+		resp = v1Request(h, http.MethodDelete, path, alice, "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, path)
+	}
+
+	// Tokens have the user's current role, and are limited to their scopes
+	token, secret, err := accounts.CreateToken("alice", "laptop", []auth.Scope{auth.ScopeSongsWrite}, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	resp = v1Request(h, http.MethodPatch, "/api/v1/users/alice", testAuthKey, `{"role": "editor"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = v1Request(h, http.MethodPut, "/api/v1/songs/Michelle/chords", "Bearer "+secret, "F Bbm")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body := v1Error{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	assert.Nil(t, err)
+	assert.Contains(t, body.Error, "token needs the chords:write scope")
+	resp = v1Request(h, http.MethodDelete, "/api/v1/songs/Michelle", "Bearer "+secret, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/me", "Bearer "+secret, "")
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, []auth.Scope{auth.ScopeSongsWrite}, user.Scopes)
+
+	resp = v1Request(h, http.MethodDelete, "/api/v1/tokens/"+token.ID, testAuthKey, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/me", "Bearer "+secret, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestV1Suggestions(t *testing.T) {
+	h, _ := newV1TestServer(t)
+
+	// Anyone can suggest a correction, but only to an existing song, and
+	// only if it changes something
+	id := addTestSuggestion(t, h, "Yesterday", "F Em7 A7 Dm G7")
+	resp := v1Request(h, http.MethodPost, "/api/v1/songs/Yesterday/suggestions", "", `{"chords": "F Em7 A7 Dm"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = v1Request(h, http.MethodPost, "/api/v1/songs/Michelle/suggestions", "", `{"chords": "F Bbm"}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = v1Request(h, http.MethodPost, "/api/v1/songs/Yesterday/suggestions", "", `{"chords": "`+strings.Repeat(`F\n`, 2000)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	body := v1Error{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "chords must be at most 2000 lines", body.Error)
+
+	// Only maintainers can see the queue
+	resp = v1Request(h, http.MethodGet, "/api/v1/suggestions", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/suggestions?status=pending&song=Yesterday", testAuthKey, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	suggestions := []dblayer.Suggestion{}
+	err := json.NewDecoder(resp.Body).Decode(&suggestions)
+	assert.Nil(t, err)
+	assert.Len(t, suggestions, 1)
+	assert.Equal(t, id, suggestions[0].ID)
+	assert.Contains(t, suggestions[0].Diff, "+F Em7 A7 Dm G7")
+
+	// If the chords change, the suggestion is stale, and can only be
+	// accepted with force
+	resp = v1Request(h, http.MethodPut, "/api/v1/songs/Yesterday/chords", testAuthKey, "F Em7 A7 Dm Bb")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/suggestions/"+id, testAuthKey, "")
+	preview := suggestionPreview{}
+	err = json.NewDecoder(resp.Body).Decode(&preview)
+	assert.Nil(t, err)
+	assert.True(t, preview.Stale)
+	assert.Contains(t, preview.CurrentDiff, "-F Em7 A7 Dm Bb")
+
+	resp = v1Request(h, http.MethodPost, "/api/v1/suggestions/"+id+"/accept", testAuthKey, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = v1Request(h, http.MethodPost, "/api/v1/suggestions/"+id+"/accept?force=true", testAuthKey, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/songs/Yesterday/chords", "", "")
+	chords, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "F Em7 A7 Dm G7", string(chords))
+
+	// Suggestions can only be reviewed once
+	resp = v1Request(h, http.MethodPost, "/api/v1/suggestions/"+id+"/reject", testAuthKey, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = v1Request(h, http.MethodGet, "/api/v1/suggestions/nope", testAuthKey, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestV1Audit(t *testing.T) {
+	h, accounts := newV1TestServer(t)
+	_, err := accounts.AddUser("alice", auth.RoleEditor, "")
+	assert.Nil(t, err)
+	_, secret, err := accounts.CreateToken("alice", "", []auth.Scope{auth.ScopeChordsWrite, auth.ScopeAudit}, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+
+	resp := v1Request(h, http.MethodPut, "/api/v1/songs/Help/chords", "Bearer "+secret, "A C#m F#m D G A")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = v1Request(h, http.MethodPut, "/api/v1/artists/TheBeatles/related/PaulMccartney", testAuthKey, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Only admins can read the audit log
+	resp = v1Request(h, http.MethodGet, "/api/v1/audit", "Bearer "+secret, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/audit?actor=alice&since=2000-01-01T00:00:00Z", testAuthKey, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	entries := []dblayer.AuditEntry{}
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, dblayer.AuditChordsUpdate, entries[0].Action)
+		assert.Equal(t, "Help", entries[0].Target)
+		assert.Contains(t, entries[0].Diff, "+A C#m F#m D G A")
+	}
+
+	resp = v1Request(h, http.MethodGet, "/api/v1/audit?action=relation.add&limit=5", testAuthKey, "")
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, auth.AuthKeyUser, entries[0].Actor)
+		assert.Equal(t, "The Beatles", entries[0].Target)
+		assert.Equal(t, "Paul McCartney", entries[0].Other)
+	}
+
+	for _, query := range []string{"action=song.explode", "since=yesterday"} {
+		resp = v1Request(h, http.MethodGet, "/api/v1/audit?"+query, testAuthKey, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
@@ -0,0 +1,139 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/cache.go
+// HTTP caching. GET responses get a Cache-Control policy for their route,
+// and a strong ETag from a hash of their content, so clients can revalidate
+// them with If-None-Match and get 304 Not Modified if they haven't changed.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Cache-Control policies.
+const (
+	// cacheRevalidate lets clients keep responses, but they must check
+	// they're up to date (cheaply, with the ETag) before using them.
+	cacheRevalidate = "no-cache"
+	// cacheShort lets clients use responses for a short time before
+	// checking them again.
+	cacheShort = "public, max-age=30"
+	// cacheDay is for files which rarely change, but aren't versioned.
+	cacheDay = "public, max-age=86400"
+	// cacheImmutable is for versioned files, which never change.
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// cacheNever is for responses which are different every time.
+	cacheNever = "no-store"
+)
+
+// cachePolicies are the Cache-Control policies for GET requests, by route.
+// Handlers can override them by setting the header themselves. Other routes
+// are left alone, and don't get ETags.
+var cachePolicies = map[string]string{
+	// Listings rarely change, and it's fine if they are a little out of
+	// date
+	"/api/v0/artists":              cacheShort,
+	"/api/v0/songs":                cacheShort,
+	"/api/v0/see-also":             cacheShort,
+	"/api/v0/search":               cacheShort,
+	"/api/v1/artists":              cacheShort,
+	"/api/v1/artists/{id}":         cacheShort,
+	"/api/v1/artists/{id}/albums":  cacheShort,
+	"/api/v1/artists/{id}/songs":   cacheShort,
+	"/api/v1/artists/{id}/related": cacheShort,
+	"/api/v1/albums":               cacheShort,
+	"/api/v1/albums/{id}":          cacheShort,
+	"/api/v1/albums/{id}/songs":    cacheShort,
+	"/api/v1/songs":                cacheShort,
+	"/api/v1/songs/{id}":           cacheShort,
+	"/api/v1/search":               cacheShort,
+	"/api/v1/openapi.json":         cacheShort,
+	"/api/v0/random":               cacheNever,
+	"/b/random":                    cacheNever,
+	"/favicon.ico":                 cacheDay,
+
+	// Chords and pages are always checked, so edits show up straight away
+	"/api/v0/chords":            cacheRevalidate,
+	"/api/v1/songs/{id}/chords": cacheRevalidate,
+	"/b/artists":                cacheRevalidate,
+	"/b/songs":                  cacheRevalidate,
+	"/b/chords":                 cacheRevalidate,
+	"/b/search":                 cacheRevalidate,
+	"/c/artists":                cacheRevalidate,
+	"/c/songs":                  cacheRevalidate,
+	"/c/chords":                 cacheRevalidate,
+	// Versioned requests for these are immutable (see serveAsset)
+	"/c/style.css": cacheRevalidate,
+	"/c/search.js": cacheRevalidate,
+}
+
+// serveCached serves a GET request to a route with a cache policy. The
+// response is buffered, so it can be given an ETag, and then sent using
+// http.ServeContent, which handles conditional and HEAD requests. The
+// Last-Modified time is taken from the header, if the handler set it.
+func (h *handler) serveCached(w http.ResponseWriter, r *http.Request, policy string) {
+	cw := &cacheWriter{ResponseWriter: w}
+	h.mux.ServeHTTP(cw, r)
+	if cw.status == 0 {
+		// Nothing was written
+		cw.status = http.StatusOK
+	}
+
+	header := w.Header()
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", policy)
+	}
+	if cw.status != http.StatusOK || policy == cacheNever {
+		w.WriteHeader(cw.status)
+		w.Write(cw.body.Bytes())
+		return
+	}
+
+	body := cw.body.Bytes()
+	header.Set("ETag", contentETag(body))
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+// contentETag returns a strong ETag for content.
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setLastModified sets the Last-Modified header, unless t is unknown. It
+// must be called before the response is written.
+func setLastModified(w http.ResponseWriter, t time.Time) {
+	if !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
+
+// cacheWriter buffers a response, for serveCached.
+type cacheWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	return cw.body.Write(p)
+}
@@ -0,0 +1,241 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/compress.go
+// Compresses responses with brotli or gzip, whichever the client prefers
+// (from its Accept-Encoding header). Only text-like responses big enough to
+// benefit are compressed.
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodings are the supported content codings, in order of preference.
+var encodings = []string{"br", "gzip"}
+
+// compressibleTypes are the prefixes of the content types worth compressing.
+// Images and archives are already compressed.
+var compressibleTypes = []string{
+	"text/html", "text/css", "text/plain", "text/javascript",
+	"application/json", "application/javascript", "application/graphql-response+json",
+	"image/svg+xml",
+}
+
+// minCompressSize is the smallest response body worth compressing.
+const minCompressSize = 1024
+
+// negotiateEncoding returns the supported encoding the client prefers,
+// according to an Accept-Encoding header, or "" to not compress.
+func negotiateEncoding(acceptEncoding string) string {
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		qs[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range encodings {
+		q, ok := qs[enc]
+		if !ok {
+			q = qs["*"]
+		}
+		// Ties go to the encoding listed first
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressible returns true if a request's response may be compressed.
+// Upgraded connections (the GraphQL websocket) and HEAD responses are left
+// alone.
+func compressible(r *http.Request) bool {
+	return (r.Method == http.MethodGet && r.Header.Get("Upgrade") == "") ||
+		r.Method == http.MethodPost
+}
+
+// stripEncodingETags removes the suffixes which compressWriter adds to
+// ETags from the request's If-None-Match header, so it matches the ETag of
+// the uncompressed content (see serveCached).
+func stripEncodingETags(r *http.Request) {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return
+	}
+	for _, enc := range encodings {
+		inm = strings.ReplaceAll(inm, "-"+enc+`"`, `"`)
+	}
+	r.Header.Set("If-None-Match", inm)
+}
+
+// compressWriter compresses a response with the given encoding. It buffers
+// the start of the body, until it's written enough to decide whether to
+// compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	// encoding is the negotiated encoding, or "" if the client doesn't
+	// accept any.
+	encoding string
+
+	// status is the status code, before it's sent.
+	status int
+	// buf is the start of the body, before deciding to compress it.
+	buf     []byte
+	decided bool
+	// enc compresses the body, or is nil if it isn't being compressed.
+	enc io.WriteCloser
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{ResponseWriter: w, encoding: encoding}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || status < http.StatusOK {
+		// Informational responses are sent straight away
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+	if status != http.StatusOK {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.write(p)
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= minCompressSize {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sets the response headers, choosing whether to compress the
+// response, and writes out the buffered start of the body. Small bodies
+// aren't compressed, unless they are being streamed.
+func (cw *compressWriter) decide(streaming bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if cw.status == http.StatusOK && len(cw.buf) > 0 && header.Get("Content-Type") == "" {
+		// As net/http would, but it can't once the body is compressed
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	switch {
+	case cw.status == http.StatusNotModified && cw.encoding != "":
+		// The client may have a compressed copy
+		cw.tagETag()
+	case cw.status != http.StatusOK || !compressibleType(header.Get("Content-Type")):
+	case header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "":
+	default:
+		header.Add("Vary", "Accept-Encoding")
+		if cw.encoding != "" && (len(cw.buf) >= minCompressSize || streaming) {
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+			cw.tagETag()
+			cw.enc = newEncoder(cw.encoding, cw.ResponseWriter)
+		}
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.write(buf)
+	return err
+}
+
+// tagETag adds the encoding to the ETag, as the compressed content is a
+// different representation.
+func (cw *compressWriter) tagETag() {
+	if etag := cw.Header().Get("ETag"); strings.HasSuffix(etag, `"`) {
+		cw.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+	}
+}
+
+// Flush implements http.Flusher, for streamed responses. They are
+// compressed, even if what has been written so far is small.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response, once the handler has returned.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func compressibleType(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == "br" {
+		// The default quality (6) is too slow for dynamic responses
+		return brotli.NewWriterLevel(w, 4)
+	}
+	return gzip.NewWriter(w)
+}
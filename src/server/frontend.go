@@ -10,12 +10,19 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/barrettj12/chords/src/client"
 	"github.com/barrettj12/chords/src/dblayer"
@@ -49,20 +56,25 @@ func (f *Frontend) registerHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/b/search", f.searchHandler)
 
 	// New frontend
-	mux.HandleFunc("/c/style.css", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "src/frontend/style.css")
-	})
-	mux.HandleFunc("/c/search.js", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "src/frontend/search.js")
-	})
+	for _, name := range staticAssets {
+		mux.HandleFunc("/c/"+name, func(w http.ResponseWriter, r *http.Request) {
+			serveAsset(w, r, name)
+		})
+	}
 	mux.HandleFunc("/c/artists", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "src/frontend/artists.html")
+		servePage(w, r, "artists.html")
 	})
 	mux.HandleFunc("/c/songs", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "src/frontend/songs.html")
+		servePage(w, r, "songs.html")
 	})
 	mux.HandleFunc("/c/chords", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "src/frontend/chords.html")
+		if id := r.URL.Query().Get("id"); id != "" {
+			songs, _ := f.client.GetSongs(nil, &id, nil)
+			if len(songs) == 0 && f.redirectAlias(w, r, id) {
+				return
+			}
+		}
+		servePage(w, r, "chords.html")
 	})
 
 	// Default redirect to frontend artists page
@@ -70,6 +82,68 @@ func (f *Frontend) registerHandlers(mux *http.ServeMux) {
 	mux.Handle("/", http.RedirectHandler("/c/artists", http.StatusTemporaryRedirect))
 }
 
+// frontendDir holds the new frontend's pages and static assets.
+const frontendDir = "src/frontend"
+
+// staticAssets are the files in frontendDir which the pages link to.
+var staticAssets = []string{"style.css", "search.js"}
+
+// servePage serves one of the new frontend's pages, with its links to static
+// assets versioned by their content, so the assets can be cached for good
+// (see serveAsset). The page's Last-Modified time is the latest of its
+// files' modification times.
+func servePage(w http.ResponseWriter, r *http.Request, name string) {
+	path := filepath.Join(frontendDir, name)
+	page, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	modTime := fileModTime(path)
+	for _, asset := range staticAssets {
+		assetPath := filepath.Join(frontendDir, asset)
+		versioned := fmt.Sprintf("%q", asset+"?v="+assetVersion(assetPath))
+		page = bytes.ReplaceAll(page, []byte(fmt.Sprintf("%q", asset)), []byte(versioned))
+		if t := fileModTime(assetPath); t.After(modTime) {
+			modTime = t
+		}
+	}
+	setLastModified(w, modTime)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// serveAsset serves a static asset. Requests for its current version, as
+// linked by servePage, can be cached forever.
+func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
+	path := filepath.Join(frontendDir, name)
+	if v := r.URL.Query().Get("v"); v != "" && v == assetVersion(path) {
+		w.Header().Set("Cache-Control", cacheImmutable)
+	}
+	http.ServeFile(w, r, path)
+}
+
+// assetVersion returns a hash of the file's content, or "" if it can't be
+// read.
+func assetVersion(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// fileModTime returns when the file was last modified, or the zero time if
+// it can't be read.
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
 func (f *Frontend) artistsHandler(w http.ResponseWriter, r *http.Request) {
 	artists, _ := f.client.GetArtists()
 	sortTitles(artists)
@@ -190,7 +264,9 @@ func (f *Frontend) chordsHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	songs, _ := f.client.GetSongs(nil, &id, nil)
 	if len(songs) == 0 {
-		http.NotFound(w, r)
+		if !f.redirectAlias(w, r, id) {
+			http.NotFound(w, r)
+		}
 		return
 	}
 	chords, _ := f.client.GetChords(id)
@@ -207,6 +283,31 @@ func (f *Frontend) chordsHandler(w http.ResponseWriter, r *http.Request) {
 	t.Execute(w, p)
 }
 
+// redirectAlias checks if id is the old ID of a song which has been renamed
+// or merged. If so, it redirects to the same page for the song's current ID,
+// and returns true. The transpose setting for the song (saved in a cookie
+// named after the song ID) is carried over.
+func (f *Frontend) redirectAlias(w http.ResponseWriter, r *http.Request, id string) bool {
+	newID, err := f.client.ResolveAlias(id)
+	if err != nil || newID == "" {
+		return false
+	}
+
+	if cookie, err := r.Cookie(id); err == nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:  newID,
+			Value: cookie.Value,
+			// Cookies set by the page's JavaScript use the page's directory
+			Path: path.Dir(r.URL.Path),
+		})
+	}
+
+	query := r.URL.Query()
+	query.Set("id", newID)
+	http.Redirect(w, r, r.URL.Path+"?"+query.Encode(), http.StatusMovedPermanently)
+	return true
+}
+
 // TODO: the JavaScript code should go in a separate .js file
 // Arguably, so should the HTML.
 const CHORDS_TEMPLATE = `
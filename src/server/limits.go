@@ -0,0 +1,270 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/limits.go
+// Protects the server from abuse: per-client rate limits on public routes
+// and password checks, and limits on the size of request bodies. GraphQL
+// operations are also limited by depth and complexity (see gqlgen.Limits).
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrettj12/chords/gqlgen"
+	"github.com/barrettj12/chords/src/metrics"
+)
+
+var rateLimited = metrics.NewCounter("chords_rate_limited_total",
+	"Requests rejected because the client exceeded the route's rate limit.", "route")
+
+// Limits stop clients from overloading the server.
+type Limits struct {
+	// Rates are the rate limits for each route, keyed by the route's path
+	// pattern, e.g. "/api/v1/songs/{id}/suggestions". Routes without a rate
+	// limit are unlimited.
+	Rates map[string]RateLimit
+	// Passwords is the rate at which each client can send requests with a
+	// password (Basic credentials), as checking a password is deliberately
+	// slow. Zero means no limit.
+	Passwords RateLimit
+	// ClientIPHeader is a header which a trusted proxy sets to the client's
+	// IP address, e.g. "Fly-Client-IP". If it's empty, or the request
+	// doesn't have the header, the address of the connection is used.
+	ClientIPHeader string
+	// MaxBody is the maximum size of request bodies, in bytes, except for
+	// imports, which are limited to MaxImportBody. Zero means no limit.
+	MaxBody       int64
+	MaxImportBody int64
+	GraphQL       gqlgen.Limits
+}
+
+// RateLimit allows each client to make Requests requests Per period, on
+// average, and up to Burst requests at once. Clients which run out are
+// sent 429 Too Many Requests, and told when to retry.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	// Burst is the size of the client's token bucket. If it's zero,
+	// Requests is used.
+	Burst int
+}
+
+// DefaultLimits apply to the public routes which do the most work, and to
+// suggestions, which anyone can make. The GraphQL limits allow any
+// reasonable query, including the playground's introspection query. The
+// config defaults are built from these (see config.Defaults).
+var DefaultLimits = Limits{
+	Rates: map[string]RateLimit{
+		"/api/v0/search":                 {Requests: 60, Per: time.Minute, Burst: 20},
+		"/api/v0/random":                 {Requests: 60, Per: time.Minute, Burst: 20},
+		"/api/v1/search":                 {Requests: 60, Per: time.Minute, Burst: 20},
+		"/api/v1/songs/{id}/suggestions": {Requests: 10, Per: time.Minute, Burst: 5},
+		"/graphql":                       {Requests: 120, Per: time.Minute, Burst: 30},
+	},
+	Passwords:     RateLimit{Requests: 20, Per: time.Minute, Burst: 10},
+	MaxBody:       1 << 20,
+	MaxImportBody: 256 << 20,
+	GraphQL:       gqlgen.Limits{MaxDepth: 10, MaxComplexity: 50000},
+}
+
+// SetLimits sets the server's rate, body size and GraphQL limits. It must
+// be called before the server starts.
+func (s *Server) SetLimits(l Limits) {
+	rates := maps.Clone(l.Rates)
+	if rates == nil {
+		rates = map[string]RateLimit{}
+	}
+	rates[passwordsLimit] = l.Passwords
+	s.handler.limits = l
+	s.handler.limiter = newRateLimiter(rates)
+}
+
+// passwordsLimit is the key of Limits.Passwords in the rate limiter. Routes
+// start with "/", so it can't clash with them.
+const passwordsLimit = "passwords"
+
+// interval is the time taken to earn a token.
+func (rl RateLimit) interval() time.Duration {
+	return rl.Per / time.Duration(rl.Requests)
+}
+
+func (rl RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return float64(rl.Requests)
+}
+
+// rateLimiter keeps a token bucket for each client of each rate-limited
+// route.
+type rateLimiter struct {
+	rates map[string]RateLimit
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	// swept is when full buckets were last removed.
+	swept time.Time
+}
+
+type bucketKey struct {
+	route, client string
+}
+
+// bucket holds tokens, which are added at the route's rate up to its burst,
+// and taken by each request.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rates map[string]RateLimit) *rateLimiter {
+	return &rateLimiter{
+		rates:   rates,
+		buckets: map[bucketKey]*bucket{},
+		swept:   time.Now(),
+	}
+}
+
+// allow takes a token from the client's bucket for the route. If there are
+// none left, it returns false, and how long until there will be one.
+func (l *rateLimiter) allow(route, client string) (bool, time.Duration) {
+	rate, ok := l.rates[route]
+	if !ok || rate.Requests <= 0 || rate.Per <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	key := bucketKey{route, client}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rate.burst()}
+		l.buckets[key] = b
+	} else {
+		earned := float64(now.Sub(b.updated)) / float64(rate.interval())
+		b.tokens = min(rate.burst(), b.tokens+earned)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(rate.interval()))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets which have filled up again, at most once a minute,
+// so clients which have stopped sending requests don't use up memory. The
+// caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		rate := l.rates[key.route]
+		refill := time.Duration((rate.burst() - b.tokens) * float64(rate.interval()))
+		if now.Sub(b.updated) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the address which requests are rate limited by.
+func (h *handler) clientIP(r *http.Request) string {
+	if name := h.limits.ClientIPHeader; name != "" {
+		if ip := strings.TrimSpace(r.Header.Get(name)); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkLimits applies the rate limits for the request's route and for
+// password checks, and the limit on its body size. If the request is over a
+// limit, it writes out an error and returns false.
+func (h *handler) checkLimits(w http.ResponseWriter, r *http.Request, route string) bool {
+	if h.limiter != nil {
+		if ok, wait := h.limiter.allow(route, h.clientIP(r)); !ok {
+			rateLimited.Inc(route)
+			// Round up, so clients don't retry too early
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			limitError(w, r, http.StatusTooManyRequests, "rate limit exceeded, try again later")
+			return false
+		}
+		// Passwords are checked on any route, so they are limited separately
+		if hasPassword(r) {
+			if ok, wait := h.limiter.allow(passwordsLimit, h.clientIP(r)); !ok {
+				rateLimited.Inc(passwordsLimit)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				limitError(w, r, http.StatusTooManyRequests, "too many password attempts, try again later")
+				return false
+			}
+		}
+	}
+
+	// Only writes have bodies worth limiting
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	limit := h.limits.MaxBody
+	if r.URL.Path == "/api/v0/import" {
+		limit = h.limits.MaxImportBody
+	}
+	if limit <= 0 {
+		return true
+	}
+	if r.ContentLength > limit {
+		limitError(w, r, http.StatusRequestEntityTooLarge, bodyTooLarge(limit))
+		return false
+	}
+	// Bodies without a Content-Length are cut off at the limit, and
+	// handlers respond with 413 (see readBody and writeErrorV1).
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// hasPassword returns true if the request has Basic credentials, which
+// are checked against a password hash (see auth.Accounts.Authenticate).
+func hasPassword(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	return strings.EqualFold(scheme, "Basic")
+}
+
+// limitError writes an error for a request which is over a limit, as JSON
+// for the v1 API, and plain text otherwise.
+func limitError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		http.Error(w, msg, status)
+		return
+	}
+	jData, _ := json.Marshal(v1Error{msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jData)
+}
+
+func bodyTooLarge(limit int64) string {
+	return fmt.Sprintf("request body is larger than %d bytes", limit)
+}
@@ -0,0 +1,229 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/openapi.go
+// Generates the OpenAPI 3 document for the v1 API from the route table.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// openAPISpec generates the OpenAPI document describing the given routes.
+// The schemas for the request and response bodies are generated from the Go
+// types using their JSON tags.
+func openAPISpec(routes []v1Route) map[string]any {
+	schemas := schemaGen{"Error": map[string]any{
+		"type":       "object",
+		"required":   []string{"error"},
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}}
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": ref("Error")},
+			},
+		}
+	}
+
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		status := route.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if route.response != nil {
+			success["content"] = schemas.content(route.response)
+		}
+		responses := map[string]any{
+			fmt.Sprint(status): success,
+			"default":          errorResponse("Error"),
+		}
+
+		op := map[string]any{
+			"summary":     route.summary,
+			"operationId": strings.ToLower(route.method) + operationName(route.path),
+			"responses":   responses,
+		}
+
+		params := []any{}
+		for _, name := range pathParams(route.path) {
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		for _, p := range route.query {
+			typ := "string"
+			switch {
+			case p.integer:
+				typ = "integer"
+			case p.boolean:
+				typ = "boolean"
+			}
+			params = append(params, map[string]any{
+				"name":        p.name,
+				"in":          "query",
+				"description": p.description,
+				"schema":      map[string]any{"type": typ},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if route.request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  schemas.content(route.request),
+			}
+		}
+		if route.role != "" {
+			op["description"] = fmt.Sprintf("Requires the %s role.", route.role)
+			if route.scope != "" {
+				op["description"] = fmt.Sprintf("Requires the %s role. API tokens need the %s scope.", route.role, route.scope)
+			}
+			op["security"] = []any{
+				map[string]any{"bearerAuth": []string{}},
+				map[string]any{"basicAuth": []string{}},
+			}
+			responses["401"] = errorResponse("Unauthorised")
+			responses["403"] = errorResponse("Forbidden")
+		}
+
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]any{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Jordy's Chordies API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+var pathParamRegexp = regexp.MustCompile(`{(\w+)}`)
+
+// pathParams returns the names of the parameters in a path.
+func pathParams(path string) []string {
+	names := []string{}
+	for _, match := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// operationName turns a path into a name for the operation, e.g.
+// "/api/v1/songs/{id}/chords" -> "SongsIdChords".
+func operationName(path string) string {
+	name := ""
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/api/v1/"), "/") {
+		part = strings.Trim(part, "{}")
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return name
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaGen generates schemas for Go types. Named struct types are added to
+// the map, and referred to by name.
+type schemaGen map[string]any
+
+// content returns the content object for a request or response body of the
+// given type.
+func (g schemaGen) content(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.String || t == reflect.TypeFor[[]byte]() {
+		return map[string]any{
+			"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+		}
+	}
+	return map[string]any{
+		"application/json": map[string]any{"schema": g.schema(t)},
+	}
+}
+
+func (g schemaGen) schema(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[time.Time]() {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int:
+		return map[string]any{"type": "integer"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Struct:
+		// Unexported types (e.g. request bodies) are capitalised
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g[name]; !ok {
+			schema := map[string]any{"type": "object"}
+			g[name] = schema // added first, in case the type is recursive
+			properties := map[string]any{}
+			required := []string{}
+			g.addFields(t, properties, &required)
+			schema["properties"] = properties
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+		}
+		return ref(name)
+	}
+	panic(fmt.Sprintf("no schema for type %s", t))
+}
+
+// addFields adds the JSON fields of a struct type to the properties.
+// Embedded structs' fields are added too, as encoding/json does.
+func (g schemaGen) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			g.addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if opts != "omitempty" && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
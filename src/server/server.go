@@ -10,6 +10,7 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	_ "embed"
@@ -17,47 +18,131 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	gqlhandle "github.com/99designs/gqlgen/graphql/handler"
 	gqlplay "github.com/99designs/gqlgen/graphql/playground"
 	"github.com/barrettj12/chords/gqlgen"
+	"github.com/barrettj12/chords/src/auth"
 	"github.com/barrettj12/chords/src/data"
 	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("chords_http_requests_total",
+		"HTTP requests handled.", "route", "method", "status")
+	httpDuration = metrics.NewHistogram("chords_http_request_duration_seconds",
+		"Time taken to handle HTTP requests.", metrics.DefaultBuckets, "route", "method", "status")
 )
 
 type Server struct {
 	httpServer http.Server
 	listener   net.Listener
-	logger     *log.Logger
+	logger     *slog.Logger
 	api        *ChordsAPI
+	handler    *handler
+	// metricsServer serves metrics on a separate, private listener, if
+	// SetMetricsAddr was called.
+	metricsServer   *http.Server
+	metricsListener net.Listener
+	// cancel cancels the context of every request, when shutting down.
+	cancel context.CancelFunc
+}
+
+// Timeouts limit how long the server spends on each connection. Zero means
+// no limit.
+type Timeouts struct {
+	// ReadHeader is the time allowed to read a request's headers, and Read
+	// the time allowed to read the whole request, including the body.
+	ReadHeader time.Duration
+	Read       time.Duration
+	// Write is the time allowed from the end of reading the request's
+	// headers to the end of writing the response.
+	Write time.Duration
+	// Idle is how long to keep idle keep-alive connections open.
+	Idle time.Duration
+}
+
+// DefaultTimeouts are long enough for exports and imports of a large
+// database, but stop slow clients from holding connections open forever.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       time.Minute,
+	Write:      2 * time.Minute,
+	Idle:       2 * time.Minute,
 }
 
-// New returns a new Server with the specified DB and address. `logFlags` is
-// as provided to log.New - see https://pkg.go.dev/log#pkg-constants
-func New(db dblayer.ChordsDB, addr string, logger *log.Logger, authKey string) (*Server, error) {
+// New returns a new Server with the specified DB and address. Each request
+// is logged to logger (see handler.ServeHTTP).
+func New(db dblayer.ChordsDB, addr string, logger *slog.Logger, accounts *auth.Accounts) (*Server, error) {
 	frontend, err := NewFrontend(fmt.Sprintf("http://localhost%s", addr))
 	if err != nil {
 		return nil, err
 	}
-	api := ChordsAPI{db, logger, authKey}
 
-	return &Server{
+	// The v1 API needs artist and album records. If db doesn't already
+	// have them, they are built in memory.
+	lib, err := data.LibraryFor(db)
+	if err != nil {
+		return nil, err
+	}
+	api := ChordsAPI{lib.V0(), lib, logger, accounts}
+	h := newHandler(logger, &api, frontend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
 		httpServer: http.Server{
-			Addr: addr,
-			Handler: newHandler(
-				logger,
-				&api,
-				frontend,
-			),
+			Addr:        addr,
+			Handler:     h,
+			BaseContext: func(net.Listener) context.Context { return ctx },
 		},
-		logger: logger,
-		api:    &api,
-	}, nil
+		logger:  logger,
+		api:     &api,
+		handler: h,
+		cancel:  cancel,
+	}
+	s.SetTimeouts(DefaultTimeouts)
+	s.SetLimits(DefaultLimits)
+	return s, nil
+}
+
+// SetTimeouts sets the server's connection timeouts. It must be called
+// before the server starts.
+func (s *Server) SetTimeouts(t Timeouts) {
+	s.httpServer.ReadHeaderTimeout = t.ReadHeader
+	s.httpServer.ReadTimeout = t.Read
+	s.httpServer.WriteTimeout = t.Write
+	s.httpServer.IdleTimeout = t.Idle
+}
+
+// SetBodyLogging logs up to maxBytes of each request and response body, for
+// debugging. 0 (the default) turns body logging off. It must be called
+// before the server starts.
+func (s *Server) SetBodyLogging(maxBytes int) {
+	s.handler.maxBodyLog = maxBytes
+}
+
+// SetMetricsAddr serves Prometheus metrics at /metrics on a separate
+// listener at addr, which shouldn't be exposed publicly, as the metrics
+// aren't authenticated. If it isn't called, metrics aren't served. It must
+// be called before the server starts.
+func (s *Server) SetMetricsAddr(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	s.metricsServer = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: DefaultTimeouts.ReadHeader,
+	}
 }
 
 // Listen opens a network connection (non-blocking) and returns the address
@@ -70,16 +155,34 @@ func (s *Server) Listen() (net.Addr, error) {
 		return nil, err
 	}
 
-	s.logger.Printf("server now listening at %s", ln.Addr())
+	if s.metricsServer != nil {
+		mln, err := net.Listen("tcp", s.metricsServer.Addr)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listening for metrics: %w", err)
+		}
+		s.logger.Info("serving metrics", "addr", mln.Addr().String())
+		s.metricsListener = mln
+	}
+
+	s.logger.Info("server listening", "addr", ln.Addr().String())
 	s.listener = ln
 	return ln.Addr(), nil
 }
 
 // Serve serves the HTTP server (blocking)
 func (s *Server) Serve() error {
+	if s.metricsListener != nil {
+		go func() {
+			err := s.metricsServer.Serve(s.metricsListener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("serving metrics", "error", err)
+			}
+		}()
+	}
 	closeErr := s.httpServer.Serve(s.listener)
 	if errors.Is(closeErr, http.ErrServerClosed) {
-		s.logger.Println("server closed")
+		s.logger.Info("server closed")
 		return nil
 	}
 	return closeErr
@@ -95,86 +198,285 @@ func (s *Server) Run() error {
 
 // Shuts down the HTTP server - necessary for running tests back-to-back.
 func (s *Server) Kill() error {
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
 	return s.httpServer.Shutdown(context.Background())
 }
 
+// Shutdown shuts down the server gracefully. It stops accepting connections,
+// waits for requests in progress to finish, and then closes the database.
+// If ctx ends before the requests finish, they are cancelled, and the
+// database is closed anyway.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("shutting down")
+	if s.metricsServer != nil {
+		// Metrics can be scraped until the end, but not after
+		defer s.metricsServer.Close()
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.logger.Warn("requests still in progress", "error", err)
+	}
+	// End long-running requests, like GraphQL subscriptions, which
+	// Shutdown doesn't wait for
+	s.cancel()
+
+	if closeErr := s.api.db.Close(); closeErr != nil {
+		s.logger.Error("closing database", "error", closeErr)
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
 // handler does some extra post-request / pre-response handling common
 // to all requests - see the ServeHTTP method below.
 type handler struct {
-	logger *log.Logger
+	logger *slog.Logger
 	mux    *http.ServeMux
+	// maxBodyLog is the maximum number of bytes of each request and response
+	// body to log. If it's 0, bodies aren't logged.
+	maxBodyLog int
+	// limits and limiter protect the server from abuse (see limits.go).
+	limits  Limits
+	limiter *rateLimiter
+	// importMu is held exclusively while a snapshot is imported, and shared
+	// by other requests which may write, so they can't interleave with the
+	// import.
+	importMu sync.RWMutex
 }
 
-func newHandler(logger *log.Logger, api *ChordsAPI, frontend *Frontend) handler {
+func newHandler(logger *slog.Logger, api *ChordsAPI, frontend *Frontend) *handler {
+	h := &handler{logger: logger}
+
 	// Set up mux
 	mux := http.NewServeMux()
 
 	// Register API endpoints
-	mux.HandleFunc("/api/v0/artists", api.artistsHandler)  // list artists in database
-	mux.HandleFunc("/api/v0/songs", api.songsHandler)      // song metadata API
-	mux.HandleFunc("/api/v0/chords", api.chordsHandler)    // view/update a chord sheet
-	mux.HandleFunc("/api/v0/see-also", api.seeAlsoHandler) // get related artists
-	mux.HandleFunc("/api/v0/random", api.randomHandler)    // get random chords
-	mux.HandleFunc("/api/v0/search", api.searchHandler)    // search chords
+	mux.HandleFunc("/api/v0/artists", api.artistsHandler)       // list artists in database
+	mux.HandleFunc("/api/v0/songs", api.songsHandler)           // song metadata API
+	mux.HandleFunc("/api/v0/chords", api.chordsHandler)         // view/update a chord sheet
+	mux.HandleFunc("/api/v0/see-also", api.seeAlsoHandler)      // get related artists
+	mux.HandleFunc("/api/v0/random", api.randomHandler)         // get random chords
+	mux.HandleFunc("/api/v0/search", api.searchHandler)         // search chords
+	mux.HandleFunc("/api/v0/export", api.exportHandler)         // download a snapshot of the database
+	mux.HandleFunc("/api/v0/import", api.importHandler)         // restore a snapshot of the database
+	mux.HandleFunc("/api/v0/trash", api.trashHandler)           // list/purge deleted songs
+	mux.HandleFunc("/api/v0/trash/restore", api.restoreHandler) // restore a deleted song
+	mux.HandleFunc("/api/v0/songs/rename", api.renameHandler)   // change a song's ID
+	mux.HandleFunc("/api/v0/songs/merge", api.mergeHandler)     // merge duplicate songs
+	mux.HandleFunc("/api/v0/aliases", api.aliasesHandler)       // look up a song's old ID
+
+	// Register v1 API endpoints (see apiv1.go)
+	api.registerV1(mux)
 
 	// Favicon
 	mux.HandleFunc("/favicon.ico", serveFavicon)
 
+	// Monitoring endpoints. Metrics are served separately - see
+	// SetMetricsAddr.
+	mux.HandleFunc("/healthz", serveHealthz)     // the process is alive
+	mux.HandleFunc("/readyz", api.readyzHandler) // the database is ready
+
 	// Register frontend endpoints
 	frontend.registerHandlers(mux)
 
 	// GraphQL endpoints
-	mux.Handle("/graphql", gqlhandle.NewDefaultServer(gqlgen.NewExecutableSchema(gqlgen.Config{
-		Resolvers: &gqlgen.Resolver{
-			DB: data.AsChordsDBv1(api.db),
-		}})))
+	mux.Handle("/graphql", api.graphQLHandler(gqlgen.NewHandler(api.v1, &h.limits.GraphQL)))
 	mux.Handle("/graphql/playground", gqlplay.Handler("GraphQL playground", "/graphql"))
 
-	return handler{
-		logger: logger,
-		mux:    mux,
-	}
+	h.mux = mux
+	return h
 }
 
-// ServeHTTP implements http.Handler.
-func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Log request
-	h.logger.Printf("request: %s %s from %s\n", r.Method, r.RequestURI, r.RemoteAddr)
+// ServeHTTP implements http.Handler. It assigns each request an ID, and
+// logs the request once it has been handled, with its status and latency.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 
-	// Log body (for debugging)
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		h.logger.Printf("ERROR reading request body: %s\n", err)
-		http.Error(w, "reading request body", http.StatusInternalServerError)
-		return
+	// Keep the client's request ID if it sent a sensible one, so requests
+	// can be traced through proxies.
+	id := r.Header.Get("X-Request-ID")
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+	// Copy the start of the bodies as they are read and written, if they
+	// are being logged. Only API responses are logged.
+	logBodies := h.maxBodyLog > 0 && logBody(r.URL.Path)
+	var reqBody *cappedBuffer
+	if logBodies {
+		reqBody = &cappedBuffer{max: h.maxBodyLog}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+	maxRespLog := 0
+	if logBodies && strings.HasPrefix(r.URL.Path, "/api") {
+		maxRespLog = h.maxBodyLog
 	}
 
-	r.Body.Close()
-	h.logger.Printf("request body:\n%s\n", body)
-	r.Body = io.NopCloser(bytes.NewReader(body))
-
-	// Duplicate writer so we can view response
-	// Only want to log API responses
-	if strings.HasPrefix(r.URL.Path, "/api") {
-		w2 := NewResponseWriterWrapper(w)
-		defer func() {
-			resp := w2.String()
-			h.logger.Printf("sending response:\n%s\n", resp)
-		}()
-		w = w2
+	// Compress the response if the client accepts it. The response is
+	// logged before it's compressed.
+	stripEncodingETags(r)
+	var cw *compressWriter
+	if compressible(r) {
+		cw = newCompressWriter(w, negotiateEncoding(r.Header.Get("Accept-Encoding")))
+		w = cw
 	}
+	rww := NewResponseWriterWrapper(w, maxRespLog)
 
 	// Add CORS header
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, pattern := h.mux.Handler(r)
+	route := routeLabel(pattern)
+	policy, cached := cachePolicies[route]
+	switch {
+	case !h.checkLimits(rww, r, route):
+		// The request was rejected
+	case cached && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.serveCached(rww, r, policy)
+	default:
+		h.serveLocked(rww, r, route)
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			h.logger.Warn("compressing response", "request_id", id, "error", err)
+		}
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("query", r.URL.RawQuery),
+		slog.Int("status", rww.Status()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", rww.Written()),
+		slog.String("remote", r.RemoteAddr),
+	}
+	if logBodies {
+		attrs = append(attrs,
+			slog.Any("headers", redactHeaders(r.Header)),
+			slog.String("request_body", reqBody.String()),
+		)
+		if maxRespLog > 0 {
+			attrs = append(attrs, slog.String("response_body", rww.String()))
+		}
+	}
+	level := slog.LevelInfo
+	if slices.Contains(monitoringPaths, r.URL.Path) {
+		// These are polled constantly, so would drown out other requests
+		level = slog.LevelDebug
+	}
+	if rww.Status() >= 500 {
+		level = slog.LevelError
+	}
+	h.logger.LogAttrs(r.Context(), level, "request", attrs...)
+
+	status := strconv.Itoa(rww.Status())
+	method := methodLabel(r.Method)
+	httpRequests.Inc(route, method, status)
+	httpDuration.ObserveSince(start, route, method, status)
+}
+
+// monitoringPaths are the endpoints used by the platform's health checks,
+// whose requests are logged at debug level.
+var monitoringPaths = []string{"/healthz", "/readyz"}
+
+// serveLocked serves the request while holding importMu (see handler).
+func (h *handler) serveLocked(w http.ResponseWriter, r *http.Request, route string) {
+	switch {
+	case route == "/api/v0/import":
+		h.importMu.Lock()
+		defer h.importMu.Unlock()
+	case r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions:
+		h.importMu.RLock()
+		defer h.importMu.RUnlock()
+	}
 	h.mux.ServeHTTP(w, r)
 }
 
+// routeLabel returns the route to record in metrics for a request matching
+// the given mux pattern. Patterns are used rather than paths, so there is a
+// bounded number of routes.
+func routeLabel(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	// The method is recorded separately
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+// metricMethods are the request methods recorded in metrics. Clients can
+// send any method, so others are recorded as "other", to bound the number of
+// series.
+var metricMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// methodLabel returns the method to record in metrics for a request.
+func methodLabel(method string) string {
+	if slices.Contains(metricMethods, method) {
+		return method
+	}
+	return "other"
+}
+
+// validRequestID matches the request IDs accepted from clients.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// noBodyLogging lists the endpoints whose request/response bodies should
+// never be logged: large binary archives, and credentials.
+var noBodyLogging = []string{
+	"/api/v0/export",
+	"/api/v0/import",
+	"/api/v1/users",
+	"/api/v1/tokens",
+}
+
+// logBody returns true if the bodies of requests to path can be logged.
+func logBody(path string) bool {
+	for _, prefix := range noBodyLogging {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// redactedHeaders are replaced with "[REDACTED]" in logs, as they hold
+// credentials.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// redactHeaders returns the headers for logging, with credentials removed.
+func redactHeaders(header http.Header) map[string]string {
+	logged := map[string]string{}
+	for name, values := range header {
+		logged[name] = strings.Join(values, ", ")
+	}
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			logged[name] = "[REDACTED]"
+		}
+	}
+	return logged
+}
+
 // API HANDLERS
 
 type ChordsAPI struct {
-	db      dblayer.ChordsDB
-	logger  *log.Logger
-	authKey string
+	db       dblayer.ChordsDB
+	v1       data.ChordsDBv1
+	logger   *slog.Logger
+	accounts *auth.Accounts
 }
 
 // Handles requests to the /api/v0/artists endpoint.
@@ -186,7 +488,7 @@ func (s *ChordsAPI) artistsHandler(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			s.writeJSON(w, artists)
 		} else {
-			s.serverError(err, "could not get artists", w)
+			s.serverError(w, r, err, "could not get artists")
 		}
 
 	default:
@@ -220,38 +522,40 @@ func (s *ChordsAPI) getSongs(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		s.writeJSON(w, songs)
 	} else {
-		s.serverError(err, "could not get songs", w)
+		s.serverError(w, r, err, "could not get songs")
 	}
 }
 
 // Add a new song to the database.
 func (s *ChordsAPI) newSong(w http.ResponseWriter, r *http.Request) {
-	if !s.authorised(w, r) {
+	r, ok := s.authorised(w, r, auth.RoleContributor, auth.ScopeSongsWrite)
+	if !ok {
 		return
 	}
 
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		s.serverError(err, "io error", w)
+	data, ok := s.readBody(w, r)
+	if !ok {
+		return
 	}
 
 	song := &dblayer.SongMeta{}
-	err = json.Unmarshal(data, song)
+	err := json.Unmarshal(data, song)
 	if err != nil {
-		s.serverError(err, "parsing body", w)
+		s.serverError(w, r, err, "parsing body")
 	}
 
-	newSong, err := s.db.NewSong(*song)
+	newSong, err := s.dbFor(r).NewSong(*song)
 	if err == nil {
 		s.writeJSON(w, newSong)
 	} else {
-		s.serverError(err, "creating new song", w)
+		s.serverError(w, r, err, "creating new song")
 	}
 }
 
 // Update the metadata for a song in the database.
 func (s *ChordsAPI) updateSong(w http.ResponseWriter, r *http.Request) {
-	if !s.authorised(w, r) {
+	r, ok := s.authorised(w, r, auth.RoleContributor, auth.ScopeSongsWrite)
+	if !ok {
 		return
 	}
 	id, ok := idParam(w, r)
@@ -259,28 +563,30 @@ func (s *ChordsAPI) updateSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		s.serverError(err, "io error", w)
+	data, ok := s.readBody(w, r)
+	if !ok {
+		return
 	}
 
 	meta := &dblayer.SongMeta{}
-	err = json.Unmarshal(data, meta)
+	err := json.Unmarshal(data, meta)
 	if err != nil {
-		s.serverError(err, "parsing body", w)
+		s.serverError(w, r, err, "parsing body")
 	}
 
-	newMeta, err := s.db.UpdateSong(id, *meta)
+	newMeta, err := s.dbFor(r).UpdateSong(id, *meta)
 	if err == nil {
 		s.writeJSON(w, newMeta)
 	} else {
-		s.serverError(err, "updating song metadata", w)
+		s.serverError(w, r, err, "updating song metadata")
 	}
 }
 
 // Delete a song from the database. The song's chords will also be deleted.
+// Deleted songs are moved to the trash, and can be restored.
 func (s *ChordsAPI) deleteSong(w http.ResponseWriter, r *http.Request) {
-	if !s.authorised(w, r) {
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeSongsWrite)
+	if !ok {
 		return
 	}
 	id, ok := idParam(w, r)
@@ -288,12 +594,94 @@ func (s *ChordsAPI) deleteSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.db.DeleteSong(id)
+	err := s.dbFor(r).DeleteSong(id)
 	if err == nil {
 		w.WriteHeader(http.StatusNoContent)
 	} else {
-		s.serverError(err, "deleting song", w)
+		s.serverError(w, r, err, "deleting song")
+	}
+}
+
+// Handles requests to the /api/v0/songs/rename endpoint. Changes a song's ID,
+// keeping the old ID as an alias.
+func (s *ChordsAPI) renameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeSongsWrite)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	newID := r.URL.Query().Get("newId")
+	if newID == "" {
+		http.Error(w, `required param "newId" not provided`, http.StatusBadRequest)
+		return
+	}
+
+	meta, err := s.dbFor(r).RenameSong(id, newID)
+	if err == nil {
+		s.writeJSON(w, meta)
+	} else {
+		s.serverError(w, r, err, "renaming song")
+	}
+}
+
+// Handles requests to the /api/v0/songs/merge endpoint. Merges a duplicate
+// song into another one.
+func (s *ChordsAPI) mergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeSongsWrite)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	dupID := r.URL.Query().Get("duplicate")
+	if dupID == "" {
+		http.Error(w, `required param "duplicate" not provided`, http.StatusBadRequest)
+		return
+	}
+
+	meta, err := s.dbFor(r).MergeSongs(id, dupID)
+	if err == nil {
+		s.writeJSON(w, meta)
+	} else {
+		s.serverError(w, r, err, "merging songs")
+	}
+}
+
+// Handles requests to the /api/v0/aliases endpoint. Returns the current ID
+// of a song which has been renamed or merged.
+func (s *ChordsAPI) aliasesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	alias, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := s.db.ResolveAlias(alias)
+	if err != nil {
+		s.serverError(w, r, err, "resolving alias")
+		return
 	}
+	if id == "" {
+		http.Error(w, fmt.Sprintf("no alias %q", alias), http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, map[string]string{"alias": alias, "id": id})
 }
 
 // Handles requests to the /api/v0/chords endpoint.
@@ -316,16 +704,23 @@ func (s *ChordsAPI) getChords(w http.ResponseWriter, r *http.Request) {
 	}
 
 	chords, err := s.db.GetChords(id)
-	if err == nil {
-		w.Write(chords)
-	} else {
-		s.serverError(err, "getting chords", w)
+	if err != nil {
+		s.serverError(w, r, err, "getting chords")
+		return
+	}
+	// For conditional requests (see serveCached)
+	modTime, err := s.db.ChordsModTime(id)
+	if err != nil {
+		s.log(r).Warn("getting chords modification time", "id", id, "error", err)
 	}
+	setLastModified(w, modTime)
+	w.Write(chords)
 }
 
 // Update chords for a given song.
 func (s *ChordsAPI) updateChords(w http.ResponseWriter, r *http.Request) {
-	if !s.authorised(w, r) {
+	r, ok := s.authorised(w, r, auth.RoleContributor, auth.ScopeChordsWrite)
+	if !ok {
 		return
 	}
 	id, ok := idParam(w, r)
@@ -333,41 +728,109 @@ func (s *ChordsAPI) updateChords(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chords, err := io.ReadAll(r.Body)
-	if err != nil {
-		s.serverError(err, "io error", w)
+	chords, ok := s.readBody(w, r)
+	if !ok {
+		return
 	}
 
-	newChords, err := s.db.UpdateChords(id, chords)
+	newChords, err := s.dbFor(r).UpdateChords(id, chords)
 	if err == nil {
 		w.Write(newChords)
 	} else {
-		s.serverError(err, "updating chords", w)
+		s.serverError(w, r, err, "updating chords")
 	}
 }
 
 // Handles requests to the /api/v0/see-also endpoint.
 func (s *ChordsAPI) seeAlsoHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		// TODO: allow updating see also data via POST/PUT/PATCH/DELETE
+	switch r.Method {
+	case http.MethodGet:
+		s.getSeeAlso(w, r)
+	case http.MethodPost:
+		s.addRelation(w, r)
+	case http.MethodDelete:
+		s.removeRelation(w, r)
+	default:
 		http.Error(w, "", http.StatusMethodNotAllowed)
-		return
 	}
+}
 
+func (s *ChordsAPI) getSeeAlso(w http.ResponseWriter, r *http.Request) {
 	artist := r.URL.Query().Get("artist")
 	relatedArtists, err := s.db.SeeAlso(artist)
 	if err != nil {
-		s.serverError(err, "could not get related artists", w)
+		s.serverError(w, r, err, "could not get related artists")
 		return
 	}
 
 	s.writeJSON(w, relatedArtists)
 }
 
+// addRelation relates two artists, and returns the updated see-also list for
+// the first one.
+func (s *ChordsAPI) addRelation(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeRelationsWrite)
+	if !ok {
+		return
+	}
+	artist, related, ok := relationParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.dbFor(r).AddRelation(artist, related)
+	if errors.Is(err, dblayer.ErrUnknownArtist) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.serverError(w, r, err, "relating artists")
+		return
+	}
+	s.getSeeAlso(w, r)
+}
+
+func (s *ChordsAPI) removeRelation(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeRelationsWrite)
+	if !ok {
+		return
+	}
+	artist, related, ok := relationParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.dbFor(r).RemoveRelation(artist, related)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, dblayer.ErrNotRelated):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		s.serverError(w, r, err, "unrelating artists")
+	}
+}
+
+// relationParams gets the two artists for a see-also request. If they aren't
+// both provided, it writes out an error.
+func relationParams(w http.ResponseWriter, r *http.Request) (artist, related string, ok bool) {
+	artist = r.URL.Query().Get("artist")
+	related = r.URL.Query().Get("related")
+	if artist == "" || related == "" {
+		http.Error(w, `required params "artist" and "related" not provided`, http.StatusBadRequest)
+		return "", "", false
+	}
+	if artist == related {
+		http.Error(w, "cannot relate an artist to itself", http.StatusBadRequest)
+		return "", "", false
+	}
+	return artist, related, true
+}
+
 func (s *ChordsAPI) randomHandler(w http.ResponseWriter, r *http.Request) {
 	allSongs, err := s.db.GetSongs("", "", "")
 	if err != nil {
-		s.serverError(err, "getting songs", w)
+		s.serverError(w, r, err, "getting songs")
 		return
 	}
 
@@ -389,13 +852,141 @@ func (s *ChordsAPI) searchHandler(w http.ResponseWriter, r *http.Request) {
 
 	results, err := s.db.Search(searchQuery)
 	if err != nil {
-		s.serverError(err, "getting songs", w)
+		s.serverError(w, r, err, "getting songs")
 		return
 	}
 
 	s.writeJSON(w, results)
 }
 
+// Handles requests to the /api/v0/export endpoint. Streams a snapshot of the
+// whole database as a gzipped tar archive.
+func (s *ChordsAPI) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeBackup)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("chords-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := dblayer.Export(s.db, w)
+	if err != nil {
+		// We may have already started writing the response, so we can't
+		// reliably send an error code. Just log it.
+		s.log(r).Error("exporting database", "error", err)
+	}
+}
+
+// Handles requests to the /api/v0/import endpoint. Restores a snapshot (as
+// produced by the export endpoint) into the database.
+func (s *ChordsAPI) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	r, ok := s.authorised(w, r, auth.RoleAdmin, auth.ScopeBackup)
+	if !ok {
+		return
+	}
+
+	mode := dblayer.ImportMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = dblayer.ImportMerge
+	}
+	if mode != dblayer.ImportMerge && mode != dblayer.ImportReplace {
+		http.Error(w, fmt.Sprintf("invalid mode %q", mode), http.StatusBadRequest)
+		return
+	}
+
+	result, err := dblayer.Import(s.dbFor(r), r.Body, mode)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, bodyTooLarge(tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		s.serverError(w, r, err, "importing snapshot")
+		return
+	}
+	s.writeJSON(w, result)
+}
+
+// Handles requests to the /api/v0/trash endpoint.
+func (s *ChordsAPI) trashHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listTrash(w, r)
+	case http.MethodDelete:
+		s.purgeSong(w, r)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+// List the songs in the trash.
+func (s *ChordsAPI) listTrash(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeTrash)
+	if !ok {
+		return
+	}
+
+	songs, err := s.db.ListTrash()
+	if err == nil {
+		s.writeJSON(w, songs)
+	} else {
+		s.serverError(w, r, err, "listing trash")
+	}
+}
+
+// Permanently delete a song in the trash.
+func (s *ChordsAPI) purgeSong(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.authorised(w, r, auth.RoleAdmin, auth.ScopeTrash)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.dbFor(r).PurgeSong(id)
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		s.serverError(w, r, err, "purging song")
+	}
+}
+
+// Handles requests to the /api/v0/trash/restore endpoint. Moves a song out
+// of the trash, and returns its metadata.
+func (s *ChordsAPI) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	r, ok := s.authorised(w, r, auth.RoleEditor, auth.ScopeTrash)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	meta, err := s.dbFor(r).RestoreSong(id)
+	if err == nil {
+		s.writeJSON(w, meta)
+	} else {
+		s.serverError(w, r, err, "restoring song")
+	}
+}
+
 //go:embed favicon.ico
 var faviconData []byte
 
@@ -404,19 +995,98 @@ func serveFavicon(w http.ResponseWriter, _ *http.Request) {
 	w.Write(faviconData)
 }
 
-// HELPER FUNCTIONS
+// serveHealthz reports that the server is alive. It doesn't check the
+// database - see readyzHandler.
+func serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether the server is ready to serve requests:
+// the database is reachable, and the search index has been built.
+func (s *ChordsAPI) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Ping(); err != nil {
+		s.log(r).Warn("not ready", "error", err)
+		http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
 
-// For methods which write to the database, check we are authorised to do this.
-// If not, write an Unauthorised error to w.
-func (s *ChordsAPI) authorised(w http.ResponseWriter, r *http.Request) bool {
-	// TODO: work out how to authorise
-	key := r.Header.Get("Authorization")
-	authd := key == s.authKey
+// HELPER FUNCTIONS
 
-	if !authd {
+// For methods which write to the database, check the request's user has the
+// given role, and scope if they used an API token. If not, write an
+// Unauthorized (not logged in) or Forbidden (not allowed) error to w.
+// Authorised requests are returned with the user in their context (see
+// withActor), so changes can be attributed to them.
+func (s *ChordsAPI) authorised(w http.ResponseWriter, r *http.Request, role auth.Role, scope auth.Scope) (*http.Request, bool) {
+	user, err := s.authenticate(r)
+	if err == nil {
+		err = user.Authorise(role, scope)
+	}
+	switch {
+	case err == nil:
+		s.log(r).Info("authorised", "user", user.Name, "role", user.Role)
+		return withActor(r, user), true
+	case errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
 		http.Error(w, "", http.StatusUnauthorized)
 	}
-	return authd
+	return r, false
+}
+
+// withActor adds the user to the request's context, for authorisation, and
+// as the actor for the audit log.
+func withActor(r *http.Request, user *auth.User) *http.Request {
+	actor := dblayer.Actor{RequestID: requestID(r.Context())}
+	if user != nil {
+		actor.User = user.Name
+	}
+	ctx := auth.WithUser(r.Context(), user)
+	return r.WithContext(dblayer.ContextWithActor(ctx, actor))
+}
+
+// dbFor returns the database, with changes recorded in the audit log as
+// made by the request's user.
+func (s *ChordsAPI) dbFor(r *http.Request) dblayer.ChordsDB {
+	return dblayer.WithAudit(s.db, dblayer.ActorFrom(r.Context()))
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID assigned to a request in handler.ServeHTTP, or ""
+// if it has none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random ID for a request.
+func newRequestID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// authenticate checks the request's Authorization header, and returns the
+// user. It returns nil if the request is anonymous, or an error if the
+// credentials are wrong.
+func (s *ChordsAPI) authenticate(r *http.Request) (*auth.User, error) {
+	if s.accounts == nil {
+		return nil, nil
+	}
+	return s.accounts.Authenticate(r.Header.Get("Authorization"))
+}
+
+// graphQLHandler passes on the request's user to the GraphQL handler, as
+// mutations require authorisation. Requests with invalid credentials are
+// treated as anonymous.
+func (s *ChordsAPI) graphQLHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := s.authenticate(r)
+		next.ServeHTTP(w, withActor(r, user))
+	})
 }
 
 // Check if the required ID param has been provided.
@@ -430,17 +1100,40 @@ func idParam(w http.ResponseWriter, r *http.Request) (string, bool) {
 	return r.URL.Query().Get("id"), true
 }
 
+// readBody reads the request body. If it can't be read, it writes out an
+// error: 413 Request Entity Too Large if it's over the limit (see
+// Limits.MaxBody), or 500 otherwise.
+func (s *ChordsAPI) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, bodyTooLarge(tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case err != nil:
+		s.serverError(w, r, err, "io error")
+	default:
+		return body, true
+	}
+	return nil, false
+}
+
 // serverError returns a 500 response, and logs the offending error.
-func (s *ChordsAPI) serverError(e error, msg string, w http.ResponseWriter) {
-	s.logger.Printf("ERROR: %v", e)
+func (s *ChordsAPI) serverError(w http.ResponseWriter, r *http.Request, e error, msg string) {
+	s.log(r).Error(msg, "error", e)
 	http.Error(w, msg, http.StatusInternalServerError)
 }
 
+// log returns a logger for messages about the request.
+func (s *ChordsAPI) log(r *http.Request) *slog.Logger {
+	return s.logger.With("request_id", requestID(r.Context()))
+}
+
 // writeJSON marshals `data` to JSON and writes it to `w`.
 func (s *ChordsAPI) writeJSON(w http.ResponseWriter, data any) {
 	jData, err := json.Marshal(data)
 	if err != nil {
-		s.serverError(err, "error marshalling to JSON", w)
+		s.logger.Error("marshalling to JSON", "error", err)
+		http.Error(w, "error marshalling to JSON", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -451,40 +1144,104 @@ func (s *ChordsAPI) writeJSON(w http.ResponseWriter, data any) {
 // Credit to Alessandro Argentieri on Stack Overflow
 // https://stackoverflow.com/a/66531582
 
-// ResponseWriterWrapper struct is used to log the response
+// ResponseWriterWrapper records the status and size of a response, and the
+// start of its body, so they can be logged.
 type ResponseWriterWrapper struct {
-	w          *http.ResponseWriter
-	body       *bytes.Buffer
-	statusCode *int
+	http.ResponseWriter
+	statusCode int
+	written    int
+	// body is the start of the response body, or nil if it isn't recorded.
+	body *cappedBuffer
+}
+
+// NewResponseWriterWrapper wraps w, recording up to maxBody bytes of the
+// response body.
+func NewResponseWriterWrapper(w http.ResponseWriter, maxBody int) *ResponseWriterWrapper {
+	rww := &ResponseWriterWrapper{ResponseWriter: w}
+	if maxBody > 0 {
+		rww.body = &cappedBuffer{max: maxBody}
+	}
+	return rww
+}
+
+func (rww *ResponseWriterWrapper) Write(buf []byte) (int, error) {
+	if rww.statusCode == 0 {
+		rww.statusCode = http.StatusOK
+	}
+	if rww.body != nil {
+		rww.body.Write(buf)
+	}
+	n, err := rww.ResponseWriter.Write(buf)
+	rww.written += n
+	return n, err
 }
 
-// NewResponseWriterWrapper static function creates a wrapper for the http.ResponseWriter
-func NewResponseWriterWrapper(w http.ResponseWriter) ResponseWriterWrapper {
-	var buf bytes.Buffer
-	var statusCode int = 200
-	return ResponseWriterWrapper{
-		w:          &w,
-		body:       &buf,
-		statusCode: &statusCode,
+// WriteHeader function overwrites the http.ResponseWriter WriteHeader() function
+func (rww *ResponseWriterWrapper) WriteHeader(statusCode int) {
+	if rww.statusCode == 0 {
+		rww.statusCode = statusCode
 	}
+	rww.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush implements http.Flusher, for streamed responses.
+func (rww *ResponseWriterWrapper) Flush() {
+	http.NewResponseController(rww.ResponseWriter).Flush()
 }
 
-func (rww ResponseWriterWrapper) Write(buf []byte) (int, error) {
-	rww.body.Write(buf)
-	return (*rww.w).Write(buf)
+// Hijack implements http.Hijacker, for the GraphQL websocket.
+func (rww *ResponseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rww.ResponseWriter).Hijack()
+	if err == nil {
+		rww.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
 }
 
-// Header function overwrites the http.ResponseWriter Header() function
-func (rww ResponseWriterWrapper) Header() http.Header {
-	return (*rww.w).Header()
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (rww *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rww.ResponseWriter
 }
 
-// WriteHeader function overwrites the http.ResponseWriter WriteHeader() function
-func (rww ResponseWriterWrapper) WriteHeader(statusCode int) {
-	(*rww.statusCode) = statusCode
-	(*rww.w).WriteHeader(statusCode)
+// Status returns the response's status code.
+func (rww *ResponseWriterWrapper) Status() int {
+	if rww.statusCode == 0 {
+		// Nothing was written
+		return http.StatusOK
+	}
+	return rww.statusCode
 }
 
-func (rww ResponseWriterWrapper) String() string {
+// Written returns the number of bytes of the body which were written.
+func (rww *ResponseWriterWrapper) Written() int {
+	return rww.written
+}
+
+// String returns the recorded start of the response body.
+func (rww *ResponseWriterWrapper) String() string {
+	if rww.body == nil {
+		return ""
+	}
 	return rww.body.String()
 }
+
+// cappedBuffer keeps the first max bytes written to it, and counts the rest.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := min(len(p), b.max-b.buf.Len())
+	b.buf.Write(p[:n])
+	b.dropped += len(p) - n
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped > 0 {
+		return fmt.Sprintf("%s... (%d more bytes)", b.buf.String(), b.dropped)
+	}
+	return b.buf.String()
+}
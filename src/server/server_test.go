@@ -11,23 +11,58 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/barrettj12/chords/gqlgen"
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/data"
 	"github.com/barrettj12/chords/src/dblayer"
+	"github.com/barrettj12/chords/src/metrics"
 	"github.com/stretchr/testify/assert"
 )
 
+// testAuthKey is the AUTH_KEY for the test servers.
+const testAuthKey = "key"
+
+// newTestAccounts returns accounts which authorise testAuthKey as an admin.
+func newTestAccounts(t *testing.T) *auth.Accounts {
+	accounts, err := auth.NewAccounts(auth.NewMemoryStore(), testAuthKey)
+	assert.Nil(t, err)
+	return accounts
+}
+
+func newTestAPI(t *testing.T, db dblayer.ChordsDB) *ChordsAPI {
+	return &ChordsAPI{db: db, logger: slog.New(slog.DiscardHandler), accounts: newTestAccounts(t)}
+}
+
+// authorise adds the test AUTH_KEY to a request.
+func authorise(r *http.Request) *http.Request {
+	r.Header.Set("Authorization", testAuthKey)
+	return r
+}
+
 func TestArtists(t *testing.T) {
 	// Set up DB & server, http writer
 	db := dblayer.NewTempDB()
-	s := Server{api: &ChordsAPI{db: db}}
+	s := Server{api: newTestAPI(t, db)}
 
 	// Add artists to DB
 	artists := []string{"Elton John", "Rod Stewart", "Spacehog", "foobar"}
@@ -65,7 +100,7 @@ func TestNewSong(t *testing.T) {
 	logger := log.Default()
 	db := dblayer.NewLocalfs(dataDir, logger)
 
-	s := Server{api: &ChordsAPI{db: db}}
+	s := Server{api: newTestAPI(t, db)}
 	w := httptest.NewRecorder()
 
 	// Add new song via API
@@ -80,7 +115,7 @@ func TestNewSong(t *testing.T) {
 	data, err := json.Marshal(newSong)
 	assert.Nil(t, err)
 	body := bytes.NewReader(data)
-	r := httptest.NewRequest(http.MethodPost, "/api/v0/songs", body)
+	r := authorise(httptest.NewRequest(http.MethodPost, "/api/v0/songs", body))
 
 	// API call
 	s.api.newSong(w, r)
@@ -101,7 +136,7 @@ func TestNewSong(t *testing.T) {
 func TestUpdateSong(t *testing.T) {
 	// Set up DB, server, http writer
 	db := dblayer.NewTempDB()
-	s := Server{api: &ChordsAPI{db: db}}
+	s := Server{api: newTestAPI(t, db)}
 	w := httptest.NewRecorder()
 
 	// Put a song in the database
@@ -127,7 +162,7 @@ func TestUpdateSong(t *testing.T) {
 	data, err := json.Marshal(updatedMeta)
 	assert.Nil(t, err)
 	body := bytes.NewReader(data)
-	r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/v0/songs?id=%s", id), body)
+	r := authorise(httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/v0/songs?id=%s", id), body))
 
 	// API call
 	s.api.updateSong(w, r)
@@ -148,3 +183,1098 @@ func TestUpdateSong(t *testing.T) {
 	assert.Len(t, dbSongs, 1)
 	assert.Equal(t, dbSongs[0], respMeta)
 }
+
+func TestExportImport(t *testing.T) {
+	// Set up source DB
+	srcDB := dblayer.NewTempDB()
+	src := Server{api: newTestAPI(t, srcDB)}
+	songs := []dblayer.SongMeta{{
+		ID:       "BananaPancakes",
+		Name:     "Banana Pancakes",
+		Artist:   "Jack Johnson",
+		Album:    "In Between Dreams",
+		TrackNum: 3,
+	}, {
+		ID:     "YourSong",
+		Name:   "Your Song",
+		Artist: "Elton John",
+	}}
+	for _, song := range songs {
+		_, err := srcDB.NewSong(song)
+		assert.Nil(t, err)
+		_, err = srcDB.UpdateChords(song.ID, []byte("chords for "+song.Name))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, srcDB.AddRelation("Jack Johnson", "Elton John"))
+
+	// Export via API
+	r := authorise(httptest.NewRequest(http.MethodGet, "/api/v0/export", nil))
+	w := httptest.NewRecorder()
+	src.api.exportHandler(w, r)
+	res := w.Result()
+	snapshot, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", snapshot)
+
+	// Set up destination DB, with one song which should be removed
+	dstDB := dblayer.NewTempDB()
+	dst := Server{api: newTestAPI(t, dstDB)}
+	_, err = dstDB.NewSong(dblayer.SongMeta{ID: "Stale", Name: "Stale", Artist: "Nobody"})
+	assert.Nil(t, err)
+
+	// Import via API
+	r = authorise(httptest.NewRequest(http.MethodPost, "/api/v0/import?mode=replace", bytes.NewReader(snapshot)))
+	w = httptest.NewRecorder()
+	dst.api.importHandler(w, r)
+	res = w.Result()
+	data, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+
+	result := dblayer.ImportResult{}
+	err = json.Unmarshal(data, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, dblayer.ImportResult{Added: 2, Deleted: 1}, result)
+
+	// Check db state
+	dbSongs, err := dstDB.GetSongs("", "", "")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, songs, dbSongs)
+	for _, song := range songs {
+		chords, err := dstDB.GetChords(song.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "chords for "+song.Name, string(chords))
+	}
+	seeAlso, err := dstDB.SeeAlso("Elton John")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Jack Johnson"}, seeAlso)
+
+	// The removed song doesn't go to the trash
+	trash, err := dstDB.ListTrash()
+	assert.Nil(t, err)
+	assert.Empty(t, trash)
+}
+
+// failingChords fails to update the chords for one song.
+type failingChords struct {
+	dblayer.ChordsDB
+	id string
+}
+
+func (f *failingChords) UpdateChords(id string, chords dblayer.Chords) (dblayer.Chords, error) {
+	if id == f.id {
+		return nil, errors.New("disk full")
+	}
+	return f.ChordsDB.UpdateChords(id, chords)
+}
+
+func TestImportRestoresOnError(t *testing.T) {
+	// Export a snapshot with two songs
+	srcDB := dblayer.NewTempDB()
+	for _, id := range []string{"A", "B"} {
+		_, err := srcDB.NewSong(dblayer.SongMeta{ID: id, Name: id, Artist: "New"})
+		assert.Nil(t, err)
+	}
+	snapshot := &bytes.Buffer{}
+	assert.Nil(t, dblayer.Export(srcDB, snapshot))
+
+	// Importing fails on the second song, after the first is written
+	dstDB := dblayer.NewTempDB()
+	old := dblayer.SongMeta{ID: "Old", Name: "Old", Artist: "Old"}
+	_, err := dstDB.NewSong(old)
+	assert.Nil(t, err)
+	_, err = dstDB.UpdateChords("Old", []byte("old chords"))
+	assert.Nil(t, err)
+	dst := Server{api: newTestAPI(t, &failingChords{dstDB, "B"})}
+
+	r := authorise(httptest.NewRequest(http.MethodPost, "/api/v0/import?mode=replace", snapshot))
+	w := httptest.NewRecorder()
+	dst.api.importHandler(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+
+	// The database is as it was before the import
+	songs, err := dstDB.GetSongs("", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []dblayer.SongMeta{old}, songs)
+	chords, err := dstDB.GetChords("Old")
+	assert.Nil(t, err)
+	assert.Equal(t, "old chords", string(chords))
+	trash, err := dstDB.ListTrash()
+	assert.Nil(t, err)
+	assert.Empty(t, trash)
+}
+
+func TestImportBlocksWrites(t *testing.T) {
+	importing, finish := make(chan struct{}), make(chan struct{})
+	wrote := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/import", func(w http.ResponseWriter, r *http.Request) {
+		close(importing)
+		<-finish
+	})
+	mux.HandleFunc("/api/v0/songs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			close(wrote)
+		}
+	})
+	h := handler{logger: slog.New(slog.DiscardHandler), mux: mux}
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v0/import", nil))
+	<-importing
+
+	// Reads carry on, but writes wait for the import to finish
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v0/songs", nil))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/api/v0/songs", nil))
+	select {
+	case <-wrote:
+		t.Fatal("write served during import")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(finish)
+	select {
+	case <-wrote:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write not served after import")
+	}
+}
+
+func TestTrash(t *testing.T) {
+	// Set up DB
+	dataDir, err := os.MkdirTemp("", "data")
+	assert.Nil(t, err)
+	defer func() {
+		err := os.RemoveAll(dataDir)
+		assert.Nil(t, err)
+	}()
+
+	db := dblayer.NewLocalfs(dataDir, log.Default())
+	s := Server{api: newTestAPI(t, db)}
+	song := dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}
+	_, err = db.NewSong(song)
+	assert.Nil(t, err)
+	_, err = db.UpdateChords(song.ID, []byte("C - F - G"))
+	assert.Nil(t, err)
+
+	// Delete song via API - it should move to the trash
+	r := authorise(httptest.NewRequest(http.MethodDelete, "/api/v0/songs?id=YourSong", nil))
+	w := httptest.NewRecorder()
+	s.api.deleteSong(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+
+	dbSongs, err := db.GetSongs("", "", "")
+	assert.Nil(t, err)
+	assert.Empty(t, dbSongs)
+
+	r = authorise(httptest.NewRequest(http.MethodGet, "/api/v0/trash", nil))
+	w = httptest.NewRecorder()
+	s.api.trashHandler(w, r)
+	res := w.Result()
+	data, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+
+	trash := []dblayer.TrashedSong{}
+	err = json.Unmarshal(data, &trash)
+	assert.Nil(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, song, trash[0].SongMeta)
+		assert.False(t, trash[0].DeletedAt.IsZero())
+	}
+
+	// Restore song
+	r = authorise(httptest.NewRequest(http.MethodPost, "/api/v0/trash/restore?id=YourSong", nil))
+	w = httptest.NewRecorder()
+	s.api.restoreHandler(w, r)
+	res = w.Result()
+	data, err = io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+
+	chords, err := db.GetChords(song.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "C - F - G", string(chords))
+
+	// Delete and purge song - it should be gone for good
+	assert.Nil(t, db.DeleteSong(song.ID))
+	r = authorise(httptest.NewRequest(http.MethodDelete, "/api/v0/trash?id=YourSong", nil))
+	w = httptest.NewRecorder()
+	s.api.trashHandler(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+
+	trash, err = db.ListTrash()
+	assert.Nil(t, err)
+	assert.Empty(t, trash)
+	_, err = db.RestoreSong(song.ID)
+	assert.NotNil(t, err)
+}
+
+func TestRenameMerge(t *testing.T) {
+	// Set up DB
+	dataDir, err := os.MkdirTemp("", "data")
+	assert.Nil(t, err)
+	defer func() {
+		err := os.RemoveAll(dataDir)
+		assert.Nil(t, err)
+	}()
+
+	db := dblayer.NewLocalfs(dataDir, log.Default())
+	s := Server{api: newTestAPI(t, db)}
+	song := dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}
+	_, err = db.NewSong(song)
+	assert.Nil(t, err)
+	dup := dblayer.SongMeta{ID: "YourSong2", Name: "Your Song", Artist: "Elton John", Album: "Elton John", TrackNum: 1}
+	_, err = db.NewSong(dup)
+	assert.Nil(t, err)
+
+	// Rename song - the old ID should become an alias
+	r := authorise(httptest.NewRequest(http.MethodPost, "/api/v0/songs/rename?id=YourSong&newId=YourSongElton", nil))
+	w := httptest.NewRecorder()
+	s.api.renameHandler(w, r)
+	res := w.Result()
+	data, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+
+	dbSongs, err := db.GetSongs("", "YourSong", "")
+	assert.Nil(t, err)
+	assert.Empty(t, dbSongs)
+	dbSongs, err = db.GetSongs("", "YourSongElton", "")
+	assert.Nil(t, err)
+	assert.Len(t, dbSongs, 1)
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v0/aliases?id=YourSong", nil)
+	w = httptest.NewRecorder()
+	s.api.aliasesHandler(w, r)
+	res = w.Result()
+	data, err = io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+	assert.JSONEq(t, `{"alias": "YourSong", "id": "YourSongElton"}`, string(data))
+
+	// Merge the duplicate - it should go to the trash, with its album kept
+	r = authorise(httptest.NewRequest(http.MethodPost, "/api/v0/songs/merge?id=YourSongElton&duplicate=YourSong2", nil))
+	w = httptest.NewRecorder()
+	s.api.mergeHandler(w, r)
+	res = w.Result()
+	data, err = io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+
+	merged := dblayer.SongMeta{}
+	assert.Nil(t, json.Unmarshal(data, &merged))
+	assert.Equal(t, dblayer.SongMeta{
+		ID: "YourSongElton", Name: "Your Song", Artist: "Elton John", Album: "Elton John", TrackNum: 1,
+	}, merged)
+
+	trash, err := db.ListTrash()
+	assert.Nil(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "YourSong2", trash[0].ID)
+	}
+	for _, alias := range []string{"YourSong", "YourSong2"} {
+		id, err := db.ResolveAlias(alias)
+		assert.Nil(t, err)
+		assert.Equal(t, "YourSongElton", id)
+	}
+
+	// Unknown aliases should 404
+	r = httptest.NewRequest(http.MethodGet, "/api/v0/aliases?id=NotASong", nil)
+	w = httptest.NewRecorder()
+	s.api.aliasesHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestRenameConcurrent(t *testing.T) {
+	db := dblayer.NewLocalfs(t.TempDir(), log.New(io.Discard, "", 0))
+	for i := range 50 {
+		_, err := db.NewSong(dblayer.SongMeta{ID: fmt.Sprintf("Song%d", i)})
+		assert.Nil(t, err)
+	}
+
+	// Concurrent renames shouldn't lose each other's aliases
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.RenameSong(fmt.Sprintf("Song%d", i), fmt.Sprintf("Renamed%d", i))
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	for i := range 50 {
+		id, err := db.ResolveAlias(fmt.Sprintf("Song%d", i))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("Renamed%d", i), id)
+	}
+
+	// Only valid IDs can be merged
+	_, err := db.MergeSongs("Renamed0", "x/../Renamed1")
+	assert.ErrorContains(t, err, "cannot contain a slash")
+}
+
+func TestMergeRollback(t *testing.T) {
+	dir := t.TempDir()
+	db := dblayer.NewLocalfs(dir, log.New(io.Discard, "", 0))
+	song := dblayer.SongMeta{ID: "YourSong", Name: "Your Song", Artist: "Elton John"}
+	dup := dblayer.SongMeta{ID: "YourSong2", Name: "Your Song", Artist: "Elton John", Album: "Elton John"}
+	for _, meta := range []dblayer.SongMeta{song, dup} {
+		_, err := db.NewSong(meta)
+		assert.Nil(t, err)
+	}
+	checkUnchanged := func() {
+		t.Helper()
+		songs, err := db.GetSongs("", "", "")
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []dblayer.SongMeta{song, dup}, songs)
+		trash, err := db.ListTrash()
+		assert.Nil(t, err)
+		assert.Empty(t, trash)
+		id, err := db.ResolveAlias("YourSong2")
+		assert.Nil(t, err)
+		assert.Equal(t, "", id)
+	}
+
+	// The duplicate can't be moved to the trash
+	trashDir := filepath.Join(dir, ".trash")
+	assert.Nil(t, os.WriteFile(trashDir, nil, 0644))
+	_, err := db.MergeSongs("YourSong", "YourSong2")
+	assert.Error(t, err)
+	assert.Nil(t, os.Remove(trashDir))
+	checkUnchanged()
+
+	// The alias can't be written
+	aliasesFile := filepath.Join(dir, "aliases.json")
+	assert.Nil(t, os.Symlink(filepath.Join(dir, "missing", "aliases.json"), aliasesFile))
+	_, err = db.MergeSongs("YourSong", "YourSong2")
+	assert.Error(t, err)
+	assert.Nil(t, os.Remove(aliasesFile))
+	assert.Nil(t, os.RemoveAll(trashDir))
+	checkUnchanged()
+	results, err := db.Search("Your Song")
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestSeeAlso(t *testing.T) {
+	db := dblayer.NewTempDB()
+	s := Server{api: newTestAPI(t, db)}
+	for _, artist := range []string{"Elton John", "Rod Stewart", "Billy Joel"} {
+		_, err := db.NewSong(dblayer.SongMeta{Artist: artist})
+		assert.Nil(t, err)
+	}
+
+	// Relate artists via API
+	for _, related := range []string{"Rod Stewart", "Billy Joel"} {
+		r := authorise(httptest.NewRequest(http.MethodPost,
+			"/api/v0/see-also?artist=Elton+John&related="+url.QueryEscape(related), nil))
+		w := httptest.NewRecorder()
+		s.api.seeAlsoHandler(w, r)
+		res := w.Result()
+		data, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode, "body: %s", data)
+	}
+
+	// Relations should be symmetric
+	seeAlso, err := db.SeeAlso("Elton John")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Rod Stewart", "Billy Joel"}, seeAlso)
+	seeAlso, err = db.SeeAlso("Billy Joel")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Elton John"}, seeAlso)
+
+	// Unknown artists can't be related
+	r := authorise(httptest.NewRequest(http.MethodPost, "/api/v0/see-also?artist=Elton+John&related=Nobody", nil))
+	w := httptest.NewRecorder()
+	s.api.seeAlsoHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// Remove relation from the other side
+	r = authorise(httptest.NewRequest(http.MethodDelete, "/api/v0/see-also?artist=Billy+Joel&related=Elton+John", nil))
+	w = httptest.NewRecorder()
+	s.api.seeAlsoHandler(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+
+	seeAlso, err = db.SeeAlso("Elton John")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Rod Stewart"}, seeAlso)
+
+	r = authorise(httptest.NewRequest(http.MethodDelete, "/api/v0/see-also?artist=Billy+Joel&related=Elton+John", nil))
+	w = httptest.NewRecorder()
+	s.api.seeAlsoHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestSeeAlsoConcurrent(t *testing.T) {
+	db := dblayer.NewLocalfs(t.TempDir(), log.New(io.Discard, "", 0))
+	artists := []string{}
+	for i := range 50 {
+		artist := fmt.Sprintf("Artist %d", i)
+		artists = append(artists, artist)
+		_, err := db.NewSong(dblayer.SongMeta{ID: fmt.Sprintf("Song%d", i), Artist: artist})
+		assert.Nil(t, err)
+	}
+
+	// Concurrent changes to the see-also data shouldn't overwrite each other
+	var wg sync.WaitGroup
+	for _, artist := range artists[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, db.AddRelation(artists[0], artist))
+		}()
+	}
+	wg.Wait()
+
+	seeAlso, err := db.SeeAlso(artists[0])
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, artists[1:], seeAlso)
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	lib, err := data.LibraryFor(dblayer.NewTempDB())
+	assert.Nil(t, err)
+	accounts := newTestAccounts(t)
+	api := &ChordsAPI{db: lib.V0(), v1: lib, logger: slog.New(slog.DiscardHandler), accounts: accounts}
+	handler := api.graphQLHandler(gqlgen.NewHandler(lib, nil))
+
+	graphQL := func(authKey, query string) (map[string]any, []any) {
+		body, err := json.Marshal(map[string]string{"query": query})
+		assert.Nil(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", authKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		resp := struct {
+			Data   map[string]any
+			Errors []any
+		}{}
+		err = json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Nil(t, err, "body: %s", w.Body)
+		return resp.Data, resp.Errors
+	}
+
+	// Mutations require authorisation
+	addSong := `mutation {
+		addSong(song: {name: "Your Song", artist: "Elton John", album: "Elton John", chords: "C F G"}) {
+			id
+			chords
+			artist { id name }
+		}
+	}`
+	_, errs := graphQL("wrong", addSong)
+	assert.Len(t, errs, 1)
+	// API tokens need the right scope
+	_, err = accounts.AddUser("ci", auth.RoleEditor, "")
+	assert.Nil(t, err)
+	_, secret, err := accounts.CreateToken("ci", "", []auth.Scope{auth.ScopeChordsWrite}, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, errs = graphQL("Bearer "+secret, addSong)
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, fmt.Sprint(errs[0]), "token needs the songs:write scope")
+	}
+	songs, err := lib.Songs(context.Background(), data.SongsFilters{})
+	assert.Nil(t, err)
+	assert.Empty(t, songs)
+
+	// Mutations return the updated objects
+	resp, errs := graphQL(testAuthKey, addSong)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{"addSong": map[string]any{
+		"id":     "YourSong",
+		"chords": "C F G",
+		"artist": map[string]any{"id": "EltonJohn", "name": "Elton John"},
+	}}, resp)
+
+	// Renaming an artist keeps the old name as an alias
+	resp, errs = graphQL(testAuthKey, `mutation {
+		updateArtist(id: "EltonJohn", artist: {name: "Sir Elton John"}) { name aliases }
+	}`)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]any{"updateArtist": map[string]any{
+		"name":    "Sir Elton John",
+		"aliases": []any{"Elton John"},
+	}}, resp)
+
+	meta, err := lib.V0().GetSongs("", "YourSong", "")
+	assert.Nil(t, err)
+	if assert.Len(t, meta, 1) {
+		assert.Equal(t, "Sir Elton John", meta[0].Artist)
+	}
+}
+
+func TestEmptyAuthKey(t *testing.T) {
+	accounts, err := auth.NewAccounts(auth.NewMemoryStore(), "")
+	assert.Nil(t, err)
+	api := &ChordsAPI{db: dblayer.NewTempDB(), logger: slog.New(slog.DiscardHandler), accounts: accounts}
+
+	// An empty AUTH_KEY doesn't authorise requests without a key
+	for _, key := range []string{"", "Bearer "} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v0/songs", bytes.NewReader([]byte(`{"name": "Michelle"}`)))
+		r.Header.Set("Authorization", key)
+		w := httptest.NewRecorder()
+		api.songsHandler(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "key %q", key)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	db := dblayer.NewLocalfs(t.TempDir(), log.New(io.Discard, "", 0))
+	for _, meta := range []dblayer.SongMeta{
+		{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles"},
+		{ID: "AnotherDay", Name: "Another Day", Artist: "Paul McCartney"},
+	} {
+		_, err := db.NewSong(meta)
+		assert.Nil(t, err)
+	}
+	api := newTestAPI(t, db)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/chords", api.chordsHandler)
+	mux.HandleFunc("/api/v0/see-also", api.seeAlsoHandler)
+	h := handler{logger: slog.New(slog.DiscardHandler), mux: mux}
+
+	// Changes through the API are recorded, with the user and request ID
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/api/v0/chords?id=Yesterday", bytes.NewReader([]byte("F Em7 A7 Dm"))),
+		httptest.NewRequest(http.MethodPost, "/api/v0/see-also?artist=The+Beatles&related=Paul+McCartney", nil),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, authorise(r))
+		assert.Less(t, w.Code, 300, "body: %s", w.Body)
+	}
+
+	entries, err := db.ListAudit(dblayer.AuditFilter{})
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, dblayer.AuditRelationAdd, entries[0].Action)
+		chords := entries[1]
+		assert.Equal(t, dblayer.AuditChordsUpdate, chords.Action)
+		assert.Equal(t, "Yesterday", chords.Target)
+		assert.Equal(t, auth.AuthKeyUser, chords.Actor)
+		assert.NotEmpty(t, chords.RequestID)
+		assert.NotEqual(t, entries[0].RequestID, chords.RequestID)
+		assert.Equal(t, dblayer.Revision(nil), chords.Before)
+		assert.Equal(t, dblayer.Revision([]byte("F Em7 A7 Dm")), chords.After)
+		assert.Contains(t, chords.Diff, "+F Em7 A7 Dm")
+	}
+
+	// Filters
+	for _, test := range []struct {
+		filter dblayer.AuditFilter
+		want   []dblayer.AuditAction
+	}{
+		{dblayer.AuditFilter{Action: dblayer.AuditChordsUpdate}, []dblayer.AuditAction{dblayer.AuditChordsUpdate}},
+		{dblayer.AuditFilter{Target: "Paul McCartney"}, []dblayer.AuditAction{dblayer.AuditRelationAdd}},
+		{dblayer.AuditFilter{Limit: 1}, []dblayer.AuditAction{dblayer.AuditRelationAdd}},
+		{dblayer.AuditFilter{Actor: "alice"}, nil},
+		{dblayer.AuditFilter{Since: time.Now().Add(time.Hour)}, nil},
+	} {
+		entries, err := db.ListAudit(test.filter)
+		assert.Nil(t, err)
+		actions := []dblayer.AuditAction(nil)
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		assert.Equal(t, test.want, actions, "filter %+v", test.filter)
+	}
+}
+
+func TestAuditConcurrent(t *testing.T) {
+	db := dblayer.NewLocalfs(t.TempDir(), log.New(io.Discard, "", 0))
+
+	// Large entries written at the same time shouldn't be interleaved
+	diff := strings.Repeat("+C G Am F\n", 100000)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, db.AppendAudit(dblayer.AuditEntry{
+				Action: dblayer.AuditChordsUpdate,
+				Target: fmt.Sprintf("Song%d", i),
+				Diff:   diff,
+			}))
+		}()
+	}
+	wg.Wait()
+
+	entries, err := db.ListAudit(dblayer.AuditFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 20)
+}
+
+func TestRequestLogging(t *testing.T) {
+	logs := &bytes.Buffer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/chords", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/api/v1/tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token": "secret"}`))
+	})
+	h := handler{logger: slog.New(slog.NewJSONHandler(logs, nil)), mux: mux, maxBodyLog: 10}
+
+	serve := func(r *http.Request) (*httptest.ResponseRecorder, map[string]any) {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		entry := map[string]any{}
+		err := json.Unmarshal(logs.Bytes(), &entry)
+		assert.Nil(t, err, "logs: %s", logs)
+		return w, entry
+	}
+
+	// The request ID is kept, and bodies are logged up to the limit, with
+	// credentials redacted
+	r := httptest.NewRequest(http.MethodPut, "/api/v0/chords?id=Yesterday", bytes.NewReader([]byte("F Em7 A7 Dm G7 C")))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Request-ID", "abc-123")
+	w, entry := serve(r)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "abc-123", entry["request_id"])
+	assert.Equal(t, "PUT", entry["method"])
+	assert.Equal(t, "/api/v0/chords", entry["path"])
+	assert.Equal(t, "id=Yesterday", entry["query"])
+	assert.EqualValues(t, http.StatusOK, entry["status"])
+	assert.EqualValues(t, 16, entry["bytes"])
+	assert.Contains(t, entry, "duration_ms")
+	assert.Equal(t, "F Em7 A7 D... (6 more bytes)", entry["request_body"])
+	assert.Equal(t, "F Em7 A7 D... (6 more bytes)", entry["response_body"])
+	assert.Equal(t, "[REDACTED]", entry["headers"].(map[string]any)["Authorization"])
+	assert.NotContains(t, logs.String(), "secret")
+
+	// Bodies with credentials are never logged, and invalid request IDs
+	// are replaced
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/tokens", bytes.NewReader([]byte(`{"user": "alice"}`)))
+	r.Header.Set("X-Request-ID", "no spaces allowed")
+	w, entry = serve(r)
+	assert.EqualValues(t, http.StatusCreated, entry["status"])
+	assert.NotContains(t, entry, "request_body")
+	assert.NotContains(t, logs.String(), "secret")
+	id := w.Header().Get("X-Request-ID")
+	assert.Regexp(t, "^[0-9a-f]{16}$", id)
+	assert.Equal(t, id, entry["request_id"])
+}
+
+func TestMonitoring(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", newTestAPI(t, dblayer.WithMetrics(dblayer.NewTempDB(), "temp")).readyzHandler)
+	missing := filepath.Join(t.TempDir(), "missing")
+	notReady := newTestAPI(t, dblayer.NewLocalfs(missing, log.New(io.Discard, "", 0)))
+	mux.HandleFunc("/notready", notReady.readyzHandler)
+	h := handler{logger: slog.New(slog.DiscardHandler), mux: mux}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+	assert.Equal(t, http.StatusOK, get("/readyz").Code)
+	w := get("/notready")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "not ready")
+	get("/no/such/page")
+	// Unknown methods share a series
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/healthz", nil))
+
+	w = get("/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "version=0.0.4")
+	for _, line := range []string{
+		"# TYPE chords_http_requests_total counter",
+		`chords_http_requests_total{route="/healthz",method="GET",status="200"}`,
+		`chords_http_requests_total{route="/notready",method="GET",status="503"}`,
+		`chords_http_requests_total{route="unmatched",method="GET",status="404"}`,
+		`chords_http_requests_total{route="/healthz",method="other",status="200"}`,
+		`chords_http_request_duration_seconds_bucket{route="/readyz",method="GET",status="200",le="+Inf"}`,
+		`chords_db_operation_duration_seconds_count{backend="temp",method="Ping"}`,
+	} {
+		assert.Contains(t, w.Body.String(), line)
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	s.SetMetricsAddr("localhost:0")
+	addr, err := s.Listen()
+	assert.Nil(t, err)
+	go s.Serve()
+	defer s.Kill()
+
+	// Metrics are only served on the private listener
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", s.metricsListener.Addr()))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err = client.Get(fmt.Sprintf("http://%s/metrics", addr))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+}
+
+// closeRecorder records whether the database has been closed.
+type closeRecorder struct {
+	dblayer.ChordsDB
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ChordsDB.Close()
+}
+
+func TestShutdown(t *testing.T) {
+	db := &closeRecorder{ChordsDB: dblayer.NewTempDB()}
+	s, err := New(db, "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	started, finish := make(chan struct{}), make(chan struct{})
+	s.handler.mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		fmt.Fprint(w, "done")
+	})
+	addr, err := s.Listen()
+	assert.Nil(t, err)
+	served := make(chan error)
+	go func() { served <- s.Serve() }()
+
+	// Start a request, then shut down while it's in progress
+	resp := make(chan string)
+	go func() {
+		res, err := http.Get(fmt.Sprintf("http://%s/slow", addr))
+		if !assert.Nil(t, err) {
+			resp <- ""
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		resp <- string(body)
+	}()
+	<-started
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// New connections are refused, but the request in progress finishes
+	// before the database is closed
+	assert.Eventually(t, func() bool {
+		_, err := net.Dial("tcp", addr.String())
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, db.closed)
+	close(finish)
+	assert.Equal(t, "done", <-resp)
+	assert.Nil(t, <-shutdown)
+	assert.Nil(t, <-served)
+	assert.True(t, db.closed)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	db := &closeRecorder{ChordsDB: dblayer.NewTempDB()}
+	s, err := New(db, "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	started := make(chan struct{})
+	s.handler.mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	addr, err := s.Listen()
+	assert.Nil(t, err)
+	go s.Serve()
+	go http.Get(fmt.Sprintf("http://%s/stuck", addr))
+	<-started
+
+	// The database is closed even if requests don't finish in time, and
+	// the stuck request is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, db.closed)
+}
+
+func TestRateLimits(t *testing.T) {
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), nil)
+	assert.Nil(t, err)
+	s.SetLimits(Limits{
+		Rates: map[string]RateLimit{
+			"/api/v0/search": {Requests: 1, Per: time.Hour, Burst: 2},
+			"/api/v1/search": {Requests: 1, Per: time.Minute},
+		},
+		ClientIPHeader: "Fly-Client-IP",
+	})
+	get := func(path, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Fly-Client-IP", ip)
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Clients can make a burst of requests, then have to wait
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, get("/api/v0/search?q=yesterday", "192.0.2.1").Code)
+	}
+	w := get("/api/v0/search?q=yesterday", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+
+	// Each client has its own limit
+	assert.Equal(t, http.StatusOK, get("/api/v0/search?q=yesterday", "192.0.2.2").Code)
+
+	// v1 errors are JSON
+	assert.Equal(t, http.StatusOK, get("/api/v1/search?q=yesterday", "192.0.2.1").Code)
+	w = get("/api/v1/search?q=yesterday", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "rate limit exceeded, try again later"}`, w.Body.String())
+
+	// Other routes aren't limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, get("/api/v0/artists", "192.0.2.1").Code)
+	}
+
+	// Password checks are limited on any route, whether or not they succeed
+	s.SetLimits(Limits{
+		Passwords:      RateLimit{Requests: 1, Per: time.Minute, Burst: 2},
+		ClientIPHeader: "Fly-Client-IP",
+	})
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v0/artists", nil)
+		r.Header.Set("Fly-Client-IP", "192.0.2.1")
+		r.SetBasicAuth("nobody", fmt.Sprint(i))
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code)
+	}
+	assert.Equal(t, http.StatusOK, get("/api/v0/artists", "192.0.2.1").Code)
+
+	out := &bytes.Buffer{}
+	metrics.Default.WriteTo(out)
+	assert.Contains(t, out.String(), `chords_rate_limited_total{route="passwords"} `)
+	assert.Contains(t, out.String(), `chords_rate_limited_total{route="/api/v1/search"} `)
+	assert.Contains(t, out.String(), `chords_http_requests_total{route="/api/v0/search",method="GET",status="429"} `)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(map[string]RateLimit{"/search": {Requests: 1, Per: time.Second, Burst: 2}})
+	l.allow("/search", "192.0.2.1")
+	l.allow("/search", "192.0.2.2")
+	assert.Len(t, l.buckets, 2)
+
+	// Buckets are removed once they have filled up again
+	l.buckets[bucketKey{"/search", "192.0.2.1"}].updated = time.Now().Add(-time.Second)
+	l.swept = time.Now().Add(-time.Minute)
+	l.allow("/search", "192.0.2.3")
+	assert.Len(t, l.buckets, 2)
+	assert.NotContains(t, l.buckets, bucketKey{"/search", "192.0.2.1"})
+}
+
+func TestBodyLimits(t *testing.T) {
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	s.SetLimits(Limits{MaxBody: 10, MaxImportBody: 20})
+	serve := func(method, path, body string, knownLength bool) *httptest.ResponseRecorder {
+		r := authorise(httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		if !knownLength {
+			// Chunked requests are cut off when they reach the limit
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, known := range []bool{true, false} {
+		w := serve(http.MethodPut, "/api/v0/chords?id=Yesterday", "F Em7 A7 Dm G7 C", known)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "request body is larger than 10 bytes")
+
+		w = serve(http.MethodPost, "/api/v1/songs", `{"name": "Yesterday"}`, known)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.JSONEq(t, `{"error": "request body is larger than 10 bytes"}`, w.Body.String())
+	}
+
+	// Bodies within the limit are fine
+	w := serve(http.MethodPost, "/api/v1/songs", `{}`, true)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Imports have their own limit
+	w = serve(http.MethodPost, "/api/v0/import", "not a snapshot", true)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = serve(http.MethodPost, "/api/v0/import", "not a snapshot, and too long", true)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "request body is larger than 20 bytes")
+}
+
+func TestCaching(t *testing.T) {
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	_, err = s.api.db.NewSong(dblayer.SongMeta{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles"})
+	assert.Nil(t, err)
+	_, err = s.api.db.UpdateChords("Yesterday", []byte("F Em7 A7 Dm"))
+	assert.Nil(t, err)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, path := range []string{"/api/v0/chords?id=Yesterday", "/api/v1/songs/Yesterday/chords"} {
+		w := serve(httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "F Em7 A7 Dm", w.Body.String())
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+		etag := w.Header().Get("ETag")
+		assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+		lastModified := w.Header().Get("Last-Modified")
+		assert.NotEmpty(t, lastModified)
+
+		// Unchanged chords aren't sent again
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("If-None-Match", etag)
+		w = serve(r)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+
+		r = httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("If-Modified-Since", lastModified)
+		assert.Equal(t, http.StatusNotModified, serve(r).Code)
+
+		// Other versions are
+		r = httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("If-None-Match", `"outdated"`)
+		assert.Equal(t, http.StatusOK, serve(r).Code)
+	}
+
+	// Listings can be cached for a short time
+	w := serve(httptest.NewRequest(http.MethodGet, "/api/v0/songs", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=30", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	w = serve(httptest.NewRequest(http.MethodGet, "/api/v0/random", nil))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	// Errors and writes aren't cached
+	w = serve(httptest.NewRequest(http.MethodGet, "/api/v1/songs/Nope/chords", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	w = serve(authorise(httptest.NewRequest(http.MethodPut, "/api/v0/chords?id=Yesterday", bytes.NewBufferString("C"))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+}
+
+func TestCompression(t *testing.T) {
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), newTestAccounts(t))
+	assert.Nil(t, err)
+	_, err = s.api.db.NewSong(dblayer.SongMeta{ID: "Yesterday", Name: "Yesterday", Artist: "The Beatles"})
+	assert.Nil(t, err)
+	chords := bytes.Repeat([]byte("F Em7 A7 Dm Bb C7 F\n"), 100)
+	_, err = s.api.db.UpdateChords("Yesterday", chords)
+	assert.Nil(t, err)
+	get := func(path, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, test := range []struct {
+		encoding string
+		decode   func(io.Reader) (io.Reader, error)
+	}{
+		{"br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	} {
+		w := get("/api/v0/chords?id=Yesterday", "deflate, "+test.encoding, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Less(t, w.Body.Len(), len(chords))
+		decoded, err := test.decode(w.Body)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(decoded)
+		assert.Nil(t, err)
+		assert.Equal(t, chords, body)
+
+		// The compressed content has its own ETag, which still matches
+		etag := w.Header().Get("ETag")
+		assert.Regexp(t, `^"[0-9a-f]{32}-`+test.encoding+`"$`, etag)
+		w = get("/api/v0/chords?id=Yesterday", test.encoding, etag)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	}
+
+	// Clients which don't accept any supported encoding, and small
+	// responses, aren't compressed
+	w := get("/api/v0/chords?id=Yesterday", "br;q=0, deflate", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, chords, w.Body.Bytes())
+	w = get("/api/v0/songs", "br", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                       "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "br",
+		"gzip;q=1.0, br;q=0.5":   "gzip",
+		"br;q=0, gzip;q=0":       "",
+		"*":                      "br",
+		"*;q=0.1, gzip":          "gzip",
+		"identity, deflate":      "",
+		"BR ; q=0.8, gzip;q=bad": "br",
+	} {
+		assert.Equal(t, want, negotiateEncoding(header), "Accept-Encoding: %s", header)
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	// The frontend is served from the repo root
+	t.Chdir("../..")
+	s, err := New(dblayer.NewTempDB(), "localhost:0", slog.New(slog.DiscardHandler), nil)
+	assert.Nil(t, err)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// Pages link to versioned assets
+	w := get("/c/artists")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	version := assetVersion("src/frontend/style.css")
+	assert.Contains(t, w.Body.String(), `href="style.css?v=`+version+`"`)
+
+	// which can be cached for good
+	w = get("/c/style.css?v=" + version)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	for _, path := range []string{"/c/style.css", "/c/style.css?v=old"} {
+		w = get(path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	}
+}
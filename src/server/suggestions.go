@@ -0,0 +1,136 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/server/suggestions.go
+// Handlers for the v1 suggestions API. Anyone can suggest a correction to a
+// song's chords; maintainers review the suggestions, and accept or reject
+// them.
+
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/barrettj12/chords/src/auth"
+	"github.com/barrettj12/chords/src/data"
+	"github.com/barrettj12/chords/src/dblayer"
+)
+
+// Limits on suggestions, as anyone can submit them. The number of lines is
+// limited as well as the size, to bound the cost of diffing them.
+const (
+	maxSuggestionChords  = 64 << 10
+	maxSuggestionLines   = 2000
+	maxSuggestionComment = 1000
+	maxSuggestionAuthor  = 100
+)
+
+// suggestionInput is the request body for suggesting a correction.
+type suggestionInput struct {
+	Chords  string `json:"chords"`
+	Comment string `json:"comment,omitempty"`
+	// Author is an optional name, to credit the suggestion to.
+	Author string `json:"author,omitempty"`
+}
+
+// suggestionPreview is a suggestion, with a diff against the song's current
+// chords.
+type suggestionPreview struct {
+	dblayer.Suggestion
+	// Stale is true if the chords have changed since the suggestion was
+	// made. CurrentDiff is then a diff from the current chords.
+	Stale       bool   `json:"stale"`
+	CurrentDiff string `json:"currentDiff,omitempty"`
+}
+
+var suggestionStatuses = []dblayer.SuggestionStatus{
+	dblayer.SuggestionPending, dblayer.SuggestionAccepted, dblayer.SuggestionRejected,
+}
+
+func (s *ChordsAPI) addSuggestionV1(r *http.Request) (any, error) {
+	in := suggestionInput{}
+	if err := decodeJSON(r, &in); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(in.Chords) > maxSuggestionChords:
+		return nil, badRequest("chords must be at most %d bytes", maxSuggestionChords)
+	case strings.Count(in.Chords, "\n") >= maxSuggestionLines:
+		return nil, badRequest("chords must be at most %d lines", maxSuggestionLines)
+	case len(in.Comment) > maxSuggestionComment:
+		return nil, badRequest("comment must be at most %d bytes", maxSuggestionComment)
+	case len(in.Author) > maxSuggestionAuthor:
+		return nil, badRequest("author must be at most %d bytes", maxSuggestionAuthor)
+	}
+
+	id := r.PathValue("id")
+	songs, err := s.v1.Songs(r.Context(), data.SongsFilters{ID: data.SongID(id)})
+	if _, err := one(songs, err, "song", id); err != nil {
+		return nil, err
+	}
+	suggestion, err := dblayer.NewSuggestion(s.db, id, in.Chords, in.Comment, in.Author)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.AddSuggestion(suggestion); err != nil {
+		return nil, err
+	}
+	s.log(r).Info("suggestion added", "id", suggestion.ID, "song", id)
+	return suggestion, nil
+}
+
+func (s *ChordsAPI) listSuggestionsV1(r *http.Request) (any, error) {
+	status := dblayer.SuggestionStatus(r.URL.Query().Get("status"))
+	if status != "" && !slices.Contains(suggestionStatuses, status) {
+		return nil, badRequest("unknown status %q", status)
+	}
+	suggestions, err := s.db.ListSuggestions(status)
+	if err != nil {
+		return nil, err
+	}
+	if song := r.URL.Query().Get("song"); song != "" {
+		filtered := []dblayer.Suggestion{}
+		for _, sug := range suggestions {
+			if sug.SongID == song {
+				filtered = append(filtered, sug)
+			}
+		}
+		suggestions = filtered
+	}
+	return suggestions, nil
+}
+
+func (s *ChordsAPI) getSuggestionV1(r *http.Request) (any, error) {
+	suggestion, err := s.db.GetSuggestion(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	preview := suggestionPreview{Suggestion: suggestion}
+	if suggestion.Status == dblayer.SuggestionPending {
+		diff, stale, err := dblayer.CurrentDiff(s.db, suggestion)
+		if err != nil {
+			return nil, err
+		}
+		preview.Stale = stale
+		if stale {
+			preview.CurrentDiff = diff
+		}
+	}
+	return preview, nil
+}
+
+func (s *ChordsAPI) acceptSuggestionV1(r *http.Request) (any, error) {
+	// The query has already been validated.
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	return dblayer.AcceptSuggestion(s.dbFor(r), r.PathValue("id"), auth.UserFrom(r.Context()).Name, force)
+}
+
+func (s *ChordsAPI) rejectSuggestionV1(r *http.Request) (any, error) {
+	return dblayer.RejectSuggestion(s.db, r.PathValue("id"), auth.UserFrom(r.Context()).Name)
+}
@@ -0,0 +1,96 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/sheet/chord.go
+// Parsing and transposing individual chords.
+
+package sheet
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Chord is a parsed chord symbol, e.g. "F#m7/C#".
+type Chord struct {
+	Root   string // e.g. "F#"
+	Suffix string // e.g. "m7"
+	Bass   string // e.g. "C#", or "" if there is no bass note
+}
+
+var chordRegex = regexp.MustCompile(
+	`^([A-G][#b]?)((?:maj|min|dim|aug|sus|add|m|M|\+|°|ø|[#b]?[0-9]+|\(|\))*)(?:/([A-G][#b]?))?$`)
+
+// ParseChord parses a chord symbol. It returns false if s is not a chord.
+func ParseChord(s string) (Chord, bool) {
+	m := chordRegex.FindStringSubmatch(s)
+	if m == nil {
+		return Chord{}, false
+	}
+	return Chord{Root: m[1], Suffix: m[2], Bass: m[3]}, true
+}
+
+func (c Chord) String() string {
+	if c.Bass == "" {
+		return c.Root + c.Suffix
+	}
+	return c.Root + c.Suffix + "/" + c.Bass
+}
+
+// Minor returns true if this is a minor chord.
+func (c Chord) Minor() bool {
+	return (strings.HasPrefix(c.Suffix, "m") && !strings.HasPrefix(c.Suffix, "maj")) ||
+		strings.HasPrefix(c.Suffix, "min")
+}
+
+// Transpose returns the chord transposed by the given number of semitones.
+// Accidentals are written as flats if flats is true, otherwise as sharps.
+func (c Chord) Transpose(semitones int, flats bool) Chord {
+	c.Root = transposeNote(c.Root, semitones, flats)
+	if c.Bass != "" {
+		c.Bass = transposeNote(c.Bass, semitones, flats)
+	}
+	return c
+}
+
+var (
+	sharpNotes = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	flatNotes  = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
+)
+
+// pitchClass returns the number of semitones from C to the given note, in
+// the range [0, 12).
+func pitchClass(note string) int {
+	pc := strings.Index("C D EF G A B", note[:1])
+	for _, acc := range note[1:] {
+		switch acc {
+		case '#':
+			pc++
+		case 'b':
+			pc--
+		}
+	}
+	return (pc + 12) % 12
+}
+
+func transposeNote(note string, semitones int, flats bool) string {
+	pc := ((pitchClass(note)+semitones)%12 + 12) % 12
+	if flats {
+		return flatNotes[pc]
+	}
+	return sharpNotes[pc]
+}
+
+// preferFlats returns true if the key with the given tonic should be written
+// using flats rather than sharps.
+func preferFlats(tonic int, minor bool) bool {
+	if minor {
+		// D, G, C, F, Bb, Eb minor
+		return tonic == 2 || tonic == 7 || tonic == 0 || tonic == 5 || tonic == 10 || tonic == 3
+	}
+	// F, Bb, Eb, Ab, Db major
+	return tonic == 5 || tonic == 10 || tonic == 3 || tonic == 8 || tonic == 1
+}
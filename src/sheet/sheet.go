@@ -0,0 +1,391 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/sheet/sheet.go
+// Rendering chord sheets as plain text: transposing, reflowing to a given
+// width while keeping chords aligned over the lyrics, and highlighting.
+// A chord sheet is plain text, where chords are written on their own line,
+// above the lyrics they go with.
+
+package sheet
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// LineKind classifies the lines of a chord sheet.
+type LineKind int
+
+const (
+	Blank   LineKind = iota
+	Lyrics           // any line which isn't one of the below
+	Chords           // a line containing only chords (and separators)
+	Section          // a section heading, e.g. "Chorus:" or "[Verse 2]"
+)
+
+// Line is a single line of a chord sheet.
+type Line struct {
+	Kind LineKind
+	Text string
+}
+
+// Options controls how a chord sheet is rendered.
+type Options struct {
+	// Number of semitones to transpose the chords by
+	Transpose int
+	// Only show chords and section headings, not lyrics
+	ChordsOnly bool
+	// Maximum line width. Lines longer than this are wrapped, keeping chords
+	// above the correct lyrics. If Width <= 0, lines are not wrapped.
+	Width int
+	// Highlight chords and section headings using ANSI escape codes
+	Colour bool
+}
+
+// Render renders a chord sheet according to the given options.
+func Render(text string, opts Options) string {
+	lines := Parse(text)
+	if opts.Transpose%12 != 0 {
+		lines = transpose(lines, opts.Transpose)
+	}
+	if opts.ChordsOnly {
+		lines = chordsOnly(lines)
+	}
+	if opts.Width > 0 {
+		lines = reflow(lines, opts.Width)
+	}
+
+	sb := &strings.Builder{}
+	for _, l := range lines {
+		if opts.Colour {
+			sb.WriteString(colourise(l))
+		} else {
+			sb.WriteString(l.Text)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Parse splits a chord sheet into lines, and classifies each one. Tabs are
+// expanded to spaces, so that columns line up.
+func Parse(text string) []Line {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return nil
+	}
+
+	lines := []Line{}
+	for _, s := range strings.Split(text, "\n") {
+		s = strings.TrimRight(expandTabs(s), " ")
+		lines = append(lines, Line{Kind: classify(s), Text: s})
+	}
+	return lines
+}
+
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	sb := &strings.Builder{}
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			n := 8 - col%8
+			sb.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		sb.WriteRune(r)
+		col++
+	}
+	return sb.String()
+}
+
+var (
+	// Non-chord tokens which may appear on a chord line
+	separatorRegex = regexp.MustCompile(`^(-+|\|+|/|%|\.+|:?\|\|?:?|\(?[x×]\d+\)?|N\.?C\.?)$`)
+	sectionRegex   = regexp.MustCompile(`^(\[.+\]|[^:]{1,30}:)$`)
+)
+
+func classify(s string) LineKind {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return Blank
+	}
+
+	chords := 0
+	for i, tok := range strings.Fields(trimmed) {
+		switch {
+		case isChord(tok):
+			chords++
+		case separatorRegex.MatchString(tok):
+		case i == 0 && strings.HasSuffix(tok, ":"):
+			// A label at the start of a chord line, e.g. "Intro: C G"
+		default:
+			if sectionRegex.MatchString(trimmed) {
+				return Section
+			}
+			return Lyrics
+		}
+	}
+	if chords == 0 {
+		if sectionRegex.MatchString(trimmed) {
+			return Section
+		}
+		return Lyrics
+	}
+	return Chords
+}
+
+// isChord returns true if tok is a chord, possibly in parentheses.
+func isChord(tok string) bool {
+	_, ok := ParseChord(strings.Trim(tok, "()"))
+	return ok
+}
+
+// token is a word on a line, and the column it starts at.
+type token struct {
+	col  int
+	text string
+}
+
+func tokens(s string) []token {
+	toks := []token{}
+	col := 0
+	start := -1
+	for _, r := range s + " " {
+		if r == ' ' {
+			if start >= 0 {
+				toks = append(toks, token{start, string([]rune(s)[start:col])})
+				start = -1
+			}
+		} else if start < 0 {
+			start = col
+		}
+		col++
+	}
+	return toks
+}
+
+// joinTokens lays out tokens at their columns. If a token would overlap the
+// previous one, it is moved right to leave a single space.
+func joinTokens(toks []token) string {
+	sb := &strings.Builder{}
+	col := 0
+	for i, tok := range toks {
+		pad := tok.col - col
+		if i > 0 && pad < 1 {
+			pad = 1
+		}
+		if pad > 0 {
+			sb.WriteString(strings.Repeat(" ", pad))
+			col += pad
+		}
+		sb.WriteString(tok.text)
+		col += len([]rune(tok.text))
+	}
+	return sb.String()
+}
+
+// mapChord applies f to the chord in a token, which may be surrounded by
+// parentheses. Tokens which aren't chords are returned unchanged.
+func mapChord(tok string, f func(Chord) string) string {
+	inner := strings.Trim(tok, "()")
+	c, ok := ParseChord(inner)
+	if !ok {
+		return tok
+	}
+	i := strings.Index(tok, inner)
+	return tok[:i] + f(c) + tok[i+len(inner):]
+}
+
+// transpose transposes all chord lines by the given number of semitones.
+// Accidentals are spelled according to the key of the transposed song, which
+// is guessed from the first chord.
+func transpose(lines []Line, semitones int) []Line {
+	flats := false
+	for _, l := range lines {
+		if l.Kind != Chords {
+			continue
+		}
+		for _, tok := range strings.Fields(l.Text) {
+			if c, ok := ParseChord(strings.Trim(tok, "()")); ok {
+				tonic := ((pitchClass(c.Root)+semitones)%12 + 12) % 12
+				flats = preferFlats(tonic, c.Minor())
+				break
+			}
+		}
+		break
+	}
+
+	result := make([]Line, len(lines))
+	for i, l := range lines {
+		result[i] = l
+		if l.Kind != Chords {
+			continue
+		}
+		toks := tokens(l.Text)
+		for j := range toks {
+			toks[j].text = mapChord(toks[j].text, func(c Chord) string {
+				return c.Transpose(semitones, flats).String()
+			})
+		}
+		result[i].Text = joinTokens(toks)
+	}
+	return result
+}
+
+// chordsOnly removes lyrics, and compacts the spacing on chord lines (which
+// was only needed to line chords up with the lyrics).
+func chordsOnly(lines []Line) []Line {
+	result := []Line{}
+	for _, l := range lines {
+		switch l.Kind {
+		case Lyrics:
+			continue
+		case Blank:
+			// Collapse runs of blank lines
+			if len(result) == 0 || result[len(result)-1].Kind == Blank {
+				continue
+			}
+		case Chords:
+			l.Text = strings.Join(strings.Fields(l.Text), " ")
+		}
+		result = append(result, l)
+	}
+	if len(result) > 0 && result[len(result)-1].Kind == Blank {
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+// reflow wraps lines which are longer than width. A chord line followed by a
+// lyric line is wrapped at the same column as the lyrics, so that each chord
+// stays above the same lyric.
+func reflow(lines []Line, width int) []Line {
+	result := []Line{}
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case l.Kind == Chords && i+1 < len(lines) && lines[i+1].Kind == Lyrics:
+			chords, lyrics := wrapPair([]rune(l.Text), []rune(lines[i+1].Text), width)
+			for j := range chords {
+				if strings.TrimSpace(chords[j]) != "" {
+					result = append(result, Line{Chords, chords[j]})
+				}
+				if strings.TrimSpace(lyrics[j]) != "" {
+					result = append(result, Line{Lyrics, lyrics[j]})
+				}
+			}
+			i++
+		case l.Kind == Chords:
+			chords, _ := wrapPair([]rune(l.Text), nil, width)
+			for _, c := range chords {
+				result = append(result, Line{Chords, c})
+			}
+		default:
+			_, text := wrapPair(nil, []rune(l.Text), width)
+			for _, t := range text {
+				result = append(result, Line{l.Kind, t})
+			}
+		}
+	}
+	return result
+}
+
+// wrapPair wraps a chord line and the lyric line below it to the given
+// width. Breaks are made where there is a space in the lyrics, and no chord
+// crosses the break. Both returned slices have the same length.
+func wrapPair(chords, lyrics []rune, width int) ([]string, []string) {
+	at := func(line []rune, i int) rune {
+		if i < 0 || i >= len(line) {
+			return ' '
+		}
+		return line[i]
+	}
+	// canBreak returns true if the lines can be broken before column i.
+	canBreak := func(i int) bool {
+		return at(lyrics, i) == ' ' && (at(chords, i) == ' ' || at(chords, i-1) == ' ')
+	}
+
+	var chordParts, lyricParts []string
+	for max(len(chords), len(lyrics)) > width {
+		brk := width
+		for brk > 0 && !canBreak(brk) {
+			brk--
+		}
+		if brk == 0 {
+			// No good place to break - just cut the line
+			brk = width
+		}
+
+		chordParts = append(chordParts, strings.TrimRight(string(chords[:min(brk, len(chords))]), " "))
+		lyricParts = append(lyricParts, strings.TrimRight(string(lyrics[:min(brk, len(lyrics))]), " "))
+		chords = chords[min(brk, len(chords)):]
+		lyrics = lyrics[min(brk, len(lyrics)):]
+
+		// Remove the spaces at the start of both lines
+		skip := min(leadingSpaces(chords), leadingSpaces(lyrics))
+		chords = chords[min(skip, len(chords)):]
+		lyrics = lyrics[min(skip, len(lyrics)):]
+	}
+	chordParts = append(chordParts, string(chords))
+	lyricParts = append(lyricParts, string(lyrics))
+	return chordParts, lyricParts
+}
+
+// leadingSpaces returns the number of spaces at the start of line. A blank
+// line doesn't limit how many spaces can be removed, so it returns MaxInt.
+func leadingSpaces(line []rune) int {
+	for i, r := range line {
+		if r != ' ' {
+			return i
+		}
+	}
+	return math.MaxInt
+}
+
+// ANSI escape codes used for highlighting
+const (
+	ansiChord   = "\x1b[1;36m"
+	ansiSection = "\x1b[1m"
+	ansiReset   = "\x1b[0m"
+)
+
+// colourise highlights the chords on a chord line, or a section heading.
+func colourise(l Line) string {
+	switch l.Kind {
+	case Section:
+		return ansiSection + l.Text + ansiReset
+	case Chords:
+		toks := tokens(l.Text)
+		for i := range toks {
+			toks[i].text = mapChord(toks[i].text, func(c Chord) string {
+				return ansiChord + c.String() + ansiReset
+			})
+		}
+		// Escape codes don't take up columns, so lay out the tokens by hand
+		sb := &strings.Builder{}
+		col := 0
+		for _, tok := range toks {
+			sb.WriteString(strings.Repeat(" ", tok.col-col))
+			sb.WriteString(tok.text)
+			col = tok.col + len([]rune(stripANSI(tok.text)))
+		}
+		return sb.String()
+	}
+	return l.Text
+}
+
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func stripANSI(s string) string {
+	return ansiRegex.ReplaceAllString(s, "")
+}
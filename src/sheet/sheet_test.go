@@ -0,0 +1,91 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/sheet/sheet_test.go
+// Tests for chord sheet rendering.
+
+package sheet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yourSong = `Verse:
+   C            Fmaj7         G/B
+It's a little bit funny, this feeling inside
+
+Chorus:
+F    G   Am  Am/G
+How wonderful life is
+`
+
+func TestParseChord(t *testing.T) {
+	for _, s := range []string{"C", "F#m7", "Bbmaj7", "G/B", "Dsus4", "E7b9", "Cadd9", "A+", "Bdim"} {
+		c, ok := ParseChord(s)
+		assert.True(t, ok, s)
+		assert.Equal(t, s, c.String())
+	}
+	for _, s := range []string{"It's", "Am-", "H", "Add", "Be"} {
+		_, ok := ParseChord(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestParse(t *testing.T) {
+	kinds := []LineKind{}
+	for _, l := range Parse(yourSong) {
+		kinds = append(kinds, l.Kind)
+	}
+	assert.Equal(t, []LineKind{Section, Chords, Lyrics, Blank, Section, Chords, Lyrics}, kinds)
+}
+
+func TestTranspose(t *testing.T) {
+	// Up 1 semitone to Db major, so flats are used. Chords which get longer
+	// push the next chord right only if they would collide.
+	assert.Equal(t, `Verse:
+   Db           Gbmaj7        Ab/C
+It's a little bit funny, this feeling inside
+
+Chorus:
+Gb   Ab  Bbm Bbm/Ab
+How wonderful life is
+`, Render(yourSong, Options{Transpose: 1}))
+
+	// Down 3 semitones to A major, using sharps
+	assert.Equal(t, "A D F#m\n", Render("C F Am", Options{Transpose: -3}))
+	// A whole octave is a no-op
+	assert.Equal(t, "C F Am\n", Render("C F Am", Options{Transpose: 12}))
+}
+
+func TestChordsOnly(t *testing.T) {
+	assert.Equal(t, `Verse:
+C Fmaj7 G/B
+
+Chorus:
+F G Am Am/G
+`, Render(yourSong, Options{ChordsOnly: true}))
+}
+
+func TestReflow(t *testing.T) {
+	// Each chord stays above the same syllable
+	assert.Equal(t, `Verse:
+   C            Fmaj7
+It's a little bit funny,
+     G/B
+this feeling inside
+
+Chorus:
+F    G   Am  Am/G
+How wonderful life is
+`, Render(yourSong, Options{Width: 25}))
+}
+
+func TestColour(t *testing.T) {
+	assert.Equal(t, "\x1b[1mChorus:\x1b[0m\n\x1b[1;36mC\x1b[0m - (\x1b[1;36mG7\x1b[0m)\n",
+		Render("Chorus:\nC - (G7)", Options{Colour: true}))
+}
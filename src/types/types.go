@@ -6,6 +6,15 @@ type SongMeta struct {
 	Artist   string `json:"artist"`
 	Album    string `json:"album,omitempty"`
 	TrackNum int    `json:"trackNum,omitempty"`
+	// Key the song is played in, e.g. "G" or "F#m".
+	Key string `json:"key,omitempty"`
+	// Tags are free-form labels for the song, e.g. "christmas".
+	Tags []string `json:"tags,omitempty"`
+
+	// IDs of the artist and album records for this song (see data.Library).
+	// These are filled in by the server.
+	ArtistID string `json:"artistId,omitempty"`
+	AlbumID  string `json:"albumId,omitempty"`
 }
 
 type SearchResult struct {
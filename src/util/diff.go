@@ -0,0 +1,242 @@
+// Jordy's Chordies - a web app for song chords
+//     https://github.com/barrettj12/chords
+// Copyright 2022, Jordan Barrett (@barrettj12)
+//     https://github.com/barrettj12
+// Licensed under the GNU AGPLv3.
+
+// src/util/diff.go
+// Line-based diffs between two texts.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffKind says whether a line in a diff is unchanged, removed or added.
+type DiffKind byte
+
+const (
+	DiffEqual  DiffKind = ' '
+	DiffDelete DiffKind = '-'
+	DiffInsert DiffKind = '+'
+)
+
+// DiffLine is a single line in a diff.
+type DiffLine struct {
+	Kind DiffKind
+	Text string
+}
+
+// SplitLines splits text into lines. A trailing newline does not produce an
+// extra empty line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// DiffLines computes a minimal line diff which turns a into b, using Myers'
+// algorithm in linear space: it takes O((n+m)D) time, where D is the number
+// of changed lines, and O(n+m) memory, so large inputs with few changes are
+// cheap.
+func DiffLines(a, b []string) []DiffLine {
+	size := 2*((len(a)+len(b)+1)/2) + 2
+	d := &differ{
+		a: a, b: b,
+		lines: make([]DiffLine, 0, max(len(a), len(b))),
+		vf:    make([]int, size),
+		vb:    make([]int, size),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.lines
+}
+
+// differ holds the state of DiffLines. vf and vb hold the furthest
+// reaching paths on each diagonal, searching forward and backward; they
+// are reused by each call to middleSnake.
+type differ struct {
+	a, b   []string
+	lines  []DiffLine
+	vf, vb []int
+}
+
+// compare appends the diff turning a[aLo:aHi] into b[bLo:bHi] to d.lines.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// Common prefixes and suffixes are always part of the diff's unchanged
+	// lines
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.lines = append(d.lines, DiffLine{DiffEqual, d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		suffix++
+	}
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.lines = append(d.lines, DiffLine{DiffInsert, line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.lines = append(d.lines, DiffLine{DiffDelete, line})
+		}
+	default:
+		// Split the problem at the middle of an optimal path. Both halves
+		// have fewer changes, so this terminates.
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for _, line := range d.a[x:u] {
+			d.lines = append(d.lines, DiffLine{DiffEqual, line})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for _, line := range d.a[aHi : aHi+suffix] {
+		d.lines = append(d.lines, DiffLine{DiffEqual, line})
+	}
+}
+
+// middleSnake finds the middle snake of an optimal path turning
+// a[aLo:aHi] into b[bLo:bHi], by searching forward from the start and
+// backward from the end until the searches meet. It returns the snake's
+// start (x, y) and end (u, v), as indices into a and b.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	// vf[off+k] is the furthest x reached on diagonal k = x-y going
+	// forward, and vb[off+k] the furthest reached going backward, measured
+	// from the end of a and b.
+	maxD := (n + m + 1) / 2
+	off := maxD
+	vf, vb := d.vf, d.vb
+	vf[off+1], vb[off+1] = 0, 0
+
+	for D := 0; D <= maxD; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			// Diagonal k forward is diagonal delta-k backward
+			if c := delta - k; odd && c >= -(D-1) && c <= D-1 && x+vb[off+c] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+
+		for c := -D; c <= D; c += 2 {
+			var x int
+			if c == -D || (c != D && vb[off+c-1] < vb[off+c+1]) {
+				x = vb[off+c+1]
+			} else {
+				x = vb[off+c-1] + 1
+			}
+			y := x - c
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			vb[off+c] = x
+			if k := delta - c; !odd && k >= -D && k <= D && x+vf[off+k] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	panic("util.DiffLines: no middle snake")
+}
+
+// UnifiedDiff returns a unified diff (as produced by `diff -u`) turning text a
+// into text b, with the given number of context lines around each change.
+// It returns the empty string if a and b are identical.
+func UnifiedDiff(aLabel, bLabel, a, b string, context int) string {
+	lines := DiffLines(SplitLines(a), SplitLines(b))
+
+	// Find the indices of changed lines
+	changes := []int{}
+	for i, l := range lines {
+		if l.Kind != DiffEqual {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "--- %s\n+++ %s\n", aLabel, bLabel)
+
+	// Group changes into hunks: changes separated by at most 2*context
+	// unchanged lines belong in the same hunk.
+	start := 0
+	for start < len(changes) {
+		end := start
+		for end+1 < len(changes) && changes[end+1]-changes[end] <= 2*context+1 {
+			end++
+		}
+
+		from := max(changes[start]-context, 0)
+		to := min(changes[end]+context+1, len(lines))
+		writeHunk(sb, lines, from, to)
+		start = end + 1
+	}
+	return sb.String()
+}
+
+// writeHunk writes lines[from:to] as a single hunk of a unified diff.
+func writeHunk(sb *strings.Builder, lines []DiffLine, from, to int) {
+	// Count lines of a and b before the hunk, and inside it
+	aBefore, bBefore := 0, 0
+	for _, l := range lines[:from] {
+		if l.Kind != DiffInsert {
+			aBefore++
+		}
+		if l.Kind != DiffDelete {
+			bBefore++
+		}
+	}
+	aCount, bCount := 0, 0
+	for _, l := range lines[from:to] {
+		if l.Kind != DiffInsert {
+			aCount++
+		}
+		if l.Kind != DiffDelete {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aBefore, aCount), hunkRange(bBefore, bCount))
+	for _, l := range lines[from:to] {
+		fmt.Fprintf(sb, "%c%s\n", l.Kind, l.Text)
+	}
+}
+
+// hunkRange formats the line range for a hunk header. By convention, an
+// empty range starts at the line before the hunk.
+func hunkRange(before, count int) string {
+	start := before + 1
+	if count == 0 {
+		start = before
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
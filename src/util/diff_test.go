@@ -0,0 +1,207 @@
+package util
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		about   string
+		a, b    string
+		context int
+		want    string
+	}{{
+		about: "empty",
+		want:  "",
+	}, {
+		about: "identical",
+		a:     "C\nG\n",
+		b:     "C\nG\n",
+		want:  "",
+	}, {
+		about: "insert only",
+		b:     "C\nG\n",
+		want: `--- a
++++ b
+@@ -0,0 +1,2 @@
++C
++G
+`,
+	}, {
+		about: "delete only",
+		a:     "C\nG\n",
+		want: `--- a
++++ b
+@@ -1,2 +0,0 @@
+-C
+-G
+`,
+	}, {
+		about: "context",
+		a:     "1\n2\n3\n4\n5\n6\n7\n8\n",
+		b:     "1\n2\n3\n4\nX\n5\n6\n7\n8\n",
+		want: `--- a
++++ b
+@@ -2,6 +2,7 @@
+ 2
+ 3
+ 4
++X
+ 5
+ 6
+ 7
+`,
+	}, {
+		about:   "hunks merged",
+		a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		b:       "1\nB\n3\n4\nE\n6\n7\n8\n9\n",
+		context: 1,
+		want: `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+-2
++B
+ 3
+ 4
+-5
++E
+ 6
+`,
+	}, {
+		about:   "hunks separate",
+		a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		b:       "1\nB\n3\n4\n5\nF\n7\n8\n9\n",
+		context: 1,
+		want: `--- a
++++ b
+@@ -1,3 +1,3 @@
+ 1
+-2
++B
+ 3
+@@ -5,3 +5,3 @@
+ 5
+-6
++F
+ 7
+`,
+	}, {
+		about: "no trailing newline",
+		a:     "C\nG",
+		b:     "C\nAm",
+		want: `--- a
++++ b
+@@ -1,2 +1,2 @@
+ C
+-G
++Am
+`,
+	}, {
+		about: "only trailing newline differs",
+		a:     "C\nG",
+		b:     "C\nG\n",
+		want:  "",
+	}}
+
+	for _, test := range tests {
+		context := test.context
+		if context == 0 {
+			context = 3
+		}
+		assert.Equal(t, test.want, UnifiedDiff("a", "b", test.a, test.b, context), test.about)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		about string
+		a, b  []string
+		want  []DiffLine
+	}{{
+		about: "empty",
+		want:  []DiffLine{},
+	}, {
+		about: "identical",
+		a:     []string{"C", "G"},
+		b:     []string{"C", "G"},
+		want:  []DiffLine{{DiffEqual, "C"}, {DiffEqual, "G"}},
+	}, {
+		about: "insert only",
+		b:     []string{"C"},
+		want:  []DiffLine{{DiffInsert, "C"}},
+	}, {
+		about: "delete only",
+		a:     []string{"C"},
+		want:  []DiffLine{{DiffDelete, "C"}},
+	}, {
+		about: "change in the middle",
+		a:     []string{"C", "F", "G"},
+		b:     []string{"C", "Fmaj7", "G"},
+		want:  []DiffLine{{DiffEqual, "C"}, {DiffDelete, "F"}, {DiffInsert, "Fmaj7"}, {DiffEqual, "G"}},
+	}}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, DiffLines(test.a, test.b), test.about)
+	}
+}
+
+// TestDiffLinesMinimal checks DiffLines against a simple quadratic LCS on
+// random inputs: the diff should turn a into b, with as few changes as
+// possible.
+func TestDiffLinesMinimal(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	randomLines := func() []string {
+		lines := make([]string, r.IntN(20))
+		for i := range lines {
+			// Few distinct lines, so there are many matches
+			lines[i] = fmt.Sprint(r.IntN(4))
+		}
+		return lines
+	}
+
+	for range 500 {
+		a, b := randomLines(), randomLines()
+		diff := DiffLines(a, b)
+
+		gotA, gotB := []string{}, []string{}
+		changes := 0
+		for _, l := range diff {
+			if l.Kind != DiffInsert {
+				gotA = append(gotA, l.Text)
+			}
+			if l.Kind != DiffDelete {
+				gotB = append(gotB, l.Text)
+			}
+			if l.Kind != DiffEqual {
+				changes++
+			}
+		}
+		about := fmt.Sprintf("a=%q b=%q", strings.Join(a, ""), strings.Join(b, ""))
+		assert.Equal(t, append([]string{}, a...), gotA, about)
+		assert.Equal(t, append([]string{}, b...), gotB, about)
+		assert.Equal(t, len(a)+len(b)-2*lcs(a, b), changes, about)
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
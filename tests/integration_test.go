@@ -13,10 +13,12 @@ package tests
 import (
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"testing"
 
+	"github.com/barrettj12/chords/src/auth"
 	"github.com/barrettj12/chords/src/client"
 	"github.com/barrettj12/chords/src/dblayer"
 	"github.com/barrettj12/chords/src/server"
@@ -37,6 +39,10 @@ func TestNewSong(t *testing.T) {
 	}
 	resp, err := c.NewSong(newSong)
 	handleClientError(t, err)
+
+	// The server links the song to artist and album records
+	newSong.ArtistID = "JackJohnson"
+	newSong.AlbumID = "InBetweenDreams"
 	assert.Equal(t, resp, newSong)
 
 	// TODO: check db state via fs?
@@ -92,7 +98,9 @@ func setup(t *testing.T) (dblayer.ChordsDB, *server.Server, *client.Client, func
 
 	// Set up server
 	authKey := "passwordfoo"
-	s, err := server.New(db, ":0", logger, authKey)
+	accounts, err := auth.NewAccounts(auth.NewMemoryStore(), authKey)
+	assert.Nil(t, err)
+	s, err := server.New(db, ":0", slog.New(slog.NewTextHandler(os.Stdout, nil)), accounts)
 	assert.Nil(t, err)
 
 	addr, err := s.Listen()